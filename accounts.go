@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Users can register an account with POST /api/register and sign in with POST
+// /api/login, both {"username":"...","password":"..."}; either answers with a
+// session token the WebSocket handshake takes as /ws?token=... or as an
+// "Authorization: Bearer" header. A connection opened with a token can only
+// use its account's name, and a registered name, or one that looks like it,
+// only connections signed in to it can use. Guests keep the names nobody
+// registered unless ACCOUNTS_REQUIRED is set. Passwords are kept as bcrypt
+// hashes, and failed logins are throttled per IP and account like admin keys.
+
+var (
+	accountsRequired  = envBool("ACCOUNTS_REQUIRED", false)
+	accountSessionTTL = envDuration("ACCOUNT_SESSION_TTL", 30*24*time.Hour)
+	passwordMinLength = envInt("PASSWORD_MIN_LENGTH", 8)
+	bcryptCost        = envInt("BCRYPT_COST", bcrypt.DefaultCost)
+)
+
+// bcrypt ignores the bytes after the 72nd, so longer passwords are refused
+const passwordMaxBytes = 72
+
+// Compared against when a login names no account, so it takes as long as one
+// that does
+var missingAccountHash, _ = bcrypt.GenerateFromPassword([]byte("missing account"), bcryptCost)
+
+// An account, a hash with "password" (bcrypt) and "createdAt" (RFC 3339)
+func accountKey(username string) string {
+	return "websocket:account:" + username
+}
+
+// Registered skeletons, see usernames.go, -> username
+func accountSkeletonKey(skeleton string) string {
+	return "websocket:account:skeleton:" + skeleton
+}
+
+// Username of a session token
+func sessionKey(token string) string {
+	return "websocket:session:" + token
+}
+
+// AccountRequest is the body of POST /api/register and /api/login
+type AccountRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// SessionResponse carries a new session token
+type SessionResponse struct {
+	Username  string    `json:"username"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// accountHolder returns the registered username a name looks like, if any
+func (h *Hub) accountHolder(name string) string {
+	if h.redis == nil {
+		return ""
+	}
+	holder, err := h.redis.Get(context.Background(), accountSkeletonKey(usernameSkeleton(name))).Result()
+	if err != nil && err != redis.Nil {
+		log.Printf("Hesap sahibi okunamadı: %v", err)
+	}
+	return holder
+}
+
+// startSession issues a session token for an account
+func (h *Hub) startSession(username string) (SessionResponse, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return SessionResponse{}, err
+	}
+	session := SessionResponse{Username: username, Token: hex.EncodeToString(buf), ExpiresAt: time.Now().Add(accountSessionTTL)}
+	return session, h.redis.Set(context.Background(), sessionKey(session.Token), username, accountSessionTTL).Err()
+}
+
+// sessionToken returns the token a request carries, from the query or the
+// Authorization header
+func sessionToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// handshakeAccount resolves the account a connection is opened with, "" for a
+// guest. It answers the request and returns false when the token isn't valid,
+// or when there is none and accounts are required.
+func (h *Hub) handshakeAccount(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token := sessionToken(r)
+	if token == "" {
+		if accountsRequired {
+			http.Error(w, "Login required", http.StatusUnauthorized)
+			return "", false
+		}
+		return "", true
+	}
+	ip := clientIP(r)
+	if wait := h.authRetryAfter(ip, ""); wait > 0 {
+		tooManyAttempts(w, wait)
+		return "", false
+	}
+	var username string
+	var err error = redis.Nil
+	if h.redis != nil {
+		username, err = h.redis.Get(context.Background(), sessionKey(token)).Result()
+	}
+	if err != nil {
+		if err == redis.Nil {
+			h.recordAuthFailure(ip, "", "session")
+		}
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return "", false
+	}
+	return username, true
+}
+
+// handleRegister serves POST /api/register
+func handleRegister(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if hub.redis == nil {
+		http.Error(w, "Accounts require Redis", http.StatusServiceUnavailable)
+		return
+	}
+	var req AccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	username := normalizeUsername(req.Username)
+	if code, _ := checkUsername(username); code != "" {
+		http.Error(w, "Username not allowed", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(req.Password) < passwordMinLength || len(req.Password) > passwordMaxBytes {
+		http.Error(w, fmt.Sprintf("password must be %d characters to %d bytes", passwordMinLength, passwordMaxBytes), http.StatusBadRequest)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcryptCost)
+	if err != nil {
+		http.Error(w, "Error creating account", http.StatusInternalServerError)
+		return
+	}
+
+	// The name must be free of accounts and of guests who used it lately
+	ctx := context.Background()
+	skeleton := usernameSkeleton(username)
+	claimed, err := hub.redis.SetNX(ctx, accountSkeletonKey(skeleton), username, 0).Result()
+	if err != nil {
+		http.Error(w, "Error creating account", http.StatusInternalServerError)
+		return
+	}
+	if !claimed {
+		http.Error(w, "Username taken", http.StatusConflict)
+		return
+	}
+	if holder, err := hub.redis.Get(ctx, usernameSkeletonKey(skeleton)).Result(); err == nil && holder != username {
+		hub.redis.Del(ctx, accountSkeletonKey(skeleton))
+		http.Error(w, "Username taken", http.StatusConflict)
+		return
+	}
+	err = hub.redis.HSet(ctx, accountKey(username), "password", hash, "createdAt", time.Now().UTC().Format(time.RFC3339)).Err()
+	if err != nil {
+		hub.redis.Del(ctx, accountSkeletonKey(skeleton))
+		http.Error(w, "Error creating account", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Hesap oluşturuldu: %s", username)
+
+	session, err := hub.startSession(username)
+	if err != nil {
+		http.Error(w, "Error creating session", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, session)
+}
+
+// handleLogin serves POST /api/login
+func handleLogin(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if hub.redis == nil {
+		http.Error(w, "Accounts require Redis", http.StatusServiceUnavailable)
+		return
+	}
+	var req AccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	username := normalizeUsername(req.Username)
+	ip := clientIP(r)
+	if wait := hub.authRetryAfter(ip, username); wait > 0 {
+		tooManyAttempts(w, wait)
+		return
+	}
+	hash, err := hub.redis.HGet(context.Background(), accountKey(username), "password").Bytes()
+	if err != nil && err != redis.Nil {
+		http.Error(w, "Error reading account", http.StatusInternalServerError)
+		return
+	}
+	if err == redis.Nil {
+		hash = missingAccountHash
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(req.Password)) != nil || err == redis.Nil {
+		hub.recordAuthFailure(ip, username, "password")
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+	hub.recordAuthSuccess(username)
+
+	session, err := hub.startSession(username)
+	if err != nil {
+		http.Error(w, "Error creating session", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+// handleLogout serves POST /api/logout, ending the session of the token it
+// carries
+func handleLogout(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := sessionToken(r)
+	if hub.redis == nil || token == "" {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return
+	}
+	if removed, err := hub.redis.Del(context.Background(), sessionKey(token)).Result(); err != nil || removed == 0 {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
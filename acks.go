@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+var (
+	// How long a connection waits for the hub to take a message before it's
+	// reported back to the sender as dropped
+	broadcastTimeout = envDuration("BROADCAST_TIMEOUT", 5*time.Second)
+	// How long a clientMsgId is remembered to drop resends of the same message
+	dedupeTTL = envDuration("DEDUPE_TTL", 10*time.Minute)
+)
+
+// Longer idempotency keys are ignored
+const clientMsgIDMaxLen = 64
+
+// inboundMessage is an encoded Message on its way to the hub. Sender and
+// ClientMsgID are set when a connection asked for an acknowledgement.
+type inboundMessage struct {
+	data        []byte
+	sender      *Client
+	clientMsgID string
+	traceID     string
+	reviewed    bool // approved by a moderator, see review.go
+}
+
+// AckFrame tells the sender what happened to a message it sent with a clientMsgId
+type AckFrame struct {
+	Type        string    `json:"type"`
+	ClientMsgID string    `json:"clientMsgId"` // idempotency key the client sent with the message
+	MessageID   string    `json:"messageId,omitempty"`
+	Channel     string    `json:"channel"`
+	Status      string    `json:"status"`    // "accepted", "duplicate", "dropped", "held" or "command"
+	Persisted   bool      `json:"persisted"` // stored in the channel history
+	TraceID     string    `json:"traceId,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// DeliveredRequest is the inbound {"type":"delivered",...} receipt a client sends
+// when a message reached it. The server records delivery itself once it wrote a
+// message to the connection, so the receipt only adds what the server missed.
+type DeliveredRequest struct {
+	Type      string `json:"type"`
+	Channel   string `json:"channel"`
+	MessageID string `json:"messageId"`
+}
+
+// DeliveredFrame tells the author that a message reached a recipient for the first time
+type DeliveredFrame struct {
+	Type      string    `json:"type"`
+	Channel   string    `json:"channel"`
+	MessageID string    `json:"messageId"`
+	Username  string    `json:"username"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Author of a stored message, kept as long as the history for receipts and edits
+func messageAuthorKey(id string) string {
+	return "websocket:message:author:" + id
+}
+
+// submit hands a message to the hub, acknowledging it as dropped when the hub
+// doesn't take it within broadcastTimeout
+func (c *Client) submit(hub *Hub, msg Message, data []byte, clientMsgID string) {
+	timer := time.NewTimer(broadcastTimeout)
+	defer timer.Stop()
+	broadcastWaiting.Add(1)
+	defer broadcastWaiting.Add(-1)
+	select {
+	case hub.broadcast <- inboundMessage{data: data, sender: c, clientMsgID: clientMsgID, traceID: msg.TraceID}:
+		traces.record(msg.TraceID, TraceQueued, "")
+	case <-timer.C:
+		broadcastDrops.Add(1)
+		traces.record(msg.TraceID, TraceDropped, "hub busy")
+		if clientMsgID != "" {
+			c.sendFrame(AckFrame{
+				Type:        "ack",
+				ClientMsgID: clientMsgID,
+				Channel:     msg.Channel,
+				Status:      "dropped",
+				TraceID:     msg.TraceID,
+				Timestamp:   time.Now(),
+			}, PriorityHigh)
+		} else {
+			c.sendFrame(ErrorFrame{Type: "error", Code: ErrDropped, Reason: "Sunucu şu anda yoğun, mesaj gönderilemedi", TraceID: msg.TraceID}, PriorityHigh)
+		}
+	}
+}
+
+// acknowledge confirms a message to its sender after it was stored and fanned
+// out, or after a resend of it was dropped as a duplicate
+func (h *Hub) acknowledge(in inboundMessage, channel, messageID, status string, persisted bool) {
+	if in.sender == nil || in.clientMsgID == "" {
+		return
+	}
+	in.sender.sendFrame(AckFrame{
+		Type:        "ack",
+		ClientMsgID: in.clientMsgID,
+		MessageID:   messageID,
+		Channel:     channel,
+		Status:      status,
+		Persisted:   persisted,
+		TraceID:     in.traceID,
+		Timestamp:   time.Now(),
+	}, PriorityHigh)
+	traces.record(in.traceID, TraceAcked, status)
+}
+
+func dedupeKey(username, clientMsgID string) string {
+	return "websocket:dedupe:" + username + ":" + clientMsgID
+}
+
+// claimClientMsgID remembers messageID under the sender's idempotency key. When
+// the key was already used it returns false and the earlier message's ID.
+// Without Redis, or when Redis fails, every message counts as new.
+func (h *Hub) claimClientMsgID(username, clientMsgID, messageID string) (string, bool) {
+	if h.redis == nil || clientMsgID == "" {
+		return "", true
+	}
+	ctx := context.Background()
+	key := dedupeKey(username, clientMsgID)
+	claimed, err := h.redis.SetNX(ctx, key, messageID, dedupeTTL).Result()
+	if err != nil || claimed {
+		return "", true
+	}
+	original, _ := h.redis.Get(ctx, key).Result()
+	return original, false
+}
+
+// handleDelivered records a recipient's delivered receipt; the author is told
+// when the message wasn't already recorded as delivered to the recipient
+func (h *Hub) handleDelivered(c *Client, raw []byte) {
+	var req DeliveredRequest
+	if err := json.Unmarshal(raw, &req); err != nil || c.Username == "" || !isULID(req.MessageID) {
+		return
+	}
+	if h.redis == nil {
+		return
+	}
+	ctx := context.Background()
+	author, err := h.redis.Get(ctx, messageAuthorKey(req.MessageID)).Result()
+	if err != nil {
+		return
+	}
+	channel, _, ok := h.locateMessage(ctx, req.MessageID)
+	if !ok {
+		return
+	}
+	h.markMessageDelivered(channel, req.MessageID, author, c.Username)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Admin API keys are configured as ADMIN_TOKENS="melih:key1,ayse:key2"
+var adminTokens = parseAdminTokens(envList("ADMIN_TOKENS"))
+
+// Lifetime of the elevated token issued after a successful TOTP verification
+var adminElevationTTL = envDuration("ADMIN_ELEVATION_TTL", 5*time.Minute)
+
+const totpIssuer = "Chatliyo"
+
+func parseAdminTokens(items []string) map[string]string {
+	tokens := make(map[string]string)
+	for _, item := range items {
+		name, key, ok := strings.Cut(item, ":")
+		if !ok || name == "" || key == "" {
+			log.Printf("ADMIN_TOKENS girdisi geçersiz, atlandı: %q", item)
+			continue
+		}
+		tokens[name] = key
+	}
+	return tokens
+}
+
+// adminFromRequest resolves the admin name from the "Authorization: Bearer <key>" header
+func adminFromRequest(r *http.Request) (string, bool) {
+	return adminFromKey(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+}
+
+// adminFromKey resolves the admin name of an admin API key
+func adminFromKey(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	for name, expected := range adminTokens {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(expected)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// requireAdmin rejects requests that don't carry a valid admin API key.
+// Failed attempts are throttled per IP.
+func requireAdmin(hub *Hub, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if wait := hub.authRetryAfter(ip, ""); wait > 0 {
+			tooManyAttempts(w, wait)
+			return
+		}
+		if _, ok := adminFromRequest(r); !ok {
+			hub.recordAuthFailure(ip, "", "admin_key")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// tooManyAttempts answers a throttled authentication attempt
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())+1))
+	http.Error(w, "Too many attempts", http.StatusTooManyRequests)
+}
+
+// requireElevated guards destructive admin operations: besides the admin API key
+// the request must carry an X-Admin-Elevation token obtained from /admin/2fa/verify
+func requireElevated(hub *Hub, next http.HandlerFunc) http.HandlerFunc {
+	return requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		admin, _ := adminFromRequest(r)
+		token := r.Header.Get("X-Admin-Elevation")
+		if token == "" || !hub.checkElevation(admin, token) {
+			http.Error(w, "Two-factor verification required", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+func totpKey(admin string) string {
+	return fmt.Sprintf("websocket:admin:totp:%s", admin)
+}
+
+func totpPendingKey(admin string) string {
+	return fmt.Sprintf("websocket:admin:totp_pending:%s", admin)
+}
+
+// Set once a code of the given period was accepted, so it can't be used again
+func totpUsedKey(admin string, counter uint64) string {
+	return fmt.Sprintf("websocket:admin:totp_used:%s:%d", admin, counter)
+}
+
+func elevationKey(token string) string {
+	return fmt.Sprintf("websocket:admin:elevated:%s", token)
+}
+
+// checkElevation reports whether the token was issued to this admin and is still valid
+func (h *Hub) checkElevation(admin, token string) bool {
+	if h.redis == nil {
+		return false
+	}
+	owner, err := h.redis.Get(context.Background(), elevationKey(token)).Result()
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(owner), []byte(admin)) == 1
+}
+
+// handleTOTPEnroll creates a new pending TOTP secret for the calling admin.
+// The secret becomes active after the first successful verification.
+func handleTOTPEnroll(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if hub.redis == nil {
+		http.Error(w, "Two-factor authentication requires Redis", http.StatusServiceUnavailable)
+		return
+	}
+	admin, _ := adminFromRequest(r)
+	ctx := context.Background()
+
+	if n, _ := hub.redis.Exists(ctx, totpKey(admin)).Result(); n > 0 {
+		http.Error(w, "Two-factor authentication already enrolled", http.StatusConflict)
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		log.Printf("TOTP secret oluşturma hatası: %v", err)
+		http.Error(w, "Error creating secret", http.StatusInternalServerError)
+		return
+	}
+	if err := hub.redis.Set(ctx, totpPendingKey(admin), secret, 10*time.Minute).Err(); err != nil {
+		log.Printf("TOTP secret kaydetme hatası: %v", err)
+		http.Error(w, "Error storing secret", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Admin 2FA kaydı başlatıldı: %s", admin)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"secret":     secret,
+		"otpauthUrl": totpURL(totpIssuer, admin, secret),
+	})
+}
+
+// handleTOTPVerify checks a TOTP code and issues a short-lived elevated token
+func handleTOTPVerify(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if hub.redis == nil {
+		http.Error(w, "Two-factor authentication requires Redis", http.StatusServiceUnavailable)
+		return
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Code == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	admin, _ := adminFromRequest(r)
+	ip := clientIP(r)
+	if wait := hub.authRetryAfter(ip, admin); wait > 0 {
+		tooManyAttempts(w, wait)
+		return
+	}
+	ctx := context.Background()
+
+	secret, err := hub.redis.Get(ctx, totpKey(admin)).Result()
+	pending := false
+	if err != nil {
+		// Not enrolled yet, the first valid code confirms a pending enrollment
+		secret, err = hub.redis.Get(ctx, totpPendingKey(admin)).Result()
+		if err != nil {
+			http.Error(w, "Two-factor authentication not enrolled", http.StatusPreconditionFailed)
+			return
+		}
+		pending = true
+	}
+
+	counter, ok := verifyTOTP(secret, body.Code, time.Now())
+	if !ok {
+		log.Printf("Admin 2FA doğrulaması başarısız: %s", admin)
+		hub.recordAuthFailure(ip, admin, "totp")
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	// A code is valid for the whole skew window but accepted only once
+	first, err := hub.redis.SetNX(ctx, totpUsedKey(admin, counter), 1, (2*totpSkew+1)*totpPeriod).Result()
+	if err != nil {
+		log.Printf("TOTP kullanımı kaydedilemedi: %v", err)
+		http.Error(w, "Error verifying code", http.StatusInternalServerError)
+		return
+	}
+	if !first {
+		log.Printf("Admin 2FA kodu tekrar kullanıldı: %s", admin)
+		hub.recordAuthFailure(ip, admin, "totp")
+		http.Error(w, "Code already used", http.StatusUnauthorized)
+		return
+	}
+	hub.recordAuthSuccess(admin)
+
+	if pending {
+		pipe := hub.redis.TxPipeline()
+		pipe.Set(ctx, totpKey(admin), secret, 0)
+		pipe.Del(ctx, totpPendingKey(admin))
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("TOTP kaydı onaylama hatası: %v", err)
+			http.Error(w, "Error storing secret", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Admin 2FA kaydı tamamlandı: %s", admin)
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, "Error creating token", http.StatusInternalServerError)
+		return
+	}
+	token := hex.EncodeToString(buf)
+	if err := hub.redis.Set(ctx, elevationKey(token), admin, adminElevationTTL).Err(); err != nil {
+		log.Printf("Yetki token'ı kaydetme hatası: %v", err)
+		http.Error(w, "Error storing token", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"token":     token,
+		"expiresIn": int(adminElevationTTL.Seconds()),
+	})
+}
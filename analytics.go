@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Channels with fewer members than this only report their size, so
+// small groups can't be de-anonymized from their read behaviour
+var analyticsMinMembers = envInt("ANALYTICS_MIN_MEMBERS", 5)
+
+// ChannelEngagement holds anonymized read-state metrics of a channel's stored
+// history; no usernames leave the server
+type ChannelEngagement struct {
+	Channel           string  `json:"channel"`
+	Messages          int     `json:"messages"`
+	Members           int     `json:"members"`
+	Suppressed        bool    `json:"suppressed,omitempty"`
+	MedianReadSeconds float64 `json:"medianTimeToReadSeconds"`
+	ReadRate          float64 `json:"readRate"`  // share of members who read at least one message
+	ReplyRate         float64 `json:"replyRate"` // share of messages that are replies
+}
+
+// channelEngagement computes the metrics of one channel from its stored
+// messages, seen receipts and member set
+func (h *Hub) channelEngagement(channel string) (ChannelEngagement, error) {
+	ctx := context.Background()
+	pipe := h.redis.Pipeline()
+	stored := pipe.LRange(ctx, "websocket:messages:"+channel, 0, -1)
+	receipts := pipe.HGetAll(ctx, receiptsKey(channel))
+	posters := pipe.SMembers(ctx, channelMembersKey(channel))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return ChannelEngagement{}, err
+	}
+
+	type sent struct {
+		author string
+		at     time.Time
+	}
+	messages := make(map[string]sent)
+	members := make(map[string]bool)
+	for _, name := range posters.Val() {
+		members[name] = true
+	}
+	stats := ChannelEngagement{Channel: channel}
+	replies := 0
+	for _, raw := range stored.Val() {
+		var msg Message
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil || msg.Type == "digest" {
+			continue
+		}
+		stats.Messages++
+		if msg.ReplyTo != nil {
+			replies++
+		}
+		members[msg.Username] = true
+		messages[messageKey(msg.MessageID, msg.Timestamp)] = sent{msg.Username, msg.Timestamp}
+	}
+
+	// Receipts are written once per user and message; authors reading their own
+	// messages are skipped
+	readers := make(map[string]bool)
+	var delays []float64
+	for field, value := range receipts.Val() {
+		message, user, ok := strings.Cut(field, ":")
+		if !ok {
+			continue
+		}
+		m, ok := messages[message]
+		if !ok || user == m.author {
+			continue
+		}
+		readers[user] = true
+		members[user] = true
+		at, _ := strconv.ParseInt(value, 10, 64)
+		delays = append(delays, max(time.Unix(0, at).Sub(m.at).Seconds(), 0))
+	}
+
+	stats.Members = len(members)
+	if stats.Members < analyticsMinMembers {
+		stats.Suppressed = true
+		return stats, nil
+	}
+	if len(delays) > 0 {
+		sort.Float64s(delays)
+		mid := len(delays) / 2
+		stats.MedianReadSeconds = delays[mid]
+		if len(delays)%2 == 0 {
+			stats.MedianReadSeconds = (delays[mid-1] + delays[mid]) / 2
+		}
+	}
+	stats.ReadRate = float64(len(readers)) / float64(stats.Members)
+	if stats.Messages > 0 {
+		stats.ReplyRate = float64(replies) / float64(stats.Messages)
+	}
+	return stats, nil
+}
+
+// handleAdminAnalytics serves GET /admin/analytics[?channel=...] with the
+// engagement metrics of every channel that has stored messages
+func handleAdminAnalytics(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if hub.redis == nil {
+		http.Error(w, "Analytics require Redis", http.StatusServiceUnavailable)
+		return
+	}
+
+	var channels []string
+	if channel := r.URL.Query().Get("channel"); channel != "" {
+		channels = []string{channel}
+	} else {
+		// Every stored message is counted by channel in the storage statistics
+		names, err := hub.redis.HKeys(context.Background(), storageKey(storageMessages, "channel")).Result()
+		if err != nil {
+			http.Error(w, "Error reading analytics", http.StatusInternalServerError)
+			return
+		}
+		sort.Strings(names)
+		channels = names
+	}
+
+	report := make([]ChannelEngagement, 0, len(channels))
+	for _, channel := range channels {
+		stats, err := hub.channelEngagement(channel)
+		if err != nil {
+			http.Error(w, "Error reading analytics", http.StatusInternalServerError)
+			return
+		}
+		if stats.Messages > 0 {
+			report = append(report, stats)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"generatedAt": time.Now(),
+		"minMembers":  analyticsMinMembers,
+		"channels":    report,
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Admins announce to every channel at once with POST /api/announce
+// {"message":"...","channels":[...]} or, over the WebSocket, with
+// {"type":"announcement","key":"<admin API key>","message":"..."}. The
+// announcement is posted to each channel as a message of type "announcement"
+// from "system", stored in the channel's history like any other message, and
+// shown by clients as a banner. Without channels it goes to every channel with
+// a stored history or a connected member; conversations are left out.
+
+// AnnouncementRequest is the body of POST /api/announce and the inbound
+// {"type":"announcement",...} frame; Key authenticates the frame
+type AnnouncementRequest struct {
+	Type     string   `json:"type,omitempty"`
+	Key      string   `json:"key,omitempty"`
+	Message  string   `json:"message"`
+	Channels []string `json:"channels,omitempty"` // all channels when empty
+}
+
+// AnnouncementResult lists the message posted to each channel
+type AnnouncementResult struct {
+	Type     string            `json:"type"`
+	Messages map[string]string `json:"messages"` // channel -> message ID
+}
+
+// announcementChannels returns the channels an announcement without a channel
+// list goes to
+func (h *Hub) announcementChannels() []string {
+	set := make(map[string]bool)
+	if h.redis != nil {
+		stored, err := h.storedChannels(context.Background())
+		if err != nil {
+			log.Printf("Kanallar okunamadı: %v", err)
+		}
+		for _, channel := range stored {
+			set[channel] = true
+		}
+	}
+	h.mutex.RLock()
+	for client := range h.clients {
+		for _, channel := range client.subscriptions() {
+			set[channel] = true
+		}
+	}
+	h.mutex.RUnlock()
+
+	channels := make([]string, 0, len(set))
+	for channel := range set {
+		if channel != "" && !isConversation(channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// announce posts an announcement by admin; it returns an error for an empty or
+// too long text
+func (h *Hub) announce(admin string, req AnnouncementRequest) (AnnouncementResult, error) {
+	result := AnnouncementResult{Type: "announcement_result", Messages: make(map[string]string)}
+	text := sanitizeMarkdown(strings.TrimSpace(req.Message))
+	if text == "" || utf8.RuneCountInString(text) > maxMessageLength {
+		return result, fmt.Errorf("message must be 1 to %d characters", maxMessageLength)
+	}
+	channels := req.Channels
+	if len(channels) == 0 {
+		channels = h.announcementChannels()
+	}
+
+	now := time.Now()
+	var posted []string
+	for _, channel := range channels {
+		if channel == "" || isConversation(channel) || result.Messages[channel] != "" {
+			continue
+		}
+		msg := Message{
+			MessageID: newULID(),
+			Username:  "system",
+			Message:   text,
+			Timestamp: now,
+			Channel:   channel,
+			Type:      "announcement",
+			Segments:  parseSegments(text),
+		}
+		frame, err := json.Marshal(msg)
+		if err != nil {
+			return result, err
+		}
+		h.broadcast <- inboundMessage{data: frame}
+		result.Messages[channel] = msg.MessageID
+		posted = append(posted, channel)
+	}
+	h.recordAudit("announcement", admin, strings.Join(posted, ","), truncate(text, 100))
+	return result, nil
+}
+
+// handleAnnounce serves POST /api/announce for admins
+func handleAnnounce(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req AnnouncementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	admin, _ := adminFromRequest(r)
+	result, err := hub.announce(admin, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleAnnouncement posts an announcement sent over the WebSocket with an
+// admin API key
+func (h *Hub) handleAnnouncement(c *Client, raw []byte) {
+	var req AnnouncementRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return
+	}
+	if wait := h.authRetryAfter(c.IP, ""); wait > 0 {
+		c.sendError(ErrRateLimited, "Çok fazla başarısız deneme, lütfen bekleyin")
+		return
+	}
+	admin, ok := adminFromKey(req.Key)
+	if !ok {
+		h.recordAuthFailure(c.IP, "", "admin_key")
+		c.sendError(ErrNotAdmin, "Duyuru yapmak için yönetici anahtarı gerekli")
+		return
+	}
+	result, err := h.announce(admin, req)
+	if err != nil {
+		c.sendError(ErrInvalidAnnouncement, "Duyuru metni boş ya da çok uzun")
+		return
+	}
+	c.sendFrame(result, PriorityNormal)
+}
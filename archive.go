@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Redis keeps a day of history. Stored messages are also appended to an
+// archive on disk, one JSON line per message in a file per channel and UTC
+// day; edits append the new version, which replaces the earlier one when
+// read. /archive/{channel} renders it as read-only pages straight from disk,
+// without the hub or Redis. Expiring messages are never archived.
+
+var (
+	// Empty turns archiving off
+	archiveDir = envString("ARCHIVE_DIR", "./archive")
+	// How long a signed archive link issued by an admin works
+	archiveLinkTTL = envDuration("ARCHIVE_LINK_TTL", 7*24*time.Hour)
+	// Anyone may read the archive of regular channels, conversations still
+	// need an admin or a signed link
+	archivePublic = envBool("ARCHIVE_PUBLIC", false)
+)
+
+const archivePageSize = 200
+
+// queueArchive hands a stored message to the archive writer
+func (h *Hub) queueArchive(msg Message) {
+	if archiveDir == "" || msg.ExpiresAt != nil || msg.MessageID == "" {
+		return
+	}
+	select {
+	case h.archive <- msg:
+	default:
+		log.Printf("Arşiv kuyruğu dolu, mesaj arşivlenmedi: %s", msg.MessageID)
+	}
+}
+
+// Directory of a channel's archive; channel names may contain anything
+func archiveChannelDir(channel string) string {
+	return filepath.Join(archiveDir, base64.RawURLEncoding.EncodeToString([]byte(channel)))
+}
+
+// runArchiveWriter appends queued messages to the archive
+func (h *Hub) runArchiveWriter() {
+	if archiveDir == "" {
+		return
+	}
+	for msg := range h.archive {
+		if err := appendArchive(msg); err != nil {
+			log.Printf("Mesaj arşivlenemedi (%s): %v", msg.MessageID, err)
+		}
+	}
+}
+
+func appendArchive(msg Message) error {
+	dir := archiveChannelDir(msg.Channel)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, msg.Timestamp.UTC().Format("2006-01-02")+".jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// removeArchive deletes the archive of a channel along with its history
+func removeArchive(channel string) {
+	if archiveDir == "" {
+		return
+	}
+	if err := os.RemoveAll(archiveChannelDir(channel)); err != nil {
+		log.Printf("Kanal arşivi silinemedi (%s): %v", channel, err)
+	}
+}
+
+// archiveDays lists the archived days of a channel, newest first
+func archiveDays(channel string) []string {
+	entries, err := os.ReadDir(archiveChannelDir(channel))
+	if err != nil {
+		return nil
+	}
+	var days []string
+	for _, entry := range entries {
+		if day, ok := strings.CutSuffix(entry.Name(), ".jsonl"); ok {
+			days = append(days, day)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(days)))
+	return days
+}
+
+// readArchiveDay loads the messages of one archived day in order, each at its
+// latest version
+func readArchiveDay(channel, day string) ([]Message, error) {
+	f, err := os.Open(filepath.Join(archiveChannelDir(channel), day+".jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var messages []Message
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var msg Message
+		if json.Unmarshal(scanner.Bytes(), &msg) != nil {
+			continue
+		}
+		if i, ok := index[msg.MessageID]; ok {
+			messages[i] = msg
+			continue
+		}
+		index[msg.MessageID] = len(messages)
+		messages = append(messages, msg)
+	}
+	return messages, scanner.Err()
+}
+
+// archiveLink signs a read-only link to a channel's archive
+func archiveLink(channel string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	query := url.Values{"exp": {exp}, "sig": {signValue("archive", channel, exp)}}
+	return "/archive/" + url.PathEscape(channel) + "?" + query.Encode()
+}
+
+// canReadArchive checks an admin key, a signed link, or public access
+func canReadArchive(r *http.Request, channel string) bool {
+	if _, ok := adminFromRequest(r); ok {
+		return true
+	}
+	exp, sig := r.URL.Query().Get("exp"), r.URL.Query().Get("sig")
+	if sig != "" {
+		expires, err := strconv.ParseInt(exp, 10, 64)
+		return err == nil && time.Now().Unix() < expires && verifySignature(sig, "archive", channel, exp)
+	}
+	return archivePublic && !isConversation(channel)
+}
+
+type archivePage struct {
+	Channel  string
+	Day      string
+	Page     int
+	Pages    []int
+	Messages []Message
+	Newer    string // links to the neighbouring days and pages
+	Older    string
+	PageLink func(int) string
+}
+
+var archiveTemplate = template.Must(template.New("archive").Funcs(template.FuncMap{
+	"clock": func(t time.Time) string { return t.UTC().Format("15:04:05") },
+}).Parse(`<!DOCTYPE html>
+<html lang="tr">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>#{{.Channel}} arşivi, {{.Day}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 860px; margin: 24px auto; padding: 0 16px; color: #212529; }
+nav { display: flex; gap: 12px; margin: 16px 0; }
+.message { padding: 6px 0; border-bottom: 1px solid #e9ecef; white-space: pre-wrap; word-break: break-word; }
+.time { color: #6c757d; font-size: 13px; margin-right: 8px; }
+.system { color: #6c757d; font-style: italic; }
+.edited, .file { color: #6c757d; font-size: 13px; }
+</style>
+</head>
+<body>
+<h1>#{{.Channel}}</h1>
+<p>{{.Day}} (UTC), salt okunur arşiv</p>
+<nav>
+{{if .Newer}}<a href="{{.Newer}}">&larr; Daha yeni</a>{{end}}
+{{if .Older}}<a href="{{.Older}}">Daha eski &rarr;</a>{{end}}
+</nav>
+{{range .Messages}}
+<div class="message{{if eq .Type "system"}} system{{end}}">
+<span class="time">{{clock .Timestamp}}</span>{{if ne .Type "system"}}<strong>{{.Username}}</strong>: {{end}}{{.Message}}
+{{- if .FileName}} <span class="file">[{{.FileName}}]</span>{{end}}
+{{- if .Poll}}{{range .Poll.Options}} <span class="file">[{{.}}]</span>{{end}}{{end}}
+{{- if .Edited}} <span class="edited">(düzenlendi)</span>{{end}}
+</div>
+{{else}}
+<p>Bu günde mesaj yok.</p>
+{{end}}
+{{if gt (len .Pages) 1}}<nav>{{$page := .Page}}{{$link := .PageLink}}{{range .Pages}}{{if eq . $page}}<strong>{{.}}</strong>{{else}}<a href="{{call $link .}}">{{.}}</a>{{end}}{{end}}</nav>{{end}}
+</body>
+</html>
+`))
+
+// handleArchive serves GET /archive/{channel}?day=YYYY-MM-DD&page=N, the
+// newest day by default
+func handleArchive(w http.ResponseWriter, r *http.Request) {
+	channel := strings.Trim(strings.TrimPrefix(r.URL.Path, "/archive/"), "/")
+	if archiveDir == "" || channel == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !canReadArchive(r, channel) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	days := archiveDays(channel)
+	if len(days) == 0 {
+		http.Error(w, "No archive for this channel", http.StatusNotFound)
+		return
+	}
+	day := r.URL.Query().Get("day")
+	if day == "" {
+		day = days[0]
+	}
+	at := sort.Search(len(days), func(i int) bool { return days[i] <= day })
+	if at == len(days) || days[at] != day {
+		http.Error(w, "Day not archived", http.StatusNotFound)
+		return
+	}
+	messages, err := readArchiveDay(channel, day)
+	if err != nil {
+		log.Printf("Arşiv okunamadı (%s, %s): %v", channel, day, err)
+		http.Error(w, "Error reading archive", http.StatusInternalServerError)
+		return
+	}
+
+	pages := max(1, (len(messages)+archivePageSize-1)/archivePageSize)
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	page = min(max(page, 1), pages)
+	// Links keep the signature the page was opened with
+	link := func(day string, page int) string {
+		query := url.Values{"day": {day}}
+		if page > 1 {
+			query.Set("page", strconv.Itoa(page))
+		}
+		for _, name := range []string{"exp", "sig"} {
+			if v := r.URL.Query().Get(name); v != "" {
+				query.Set(name, v)
+			}
+		}
+		return "/archive/" + url.PathEscape(channel) + "?" + query.Encode()
+	}
+	view := archivePage{
+		Channel:  channel,
+		Day:      day,
+		Page:     page,
+		Messages: messages[(page-1)*archivePageSize : min(page*archivePageSize, len(messages))],
+		PageLink: func(p int) string { return link(day, p) },
+	}
+	for p := 1; p <= pages; p++ {
+		view.Pages = append(view.Pages, p)
+	}
+	if at > 0 {
+		view.Newer = link(days[at-1], 1)
+	}
+	if at+1 < len(days) {
+		view.Older = link(days[at+1], 1)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	if err := archiveTemplate.Execute(w, view); err != nil {
+		log.Printf("Arşiv sayfası oluşturulamadı: %v", err)
+	}
+}
+
+// ArchiveLink is the /admin/archive response
+type ArchiveLink struct {
+	Channel   string    `json:"channel"`
+	Days      []string  `json:"days"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// handleAdminArchive serves GET /admin/archive?channel=..., the archived days
+// of a channel with a signed link to read them
+func handleAdminArchive(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if archiveDir == "" {
+		http.Error(w, "Archive disabled", http.StatusServiceUnavailable)
+		return
+	}
+	channel := r.URL.Query().Get("channel")
+	if channel == "" {
+		http.Error(w, "channel required", http.StatusBadRequest)
+		return
+	}
+	days := archiveDays(channel)
+	if len(days) == 0 {
+		http.Error(w, "No archive for this channel", http.StatusNotFound)
+		return
+	}
+	expires := time.Now().Add(archiveLinkTTL)
+	admin, _ := adminFromRequest(r)
+	hub.recordAudit("archive_link_created", admin, channel, "expires="+expires.UTC().Format(time.RFC3339))
+	writeJSON(w, http.StatusOK, ArchiveLink{Channel: channel, Days: days, URL: archiveLink(channel, expires), ExpiresAt: expires})
+}
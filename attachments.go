@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+// Bots, the endpoints behind slash commands, can attach status badges and
+// action buttons to the messages they post. A click on a button comes in as an
+// interaction frame and is POSTed to the bot like a command, together with who
+// clicked, so approve/deny workflows can run inside the channel.
+
+const (
+	maxAttachments     = 5
+	maxAttachmentItems = 5 // badges and actions per attachment
+	maxAttachmentText  = 500
+	maxAttachmentLabel = 40
+)
+
+// Styles clients know how to render; others are dropped
+var (
+	badgeStyles  = map[string]bool{"success": true, "warning": true, "danger": true, "info": true}
+	actionStyles = map[string]bool{"primary": true, "danger": true}
+
+	// Action IDs end up in client markup, so they're kept to a safe alphabet
+	actionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+)
+
+// MessageAttachment is a block of structured content under a bot's message
+type MessageAttachment struct {
+	Title   string          `json:"title,omitempty"`
+	Text    string          `json:"text,omitempty"`
+	Badges  []MessageBadge  `json:"badges,omitempty"`
+	Actions []MessageAction `json:"actions,omitempty"`
+}
+
+// MessageBadge is a status label, e.g. {"label":"deployed","style":"success"}
+type MessageBadge struct {
+	Label string `json:"label"`
+	Style string `json:"style,omitempty"` // "success", "warning", "danger" or "info"
+}
+
+// MessageAction is a button; its ID (letters, digits and "_.:-") and Value go
+// back to the bot when clicked
+type MessageAction struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+	Style string `json:"style,omitempty"` // "primary" or "danger"
+	Value string `json:"value,omitempty"`
+}
+
+// InteractionRequest is the inbound {"type":"interaction","messageId":"...","actionId":"..."} frame
+type InteractionRequest struct {
+	Type      string `json:"type"`
+	MessageID string `json:"messageId"`
+	ActionID  string `json:"actionId"`
+}
+
+// InteractionEvent is POSTed to the bot when one of its buttons is clicked
+type InteractionEvent struct {
+	Type      string    `json:"type"` // "interaction"
+	Command   string    `json:"command"`
+	MessageID string    `json:"messageId"`
+	ActionID  string    `json:"actionId"`
+	Value     string    `json:"value,omitempty"`
+	Channel   string    `json:"channel"`
+	Username  string    `json:"username"`
+	UserID    string    `json:"userId"`
+	Identity  Identity  `json:"identity"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// truncate shortens s to at most n runes
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// sanitizeAttachments bounds what a bot sent: counts, text lengths, known
+// styles, and actions with a unique ID and a label
+func sanitizeAttachments(list []MessageAttachment) []MessageAttachment {
+	if len(list) > maxAttachments {
+		list = list[:maxAttachments]
+	}
+	var result []MessageAttachment
+	ids := make(map[string]bool)
+	for _, in := range list {
+		out := MessageAttachment{
+			Title: truncate(in.Title, maxAttachmentLabel*2),
+			Text:  truncate(in.Text, maxAttachmentText),
+		}
+		for _, badge := range in.Badges {
+			if badge.Label == "" || len(out.Badges) == maxAttachmentItems {
+				continue
+			}
+			if !badgeStyles[badge.Style] {
+				badge.Style = ""
+			}
+			out.Badges = append(out.Badges, MessageBadge{Label: truncate(badge.Label, maxAttachmentLabel), Style: badge.Style})
+		}
+		for _, action := range in.Actions {
+			if !actionIDPattern.MatchString(action.ID) || action.Label == "" || ids[action.ID] || len(out.Actions) == maxAttachmentItems {
+				continue
+			}
+			ids[action.ID] = true
+			if !actionStyles[action.Style] {
+				action.Style = ""
+			}
+			action.Label = truncate(action.Label, maxAttachmentLabel)
+			action.Value = truncate(action.Value, maxAttachmentText)
+			out.Actions = append(out.Actions, action)
+		}
+		if out.Title != "" || out.Text != "" || len(out.Badges) > 0 || len(out.Actions) > 0 {
+			result = append(result, out)
+		}
+	}
+	return result
+}
+
+// handleInteraction forwards a button click to the bot that posted the message
+// and delivers the bot's reply
+func (h *Hub) handleInteraction(c *Client, raw []byte) {
+	var req InteractionRequest
+	if err := json.Unmarshal(raw, &req); err != nil || !isULID(req.MessageID) || req.ActionID == "" {
+		c.sendError(ErrInvalidInteraction, "Geçersiz etkileşim")
+		return
+	}
+	if h.redis == nil {
+		c.sendError(ErrInvalidInteraction, "Mesaj geçmişi olmadan etkileşim yapılamaz")
+		return
+	}
+	ctx := context.Background()
+	channel, seq, ok := h.locateMessage(ctx, req.MessageID)
+	if !ok || !c.inChannel(channel) {
+		c.sendError(ErrInvalidInteraction, "Mesaj bulunamadı")
+		return
+	}
+	stored, err := h.messagesBySeq(ctx, channel, seq, seq)
+	if err != nil || len(stored) == 0 || stored[0].MessageID != req.MessageID || stored[0].Bot == "" {
+		c.sendError(ErrInvalidInteraction, "Mesaj bulunamadı")
+		return
+	}
+	msg := stored[0]
+	var action *MessageAction
+	for i := range msg.Attachments {
+		for j := range msg.Attachments[i].Actions {
+			if msg.Attachments[i].Actions[j].ID == req.ActionID {
+				action = &msg.Attachments[i].Actions[j]
+			}
+		}
+	}
+	if action == nil {
+		c.sendError(ErrInvalidInteraction, "Düğme bulunamadı")
+		return
+	}
+	cmd, ok := h.lookupCommand(channel, msg.Bot)
+	if !ok {
+		c.sendError(ErrInvalidInteraction, "Bu mesajın botu artık kayıtlı değil")
+		return
+	}
+
+	now := time.Now()
+	reply, err := callCommand(cmd, InteractionEvent{
+		Type:      "interaction",
+		Command:   "/" + cmd.Name,
+		MessageID: msg.MessageID,
+		ActionID:  action.ID,
+		Value:     action.Value,
+		Channel:   channel,
+		Username:  c.Username,
+		UserID:    c.ID,
+		Identity:  userIdentity(c.Username),
+		Timestamp: now,
+	}, now)
+	if err != nil {
+		h.commandFailed(c, cmd, err)
+		return
+	}
+	h.deliverCommandReply(c, cmd, reply)
+}
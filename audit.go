@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Audit entries are kept for this long; the index holds at most auditMaxEntries
+var auditRetention = envDuration("AUDIT_RETENTION", 90*24*time.Hour)
+
+const (
+	auditIndexKey   = "websocket:audit"
+	auditMaxEntries = 10000
+)
+
+// AuditEntry records a security or moderation relevant event. Repeated events of
+// an incident are collapsed into one entry with a Count.
+type AuditEntry struct {
+	ID      string    `json:"id"`
+	Action  string    `json:"action"`
+	Actor   string    `json:"actor"`
+	Target  string    `json:"target,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+	Count   int64     `json:"count"`
+	FirstAt time.Time `json:"firstAt"`
+	LastAt  time.Time `json:"lastAt"`
+}
+
+func auditEntryKey(id string) string {
+	return "websocket:audit:entry:" + id
+}
+
+func auditIncidentKey(action, actor string) string {
+	return "websocket:audit:incident:" + action + ":" + actor
+}
+
+// recordAudit stores a new audit entry and returns its ID
+func (h *Hub) recordAudit(action, actor, target, detail string) string {
+	log.Printf("Denetim kaydı: %s, aktör=%s, hedef=%s, %s", action, actor, target, detail)
+	if h.redis == nil {
+		return ""
+	}
+	now := time.Now()
+	id := newULID()
+	ctx := context.Background()
+	pipe := h.redis.TxPipeline()
+	pipe.HSet(ctx, auditEntryKey(id), map[string]interface{}{
+		"action":  action,
+		"actor":   actor,
+		"target":  target,
+		"detail":  detail,
+		"count":   1,
+		"firstAt": now.UnixMilli(),
+		"lastAt":  now.UnixMilli(),
+	})
+	pipe.Expire(ctx, auditEntryKey(id), auditRetention)
+	pipe.ZAdd(ctx, auditIndexKey, &redis.Z{Score: float64(now.UnixMilli()), Member: id})
+	pipe.ZRemRangeByRank(ctx, auditIndexKey, 0, -auditMaxEntries-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Denetim kaydı yazılamadı: %v", err)
+		return ""
+	}
+	return id
+}
+
+// recordAuditIncident collapses repeated events of the same action by the same
+// actor into one entry while they keep occurring within window of each other
+func (h *Hub) recordAuditIncident(action, actor, target, detail string, window time.Duration) {
+	if h.redis == nil {
+		log.Printf("Denetim olayı: %s, aktör=%s, hedef=%s, %s", action, actor, target, detail)
+		return
+	}
+	ctx := context.Background()
+	incident := auditIncidentKey(action, actor)
+	if id, err := h.redis.Get(ctx, incident).Result(); err == nil {
+		pipe := h.redis.Pipeline()
+		pipe.HIncrBy(ctx, auditEntryKey(id), "count", 1)
+		pipe.HSet(ctx, auditEntryKey(id), "lastAt", time.Now().UnixMilli())
+		pipe.Expire(ctx, incident, window)
+		pipe.Exec(ctx)
+		return
+	}
+	if id := h.recordAudit(action, actor, target, detail); id != "" {
+		h.redis.Set(ctx, incident, id, window)
+	}
+}
+
+// listAudit returns the newest entries first, optionally filtered by action
+func (h *Hub) listAudit(action string, limit int) []AuditEntry {
+	ctx := context.Background()
+	entries := []AuditEntry{}
+	// Filtering happens after the read, so scan a larger window when filtering
+	scan := limit
+	if action != "" {
+		scan = limit * 10
+	}
+	ids, err := h.redis.ZRevRange(ctx, auditIndexKey, 0, int64(scan-1)).Result()
+	if err != nil {
+		return entries
+	}
+	for _, id := range ids {
+		fields, err := h.redis.HGetAll(ctx, auditEntryKey(id)).Result()
+		if err != nil || len(fields) == 0 {
+			continue
+		}
+		if action != "" && fields["action"] != action {
+			continue
+		}
+		count, _ := strconv.ParseInt(fields["count"], 10, 64)
+		first, _ := strconv.ParseInt(fields["firstAt"], 10, 64)
+		last, _ := strconv.ParseInt(fields["lastAt"], 10, 64)
+		entries = append(entries, AuditEntry{
+			ID:      id,
+			Action:  fields["action"],
+			Actor:   fields["actor"],
+			Target:  fields["target"],
+			Detail:  fields["detail"],
+			Count:   count,
+			FirstAt: time.UnixMilli(first),
+			LastAt:  time.UnixMilli(last),
+		})
+		if len(entries) == limit {
+			break
+		}
+	}
+	return entries
+}
+
+// handleAdminAudit serves GET /admin/audit?action=&limit=
+func handleAdminAudit(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if hub.redis == nil {
+		http.Error(w, "Audit log requires Redis", http.StatusServiceUnavailable)
+		return
+	}
+	limit := 100
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = min(v, 1000)
+	}
+	writeJSON(w, http.StatusOK, hub.listAudit(r.URL.Query().Get("action"), limit))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Egress is throttled so one client can't saturate a small server's uplink:
+// each WebSocket connection is paced to CLIENT_EGRESS_RATE, downloads from
+// /uploads to DOWNLOAD_IP_RATE per IP and, in stream mode, DOWNLOAD_USER_RATE
+// per user. Rates are bytes per second, 0 turns a limit off. Limits apply per
+// instance.
+
+var (
+	clientEgressRate = envInt("CLIENT_EGRESS_RATE", 0)
+	downloadIPRate   = envInt("DOWNLOAD_IP_RATE", 0)
+)
+
+var (
+	downloadUserLimiters = newRateLimiterSet(downloadUserRate)
+	downloadIPLimiters   = newRateLimiterSet(downloadIPRate)
+)
+
+// byteRateLimiter spaces writes so they average rate bytes per second
+type byteRateLimiter struct {
+	rate int
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until n more bytes may be written; false if done closed first
+func (l *byteRateLimiter) wait(done <-chan struct{}, n int) bool {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(time.Duration(n) * time.Second / time.Duration(l.rate))
+	l.mu.Unlock()
+	if at.Equal(now) {
+		return true
+	}
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// idle reports whether the limiter has no writes to space out anymore
+func (l *byteRateLimiter) idle(after time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Since(l.next) > after
+}
+
+// rateLimiterSet hands out one limiter per key, shared by the key's
+// concurrent transfers
+type rateLimiterSet struct {
+	rate  int
+	mu    sync.Mutex
+	byKey map[string]*byteRateLimiter
+}
+
+func newRateLimiterSet(rate int) *rateLimiterSet {
+	return &rateLimiterSet{rate: rate, byKey: make(map[string]*byteRateLimiter)}
+}
+
+// get returns the limiter of a key; nil when the set has no limit
+func (s *rateLimiterSet) get(key string) *byteRateLimiter {
+	if s.rate <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.byKey[key]; ok {
+		return l
+	}
+	// Limiters idle for a minute carry no debt, drop them while adding one
+	for k, l := range s.byKey {
+		if l.idle(time.Minute) {
+			delete(s.byKey, k)
+		}
+	}
+	l := &byteRateLimiter{rate: s.rate}
+	s.byKey[key] = l
+	return l
+}
+
+// throttledWriter writes a response in chunks paced by a limiter
+type throttledWriter struct {
+	http.ResponseWriter
+	limiter *byteRateLimiter
+	done    <-chan struct{}
+}
+
+// throttle wraps w with a limiter; w itself when the limiter is nil
+func throttle(w http.ResponseWriter, r *http.Request, limiter *byteRateLimiter) http.ResponseWriter {
+	if limiter == nil {
+		return w
+	}
+	return throttledWriter{ResponseWriter: w, limiter: limiter, done: r.Context().Done()}
+}
+
+func (w throttledWriter) Write(p []byte) (int, error) {
+	chunk := min(16*1024, w.limiter.rate)
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), chunk)
+		if !w.limiter.wait(w.done, n) {
+			return written, http.ErrAbortHandler
+		}
+		m, err := w.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"expvar"
+	"math"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Connections one instance is sized for, the slot budget reported to autoscalers
+var capacityMaxConnections = envInt("CAPACITY_MAX_CONNECTIONS", 10000)
+
+// A client whose normal lane is this full is counted as falling behind
+const laneBacklogRatio = 0.75
+
+var (
+	// Connections currently waiting for the hub to take a message
+	broadcastWaiting atomic.Int64
+	// Messages reported back as dropped because the hub didn't take them in time
+	broadcastDrops = expvar.NewInt("broadcast_drops")
+)
+
+// Capacity is the /internal/capacity response. Ratios are between 0 and 1;
+// Utilization is the highest of them, the figure to scale on.
+type Capacity struct {
+	Connections ConnectionCapacity `json:"connections"`
+	Broadcast   BroadcastCapacity  `json:"broadcast"`
+	Memory      MemoryCapacity     `json:"memory"`
+	Utilization float64            `json:"utilization"`
+	Goroutines  int                `json:"goroutines"`
+	Timestamp   time.Time          `json:"timestamp"`
+}
+
+// ConnectionCapacity counts connections against the configured slot budget
+type ConnectionCapacity struct {
+	Used  int     `json:"used"`
+	Free  int     `json:"free"`
+	Max   int     `json:"max"`
+	Ratio float64 `json:"ratio"`
+}
+
+// BroadcastCapacity describes the fan-out path: senders waiting for the hub and
+// per-client send lanes filling up because clients can't keep up
+type BroadcastCapacity struct {
+	Waiting       int64   `json:"waiting"`       // senders blocked on the hub
+	Dropped       int64   `json:"dropped"`       // messages dropped since start
+	LaneFill      float64 `json:"laneFill"`      // average fill of the clients' normal lanes
+	ClientsBehind int     `json:"clientsBehind"` // clients with a lane over 75% full
+	UploadQueue   float64 `json:"uploadQueue"`   // fill of the upload processing queue
+	ReceiptQueue  float64 `json:"receiptQueue"`  // fill of the seen receipt buffer
+	Saturation    float64 `json:"saturation"`    // highest of the ratios above
+}
+
+// MemoryCapacity compares the Go heap with the memory limit of the process,
+// GOMEMLIMIT or the container's cgroup limit. Without a limit only usage is reported.
+type MemoryCapacity struct {
+	HeapInUseBytes uint64  `json:"heapInUseBytes"`
+	SysBytes       uint64  `json:"sysBytes"`
+	LimitBytes     uint64  `json:"limitBytes,omitempty"`
+	HeadroomBytes  uint64  `json:"headroomBytes,omitempty"`
+	Ratio          float64 `json:"ratio"`
+}
+
+// memoryLimit returns the process memory limit in bytes, 0 when unlimited
+func memoryLimit() uint64 {
+	if limit := debug.SetMemoryLimit(-1); limit > 0 && limit != math.MaxInt64 {
+		return uint64(limit)
+	}
+	data, err := os.ReadFile("/sys/fs/cgroup/memory.max")
+	if err != nil {
+		return 0
+	}
+	limit, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0 // "max"
+	}
+	return limit
+}
+
+func fillRatio(length, capacity int) float64 {
+	if capacity == 0 {
+		return 0
+	}
+	return float64(length) / float64(capacity)
+}
+
+// capacity takes a snapshot of the instance's socket workload
+func (h *Hub) capacity() Capacity {
+	result := Capacity{Timestamp: time.Now(), Goroutines: runtime.NumGoroutine()}
+
+	var lanes float64
+	h.mutex.RLock()
+	used := len(h.clients)
+	for client := range h.clients {
+		fill := fillRatio(len(client.Send), cap(client.Send))
+		lanes += fill
+		if fill >= laneBacklogRatio {
+			result.Broadcast.ClientsBehind++
+		}
+	}
+	h.mutex.RUnlock()
+
+	result.Connections = ConnectionCapacity{
+		Used:  used,
+		Free:  max(capacityMaxConnections-used, 0),
+		Max:   capacityMaxConnections,
+		Ratio: fillRatio(used, capacityMaxConnections),
+	}
+
+	b := &result.Broadcast
+	b.Waiting = broadcastWaiting.Load()
+	b.Dropped = broadcastDrops.Value()
+	if used > 0 {
+		b.LaneFill = lanes / float64(used)
+		b.Saturation = float64(b.ClientsBehind) / float64(used)
+	}
+	b.UploadQueue = fillRatio(len(h.uploadQueue), cap(h.uploadQueue))
+	b.ReceiptQueue = fillRatio(len(h.receipts), cap(h.receipts))
+	b.Saturation = max(b.Saturation, b.LaneFill, b.UploadQueue, b.ReceiptQueue)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	result.Memory = MemoryCapacity{HeapInUseBytes: mem.HeapInuse, SysBytes: mem.Sys}
+	if limit := memoryLimit(); limit > 0 {
+		result.Memory.LimitBytes = limit
+		if limit > mem.Sys {
+			result.Memory.HeadroomBytes = limit - mem.Sys
+		}
+		result.Memory.Ratio = min(float64(mem.Sys)/float64(limit), 1)
+	}
+
+	result.Utilization = min(max(result.Connections.Ratio, b.Saturation, result.Memory.Ratio), 1)
+	return result
+}
+
+// handleCapacity serves GET /internal/capacity for autoscalers and orchestrators
+func handleCapacity(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, hub.capacity())
+}
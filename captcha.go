@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CAPTCHA gate configuration. The gate is disabled unless a provider and secret are set.
+var (
+	captchaProvider = strings.ToLower(envString("CAPTCHA_PROVIDER", "")) // "hcaptcha" or "turnstile"
+	captchaSecret   = envString("CAPTCHA_SECRET", "")
+	captchaSiteKey  = envString("CAPTCHA_SITE_KEY", "")
+	captchaTTL      = envDuration("CAPTCHA_TTL", 24*time.Hour)
+)
+
+var captchaVerifyURLs = map[string]string{
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+// Fallback store of verified IPs when Redis isn't available
+var (
+	captchaPassed      = make(map[string]time.Time)
+	captchaPassedMutex sync.Mutex
+)
+
+func captchaEnabled() bool {
+	return captchaSecret != "" && captchaVerifyURLs[captchaProvider] != ""
+}
+
+func captchaKey(ip string) string {
+	return fmt.Sprintf("websocket:captcha:ok:%s", ip)
+}
+
+// captchaVerified reports whether the IP has passed the CAPTCHA recently
+func (h *Hub) captchaVerified(ip string) bool {
+	if !captchaEnabled() {
+		return true
+	}
+	if h.redis != nil {
+		n, err := h.redis.Exists(context.Background(), captchaKey(ip)).Result()
+		return err == nil && n > 0
+	}
+	captchaPassedMutex.Lock()
+	defer captchaPassedMutex.Unlock()
+	return time.Now().Before(captchaPassed[ip])
+}
+
+func (h *Hub) markCaptchaVerified(ip string) {
+	if h.redis != nil {
+		if err := h.redis.Set(context.Background(), captchaKey(ip), 1, captchaTTL).Err(); err != nil {
+			log.Printf("CAPTCHA durumu kaydedilemedi: %v", err)
+		}
+		return
+	}
+	captchaPassedMutex.Lock()
+	captchaPassed[ip] = time.Now().Add(captchaTTL)
+	captchaPassedMutex.Unlock()
+}
+
+// verifyCaptchaToken validates the widget response with the provider's siteverify API
+func verifyCaptchaToken(token, ip string) (bool, error) {
+	form := url.Values{}
+	form.Set("secret", captchaSecret)
+	form.Set("response", token)
+	form.Set("remoteip", ip)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.PostForm(captchaVerifyURLs[captchaProvider], form)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	if !result.Success {
+		log.Printf("CAPTCHA doğrulaması reddedildi: ip=%s, hatalar=%v", ip, result.ErrorCodes)
+	}
+	return result.Success, nil
+}
+
+// handleCaptchaVerify accepts a CAPTCHA widget token and marks the caller's IP as verified
+func handleCaptchaVerify(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !captchaEnabled() {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+		return
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	ip := clientIP(r)
+	ok, err := verifyCaptchaToken(body.Token, ip)
+	if err != nil {
+		log.Printf("CAPTCHA doğrulama hatası: %v", err)
+		http.Error(w, "Error verifying captcha", http.StatusServiceUnavailable)
+		return
+	}
+	if !ok {
+		http.Error(w, "Captcha verification failed", http.StatusForbidden)
+		return
+	}
+
+	hub.markCaptchaVerified(ip)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// The members of a channel are the named users whose connections currently
+// follow it, taken from the presence states so the users of every instance
+// are listed. A client joining or subscribing gets the list as a members
+// frame, and the channel gets it again whenever someone enters or leaves.
+// Legacy clients following every channel aren't listed, and conversations
+// announce their members themselves.
+
+// MembersFrame lists the users connected to a channel
+type MembersFrame struct {
+	Type      string                  `json:"type"`
+	Channel   string                  `json:"channel"`
+	Members   []string                `json:"members"`
+	Statuses  map[string]CustomStatus `json:"statuses,omitempty"` // custom statuses of members who set one
+	Timestamp time.Time               `json:"timestamp"`
+}
+
+// channelMembers returns the usernames connected to a channel, sorted
+func (h *Hub) channelMembers(channel string) []string {
+	members := []string{}
+	for _, state := range h.presenceList(channel) {
+		if containsString(noticeChannels(state), channel) {
+			members = append(members, state.Username)
+		}
+	}
+	return members
+}
+
+func (h *Hub) membersFrame(channel string) ([]byte, error) {
+	members := h.channelMembers(channel)
+	return encodeFrame(MembersFrame{Type: "members", Channel: channel, Members: members, Statuses: h.customStatuses(members), Timestamp: time.Now()})
+}
+
+// sendMembers tells a client who is in a channel it joined
+func (h *Hub) sendMembers(c *Client, channel string) {
+	if isConversation(channel) {
+		return
+	}
+	frame, err := h.membersFrame(channel)
+	if err != nil {
+		return
+	}
+	c.enqueue(frame, PriorityLow)
+}
+
+// announceMembers sends the new member list to every channel a user entered
+// or left between two presence states
+func (h *Hub) announceMembers(previous, state PresenceState) {
+	before, after := noticeChannels(previous), noticeChannels(state)
+	for _, channel := range append(before, after...) {
+		if containsString(before, channel) == containsString(after, channel) {
+			continue
+		}
+		frame, err := h.membersFrame(channel)
+		if err != nil {
+			log.Printf("Kanal üyeleri gönderilemedi (%s): %v", channel, err)
+			continue
+		}
+		h.sendToChannel(channel, frame, PriorityLow, nil)
+	}
+}
+
+// handleChannelMembers serves GET /api/channels/{channel}/members
+func handleChannelMembers(hub *Hub, channel string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	members := hub.channelMembers(channel)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"channel":  channel,
+		"members":  members,
+		"statuses": hub.customStatuses(members),
+	})
+}
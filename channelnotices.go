@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// When the channels a named user follows change, the channels gained get a
+// "katıldı" and the ones lost an "ayrıldı" system message, stored like any
+// other message. The channels come from the user's presence, so a reconnect
+// within the grace window posts nothing, and neither does a restart shorter
+// than the presence refresh since the last state is read back from Redis.
+// Legacy clients following every channel and conversations, which announce
+// their own member changes, are left out.
+
+var channelNotices = envBool("CHANNEL_NOTICES", true)
+
+// noticeChannels are the channels a presence state counts as joined
+func noticeChannels(state PresenceState) []string {
+	if state.Status == PresenceOffline {
+		return nil
+	}
+	return state.Channels
+}
+
+// lastPresence reads the state another run of this instance left in Redis
+func (h *Hub) lastPresence(username string) (PresenceState, bool) {
+	if h.redis == nil {
+		return PresenceState{}, false
+	}
+	raw, err := h.redis.HGet(context.Background(), presenceKey, username).Result()
+	if err != nil {
+		return PresenceState{}, false
+	}
+	var stored storedPresence
+	if json.Unmarshal([]byte(raw), &stored) != nil || time.Since(stored.Updated) > 3*presenceRefreshInterval {
+		return PresenceState{}, false
+	}
+	return stored.PresenceState, true
+}
+
+// postChannelNotices posts the joins and leaves between two presence states
+func (h *Hub) postChannelNotices(username string, previous, state PresenceState) {
+	if !channelNotices {
+		return
+	}
+	before, after := noticeChannels(previous), noticeChannels(state)
+	for _, channel := range after {
+		if !containsString(before, channel) {
+			h.postSystemMessage(channel, fmt.Sprintf("%s kanala katıldı", username))
+		}
+	}
+	for _, channel := range before {
+		if !containsString(after, channel) {
+			h.postSystemMessage(channel, fmt.Sprintf("%s kanaldan ayrıldı", username))
+		}
+	}
+}
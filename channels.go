@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// handleChannelAPI routes /api/channels/{channel}/{resource} requests
+func handleChannelAPI(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/channels/"), "/"), "/")
+	// Conversations are only readable by their members over the socket
+	if len(parts) != 2 || parts[0] == "" || isConversation(parts[0]) {
+		http.NotFound(w, r)
+		return
+	}
+	channel, resource := parts[0], parts[1]
+
+	switch resource {
+	case "activity":
+		handleChannelActivity(hub, channel, w, r)
+	case "members":
+		handleChannelMembers(hub, channel, w, r)
+	case "pins":
+		handleChannelPins(hub, channel, w, r)
+	case "settings":
+		handleChannelSettings(hub, channel, w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Activity counters are partitioned per UTC day (hourly buckets) and per month
+// (daily buckets) so old data simply expires with its key
+func activityKey(channel, granularity string, t time.Time) string {
+	if granularity == "day" {
+		return fmt.Sprintf("websocket:activity:%s:day:%s", channel, t.Format("200601"))
+	}
+	return fmt.Sprintf("websocket:activity:%s:hour:%s", channel, t.Format("20060102"))
+}
+
+// recordActivity counts a stored message in the hourly and daily buckets
+func (h *Hub) recordActivity(channel string, t time.Time) {
+	if h.redis == nil {
+		return
+	}
+	t = t.UTC()
+	ctx := context.Background()
+	hourKey := activityKey(channel, "hour", t)
+	dayKey := activityKey(channel, "day", t)
+	pipe := h.redis.Pipeline()
+	pipe.HIncrBy(ctx, hourKey, strconv.Itoa(t.Hour()), 1)
+	pipe.Expire(ctx, hourKey, 8*24*time.Hour)
+	pipe.HIncrBy(ctx, dayKey, strconv.Itoa(t.Day()), 1)
+	pipe.Expire(ctx, dayKey, 400*24*time.Hour)
+	pipe.Exec(ctx)
+}
+
+// ActivityBucket is the number of messages in the bucket starting at Start
+type ActivityBucket struct {
+	Start time.Time `json:"start"`
+	Count int64     `json:"count"`
+}
+
+// handleChannelActivity serves GET /api/channels/{channel}/activity?granularity=hour|day
+func handleChannelActivity(hub *Hub, channel string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	granularity := r.URL.Query().Get("granularity")
+	var step time.Duration
+	var count, maxCount int
+	switch granularity {
+	case "", "hour":
+		granularity, step, count, maxCount = "hour", time.Hour, 24, 7*24
+	case "day":
+		granularity, step, count, maxCount = "day", 24*time.Hour, 30, 365
+	default:
+		http.Error(w, "granularity must be hour or day", http.StatusBadRequest)
+		return
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("buckets")); err == nil && n > 0 {
+		count = min(n, maxCount)
+	}
+
+	now := time.Now().UTC()
+	last := now.Truncate(step)
+	if granularity == "day" {
+		last = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+
+	buckets := make([]ActivityBucket, count)
+	for i := range buckets {
+		buckets[i].Start = last.Add(-time.Duration(count-1-i) * step)
+	}
+
+	if hub.redis != nil {
+		ctx := context.Background()
+		pipe := hub.redis.Pipeline()
+		cmds := make([]*redis.StringCmd, len(buckets))
+		for i, b := range buckets {
+			field := strconv.Itoa(b.Start.Hour())
+			if granularity == "day" {
+				field = strconv.Itoa(b.Start.Day())
+			}
+			cmds[i] = pipe.HGet(ctx, activityKey(channel, granularity, b.Start), field)
+		}
+		pipe.Exec(ctx)
+		for i, cmd := range cmds {
+			if n, err := cmd.Int64(); err == nil {
+				buckets[i].Count = n
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"channel":     channel,
+		"granularity": granularity,
+		"buckets":     buckets,
+	})
+}
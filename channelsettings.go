@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Channels have settings admins change through the channel API. The content
+// mode restricts what a channel accepts: "media-only" for photo channels,
+// "emoji-only" for reaction channels. Rejected messages get a content_mode
+// error explaining the mode.
+
+const (
+	contentModeAny       = ""
+	contentModeMediaOnly = "media-only"
+	contentModeEmojiOnly = "emoji-only"
+)
+
+// ChannelSettings are the stored settings of a channel
+type ChannelSettings struct {
+	ContentMode        string    `json:"contentMode"`                  // "", "media-only" or "emoji-only"
+	FirstMessageReview int       `json:"firstMessageReview,omitempty"` // messages of new accounts held for a moderator, see review.go
+	Timezone           string    `json:"timezone,omitempty"`           // IANA name days are counted in, see daymarkers.go
+	UpdatedBy          string    `json:"updatedBy,omitempty"`
+	UpdatedAt          time.Time `json:"updatedAt,omitempty"`
+}
+
+func channelSettingsKey(channel string) string {
+	return "websocket:channel:settings:" + channel
+}
+
+// channelSettings returns the settings of a channel; defaults when none are stored
+func (h *Hub) channelSettings(channel string) ChannelSettings {
+	var settings ChannelSettings
+	if h.redis == nil {
+		return settings
+	}
+	raw, err := h.redis.Get(context.Background(), channelSettingsKey(channel)).Result()
+	if err == nil {
+		json.Unmarshal([]byte(raw), &settings)
+	}
+	return settings
+}
+
+// isEmojiText reports whether text holds only emoji and spaces. Emoji are
+// symbols plus the joiners, variation selectors, skin tones, keycaps and tags
+// that combine them.
+func isEmojiText(text string) bool {
+	found := false
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+		case r == 0x200D || r == 0x20E3 || (r >= 0xFE00 && r <= 0xFE0F) || (r >= 0xE0020 && r <= 0xE007F):
+		case r >= 0x1F3FB && r <= 0x1F3FF:
+		case unicode.Is(unicode.So, r):
+			found = true
+		default:
+			return false
+		}
+	}
+	return found
+}
+
+// contentModeRejection explains why a message of the given type and text
+// doesn't fit a content mode; empty when it does
+func contentModeRejection(mode, msgType, text string) string {
+	switch mode {
+	case contentModeMediaOnly:
+		if msgType != "file" && msgType != "image" {
+			return "Bu kanal yalnızca fotoğraf ve dosya paylaşımı içindir"
+		}
+	case contentModeEmojiOnly:
+		if msgType != "text" || !isEmojiText(text) {
+			return "Bu kanalda yalnızca emoji gönderilebilir"
+		}
+	}
+	return ""
+}
+
+// contentModeStage rejects messages that don't fit the channel's content mode
+func contentModeStage(h *Hub, m *pipelineMessage) bool {
+	if m.Msg.Type == "seen" || m.Msg.Message == "__GET_RECENT_MESSAGES__" {
+		return true
+	}
+	mode := h.channelSettings(m.Msg.Channel).ContentMode
+	if reason := contentModeRejection(mode, m.Msg.Type, m.Msg.Message); reason != "" {
+		m.reject(ErrContentMode, reason)
+		return false
+	}
+	return true
+}
+
+// handleChannelSettings serves GET /api/channels/{channel}/settings and, for
+// admins, PUT with the new settings
+func handleChannelSettings(hub *Hub, channel string, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, hub.channelSettings(channel))
+	case "PUT":
+		requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+			updateChannelSettings(hub, channel, w, r)
+		})(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func updateChannelSettings(hub *Hub, channel string, w http.ResponseWriter, r *http.Request) {
+	if hub.redis == nil {
+		http.Error(w, "Channel settings require Redis", http.StatusServiceUnavailable)
+		return
+	}
+	var settings ChannelSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	settings.ContentMode = strings.ToLower(strings.TrimSpace(settings.ContentMode))
+	switch settings.ContentMode {
+	case contentModeAny, contentModeMediaOnly, contentModeEmojiOnly:
+	default:
+		http.Error(w, "Invalid content mode", http.StatusBadRequest)
+		return
+	}
+	if settings.FirstMessageReview < 0 || settings.FirstMessageReview > maxFirstMessageReview {
+		http.Error(w, "Invalid first message review", http.StatusBadRequest)
+		return
+	}
+	settings.Timezone = strings.TrimSpace(settings.Timezone)
+	if _, err := time.LoadLocation(settings.Timezone); err != nil {
+		http.Error(w, "Invalid time zone", http.StatusBadRequest)
+		return
+	}
+	admin, _ := adminFromRequest(r)
+	settings.UpdatedBy, settings.UpdatedAt = admin, time.Now()
+	data, err := json.Marshal(settings)
+	if err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := hub.redis.Set(context.Background(), channelSettingsKey(channel), data, 0).Err(); err != nil {
+		http.Error(w, "Error saving channel settings", http.StatusInternalServerError)
+		return
+	}
+	hub.recordAudit("channel_settings", admin, channel, fmt.Sprintf("contentMode=%s, firstMessageReview=%d, timezone=%s", settings.ContentMode, settings.FirstMessageReview, settings.Timezone))
+	writeJSON(w, http.StatusOK, settings)
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A channel's setup is its settings, moderators, slash commands, reaction
+// triggers and pinned messages. Cloning copies the setup of a channel to a new
+// one, without history. Templates are named setups, saved from a channel or
+// written by an admin, that new channels are created from, e.g. one per
+// onboarded team.
+
+// Templates by name, as JSON ChannelTemplate values
+const channelTemplatesKey = "websocket:channel:templates"
+
+var templateNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// TemplatePin is a message a template posts and pins. Pins saved from a
+// channel keep the original, posted as a forwarded copy of it.
+type TemplatePin struct {
+	Username string   `json:"username"`
+	Message  string   `json:"message"`
+	Original *Message `json:"original,omitempty"`
+}
+
+// ChannelTemplate is the setup of a channel
+type ChannelTemplate struct {
+	Name               string            `json:"name,omitempty"`
+	ContentMode        string            `json:"contentMode,omitempty"`
+	FirstMessageReview int               `json:"firstMessageReview,omitempty"`
+	Moderators         []string          `json:"moderators,omitempty"`
+	Commands           []SlashCommand    `json:"commands,omitempty"`
+	Triggers           []ReactionTrigger `json:"triggers,omitempty"`
+	Pins               []TemplatePin     `json:"pins,omitempty"`
+	UpdatedBy          string            `json:"updatedBy,omitempty"`
+	UpdatedAt          time.Time         `json:"updatedAt,omitempty"`
+}
+
+// CloneRequest is the body of POST /admin/channels/{channel}/clone: To names
+// the new channel, or SaveAs a template to store the setup as
+type CloneRequest struct {
+	To      string `json:"to"`
+	Members bool   `json:"members"` // subscribe the channel's connected members to the clone
+	SaveAs  string `json:"saveAs"`
+}
+
+// ChannelSetupResult reports what a clone or template created
+type ChannelSetupResult struct {
+	Channel     string `json:"channel"`
+	ContentMode string `json:"contentMode,omitempty"`
+	Moderators  int    `json:"moderators"`
+	Commands    int    `json:"commands"`
+	Triggers    int    `json:"triggers"`
+	Pins        int    `json:"pins"`
+	Members     int    `json:"members"`
+	// New secrets by "/command" or trigger ID, shown only here
+	Secrets map[string]string `json:"secrets,omitempty"`
+}
+
+// channelSetup reads the setup of a channel as a template
+func (h *Hub) channelSetup(channel string) (ChannelTemplate, error) {
+	var setup ChannelTemplate
+	settings := h.channelSettings(channel)
+	setup.ContentMode, setup.FirstMessageReview = settings.ContentMode, settings.FirstMessageReview
+	moderators, err := h.redis.SMembers(context.Background(), moderatorsKey(channel)).Result()
+	if err != nil {
+		return setup, err
+	}
+	sort.Strings(moderators)
+	setup.Moderators = moderators
+	if setup.Commands, err = h.channelCommands(channel); err != nil {
+		return setup, err
+	}
+	setup.Triggers = h.channelTriggers(channel)
+	pins, err := h.channelPins(channel)
+	if err != nil {
+		return setup, err
+	}
+	// Oldest pin first, so the copies are pinned in the original order
+	for i := len(pins) - 1; i >= 0; i-- {
+		msg := pins[i].Message
+		setup.Pins = append(setup.Pins, TemplatePin{Username: msg.Username, Message: msg.Message, Original: &msg})
+	}
+	return setup, nil
+}
+
+// channelInUse reports whether a channel has history or a setup already
+func (h *Hub) channelInUse(channel string) (bool, error) {
+	n, err := h.redis.Exists(context.Background(),
+		sequenceKey(channel), channelSettingsKey(channel), moderatorsKey(channel),
+		commandsKey(channel), triggersKey(channel), pinsKey(channel)).Result()
+	return n > 0, err
+}
+
+// applySetup gives an unused channel the setup of a template. Commands and
+// webhook triggers keep their secrets so their endpoints keep verifying them;
+// ones without a secret get a new one.
+func (h *Hub) applySetup(channel string, setup ChannelTemplate, actor string) (ChannelSetupResult, error) {
+	ctx := context.Background()
+	now := time.Now()
+	result := ChannelSetupResult{Channel: channel, ContentMode: setup.ContentMode}
+	pipe := h.redis.TxPipeline()
+	if setup.ContentMode != contentModeAny || setup.FirstMessageReview > 0 {
+		data, _ := json.Marshal(ChannelSettings{ContentMode: setup.ContentMode, FirstMessageReview: setup.FirstMessageReview, UpdatedBy: actor, UpdatedAt: now})
+		pipe.Set(ctx, channelSettingsKey(channel), data, 0)
+	}
+	for _, username := range setup.Moderators {
+		pipe.SAdd(ctx, moderatorsKey(channel), username)
+		result.Moderators++
+	}
+	for _, cmd := range setup.Commands {
+		cmd.Channel, cmd.CreatedBy, cmd.CreatedAt = channel, actor, now
+		if cmd.Secret == "" {
+			cmd.Secret = newHookSecret()
+			result.addSecret("/"+cmd.Name, cmd.Secret)
+		}
+		data, _ := json.Marshal(cmd)
+		pipe.HSet(ctx, commandsKey(channel), cmd.Name, data)
+		result.Commands++
+	}
+	for _, t := range setup.Triggers {
+		t.ID, t.Channel, t.CreatedBy, t.CreatedAt = newULID(), channel, actor, now
+		if t.Action == "webhook" && t.Secret == "" {
+			t.Secret = newHookSecret()
+			result.addSecret(t.ID, t.Secret)
+		}
+		data, _ := json.Marshal(t)
+		pipe.HSet(ctx, triggersKey(channel), t.ID, data)
+		result.Triggers++
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return result, err
+	}
+
+	for _, pin := range setup.Pins {
+		var posted Message
+		var err error
+		if pin.Original != nil {
+			posted, err = h.forwardMessage(*pin.Original, channel)
+		} else {
+			posted, err = h.postTemplateMessage(pin, channel)
+		}
+		if err == nil {
+			err = h.pinMessage(posted, actor)
+		}
+		if err != nil {
+			log.Printf("Şablon mesajı sabitlenemedi (%s): %v", channel, err)
+			continue
+		}
+		result.Pins++
+	}
+	return result, nil
+}
+
+func (r *ChannelSetupResult) addSecret(name, secret string) {
+	if r.Secrets == nil {
+		r.Secrets = make(map[string]string)
+	}
+	r.Secrets[name] = secret
+}
+
+// postTemplateMessage posts a template's pin text as a new message
+func (h *Hub) postTemplateMessage(pin TemplatePin, channel string) (Message, error) {
+	posted := Message{
+		MessageID: newULID(),
+		Username:  pin.Username,
+		Message:   pin.Message,
+		Timestamp: time.Now(),
+		Channel:   channel,
+		Type:      "text",
+	}
+	frame, err := json.Marshal(posted)
+	if err != nil {
+		return posted, err
+	}
+	h.broadcast <- inboundMessage{data: frame}
+	return posted, nil
+}
+
+// subscribeMembers subscribes the connected members of one channel to another
+func (h *Hub) subscribeMembers(from, to string) int {
+	h.mutex.RLock()
+	var members []*Client
+	for client := range h.clients {
+		// Legacy clients follow every channel anyway
+		if !client.followsAll() && client.inChannel(from) {
+			members = append(members, client)
+		}
+	}
+	h.mutex.RUnlock()
+	subscribed := 0
+	for _, client := range members {
+		if client.subscribe(to) {
+			client.sendFrame(SubscriptionsFrame{Type: "subscriptions", Channels: client.subscriptions(), Timestamp: time.Now()}, PriorityNormal)
+			subscribed++
+		}
+	}
+	return subscribed
+}
+
+func newHookSecret() string {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return hex.EncodeToString(secret)
+}
+
+// validateTemplate checks a template an admin wrote like the admin APIs check
+// each part
+func validateTemplate(t *ChannelTemplate) error {
+	if !templateNamePattern.MatchString(t.Name) {
+		return fmt.Errorf("invalid template name")
+	}
+	switch t.ContentMode {
+	case contentModeAny, contentModeMediaOnly, contentModeEmojiOnly:
+	default:
+		return fmt.Errorf("invalid content mode")
+	}
+	if t.FirstMessageReview < 0 || t.FirstMessageReview > maxFirstMessageReview {
+		return fmt.Errorf("invalid first message review")
+	}
+	for i, cmd := range t.Commands {
+		t.Commands[i].Name = strings.ToLower(strings.TrimPrefix(cmd.Name, "/"))
+		if !commandNamePattern.MatchString(t.Commands[i].Name) || !validHookURL(cmd.URL) {
+			return fmt.Errorf("invalid command /%s", cmd.Name)
+		}
+	}
+	for _, trigger := range t.Triggers {
+		valid := validEmoji(trigger.Emoji)
+		switch trigger.Action {
+		case "pin":
+		case "forward":
+			valid = valid && trigger.Target != ""
+		case "webhook":
+			valid = valid && validHookURL(trigger.URL)
+		default:
+			valid = false
+		}
+		if !valid {
+			return fmt.Errorf("invalid trigger %s %s", trigger.Emoji, trigger.Action)
+		}
+	}
+	for _, pin := range t.Pins {
+		if pin.Username == "" || strings.TrimSpace(pin.Message) == "" {
+			return fmt.Errorf("pins need a username and a message")
+		}
+	}
+	return nil
+}
+
+// handleAdminChannel routes /admin/channels/{channel}/{action}
+func handleAdminChannel(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/channels/"), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if hub.redis == nil {
+		http.Error(w, "Channel setup requires Redis", http.StatusServiceUnavailable)
+		return
+	}
+	switch parts[1] {
+	case "clone":
+		handleChannelClone(hub, parts[0], w, r)
+	case "create":
+		handleChannelCreate(hub, parts[0], w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleChannelClone serves POST /admin/channels/{channel}/clone
+func handleChannelClone(hub *Hub, channel string, w http.ResponseWriter, r *http.Request) {
+	var req CloneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.To == "") == (req.SaveAs == "") {
+		http.Error(w, "Invalid request, give either to or saveAs", http.StatusBadRequest)
+		return
+	}
+	admin, _ := adminFromRequest(r)
+	setup, err := hub.channelSetup(channel)
+	if err != nil {
+		http.Error(w, "Error reading channel", http.StatusInternalServerError)
+		return
+	}
+
+	if req.SaveAs != "" {
+		setup.Name, setup.UpdatedBy, setup.UpdatedAt = req.SaveAs, admin, time.Now()
+		if !templateNamePattern.MatchString(setup.Name) {
+			http.Error(w, "Invalid template name", http.StatusBadRequest)
+			return
+		}
+		if err := hub.saveChannelTemplate(setup); err != nil {
+			http.Error(w, "Error saving template", http.StatusInternalServerError)
+			return
+		}
+		hub.recordAudit("channel_template_saved", admin, channel, setup.Name)
+		writeJSON(w, http.StatusCreated, redactTemplate(setup))
+		return
+	}
+
+	if req.To == channel || isConversation(req.To) {
+		http.Error(w, "Invalid target channel", http.StatusBadRequest)
+		return
+	}
+	if inUse, err := hub.channelInUse(req.To); err != nil || inUse {
+		http.Error(w, "Channel already exists", http.StatusConflict)
+		return
+	}
+	result, err := hub.applySetup(req.To, setup, admin)
+	if err != nil {
+		http.Error(w, "Error cloning channel", http.StatusInternalServerError)
+		return
+	}
+	if req.Members {
+		result.Members = hub.subscribeMembers(channel, req.To)
+	}
+	hub.recordAudit("channel_cloned", admin, req.To, "from="+channel)
+	writeJSON(w, http.StatusCreated, result)
+}
+
+// handleChannelCreate serves POST /admin/channels/{channel}/create with
+// {"template":"..."}
+func handleChannelCreate(hub *Hub, channel string, w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Template string `json:"template"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Template == "" {
+		http.Error(w, "template required", http.StatusBadRequest)
+		return
+	}
+	if isConversation(channel) {
+		http.Error(w, "Invalid channel", http.StatusBadRequest)
+		return
+	}
+	setup, ok := hub.getChannelTemplate(req.Template)
+	if !ok {
+		http.Error(w, "Unknown template", http.StatusNotFound)
+		return
+	}
+	if inUse, err := hub.channelInUse(channel); err != nil || inUse {
+		http.Error(w, "Channel already exists", http.StatusConflict)
+		return
+	}
+	admin, _ := adminFromRequest(r)
+	result, err := hub.applySetup(channel, setup, admin)
+	if err != nil {
+		http.Error(w, "Error creating channel", http.StatusInternalServerError)
+		return
+	}
+	hub.recordAudit("channel_created", admin, channel, "template="+req.Template)
+	writeJSON(w, http.StatusCreated, result)
+}
+
+func (h *Hub) saveChannelTemplate(t ChannelTemplate) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return h.redis.HSet(context.Background(), channelTemplatesKey, t.Name, data).Err()
+}
+
+func (h *Hub) getChannelTemplate(name string) (ChannelTemplate, bool) {
+	var t ChannelTemplate
+	raw, err := h.redis.HGet(context.Background(), channelTemplatesKey, name).Result()
+	return t, err == nil && json.Unmarshal([]byte(raw), &t) == nil
+}
+
+// redactTemplate hides the secrets of a template's commands and triggers
+func redactTemplate(t ChannelTemplate) ChannelTemplate {
+	t.Commands = append([]SlashCommand(nil), t.Commands...)
+	for i := range t.Commands {
+		t.Commands[i].Secret = ""
+	}
+	t.Triggers = append([]ReactionTrigger(nil), t.Triggers...)
+	for i := range t.Triggers {
+		t.Triggers[i].Secret = ""
+	}
+	return t
+}
+
+// handleAdminChannelTemplates serves /admin/channel-templates: GET lists the
+// templates, PUT saves one, DELETE ?name= removes one
+func handleAdminChannelTemplates(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if hub.redis == nil {
+		http.Error(w, "Channel templates require Redis", http.StatusServiceUnavailable)
+		return
+	}
+	ctx := context.Background()
+	admin, _ := adminFromRequest(r)
+	switch r.Method {
+	case "GET":
+		stored, err := hub.redis.HVals(ctx, channelTemplatesKey).Result()
+		if err != nil {
+			http.Error(w, "Error reading templates", http.StatusInternalServerError)
+			return
+		}
+		templates := make([]ChannelTemplate, 0, len(stored))
+		for _, raw := range stored {
+			var t ChannelTemplate
+			if json.Unmarshal([]byte(raw), &t) == nil {
+				templates = append(templates, redactTemplate(t))
+			}
+		}
+		sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+		writeJSON(w, http.StatusOK, templates)
+	case "PUT":
+		var t ChannelTemplate
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := validateTemplate(&t); err != nil {
+			http.Error(w, "Invalid template: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Secrets are made when the template is applied, pins always post new messages
+		for i := range t.Commands {
+			t.Commands[i].Secret = ""
+		}
+		for i := range t.Triggers {
+			t.Triggers[i].Secret = ""
+		}
+		for i := range t.Pins {
+			t.Pins[i].Original = nil
+		}
+		t.UpdatedBy, t.UpdatedAt = admin, time.Now()
+		if err := hub.saveChannelTemplate(t); err != nil {
+			http.Error(w, "Error saving template", http.StatusInternalServerError)
+			return
+		}
+		hub.recordAudit("channel_template_saved", admin, "", t.Name)
+		writeJSON(w, http.StatusOK, t)
+	case "DELETE":
+		name := r.URL.Query().Get("name")
+		removed, err := hub.redis.HDel(ctx, channelTemplatesKey, name).Result()
+		if err != nil {
+			http.Error(w, "Error removing template", http.StatusInternalServerError)
+			return
+		}
+		if removed == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		hub.recordAudit("channel_template_removed", admin, "", name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,12 @@
+// Package chatpb holds the protobuf schema of the chat protocol and its
+// generated Go types.
+package chatpb
+
+import _ "embed"
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative chat.proto
+
+// Schema is the chat.proto source, served to clients at /static/chat.proto
+//
+//go:embed chat.proto
+var Schema []byte
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Admins register slash commands per channel, backed by a URL like Slack's
+// outgoing commands. "/deploy prod" in that channel isn't sent as a message:
+// the server POSTs a signed payload to the command's URL and posts the reply
+// back into the channel, or only to the caller when the reply is ephemeral.
+// Replies may carry attachments with buttons, see attachments.go.
+
+var (
+	// How long a command's endpoint has to answer
+	commandTimeout = envDuration("COMMAND_TIMEOUT", 5*time.Second)
+	// Largest reply body read from an endpoint
+	commandMaxResponse = int64(envInt("COMMAND_MAX_RESPONSE_BYTES", 16*1024))
+)
+
+var commandNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// SlashCommand is a command registered for a channel. Secret signs the
+// requests to URL and is only shown when the command is registered.
+type SlashCommand struct {
+	Name        string    `json:"name"`
+	Channel     string    `json:"channel"`
+	URL         string    `json:"url"`
+	Description string    `json:"description,omitempty"`
+	Secret      string    `json:"secret,omitempty"`
+	CreatedBy   string    `json:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// CommandRequest is the JSON body POSTed to a command's URL
+type CommandRequest struct {
+	Type      string    `json:"type"`    // "command"
+	Command   string    `json:"command"` // "/deploy"
+	Text      string    `json:"text"`    // everything after the command name
+	Channel   string    `json:"channel"`
+	Username  string    `json:"username"`
+	UserID    string    `json:"userId"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// CommandResponse is what an endpoint answers; a plain text body counts as Text
+type CommandResponse struct {
+	Text        string              `json:"text"`
+	Attachments []MessageAttachment `json:"attachments,omitempty"`
+	Ephemeral   bool                `json:"ephemeral"` // only the caller sees the reply
+}
+
+// CommandResultFrame carries an ephemeral reply to the caller
+type CommandResultFrame struct {
+	Type        string              `json:"type"`
+	Command     string              `json:"command"`
+	Channel     string              `json:"channel"`
+	Text        string              `json:"text"`
+	Attachments []MessageAttachment `json:"attachments,omitempty"`
+	Timestamp   time.Time           `json:"timestamp"`
+}
+
+// Commands of a channel by name, as JSON SlashCommand values
+func commandsKey(channel string) string {
+	return "websocket:commands:" + channel
+}
+
+// parseSlashCommand splits "/name rest" into the command name and its text
+func parseSlashCommand(text string) (string, string, bool) {
+	if !strings.HasPrefix(text, "/") {
+		return "", "", false
+	}
+	name, rest, _ := strings.Cut(strings.TrimPrefix(text, "/"), " ")
+	name = strings.ToLower(name)
+	if !commandNamePattern.MatchString(name) {
+		return "", "", false
+	}
+	return name, strings.TrimSpace(rest), true
+}
+
+// lookupCommand returns the command registered for a channel under name
+func (h *Hub) lookupCommand(channel, name string) (SlashCommand, bool) {
+	var cmd SlashCommand
+	if h.redis == nil {
+		return cmd, false
+	}
+	raw, err := h.redis.HGet(context.Background(), commandsKey(channel), name).Result()
+	if err != nil || json.Unmarshal([]byte(raw), &cmd) != nil {
+		return cmd, false
+	}
+	return cmd, true
+}
+
+// slashStage runs registered commands instead of sending them; other text
+// starting with "/" is sent as usual
+func slashStage(h *Hub, m *pipelineMessage) bool {
+	if m.Msg.Type != "text" {
+		return true
+	}
+	name, text, ok := parseSlashCommand(m.Msg.Message)
+	if !ok {
+		return true
+	}
+	cmd, ok := h.lookupCommand(m.Msg.Channel, name)
+	if !ok {
+		return true
+	}
+	if m.ClientMsgID != "" {
+		m.Client.sendFrame(AckFrame{
+			Type:        "ack",
+			ClientMsgID: m.ClientMsgID,
+			Channel:     m.Msg.Channel,
+			Status:      "command",
+			Timestamp:   time.Now(),
+		}, PriorityHigh)
+	}
+	go h.runCommand(m.Client, cmd, text)
+	return false
+}
+
+// signCommandRequest signs "v1:<timestamp>:<body>" with the command's secret,
+// the value of the X-Chat-Signature header
+func signCommandRequest(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v1:" + timestamp + ":"))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// runCommand calls a command's endpoint and delivers its reply
+func (h *Hub) runCommand(c *Client, cmd SlashCommand, text string) {
+	now := time.Now()
+	reply, err := callCommand(cmd, CommandRequest{
+		Type:      "command",
+		Command:   "/" + cmd.Name,
+		Text:      text,
+		Channel:   cmd.Channel,
+		Username:  c.Username,
+		UserID:    c.ID,
+		Timestamp: now,
+	}, now)
+	if err != nil {
+		h.commandFailed(c, cmd, err)
+		return
+	}
+	h.deliverCommandReply(c, cmd, reply)
+}
+
+// commandFailed logs a failed call and tells the caller
+func (h *Hub) commandFailed(c *Client, cmd SlashCommand, err error) {
+	log.Printf("Komut çalıştırılamadı: /%s (%s): %v", cmd.Name, cmd.Channel, err)
+	c.sendError(ErrCommandFailed, fmt.Sprintf("/%s komutu yanıt vermedi", cmd.Name))
+}
+
+// deliverCommandReply posts a bot's reply to the channel, or sends it only to
+// the caller when it's ephemeral
+func (h *Hub) deliverCommandReply(c *Client, cmd SlashCommand, reply CommandResponse) {
+	attachments := sanitizeAttachments(reply.Attachments)
+	if reply.Text == "" && len(attachments) == 0 {
+		return
+	}
+	if reply.Ephemeral {
+		c.sendFrame(CommandResultFrame{
+			Type:        "command_result",
+			Command:     "/" + cmd.Name,
+			Channel:     cmd.Channel,
+			Text:        reply.Text,
+			Attachments: attachments,
+			Timestamp:   time.Now(),
+		}, PriorityNormal)
+		return
+	}
+	frame, err := json.Marshal(Message{
+		MessageID:   newULID(),
+		Username:    "/" + cmd.Name,
+		Message:     reply.Text,
+		Timestamp:   time.Now(),
+		Channel:     cmd.Channel,
+		Type:        "text",
+		Attachments: attachments,
+		Bot:         cmd.Name,
+	})
+	if err != nil {
+		return
+	}
+	h.broadcast <- inboundMessage{data: frame}
+}
+
+// callCommand POSTs a signed command or interaction payload to the command's
+// URL and reads the reply
+func callCommand(cmd SlashCommand, payload interface{}, at time.Time) (CommandResponse, error) {
+	var reply CommandResponse
+	resp, err := postSigned(cmd.URL, cmd.Secret, payload, at)
+	if err != nil {
+		return reply, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, commandMaxResponse))
+	if err != nil {
+		return reply, err
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		err = json.Unmarshal(data, &reply)
+		return reply, err
+	}
+	reply.Text = strings.TrimSpace(string(data))
+	return reply, nil
+}
+
+// validHookURL reports whether raw is an absolute http(s) URL
+func validHookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// postSigned POSTs payload as JSON, signed with secret, and fails on non-2xx
+// answers; the caller closes the body
+func postSigned(target, secret string, payload interface{}, at time.Time) (*http.Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Chat-Timestamp", timestamp)
+	req.Header.Set("X-Chat-Signature", signCommandRequest(secret, timestamp, body))
+
+	client := &http.Client{Timeout: commandTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// channelCommands returns the commands of a channel by name
+func (h *Hub) channelCommands(channel string) ([]SlashCommand, error) {
+	stored, err := h.redis.HVals(context.Background(), commandsKey(channel)).Result()
+	if err != nil {
+		return nil, err
+	}
+	commands := make([]SlashCommand, 0, len(stored))
+	for _, raw := range stored {
+		var cmd SlashCommand
+		if json.Unmarshal([]byte(raw), &cmd) == nil {
+			commands = append(commands, cmd)
+		}
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands, nil
+}
+
+// handleAdminCommands serves /admin/commands: GET ?channel= lists a channel's
+// commands, POST registers or replaces one, DELETE ?channel=&name= removes one
+func handleAdminCommands(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if hub.redis == nil {
+		http.Error(w, "Commands require Redis", http.StatusServiceUnavailable)
+		return
+	}
+	ctx := context.Background()
+	admin, _ := adminFromRequest(r)
+	switch r.Method {
+	case "GET":
+		channel := r.URL.Query().Get("channel")
+		if channel == "" {
+			http.Error(w, "channel required", http.StatusBadRequest)
+			return
+		}
+		commands, err := hub.channelCommands(channel)
+		if err != nil {
+			http.Error(w, "Error reading commands", http.StatusInternalServerError)
+			return
+		}
+		for i := range commands {
+			commands[i].Secret = ""
+		}
+		writeJSON(w, http.StatusOK, commands)
+	case "POST":
+		var cmd SlashCommand
+		if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil || cmd.Channel == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		cmd.Name = strings.ToLower(strings.TrimPrefix(cmd.Name, "/"))
+		if !commandNamePattern.MatchString(cmd.Name) {
+			http.Error(w, "Invalid command name", http.StatusBadRequest)
+			return
+		}
+		if !validHookURL(cmd.URL) {
+			http.Error(w, "Invalid command URL", http.StatusBadRequest)
+			return
+		}
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			http.Error(w, "Error creating secret", http.StatusInternalServerError)
+			return
+		}
+		cmd.Secret = hex.EncodeToString(secret)
+		cmd.CreatedBy, cmd.CreatedAt = admin, time.Now()
+		data, err := json.Marshal(cmd)
+		if err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := hub.redis.HSet(ctx, commandsKey(cmd.Channel), cmd.Name, data).Err(); err != nil {
+			http.Error(w, "Error saving command", http.StatusInternalServerError)
+			return
+		}
+		hub.recordAudit("command_registered", admin, cmd.Channel, fmt.Sprintf("/%s -> %s", cmd.Name, cmd.URL))
+		writeJSON(w, http.StatusCreated, cmd)
+	case "DELETE":
+		channel, name := r.URL.Query().Get("channel"), r.URL.Query().Get("name")
+		removed, err := hub.redis.HDel(ctx, commandsKey(channel), strings.TrimPrefix(name, "/")).Result()
+		if err != nil {
+			http.Error(w, "Error removing command", http.StatusInternalServerError)
+			return
+		}
+		if removed == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		hub.recordAudit("command_removed", admin, channel, "/"+strings.TrimPrefix(name, "/"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envString returns the environment variable or the given default
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt parses an integer environment variable, falling back to def
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("%s geçersiz (%q), varsayılan kullanılıyor: %d", name, v, def)
+		return def
+	}
+	return n
+}
+
+// envDuration parses a duration environment variable such as "5m" or "30s"
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("%s geçersiz (%q), varsayılan kullanılıyor: %s", name, v, def)
+		return def
+	}
+	return d
+}
+
+// envBool parses a boolean environment variable ("1", "true", "yes")
+func envBool(name string, def bool) bool {
+	switch strings.ToLower(os.Getenv(name)) {
+	case "":
+		return def
+	case "1", "true", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
+
+// envList splits a comma separated environment variable, dropping empty items
+func envList(name string) []string {
+	var out []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// envListOr is envList with a default for an unset variable
+func envListOr(name string, def []string) []string {
+	if list := envList(name); len(list) > 0 {
+		return list
+	}
+	return def
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Every QUALITY_CHECK_INTERVAL each connection is rated good, degraded or poor
+// from what the server sees of it: WebSocket pongs that come late or not at
+// all, its normal lane filling up, presence frames dropped because it fell
+// behind, and the round trip the client last reported. When the rating
+// changes the client gets a connection_quality frame with the reasons, so it
+// can show an indicator and send less, e.g. switch to lite mode, until the
+// connection is good again.
+
+var (
+	qualityCheckInterval = envDuration("QUALITY_CHECK_INTERVAL", 5*time.Second)
+	// A pong arriving later than this after its ping counts as late
+	qualityPongTimeout = envDuration("QUALITY_PONG_TIMEOUT", 10*time.Second)
+	qualityDegradedRTT = envDuration("QUALITY_DEGRADED_RTT", 500*time.Millisecond)
+	qualityPoorRTT     = envDuration("QUALITY_POOR_RTT", 2*time.Second)
+)
+
+const (
+	QualityGood     = "good"
+	QualityDegraded = "degraded"
+	QualityPoor     = "poor"
+
+	qualityRTTMaxAge   = time.Minute // older round trip reports are ignored
+	qualityPoorDropped = 50          // dropped frames between two checks
+)
+
+// Reasons of a degraded or poor rating, as sent to the client
+const (
+	QualityPongTimeout = "pong_timeout"
+	QualityLatePongs   = "late_pongs"
+	QualitySendBuffer  = "send_buffer"
+	QualityDropped     = "dropped_frames"
+	QualityHighRTT     = "high_rtt"
+)
+
+// Rating changes per new rating
+var connectionQualityChanges = expvar.NewMap("connection_quality_changes")
+
+// ConnectionQualityFrame tells a client how the server rates its connection
+type ConnectionQualityFrame struct {
+	Type      string    `json:"type"`
+	Quality   string    `json:"quality"`
+	Reasons   []string  `json:"reasons,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// pingSent records a WebSocket ping. WebTransport sessions have no pings, so
+// only WebSocket connections are rated on pongs.
+func (c *Client) pingSent() {
+	if _, ok := c.Conn.(*websocket.Conn); ok {
+		c.pingAt.Store(time.Now().UnixNano())
+	}
+}
+
+// pongReceived records a pong and whether it came late; a timely pong clears
+// the count of late ones
+func (c *Client) pongReceived() {
+	now := time.Now().UnixNano()
+	if sent := c.pingAt.Load(); sent != 0 && now-sent > int64(qualityPongTimeout) {
+		c.latePongs.Add(1)
+	} else {
+		c.latePongs.Store(0)
+	}
+	c.pongAt.Store(now)
+}
+
+// assessQuality rates a connection; dropped is the number of frames dropped
+// since the previous check
+func (c *Client) assessQuality(now time.Time, dropped int64) (string, []string) {
+	quality := QualityGood
+	var reasons []string
+	rate := func(q, reason string) {
+		if q == QualityPoor || quality == QualityGood {
+			quality = q
+		}
+		reasons = append(reasons, reason)
+	}
+
+	if sent := c.pingAt.Load(); sent > c.pongAt.Load() && now.UnixNano()-sent > int64(qualityPongTimeout) {
+		rate(QualityPoor, QualityPongTimeout)
+	} else if late := c.latePongs.Load(); late >= 2 {
+		rate(QualityPoor, QualityLatePongs)
+	} else if late == 1 {
+		rate(QualityDegraded, QualityLatePongs)
+	}
+
+	if fill := float64(len(c.Send)) / float64(cap(c.Send)); fill >= 0.75 {
+		rate(QualityPoor, QualitySendBuffer)
+	} else if fill >= 0.25 {
+		rate(QualityDegraded, QualitySendBuffer)
+	}
+
+	if dropped >= qualityPoorDropped {
+		rate(QualityPoor, QualityDropped)
+	} else if dropped > 0 {
+		rate(QualityDegraded, QualityDropped)
+	}
+
+	if at := c.rttAt.Load(); at != 0 && now.Sub(time.Unix(0, at)) < qualityRTTMaxAge {
+		rtt := time.Duration(c.rtt.Load()) * time.Microsecond
+		if rtt >= qualityPoorRTT {
+			rate(QualityPoor, QualityHighRTT)
+		} else if rtt >= qualityDegradedRTT {
+			rate(QualityDegraded, QualityHighRTT)
+		}
+	}
+	return quality, reasons
+}
+
+// connectionQuality is the client's current rating
+func (c *Client) connectionQuality() string {
+	if quality, _ := c.quality.Load().(string); quality != "" {
+		return quality
+	}
+	return QualityGood
+}
+
+// runConnectionQuality rates every connection periodically and tells the
+// clients whose rating changed
+func (h *Hub) runConnectionQuality() {
+	if qualityCheckInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(qualityCheckInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		h.mutex.RLock()
+		clients := make([]*Client, 0, len(h.clients))
+		for client := range h.clients {
+			clients = append(clients, client)
+		}
+		h.mutex.RUnlock()
+
+		for _, client := range clients {
+			dropped := client.dropped.Load()
+			quality, reasons := client.assessQuality(now, dropped-client.droppedChecked)
+			client.droppedChecked = dropped
+			if quality == client.connectionQuality() {
+				continue
+			}
+			client.quality.Store(quality)
+			connectionQualityChanges.Add(quality, 1)
+			client.sendFrame(ConnectionQualityFrame{
+				Type:      "connection_quality",
+				Quality:   quality,
+				Reasons:   reasons,
+				Timestamp: now,
+			}, PriorityHigh)
+		}
+	}
+}
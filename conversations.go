@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A conversation is a private channel named "dm-<ULID>" with a member list
+// kept in Redis. Only members can join it, send to it or read its history;
+// legacy clients that follow every channel don't receive its traffic. Members
+// may add others, the creator may remove them and anyone may leave. Changes
+// are announced in the conversation with system messages.
+
+const conversationPrefix = "dm-"
+
+var (
+	// Members of a conversation including its creator
+	conversationMaxMembers = envInt("CONVERSATION_MAX_MEMBERS", 20)
+)
+
+const conversationTitleMaxLen = 100
+
+// Conversation describes a private conversation
+type Conversation struct {
+	Channel   string    `json:"channel"`
+	Title     string    `json:"title,omitempty"`
+	Members   []string  `json:"members"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ConversationCreateRequest is the inbound {"type":"conversation_create",
+// "members":[...],"title":"..."} frame; the sender is always a member
+type ConversationCreateRequest struct {
+	Type    string   `json:"type"`
+	Title   string   `json:"title"`
+	Members []string `json:"members"`
+}
+
+// ConversationMembersRequest is the inbound conversation_add or
+// conversation_remove frame
+type ConversationMembersRequest struct {
+	Type    string   `json:"type"`
+	Channel string   `json:"channel"`
+	Members []string `json:"members"`
+}
+
+// ConversationsRequest is the inbound {"type":"conversations"} frame
+type ConversationsRequest struct {
+	Type string `json:"type"`
+}
+
+// ConversationFrame tells members about a new or changed conversation.
+// Removed is set for users who are no longer members.
+type ConversationFrame struct {
+	Type      string    `json:"type"`
+	Channel   string    `json:"channel"`
+	Title     string    `json:"title,omitempty"`
+	Members   []string  `json:"members"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	Removed   bool      `json:"removed,omitempty"`
+}
+
+// ConversationsFrame lists the conversations of a user, sent on connect and
+// on request
+type ConversationsFrame struct {
+	Type          string         `json:"type"`
+	Conversations []Conversation `json:"conversations"`
+	Timestamp     time.Time      `json:"timestamp"`
+}
+
+func isConversation(channel string) bool {
+	return strings.HasPrefix(channel, conversationPrefix)
+}
+
+// Title, creator and creation time of a conversation as JSON
+func conversationKey(channel string) string {
+	return "websocket:conversation:" + channel
+}
+
+func conversationMembersKey(channel string) string {
+	return "websocket:conversation:members:" + channel
+}
+
+// Conversations a user is a member of
+func userConversationsKey(username string) string {
+	return "websocket:conversations:user:" + username
+}
+
+// isConversationMember reports whether username belongs to a conversation
+func (h *Hub) isConversationMember(channel, username string) bool {
+	if h.redis == nil || username == "" {
+		return false
+	}
+	member, err := h.redis.SIsMember(context.Background(), conversationMembersKey(channel), username).Result()
+	return err == nil && member
+}
+
+// canJoin reports whether a client may follow a channel; only members may
+// follow a conversation
+func (h *Hub) canJoin(c *Client, channel string) bool {
+	return !isConversation(channel) || h.isConversationMember(channel, c.Username)
+}
+
+// membersStage keeps conversations to their members
+func membersStage(h *Hub, m *pipelineMessage) bool {
+	if !isConversation(m.Msg.Channel) || h.isConversationMember(m.Msg.Channel, m.Msg.Username) {
+		return true
+	}
+	if m.Msg.Type != "seen" {
+		m.reject(ErrNotMember, "Bu konuşmanın üyesi değilsiniz")
+	}
+	return false
+}
+
+// conversation loads a conversation with its members, sorted
+func (h *Hub) conversation(ctx context.Context, channel string) (Conversation, bool) {
+	pipe := h.redis.Pipeline()
+	info := pipe.Get(ctx, conversationKey(channel))
+	members := pipe.SMembers(ctx, conversationMembersKey(channel))
+	pipe.Exec(ctx)
+	var conv Conversation
+	if info.Err() != nil || json.Unmarshal([]byte(info.Val()), &conv) != nil {
+		return Conversation{}, false
+	}
+	conv.Channel, conv.Members = channel, members.Val()
+	sort.Strings(conv.Members)
+	return conv, true
+}
+
+// cleanMembers trims and deduplicates requested usernames, leaving out skip
+func cleanMembers(requested []string, skip string) []string {
+	seen := map[string]bool{skip: true, "": true}
+	var members []string
+	for _, username := range requested {
+		username = strings.TrimSpace(username)
+		if !seen[username] {
+			seen[username] = true
+			members = append(members, username)
+		}
+	}
+	return members
+}
+
+// handleConversationFrame dispatches the conversation frames of a client
+func (h *Hub) handleConversationFrame(c *Client, msgType string, raw []byte) {
+	if h.redis == nil {
+		c.sendError(ErrInvalidConversation, "Konuşmalar için mesaj geçmişi gerekli")
+		return
+	}
+	switch msgType {
+	case "conversation_create":
+		var req ConversationCreateRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			c.sendError(ErrInvalidConversation, "Geçersiz konuşma isteği")
+			return
+		}
+		h.createConversation(c, req)
+	case "conversation_add", "conversation_remove":
+		var req ConversationMembersRequest
+		if err := json.Unmarshal(raw, &req); err != nil || !isConversation(req.Channel) {
+			c.sendError(ErrInvalidConversation, "Geçersiz konuşma isteği")
+			return
+		}
+		if msgType == "conversation_add" {
+			h.addConversationMembers(c, req)
+		} else {
+			h.removeConversationMembers(c, req)
+		}
+	case "conversations":
+		h.sendConversations(c)
+	}
+}
+
+// createConversation starts a conversation of the sender and the requested members
+func (h *Hub) createConversation(c *Client, req ConversationCreateRequest) {
+	members := cleanMembers(req.Members, c.Username)
+	if len(members) == 0 {
+		c.sendError(ErrInvalidConversation, "Konuşma için en az bir üye gerekli")
+		return
+	}
+	if len(members)+1 > conversationMaxMembers {
+		c.sendError(ErrInvalidConversation, fmt.Sprintf("Konuşmada en fazla %d üye olabilir", conversationMaxMembers))
+		return
+	}
+	conv := Conversation{
+		Channel:   conversationPrefix + newULID(),
+		Title:     truncate(strings.TrimSpace(req.Title), conversationTitleMaxLen),
+		CreatedBy: c.Username,
+		CreatedAt: time.Now(),
+	}
+	info, _ := json.Marshal(Conversation{Title: conv.Title, CreatedBy: conv.CreatedBy, CreatedAt: conv.CreatedAt})
+
+	ctx := context.Background()
+	pipe := h.redis.TxPipeline()
+	pipe.Set(ctx, conversationKey(conv.Channel), info, 0)
+	for _, username := range append([]string{c.Username}, members...) {
+		pipe.SAdd(ctx, conversationMembersKey(conv.Channel), username)
+		pipe.SAdd(ctx, userConversationsKey(username), conv.Channel)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Konuşma oluşturulamadı: %v", err)
+		c.sendError(ErrInvalidConversation, "Konuşma oluşturulamadı")
+		return
+	}
+	log.Printf("Konuşma oluşturuldu: kanal=%s, kullanıcı=%s, üyeler=%v", conv.Channel, c.Username, members)
+
+	conv, _ = h.conversation(ctx, conv.Channel)
+	h.announceConversation(conv, conv.Members, nil)
+	h.postSystemMessage(conv.Channel, fmt.Sprintf("%s konuşmayı başlattı: %s", c.Username, strings.Join(members, ", ")))
+}
+
+// addConversationMembers adds users to a conversation the sender is a member of
+func (h *Hub) addConversationMembers(c *Client, req ConversationMembersRequest) {
+	ctx := context.Background()
+	conv, ok := h.conversation(ctx, req.Channel)
+	if !ok || !h.isConversationMember(req.Channel, c.Username) {
+		c.sendError(ErrNotMember, "Bu konuşmanın üyesi değilsiniz")
+		return
+	}
+	current := make(map[string]bool, len(conv.Members))
+	for _, username := range conv.Members {
+		current[username] = true
+	}
+	var added []string
+	for _, username := range cleanMembers(req.Members, c.Username) {
+		if !current[username] {
+			added = append(added, username)
+		}
+	}
+	if len(added) == 0 {
+		c.sendError(ErrInvalidConversation, "Eklenecek yeni üye yok")
+		return
+	}
+	if len(conv.Members)+len(added) > conversationMaxMembers {
+		c.sendError(ErrInvalidConversation, fmt.Sprintf("Konuşmada en fazla %d üye olabilir", conversationMaxMembers))
+		return
+	}
+	pipe := h.redis.TxPipeline()
+	for _, username := range added {
+		pipe.SAdd(ctx, conversationMembersKey(req.Channel), username)
+		pipe.SAdd(ctx, userConversationsKey(username), req.Channel)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Konuşmaya üye eklenemedi: %v", err)
+		c.sendError(ErrInvalidConversation, "Üyeler eklenemedi")
+		return
+	}
+
+	conv, _ = h.conversation(ctx, req.Channel)
+	h.announceConversation(conv, added, nil)
+	h.postSystemMessage(req.Channel, fmt.Sprintf("%s konuşmaya ekledi: %s", c.Username, strings.Join(added, ", ")))
+}
+
+// removeConversationMembers removes users from a conversation. Anyone may
+// leave; only the creator removes others.
+func (h *Hub) removeConversationMembers(c *Client, req ConversationMembersRequest) {
+	ctx := context.Background()
+	conv, ok := h.conversation(ctx, req.Channel)
+	if !ok || !h.isConversationMember(req.Channel, c.Username) {
+		c.sendError(ErrNotMember, "Bu konuşmanın üyesi değilsiniz")
+		return
+	}
+	current := make(map[string]bool, len(conv.Members))
+	for _, username := range conv.Members {
+		current[username] = true
+	}
+	var removed []string
+	for _, username := range cleanMembers(req.Members, "") {
+		if !current[username] {
+			continue
+		}
+		if username != c.Username && c.Username != conv.CreatedBy {
+			c.sendError(ErrInvalidConversation, "Yalnızca konuşmayı başlatan üye çıkarabilir")
+			return
+		}
+		removed = append(removed, username)
+	}
+	if len(removed) == 0 {
+		c.sendError(ErrInvalidConversation, "Çıkarılacak üye yok")
+		return
+	}
+	pipe := h.redis.TxPipeline()
+	for _, username := range removed {
+		pipe.SRem(ctx, conversationMembersKey(req.Channel), username)
+		pipe.SRem(ctx, userConversationsKey(username), req.Channel)
+	}
+	if len(removed) == len(conv.Members) {
+		pipe.Del(ctx, conversationKey(req.Channel))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Konuşmadan üye çıkarılamadı: %v", err)
+		c.sendError(ErrInvalidConversation, "Üyeler çıkarılamadı")
+		return
+	}
+
+	remaining := conv.Members[:0:0]
+	for _, username := range conv.Members {
+		if !containsString(removed, username) {
+			remaining = append(remaining, username)
+		}
+	}
+	conv.Members = remaining
+	h.announceConversation(conv, nil, removed)
+	if len(remaining) == 0 {
+		return
+	}
+	if len(removed) == 1 && removed[0] == c.Username {
+		h.postSystemMessage(req.Channel, fmt.Sprintf("%s konuşmadan ayrıldı", c.Username))
+	} else {
+		h.postSystemMessage(req.Channel, fmt.Sprintf("%s konuşmadan çıkardı: %s", c.Username, strings.Join(removed, ", ")))
+	}
+}
+
+// announceConversation subscribes the connections of added users, drops the
+// subscriptions of removed ones and sends both the conversation. Legacy
+// clients aren't subscribed since that would end their every-channel mode.
+func (h *Hub) announceConversation(conv Conversation, added, removed []string) {
+	frame := ConversationFrame{
+		Type:      "conversation",
+		Channel:   conv.Channel,
+		Title:     conv.Title,
+		Members:   conv.Members,
+		CreatedBy: conv.CreatedBy,
+		CreatedAt: conv.CreatedAt,
+	}
+	h.mutex.RLock()
+	var joining, leaving []*Client
+	for client := range h.clients {
+		switch {
+		case containsString(added, client.Username) && !client.followsAll():
+			joining = append(joining, client)
+		case containsString(removed, client.Username):
+			leaving = append(leaving, client)
+		}
+	}
+	h.mutex.RUnlock()
+
+	for _, client := range joining {
+		if client.subscribe(conv.Channel) {
+			client.sendFrame(SubscriptionsFrame{Type: "subscriptions", Channels: client.subscriptions(), Timestamp: time.Now()}, PriorityNormal)
+		}
+	}
+	for _, client := range leaving {
+		if !client.followsAll() {
+			client.unsubscribe(conv.Channel)
+			client.sendFrame(SubscriptionsFrame{Type: "subscriptions", Channels: client.subscriptions(), Timestamp: time.Now()}, PriorityNormal)
+		}
+	}
+
+	for _, username := range conv.Members {
+		h.notifyUser(username, frame, PriorityNormal)
+	}
+	frame.Removed = true
+	for _, username := range removed {
+		h.notifyUser(username, frame, PriorityNormal)
+	}
+}
+
+// postSystemMessage posts a server notice to a channel
+func (h *Hub) postSystemMessage(channel, text string) {
+	frame, err := json.Marshal(Message{
+		MessageID: newULID(),
+		Username:  "system",
+		Message:   text,
+		Timestamp: time.Now(),
+		Channel:   channel,
+		Type:      "system",
+	})
+	if err != nil {
+		return
+	}
+	h.broadcast <- inboundMessage{data: frame}
+}
+
+// sendConversations sends a client the conversations of its user
+func (h *Hub) sendConversations(c *Client) {
+	if h.redis == nil || c.Username == "" {
+		return
+	}
+	ctx := context.Background()
+	channels, err := h.redis.SMembers(ctx, userConversationsKey(c.Username)).Result()
+	if err != nil {
+		log.Printf("Konuşmalar okunamadı: %v", err)
+		return
+	}
+	conversations := make([]Conversation, 0, len(channels))
+	for _, channel := range channels {
+		if conv, ok := h.conversation(ctx, channel); ok {
+			conversations = append(conversations, conv)
+		}
+	}
+	sort.Slice(conversations, func(i, j int) bool {
+		return conversations[i].CreatedAt.Before(conversations[j].CreatedAt)
+	})
+	c.sendFrame(ConversationsFrame{Type: "conversations", Conversations: conversations, Timestamp: time.Now()}, PriorityNormal)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// History responses carry day markers, so every client puts the same "3
+// Haziran Çarşamba" separators between the messages. A marker names the first
+// message of each day in the history, and days are counted in the time zone
+// of the user's preferences (see notificationprefs.go), else the channel's
+// "timezone" setting, else UTC; the frames name the zone used so clients can
+// continue with live messages. The legacy __GET_RECENT_MESSAGES__ replay
+// gets the markers as a day_markers frame after the messages.
+
+var turkishMonths = [...]string{"Ocak", "Şubat", "Mart", "Nisan", "Mayıs", "Haziran", "Temmuz", "Ağustos", "Eylül", "Ekim", "Kasım", "Aralık"}
+
+var turkishWeekdays = [...]string{"Pazar", "Pazartesi", "Salı", "Çarşamba", "Perşembe", "Cuma", "Cumartesi"}
+
+// DayMarker starts a day in a list of messages
+type DayMarker struct {
+	Date      string    `json:"date"`      // "2026-06-03" in the time zone of the frame
+	Label     string    `json:"label"`     // "3 Haziran Çarşamba", with the year when it isn't this year
+	Start     time.Time `json:"start"`     // midnight the day starts at
+	MessageID string    `json:"messageId"` // the day's first message
+}
+
+// DayMarkersFrame follows the messages of a legacy history replay
+type DayMarkersFrame struct {
+	Type     string      `json:"type"`
+	Channel  string      `json:"channel"`
+	Timezone string      `json:"timezone"`
+	Days     []DayMarker `json:"days"`
+}
+
+// dayLocation returns the time zone days are counted in for a client in a
+// channel
+func (h *Hub) dayLocation(c *Client, channel string) *time.Location {
+	c.notificationPrefsMutex.RLock()
+	name := c.notificationPrefs.Timezone
+	c.notificationPrefsMutex.RUnlock()
+	if name == "" {
+		name = h.channelSettings(channel).Timezone
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// dayMarkers marks the first message of every day in messages, oldest first
+func dayMarkers(messages []Message, location *time.Location) []DayMarker {
+	var markers []DayMarker
+	now := time.Now().In(location)
+	previous := ""
+	for _, msg := range messages {
+		if msg.MessageID == "" || msg.Timestamp.IsZero() {
+			continue
+		}
+		local := msg.Timestamp.In(location)
+		date := local.Format("2006-01-02")
+		if date == previous {
+			continue
+		}
+		previous = date
+		markers = append(markers, DayMarker{
+			Date:      date,
+			Label:     dayLabel(local, now),
+			Start:     time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location),
+			MessageID: msg.MessageID,
+		})
+	}
+	return markers
+}
+
+// dayLabel names a day the way the web page does with the tr-TR locale
+func dayLabel(day, now time.Time) string {
+	if day.Year() != now.Year() {
+		return fmt.Sprintf("%d %s %d %s", day.Day(), turkishMonths[day.Month()-1], day.Year(), turkishWeekdays[day.Weekday()])
+	}
+	return fmt.Sprintf("%d %s %s", day.Day(), turkishMonths[day.Month()-1], turkishWeekdays[day.Weekday()])
+}
+
+// sendDayMarkers sends the day markers of a legacy history replay
+func (h *Hub) sendDayMarkers(c *Client, channel string, frames []json.RawMessage) {
+	var messages []Message
+	for _, raw := range frames {
+		var env struct {
+			Payload Message `json:"payload"`
+		}
+		if json.Unmarshal(raw, &env) == nil {
+			messages = append(messages, env.Payload)
+		}
+	}
+	location := h.dayLocation(c, channel)
+	days := dayMarkers(messages, location)
+	if len(days) == 0 {
+		return
+	}
+	c.sendFrame(DayMarkersFrame{Type: "day_markers", Channel: channel, Timezone: location.String(), Days: days}, PriorityNormal)
+}
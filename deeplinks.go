@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// Shared links like /#/channel/genel/message/<id> open at the message. The
+// client passes the link in its hello frame ({"type":"hello",...,"link":"..."})
+// and once the user connected the server resolves it: the client is
+// subscribed to the message's channel and gets a message_context frame with
+// the message and DEEP_LINK_CONTEXT messages on either side instead of the
+// latest history, followed by the channel's pins. {"type":"open_link",
+// "link":"..."} does the same for a link followed later. A link without a
+// message (/#/channel/genel) subscribes and sends the recent history.
+
+var deepLinkContext = envInt("DEEP_LINK_CONTEXT", 20)
+
+var deepLinkPattern = regexp.MustCompile(`^/?#?/channel/([^/?#]+)(?:/message/([0-9A-Z]{26}))?/?$`)
+
+// OpenLinkRequest is the inbound {"type":"open_link","link":"/#/channel/..."} frame
+type OpenLinkRequest struct {
+	Type string `json:"type"`
+	Link string `json:"link"`
+}
+
+// MessageContextFrame is the page of a channel around a linked message
+type MessageContextFrame struct {
+	Type      string           `json:"type"`
+	Channel   string           `json:"channel"`
+	MessageID string           `json:"messageId"` // the linked message, to scroll to
+	Messages  []Message        `json:"messages"`  // oldest first, the linked message included
+	Stubs     []MediaStubFrame `json:"stubs,omitempty"`
+	Timezone  string           `json:"timezone"`       // the days are counted in, see daymarkers.go
+	Days      []DayMarker      `json:"days,omitempty"` // separators before messages and stubs
+	Timestamp time.Time        `json:"timestamp"`
+}
+
+// parseDeepLink reads the channel and message ID of a link; the ID is empty
+// for a channel link
+func parseDeepLink(link string) (string, string, bool) {
+	m := deepLinkPattern.FindStringSubmatch(link)
+	if m == nil {
+		return "", "", false
+	}
+	channel, err := url.PathUnescape(m[1])
+	if err != nil || channel == "" {
+		return "", "", false
+	}
+	return channel, m[2], true
+}
+
+// handleOpenLink opens a link sent by a connected client
+func (h *Hub) handleOpenLink(c *Client, raw []byte) {
+	var req OpenLinkRequest
+	if err := json.Unmarshal(raw, &req); err != nil || c.Username == "" {
+		return
+	}
+	h.openDeepLink(c, req.Link)
+}
+
+// openDeepLink subscribes a client to the channel of a link and sends the
+// page around the linked message, or the channel's recent history
+func (h *Hub) openDeepLink(c *Client, link string) {
+	channel, messageID, ok := parseDeepLink(link)
+	if !ok {
+		c.sendError(ErrInvalidLink, "Bağlantı geçersiz")
+		return
+	}
+	var page MessageContext
+	if messageID != "" {
+		var found bool
+		var err error
+		if h.redis != nil {
+			around := min(max(deepLinkContext, 0), contextMaxMessages)
+			page, found, err = h.messageContext(messageID, around, around)
+		}
+		if err != nil {
+			log.Printf("Bağlantıdaki mesaj okunamadı (%s): %v", messageID, err)
+		}
+		if !found || page.Message.Channel != channel {
+			c.sendError(ErrInvalidLink, "Bağlantıdaki mesaj artık yok")
+			return
+		}
+	}
+	if !h.canJoin(c, channel) {
+		c.sendError(ErrNotMember, "Bu konuşmanın üyesi değilsiniz")
+		return
+	}
+
+	if !c.inChannel(channel) {
+		c.handleChannelRequest(ChannelRequest{Type: "subscribe", Channel: channel})
+		if !c.inChannel(channel) {
+			return // too many subscriptions, already reported
+		}
+		defer h.sendMembers(c, channel)
+		defer h.updatePresence(c.Username)
+	}
+	if messageID == "" {
+		h.sendRecentMessages(c, channel)
+		return
+	}
+
+	h.sendMOTD(c, channel)
+	frame := MessageContextFrame{Type: "message_context", Channel: channel, MessageID: messageID}
+	stored := append(append(page.Before, page.Message), page.After...)
+	frame.Messages, frame.Stubs = c.viewMessages(channel, stored)
+	location := h.dayLocation(c, channel)
+	frame.Timezone, frame.Days = location.String(), dayMarkers(stored, location)
+	frame.Timestamp = time.Now()
+	c.sendFrame(frame, PriorityNormal)
+	h.sendPins(c, channel)
+	log.Printf("Bağlantı açıldı: kullanıcı=%s, kanal=%s, mesaj=%s", c.Username, channel, messageID)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Weekly digests summarize each active channel's previous ISO week. The stats are
+// collected while the week happens because messages and reactions expire sooner.
+var digestEnabled = envBool("DIGEST_ENABLED", true)
+
+const (
+	digestUsername = "Chatliyo"
+	digestTopN     = 3
+	digestKeyTTL   = 15 * 24 * time.Hour
+)
+
+// weekID formats the ISO week of t, e.g. "2026-W42"
+func weekID(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+func digestChannelsKey(week string) string {
+	return "websocket:digest:" + week + ":channels"
+}
+
+func digestKey(week, channel, part string) string {
+	return "websocket:digest:" + week + ":" + channel + ":" + part
+}
+
+func channelMembersKey(channel string) string {
+	return "websocket:channel:members:" + channel
+}
+
+// recordDigestMessage notes a stored message for its week's digest. Expiring
+// messages aren't excerpted, so their text doesn't outlive them in the digest.
+func (h *Hub) recordDigestMessage(msg Message) {
+	if h.redis == nil || msg.Type == "digest" || msg.Type == "system" {
+		return
+	}
+	ctx := context.Background()
+	week := weekID(msg.Timestamp)
+	excerpts := digestKey(week, msg.Channel, "messages")
+	pipe := h.redis.Pipeline()
+	pipe.SAdd(ctx, digestChannelsKey(week), msg.Channel)
+	pipe.Expire(ctx, digestChannelsKey(week), digestKeyTTL)
+	if msg.ExpiresAt == nil {
+		pipe.HSet(ctx, excerpts, messageKey(msg.MessageID, msg.Timestamp), fmt.Sprintf("%s: %s", msg.Username, digestExcerpt(msg.Message)))
+		pipe.Expire(ctx, excerpts, digestKeyTTL)
+	}
+	firstPost := pipe.SAdd(ctx, channelMembersKey(msg.Channel), msg.Username)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return
+	}
+	if firstPost.Val() == 1 {
+		members := digestKey(week, msg.Channel, "members")
+		h.redis.SAdd(ctx, members, msg.Username)
+		h.redis.Expire(ctx, members, digestKeyTTL)
+	}
+}
+
+// recordDigestReaction adjusts the reaction score of a message by delta
+func (h *Hub) recordDigestReaction(channel string, timestamp time.Time, message string, delta int) {
+	if h.redis == nil {
+		return
+	}
+	ctx := context.Background()
+	key := digestKey(weekID(timestamp), channel, "reactions")
+	h.redis.ZIncrBy(ctx, key, float64(delta), message)
+	h.redis.Expire(ctx, key, digestKeyTTL)
+}
+
+// recordDigestDownload counts a file download in the current week
+func (h *Hub) recordDigestDownload(meta *FileMeta) {
+	if h.redis == nil {
+		return
+	}
+	ctx := context.Background()
+	key := digestKey(weekID(time.Now()), meta.Channel, "files")
+	h.redis.ZIncrBy(ctx, key, 1, meta.ID)
+	h.redis.Expire(ctx, key, digestKeyTTL)
+}
+
+// postWeeklyDigests posts a digest message into every channel active in week
+func (h *Hub) postWeeklyDigests(week string) error {
+	if !digestEnabled {
+		return nil
+	}
+	channels, err := h.redis.SMembers(context.Background(), digestChannelsKey(week)).Result()
+	if err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		text := h.buildDigest(week, channel)
+		if text == "" {
+			continue
+		}
+		frame, err := json.Marshal(Message{
+			MessageID: newULID(),
+			Username:  digestUsername,
+			Message:   text,
+			Timestamp: time.Now(),
+			Channel:   channel,
+			Type:      "digest",
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("Haftalık özet gönderildi: %s (%s)", channel, week)
+		h.broadcast <- inboundMessage{data: frame}
+	}
+	return nil
+}
+
+// buildDigest renders the summary of one channel's week, "" when there is nothing to report
+func (h *Hub) buildDigest(week, channel string) string {
+	ctx := context.Background()
+	var sections []string
+
+	top, _ := h.redis.ZRevRangeWithScores(ctx, digestKey(week, channel, "reactions"), 0, digestTopN-1).Result()
+	var lines []string
+	for _, z := range top {
+		if z.Score <= 0 {
+			continue
+		}
+		excerpt, err := h.redis.HGet(ctx, digestKey(week, channel, "messages"), z.Member.(string)).Result()
+		if err != nil {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("• %s (%d tepki)", excerpt, int(z.Score)))
+	}
+	if len(lines) > 0 {
+		sections = append(sections, "En çok tepki alan mesajlar:\n"+strings.Join(lines, "\n"))
+	}
+
+	files, _ := h.redis.ZRevRangeWithScores(ctx, digestKey(week, channel, "files"), 0, digestTopN-1).Result()
+	lines = nil
+	for _, z := range files {
+		if meta, ok := getFileMeta(z.Member.(string)); ok {
+			lines = append(lines, fmt.Sprintf("• %s (%d indirme)", meta.Name, int(z.Score)))
+		}
+	}
+	if len(lines) > 0 {
+		sections = append(sections, "En çok paylaşılan dosyalar:\n"+strings.Join(lines, "\n"))
+	}
+
+	members, _ := h.redis.SMembers(ctx, digestKey(week, channel, "members")).Result()
+	if len(members) > 0 {
+		shown := members
+		if len(shown) > 10 {
+			shown = shown[:10]
+		}
+		line := fmt.Sprintf("Yeni üyeler (%d): %s", len(members), strings.Join(shown, ", "))
+		sections = append(sections, line)
+	}
+
+	if len(sections) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("📰 #%s haftalık özeti (%s)\n\n%s", channel, week, strings.Join(sections, "\n\n"))
+}
+
+// digestExcerpt shortens a message for the digest
+func digestExcerpt(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) > 100 {
+		text = string([]rune(text)[:100]) + "…"
+	}
+	return text
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Uploads are always read through the server. In the default direct mode a
+// link signed for the file's channel is enough and responses may be cached by
+// anyone. With DOWNLOAD_MODE=stream every request also needs a download token
+// the client got over its socket: the connection must still be open and in the
+// file's channel. Stream mode downloads are private, throttled per user and
+// written to the audit log.
+
+const (
+	downloadModeDirect = "direct"
+	downloadModeStream = "stream"
+)
+
+var (
+	downloadMode     = envString("DOWNLOAD_MODE", downloadModeDirect)
+	downloadTokenTTL = envDuration("DOWNLOAD_TOKEN_TTL", time.Hour)
+	// Bytes per second one user may download in stream mode, 0 for no limit
+	downloadUserRate = envInt("DOWNLOAD_USER_RATE", 0)
+)
+
+// BlobStore reads upload content by its SHA-256. The default reads the local
+// blobs directory; other backends such as object storage implement it to serve
+// uploads without local files.
+type BlobStore interface {
+	Open(blob string) (io.ReadSeekCloser, error)
+}
+
+type diskBlobStore struct {
+	dir string
+}
+
+func (s diskBlobStore) Open(blob string) (io.ReadSeekCloser, error) {
+	return os.Open(filepath.Join(s.dir, blob))
+}
+
+var blobStore BlobStore = diskBlobStore{dir: blobsDir}
+
+// DownloadTokenRequest is the inbound {"type":"download_token"} frame
+type DownloadTokenRequest struct {
+	Type string `json:"type"`
+}
+
+// DownloadTokenFrame carries a token for the ?token= parameter of /uploads,
+// sent on connect in stream mode and on request
+type DownloadTokenFrame struct {
+	Type      string    `json:"type"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// downloadClaims is the signed content of a download token
+type downloadClaims struct {
+	ClientID string `json:"cid"`
+	Username string `json:"u"`
+	Expires  int64  `json:"exp"`
+}
+
+// sendDownloadToken issues a download token bound to the connection
+func (c *Client) sendDownloadToken() {
+	if c.Username == "" {
+		return
+	}
+	expires := time.Now().Add(downloadTokenTTL)
+	c.sendFrame(DownloadTokenFrame{
+		Type:      "download_token",
+		Token:     signClaims("download", downloadClaims{ClientID: c.ID, Username: c.Username, Expires: expires.Unix()}),
+		ExpiresAt: expires,
+	}, PriorityHigh)
+}
+
+// downloadIdentity checks a download token against the connections of the hub
+// and returns its username if that connection is in the channel
+func (h *Hub) downloadIdentity(token, channel string) (string, bool) {
+	var claims downloadClaims
+	if !parseClaims("download", token, &claims) || time.Now().Unix() >= claims.Expires {
+		return "", false
+	}
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for client := range h.clients {
+		if client.ID == claims.ClientID && client.Username == claims.Username {
+			return claims.Username, client.inChannel(channel)
+		}
+	}
+	return "", false
+}
+
+// authorizeDownload returns who downloads a file: an admin, a token holder in
+// stream mode, or nobody in particular for a signed link in direct mode
+func (h *Hub) authorizeDownload(r *http.Request, meta *FileMeta) (string, bool) {
+	if admin, ok := adminFromRequest(r); ok {
+		return admin, true
+	}
+	sig := r.URL.Query().Get("sig")
+	if sig == "" || !verifySignature(sig, "file", meta.ID, meta.Channel) {
+		return "", false
+	}
+	if downloadMode != downloadModeStream {
+		return "", true
+	}
+	return h.downloadIdentity(r.URL.Query().Get("token"), meta.Channel)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Authors can edit their stored text messages. The stored message is replaced
+// in the channel history and resume log and marked edited, the text it
+// replaced is kept as a version, and the channel gets an edited frame.
+// Link previews are dropped and fetched again for the new text.
+
+// Previous versions kept per message; 0 keeps none
+var editHistoryVersions = envInt("EDIT_HISTORY_VERSIONS", 20)
+
+// EditRequest is the inbound {"type":"edit","messageId":"...","message":"..."} frame
+type EditRequest struct {
+	Type      string `json:"type"`
+	MessageID string `json:"messageId"`
+	Message   string `json:"message"`
+}
+
+// MessageEditFrame announces the new text of an edited message
+type MessageEditFrame struct {
+	Type      string           `json:"type"`
+	MessageID string           `json:"messageId"`
+	Seq       int64            `json:"seq,omitempty"`
+	Channel   string           `json:"channel"`
+	Message   string           `json:"message"`
+	Mentions  []string         `json:"mentions,omitempty"`
+	Segments  []MessageSegment `json:"segments,omitempty"`
+	Edited    bool             `json:"edited"`
+	EditedAt  time.Time        `json:"editedAt"`
+}
+
+// MessageVersion is an earlier text of a message and when it was written
+type MessageVersion struct {
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// MessageHistory is the /api/messages/{id}/history response, versions newest first
+type MessageHistory struct {
+	Message  Message          `json:"message"`
+	Versions []MessageVersion `json:"versions"`
+}
+
+// Earlier versions of a message, newest first, kept as long as the message
+func messageVersionsKey(id string) string {
+	return "websocket:message:versions:" + id
+}
+
+// handleEdit applies an edit of the sender's own text message
+func (h *Hub) handleEdit(c *Client, raw []byte) {
+	var req EditRequest
+	if err := json.Unmarshal(raw, &req); err != nil || !isULID(req.MessageID) {
+		c.sendError(ErrInvalidEdit, "Düzenlenecek mesaj bulunamadı")
+		return
+	}
+	text := sanitizeMarkdown(req.Message)
+	if text == "" {
+		c.sendError(ErrInvalidEdit, "Mesaj boş olamaz")
+		return
+	}
+	if utf8.RuneCountInString(text) > maxMessageLength {
+		c.sendLimitError(ErrMessageTooLong, fmt.Sprintf("Mesaj çok uzun (en fazla %d karakter)", maxMessageLength), maxMessageLength)
+		return
+	}
+	if h.redis == nil {
+		c.sendError(ErrInvalidEdit, "Mesaj geçmişi olmadan düzenleme yapılamaz")
+		return
+	}
+
+	ctx := context.Background()
+	author, err := h.redis.Get(ctx, messageAuthorKey(req.MessageID)).Result()
+	if err != nil || author != c.Username {
+		c.sendError(ErrInvalidEdit, "Yalnızca kendi mesajlarınızı düzenleyebilirsiniz")
+		return
+	}
+	channel, seq, ok := h.locateMessage(ctx, req.MessageID)
+	if !ok {
+		c.sendError(ErrInvalidEdit, "Düzenlenecek mesaj bulunamadı")
+		return
+	}
+	stored, err := h.messagesBySeq(ctx, channel, seq, seq)
+	if err != nil || len(stored) == 0 || stored[0].MessageID != req.MessageID {
+		c.sendError(ErrInvalidEdit, "Düzenlenecek mesaj bulunamadı")
+		return
+	}
+	original := stored[0]
+	if original.Type != "text" {
+		c.sendError(ErrInvalidEdit, "Yalnızca metin mesajları düzenlenebilir")
+		return
+	}
+	if reason := contentModeRejection(h.channelSettings(channel).ContentMode, "text", text); reason != "" {
+		c.sendError(ErrContentMode, reason)
+		return
+	}
+
+	now := time.Now()
+	edited := original
+	edited.Message = h.shortenLinks(text, c.Username, channel)
+	edited.Mentions = h.mentionedUsers(edited.Message)
+	edited.Segments = parseSegments(edited.Message)
+	edited.Previews = nil
+	edited.Edited = true
+	edited.EditedAt = &now
+	if edited.Message == original.Message {
+		return
+	}
+	edited.Integrity = messageIntegrity(edited)
+	replaced, err := h.replaceMessage(ctx, original, edited)
+	if err != nil {
+		log.Printf("Mesaj düzenlenemedi: %v", err)
+	}
+	if !replaced {
+		c.sendError(ErrInvalidEdit, "Mesaj düzenlenemedi")
+		return
+	}
+	log.Printf("Mesaj düzenlendi: %s, Kullanıcı: %s", edited.MessageID, c.Username)
+	h.queueArchive(edited)
+
+	frame, err := encodeFrame(MessageEditFrame{
+		Type:      "edited",
+		MessageID: edited.MessageID,
+		Seq:       edited.Seq,
+		Channel:   channel,
+		Message:   edited.Message,
+		Mentions:  edited.Mentions,
+		Segments:  edited.Segments,
+		Edited:    true,
+		EditedAt:  now,
+	})
+	if err != nil {
+		return
+	}
+	h.sendToChannel(channel, frame, PriorityNormal, nil)
+	if linkPreviewsEnabled {
+		h.unfurlLinks(edited)
+	}
+}
+
+// replaceMessage swaps the stored copies of a message for the edited one and
+// keeps the replaced text as a version; false when the message was edited or
+// removed meanwhile
+func (h *Hub) replaceMessage(ctx context.Context, original, edited Message) (bool, error) {
+	version, err := json.Marshal(MessageVersion{Message: original.Message, Timestamp: messageWrittenAt(original)})
+	if err != nil {
+		return false, err
+	}
+	change := func(stored *Message) bool {
+		if stored.Message != original.Message {
+			return false
+		}
+		*stored = edited
+		return true
+	}
+	return h.rewriteMessage(ctx, edited, change, func(pipe redis.Pipeliner) {
+		if editHistoryVersions > 0 {
+			versions := messageVersionsKey(edited.MessageID)
+			pipe.LPush(ctx, versions, version)
+			pipe.LTrim(ctx, versions, 0, int64(editHistoryVersions-1))
+			pipe.Expire(ctx, versions, 24*time.Hour)
+		}
+	})
+}
+
+// Times a rewrite is tried before giving up, as every message stored in the
+// channel meanwhile starts it over
+const rewriteAttempts = 10
+
+var errRewriteContended = errors.New("message rewrite contended")
+
+// rewriteMessage swaps the stored copies of msg in the history list and the
+// resume log for what change makes of the current one, found by ID. Both keys
+// are watched, so a message stored meanwhile can't shift the list under the
+// write; change returns false to leave the message as it is. extra, if set,
+// adds commands to the same transaction. It reports whether the message was
+// rewritten.
+func (h *Hub) rewriteMessage(ctx context.Context, msg Message, change func(*Message) bool, extra func(redis.Pipeliner)) (bool, error) {
+	if msg.Seq == 0 {
+		return false, nil
+	}
+	key := "websocket:messages:" + msg.Channel
+	logKey := resumeLogKey(msg.Channel)
+	score := strconv.FormatInt(msg.Seq, 10)
+	for attempt := 0; attempt < rewriteAttempts; attempt++ {
+		rewritten := false
+		err := h.redis.Watch(ctx, func(tx *redis.Tx) error {
+			logged, err := tx.ZRangeByScore(ctx, logKey, &redis.ZRangeBy{Min: score, Max: score}).Result()
+			if err != nil {
+				return err
+			}
+			var stored Message
+			found := false
+			for _, raw := range logged {
+				if json.Unmarshal([]byte(raw), &stored) == nil && stored.MessageID == msg.MessageID {
+					found = true
+					break
+				}
+			}
+			if !found || !change(&stored) {
+				return nil
+			}
+			data, err := json.Marshal(stored)
+			if err != nil {
+				return err
+			}
+
+			// The history list is short, find the message's position in it
+			list, err := tx.LRange(ctx, key, 0, -1).Result()
+			if err != nil {
+				return err
+			}
+			index := -1
+			for i, raw := range list {
+				var listed Message
+				if json.Unmarshal([]byte(raw), &listed) == nil && listed.MessageID == msg.MessageID {
+					index = i
+					break
+				}
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				if index >= 0 {
+					pipe.LSet(ctx, key, int64(index), data)
+				}
+				pipe.ZRemRangeByScore(ctx, logKey, score, score)
+				appendResumeLog(ctx, pipe, stored, data)
+				if extra != nil {
+					extra(pipe)
+				}
+				return nil
+			})
+			rewritten = err == nil
+			return err
+		}, key, logKey)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil || !rewritten {
+			return false, err
+		}
+		h.invalidateSnapshot(msg.Channel)
+		return true, nil
+	}
+	return false, errRewriteContended
+}
+
+// messageWrittenAt is when the current text of a message was written
+func messageWrittenAt(msg Message) time.Time {
+	if msg.EditedAt != nil {
+		return *msg.EditedAt
+	}
+	return msg.Timestamp
+}
+
+// messageVersions reads the earlier versions of a message, newest first
+func (h *Hub) messageVersions(id string) ([]MessageVersion, error) {
+	stored, err := h.redis.LRange(context.Background(), messageVersionsKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]MessageVersion, 0, len(stored))
+	for _, raw := range stored {
+		var version MessageVersion
+		if err := json.Unmarshal([]byte(raw), &version); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
+
+// handleMessageHistory serves GET /api/messages/{id}/history
+func handleMessageHistory(hub *Hub, id string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if hub.redis == nil {
+		http.Error(w, "History unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	result, ok, err := hub.messageContext(id, 0, 0)
+	if err != nil {
+		log.Printf("Mesaj okunamadı: %v", err)
+		http.Error(w, "History unavailable", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	versions, err := hub.messageVersions(id)
+	if err != nil {
+		log.Printf("Mesaj sürümleri okunamadı: %v", err)
+		http.Error(w, "History unavailable", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, MessageHistory{Message: result.Message, Versions: versions})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Senders may give a message an expiresIn in seconds, e.g. for credentials or
+// one-time links. The server stamps it with expiresAt, and once that passes
+// removes the stored copies and tells the channel with an expired frame so
+// clients drop it too.
+
+var (
+	// Longest expiresIn accepted; history is kept a day anyway
+	expiryMax = envDuration("EXPIRY_MAX", 24*time.Hour)
+	// How often due messages are removed
+	expirySweepInterval = envDuration("EXPIRY_SWEEP_INTERVAL", time.Second)
+)
+
+const expiryMin = 5 * time.Second
+
+// Messages waiting to expire, "<channel>|<seq>|<id>" scored by expiry in Unix milliseconds
+const expiringKey = "websocket:expiring"
+
+// ExpiredFrame tells a channel a message expired
+type ExpiredFrame struct {
+	Type      string    `json:"type"`
+	Channel   string    `json:"channel"`
+	MessageID string    `json:"messageId"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// expiryStage turns the expiresIn a client asked for into expiresAt
+func expiryStage(h *Hub, m *pipelineMessage) bool {
+	if m.Msg.ExpiresIn <= 0 {
+		return true
+	}
+	ttl := time.Duration(m.Msg.ExpiresIn) * time.Second
+	ttl = max(min(ttl, expiryMax), expiryMin)
+	at := m.Msg.Timestamp.Add(ttl)
+	m.Msg.ExpiresIn, m.Msg.ExpiresAt = 0, &at
+	return true
+}
+
+// appendExpiry schedules the removal of an expiring message
+func appendExpiry(ctx context.Context, pipe redis.Pipeliner, msg Message) {
+	if msg.ExpiresAt == nil || msg.MessageID == "" || msg.Seq == 0 {
+		return
+	}
+	pipe.ZAdd(ctx, expiringKey, &redis.Z{
+		Score:  float64(msg.ExpiresAt.UnixMilli()),
+		Member: fmt.Sprintf("%s|%d|%s", msg.Channel, msg.Seq, msg.MessageID),
+	})
+}
+
+// runExpirySweeper removes messages whose time is up. Entries are claimed with
+// ZREM, so with several instances each message is removed once.
+func (h *Hub) runExpirySweeper() {
+	if h.redis == nil || expirySweepInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx := context.Background()
+		due, err := h.redis.ZRangeByScore(ctx, expiringKey, &redis.ZRangeBy{
+			Min: "-inf",
+			Max: strconv.FormatInt(time.Now().UnixMilli(), 10),
+		}).Result()
+		if err != nil {
+			continue
+		}
+		for _, entry := range due {
+			if claimed, err := h.redis.ZRem(ctx, expiringKey, entry).Result(); err != nil || claimed == 0 {
+				continue
+			}
+			parts := strings.SplitN(entry, "|", 3)
+			if len(parts) != 3 {
+				continue
+			}
+			seq, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			if err := h.expireMessage(ctx, parts[0], seq, parts[2]); err != nil {
+				log.Printf("Süresi dolan mesaj silinemedi (%s): %v", parts[2], err)
+			}
+		}
+	}
+}
+
+// expireMessage removes the stored copies of a message and tells its channel
+func (h *Hub) expireMessage(ctx context.Context, channel string, seq int64, id string) error {
+	key := "websocket:messages:" + channel
+	list, err := h.redis.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	var threadID string
+	pipe := h.redis.TxPipeline()
+	for _, raw := range list {
+		var msg Message
+		if json.Unmarshal([]byte(raw), &msg) == nil && msg.MessageID == id {
+			pipe.LRem(ctx, key, 1, raw)
+			threadID = msg.ThreadID
+			break
+		}
+	}
+	score := strconv.FormatInt(seq, 10)
+	pipe.ZRemRangeByScore(ctx, resumeLogKey(channel), score, score)
+	pipe.ZRem(ctx, messageIndexKey(channel), id)
+	pipe.Del(ctx, messageLocationKey(id), messageAuthorKey(id), messageVersionsKey(id), pollVotesKey(id))
+	pipe.HDel(ctx, pinsKey(channel), id)
+	if threadID != "" {
+		pipe.ZRem(ctx, threadKey(threadID), id)
+		pipe.HIncrBy(ctx, threadCountsKey(channel), threadID, -1)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	h.invalidateSnapshot(channel)
+
+	frame, _ := encodeFrame(ExpiredFrame{Type: "expired", Channel: channel, MessageID: id, Timestamp: time.Now()})
+	h.sendToChannel(channel, frame, PriorityNormal, nil)
+	return nil
+}
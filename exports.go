@@ -0,0 +1,565 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Compliance exports collect the messages of a user or channel in a time range,
+// with their edit history and files, into a zip an admin can hand over on a
+// legal request. Each message record carries the hash of the one before it, the
+// manifest lists the SHA-256 of every other file in the archive and is signed
+// with the export signing key (ed25519), so removed or altered content shows.
+// The public key is served at /api/export-key, so whoever receives an archive
+// can verify it without the server. Exports are built in the background; admins
+// poll the job for its progress.
+
+const exportsDir = "./exports"
+
+// How long export jobs are kept; archives of expired jobs are removed when the
+// exports are listed next
+var exportRetention = envDuration("EXPORT_RETENTION", 30*24*time.Hour)
+
+const (
+	exportIndexKey = "websocket:exports"
+
+	ExportStatusQueued  = "queued"
+	ExportStatusRunning = "running"
+	ExportStatusDone    = "done"
+	ExportStatusFailed  = "failed"
+)
+
+// ExportRequest selects what goes into an export; at least one of Username
+// and Channel is required
+type ExportRequest struct {
+	Username  string    `json:"username,omitempty"`
+	Channel   string    `json:"channel,omitempty"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Reference string    `json:"reference"` // case or request number the export answers
+}
+
+// CustodyEvent is one step in the chain of custody of an export
+type CustodyEvent struct {
+	Action string    `json:"action"` // requested, generated, downloaded
+	Actor  string    `json:"actor"`
+	At     time.Time `json:"at"`
+}
+
+// ExportJob is the state of an export as shown by the admin API
+type ExportJob struct {
+	ID           string         `json:"id"`
+	Request      ExportRequest  `json:"request"`
+	Status       string         `json:"status"`
+	Progress     int            `json:"progress"` // percent
+	Messages     int            `json:"messages"`
+	Files        int            `json:"files"`
+	ManifestHash string         `json:"manifestHash,omitempty"` // SHA-256 of manifest.json
+	Error        string         `json:"error,omitempty"`
+	Custody      []CustodyEvent `json:"custody"`
+	CreatedAt    time.Time      `json:"createdAt"`
+	FinishedAt   *time.Time     `json:"finishedAt,omitempty"`
+}
+
+// ExportManifest is manifest.json in the archive
+type ExportManifest struct {
+	ExportID    string         `json:"exportId"`
+	Request     ExportRequest  `json:"request"`
+	Channels    []string       `json:"channels"`
+	Messages    int            `json:"messages"`
+	ChainHead   string         `json:"chainHead"` // hash of the last message record
+	Files       []ExportEntry  `json:"files"`
+	Custody     []CustodyEvent `json:"custody"`
+	Host        string         `json:"host"`
+	GeneratedAt time.Time      `json:"generatedAt"`
+	PublicKey   string         `json:"publicKey"` // base64 ed25519 key manifest.sig was made with
+}
+
+// ExportEntry is a file of the archive with its hash
+type ExportEntry struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// exportRecord is one line of messages.jsonl. Hash is the SHA-256 of PrevHash
+// followed by Record.
+type exportRecord struct {
+	PrevHash string          `json:"prevHash"`
+	Hash     string          `json:"hash"`
+	Record   json.RawMessage `json:"record"`
+}
+
+// exportedMessage is the record of a message: as stored, with its earlier versions
+type exportedMessage struct {
+	Message  Message          `json:"message"`
+	Versions []MessageVersion `json:"versions,omitempty"`
+}
+
+func exportKey(id string) string {
+	return "websocket:exports:" + id
+}
+
+func exportPath(id string) string {
+	return filepath.Join(exportsDir, id+".zip")
+}
+
+func chainHash(prev string, record []byte) string {
+	sum := sha256.Sum256(append([]byte(prev), record...))
+	return hex.EncodeToString(sum[:])
+}
+
+func (h *Hub) saveExportJob(job *ExportJob) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return
+	}
+	if err := h.redis.Set(context.Background(), exportKey(job.ID), data, exportRetention).Err(); err != nil {
+		log.Printf("Dışa aktarma durumu kaydedilemedi: %v", err)
+	}
+}
+
+func (h *Hub) getExportJob(id string) (*ExportJob, bool) {
+	data, err := h.redis.Get(context.Background(), exportKey(id)).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	var job ExportJob
+	if json.Unmarshal(data, &job) != nil {
+		return nil, false
+	}
+	return &job, true
+}
+
+// exportChannels returns the channels an export reads
+func (h *Hub) exportChannels(req ExportRequest) ([]string, error) {
+	if req.Channel != "" {
+		return []string{req.Channel}, nil
+	}
+	// Every stored message is counted by channel in the storage statistics
+	channels, err := h.redis.HKeys(context.Background(), storageKey(storageMessages, "channel")).Result()
+	sort.Strings(channels)
+	return channels, err
+}
+
+// exportMessages returns the stored messages of a channel matching the request, oldest first
+func (h *Hub) exportMessages(ctx context.Context, channel string, req ExportRequest) ([]Message, error) {
+	stored, err := h.messagesBySeq(ctx, channel, 0, 1<<53)
+	if err != nil {
+		return nil, err
+	}
+	matched := make([]Message, 0, len(stored))
+	for _, msg := range stored {
+		if msg.Timestamp.Before(req.From) || msg.Timestamp.After(req.To) {
+			continue
+		}
+		if req.Username != "" && msg.Username != req.Username {
+			continue
+		}
+		matched = append(matched, msg)
+	}
+	return matched, nil
+}
+
+// runExport builds the archive of an export job
+func (h *Hub) runExport(job *ExportJob) {
+	job.Status = ExportStatusRunning
+	h.saveExportJob(job)
+	err := h.buildExport(job)
+	now := time.Now()
+	job.FinishedAt = &now
+	if err != nil {
+		log.Printf("Dışa aktarma başarısız (%s): %v", job.ID, err)
+		job.Status, job.Error = ExportStatusFailed, err.Error()
+		os.Remove(exportPath(job.ID))
+		h.saveExportJob(job)
+		return
+	}
+	job.Status, job.Progress = ExportStatusDone, 100
+	h.saveExportJob(job)
+	h.recordAudit("compliance_export_completed", "system", job.ID,
+		fmt.Sprintf("messages=%d, files=%d, manifest=%s", job.Messages, job.Files, job.ManifestHash))
+}
+
+func (h *Hub) buildExport(job *ExportJob) error {
+	ctx := context.Background()
+	channels, err := h.exportChannels(job.Request)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(exportsDir, 0700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(exportPath(job.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	archive := zip.NewWriter(out)
+	var entries []ExportEntry
+	// add writes a file into the archive and records its hash
+	add := func(name string, src io.Reader) error {
+		w, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		hasher := sha256.New()
+		size, err := io.Copy(io.MultiWriter(w, hasher), src)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, ExportEntry{Path: name, SHA256: hex.EncodeToString(hasher.Sum(nil)), Size: size})
+		return nil
+	}
+
+	// Messages take the first 80 percent of the progress, files the rest
+	var lines strings.Builder
+	var fileIDs []string
+	seenFiles := make(map[string]bool)
+	head := ""
+	for i, channel := range channels {
+		messages, err := h.exportMessages(ctx, channel, job.Request)
+		if err != nil {
+			return err
+		}
+		for _, msg := range messages {
+			record := exportedMessage{Message: msg}
+			if msg.Edited && msg.MessageID != "" {
+				record.Versions, _ = h.messageVersions(msg.MessageID)
+			}
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			hash := chainHash(head, data)
+			line, _ := json.Marshal(exportRecord{PrevHash: head, Hash: hash, Record: data})
+			head = hash
+			lines.Write(line)
+			lines.WriteByte('\n')
+			if msg.FileID != "" && !seenFiles[msg.FileID] {
+				seenFiles[msg.FileID] = true
+				fileIDs = append(fileIDs, msg.FileID)
+			}
+		}
+		job.Messages += len(messages)
+		job.Progress = (i + 1) * 80 / len(channels)
+		h.saveExportJob(job)
+	}
+	if err := add("messages.jsonl", strings.NewReader(lines.String())); err != nil {
+		return err
+	}
+
+	var files []FileMeta
+	for i, id := range fileIDs {
+		meta, ok := getFileMeta(id)
+		if !ok {
+			continue
+		}
+		blob, err := blobStore.Open(meta.Blob)
+		if err != nil {
+			continue
+		}
+		err = add("files/"+meta.ID, blob)
+		blob.Close()
+		if err != nil {
+			return err
+		}
+		files = append(files, *meta)
+		job.Files++
+		job.Progress = 80 + (i+1)*19/len(fileIDs)
+		h.saveExportJob(job)
+	}
+	filesJSON, _ := json.MarshalIndent(files, "", "  ")
+	if err := add("files.json", strings.NewReader(string(filesJSON))); err != nil {
+		return err
+	}
+
+	host, _ := os.Hostname()
+	now := time.Now()
+	manifest := ExportManifest{
+		ExportID:    job.ID,
+		Request:     job.Request,
+		Channels:    channels,
+		Messages:    job.Messages,
+		ChainHead:   head,
+		Files:       entries,
+		Custody:     append(job.Custody, CustodyEvent{Action: "generated", Actor: "system", At: now}),
+		Host:        host,
+		GeneratedAt: now,
+		PublicKey:   exportPublicKey(),
+	}
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(manifestJSON)
+	job.ManifestHash = hex.EncodeToString(sum[:])
+	job.Custody = manifest.Custody
+	if err := add("manifest.json", strings.NewReader(string(manifestJSON))); err != nil {
+		return err
+	}
+	signature := ed25519.Sign(exportSigningKey, manifestJSON)
+	if err := add("manifest.sig", strings.NewReader(base64.StdEncoding.EncodeToString(signature))); err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// exportPublicKey is the public half of the export signing key, base64 encoded
+func exportPublicKey() string {
+	return base64.StdEncoding.EncodeToString(exportSigningKey.Public().(ed25519.PublicKey))
+}
+
+// verifyManifest checks manifest.sig against the export signing key. Archives
+// built before manifests were signed with ed25519 carry an HMAC of the
+// manifest's hash instead.
+func verifyManifest(id string, manifest, sig []byte) bool {
+	if signature, err := base64.StdEncoding.DecodeString(string(sig)); err == nil &&
+		ed25519.Verify(exportSigningKey.Public().(ed25519.PublicKey), manifest, signature) {
+		return true
+	}
+	sum := sha256.Sum256(manifest)
+	return verifySignature(string(sig), "export", id, hex.EncodeToString(sum[:]))
+}
+
+// handleExportKey serves the public key export manifests are signed with, so a
+// recipient of an archive can check manifest.sig without the server's secret
+func handleExportKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"algorithm": "ed25519", "publicKey": exportPublicKey()})
+}
+
+// verifyExport checks an archive against its manifest, message chain and
+// signature; it returns what doesn't match
+func verifyExport(id string) ([]string, error) {
+	archive, err := zip.OpenReader(exportPath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	contents := make(map[string][]byte)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		contents[f.Name] = data
+	}
+
+	problems := []string{}
+	var manifest ExportManifest
+	if err := json.Unmarshal(contents["manifest.json"], &manifest); err != nil {
+		return append(problems, "manifest.json is missing or invalid"), nil
+	}
+	if !verifyManifest(id, contents["manifest.json"], contents["manifest.sig"]) {
+		problems = append(problems, "manifest signature does not match")
+	}
+	listed := map[string]bool{"manifest.json": true, "manifest.sig": true}
+	for _, entry := range manifest.Files {
+		listed[entry.Path] = true
+		data, ok := contents[entry.Path]
+		if !ok {
+			problems = append(problems, entry.Path+" is missing")
+			continue
+		}
+		if fileSum := sha256.Sum256(data); hex.EncodeToString(fileSum[:]) != entry.SHA256 {
+			problems = append(problems, entry.Path+" does not match its hash")
+		}
+	}
+	for name := range contents {
+		if !listed[name] {
+			problems = append(problems, name+" is not in the manifest")
+		}
+	}
+
+	head := ""
+	for i, line := range strings.Split(strings.TrimSpace(string(contents["messages.jsonl"])), "\n") {
+		if line == "" {
+			continue
+		}
+		var record exportRecord
+		if json.Unmarshal([]byte(line), &record) != nil || record.PrevHash != head || record.Hash != chainHash(head, record.Record) {
+			problems = append(problems, fmt.Sprintf("message record %d breaks the hash chain", i+1))
+			break
+		}
+		head = record.Hash
+	}
+	if head != manifest.ChainHead {
+		problems = append(problems, "message chain does not end at the manifest's chain head")
+	}
+	sort.Strings(problems)
+	return problems, nil
+}
+
+// handleAdminExports serves /admin/exports: GET lists the exports, POST starts
+// one with an ExportRequest body
+func handleAdminExports(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if hub.redis == nil {
+		http.Error(w, "Exports require Redis", http.StatusServiceUnavailable)
+		return
+	}
+	ctx := context.Background()
+	switch r.Method {
+	case "GET":
+		ids, err := hub.redis.ZRevRange(ctx, exportIndexKey, 0, -1).Result()
+		if err != nil {
+			http.Error(w, "Error reading exports", http.StatusInternalServerError)
+			return
+		}
+		jobs := []ExportJob{}
+		for _, id := range ids {
+			if job, ok := hub.getExportJob(id); ok {
+				jobs = append(jobs, *job)
+			} else {
+				// The job expired, its archive goes with it
+				hub.redis.ZRem(ctx, exportIndexKey, id)
+				os.Remove(exportPath(id))
+			}
+		}
+		writeJSON(w, http.StatusOK, jobs)
+	case "POST":
+		var req ExportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		req.Username, req.Channel = strings.TrimSpace(req.Username), strings.TrimSpace(req.Channel)
+		req.Reference = strings.TrimSpace(req.Reference)
+		if req.Username == "" && req.Channel == "" {
+			http.Error(w, "username or channel required", http.StatusBadRequest)
+			return
+		}
+		if req.Reference == "" {
+			http.Error(w, "reference required", http.StatusBadRequest)
+			return
+		}
+		if req.To.IsZero() {
+			req.To = time.Now()
+		}
+		if req.To.Before(req.From) {
+			http.Error(w, "from must be before to", http.StatusBadRequest)
+			return
+		}
+		admin, _ := adminFromRequest(r)
+		now := time.Now()
+		job := &ExportJob{
+			ID:        newULID(),
+			Request:   req,
+			Status:    ExportStatusQueued,
+			Custody:   []CustodyEvent{{Action: "requested", Actor: admin, At: now}},
+			CreatedAt: now,
+		}
+		hub.saveExportJob(job)
+		hub.redis.ZAdd(ctx, exportIndexKey, &redis.Z{Score: float64(now.UnixMilli()), Member: job.ID})
+		target := req.Username
+		if req.Channel != "" {
+			target = strings.TrimSpace(target + " #" + req.Channel)
+		}
+		hub.recordAudit("compliance_export_requested", admin, target,
+			fmt.Sprintf("id=%s, reference=%s, %s - %s", job.ID, req.Reference, req.From.Format(time.RFC3339), req.To.Format(time.RFC3339)))
+		go hub.runExport(job)
+		writeJSON(w, http.StatusAccepted, job)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminExport serves /admin/exports/{id}: GET shows the job, DELETE
+// removes it with its archive, /download returns the archive and /verify
+// checks it
+func handleAdminExport(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if hub.redis == nil {
+		http.Error(w, "Exports require Redis", http.StatusServiceUnavailable)
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/exports/"), "/"), "/")
+	if len(parts) > 2 || !isULID(parts[0]) {
+		http.NotFound(w, r)
+		return
+	}
+	job, ok := hub.getExportJob(parts[0])
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	admin, _ := adminFromRequest(r)
+	resource := ""
+	if len(parts) == 2 {
+		resource = parts[1]
+	}
+
+	switch {
+	case resource == "" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, job)
+	case resource == "" && r.Method == "DELETE":
+		if err := os.Remove(exportPath(job.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "Error removing export", http.StatusInternalServerError)
+			return
+		}
+		ctx := context.Background()
+		hub.redis.Del(ctx, exportKey(job.ID))
+		hub.redis.ZRem(ctx, exportIndexKey, job.ID)
+		hub.recordAudit("compliance_export_deleted", admin, job.ID, "reference="+job.Request.Reference)
+		w.WriteHeader(http.StatusNoContent)
+	case resource == "download" && r.Method == "GET":
+		if job.Status != ExportStatusDone {
+			http.Error(w, "Export is not ready", http.StatusConflict)
+			return
+		}
+		f, err := os.Open(exportPath(job.ID))
+		if err != nil {
+			http.Error(w, "Export archive not found on this instance", http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		job.Custody = append(job.Custody, CustodyEvent{Action: "downloaded", Actor: admin, At: time.Now()})
+		hub.saveExportJob(job)
+		hub.recordAudit("compliance_export_downloaded", admin, job.ID, "manifest="+job.ManifestHash)
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%s.zip"`, job.ID))
+		http.ServeContent(w, r, job.ID+".zip", *job.FinishedAt, f)
+	case resource == "verify" && r.Method == "GET":
+		if job.Status != ExportStatusDone {
+			http.Error(w, "Export is not ready", http.StatusConflict)
+			return
+		}
+		problems, err := verifyExport(job.ID)
+		if err != nil {
+			http.Error(w, "Export archive not found on this instance", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"valid": len(problems) == 0, "problems": problems})
+	case resource == "" || resource == "download" || resource == "verify":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
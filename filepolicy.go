@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// Types accepted when UPLOAD_ALLOWED_TYPES isn't set
+var defaultAllowedTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"image/bmp",
+	"text/plain",
+	"application/pdf",
+	"application/zip",
+	"application/x-zip-compressed",
+	"application/rar",
+	"application/msword",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"application/vnd.ms-excel",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// Extensions rejected when UPLOAD_DENIED_EXTENSIONS isn't set
+var defaultDeniedExtensions = []string{
+	".exe", ".dll", ".com", ".scr", ".msi", ".bat", ".cmd", ".ps1", ".sh", ".jar", ".app",
+}
+
+// Upload type policy. Types may be exact ("image/png") or wildcards ("image/*", "*");
+// the deny list wins over the allow list.
+var (
+	uploadAllowedTypes     = envListOr("UPLOAD_ALLOWED_TYPES", defaultAllowedTypes)
+	uploadDeniedTypes      = envList("UPLOAD_DENIED_TYPES")
+	uploadDeniedExtensions = envListOr("UPLOAD_DENIED_EXTENSIONS", defaultDeniedExtensions)
+	uploadAllowExecutables = envBool("UPLOAD_ALLOW_EXECUTABLES", false)
+)
+
+// Executable headers that are rejected whatever the declared type or extension
+var executableSignatures = []struct {
+	Name  string
+	Magic []byte
+}{
+	{"PE", []byte("MZ")},
+	{"ELF", []byte("\x7fELF")},
+	{"Mach-O", []byte{0xfe, 0xed, 0xfa, 0xce}},
+	{"Mach-O", []byte{0xfe, 0xed, 0xfa, 0xcf}},
+	{"Mach-O", []byte{0xce, 0xfa, 0xed, 0xfe}},
+	{"Mach-O", []byte{0xcf, 0xfa, 0xed, 0xfe}},
+	{"Mach-O universal", []byte{0xca, 0xfe, 0xba, 0xbe}},
+	{"script", []byte("#!")},
+}
+
+// matchesType reports whether contentType matches any of the patterns
+func matchesType(contentType string, patterns []string) bool {
+	if parsed, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = parsed
+	}
+	contentType = strings.ToLower(contentType)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		switch {
+		case pattern == "*", pattern == contentType:
+			return true
+		case strings.HasSuffix(pattern, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(pattern, "*")):
+			return true
+		}
+	}
+	return false
+}
+
+// uploadTypeAllowed applies the configured allow/deny lists to a declared type
+func uploadTypeAllowed(contentType string) bool {
+	return !matchesType(contentType, uploadDeniedTypes) && matchesType(contentType, uploadAllowedTypes)
+}
+
+// uploadExtensionDenied reports whether the filename has a denied extension
+func uploadExtensionDenied(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, denied := range uploadDeniedExtensions {
+		if ext != "" && ext == strings.ToLower(denied) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectExecutable inspects the first bytes of an upload and returns the kind of
+// executable it is, or "" for anything else. The reader is rewound afterwards.
+func detectExecutable(file io.ReadSeeker) (string, error) {
+	head := make([]byte, 16)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	// A UTF-8 BOM in front of a shebang is still run by some shells
+	head = bytes.TrimPrefix(head[:n], []byte("\xef\xbb\xbf"))
+	for _, sig := range executableSignatures {
+		if bytes.HasPrefix(head, sig.Magic) {
+			return sig.Name, nil
+		}
+	}
+	return "", nil
+}
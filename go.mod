@@ -4,10 +4,31 @@ go 1.21
 
 require github.com/gorilla/websocket v1.5.1
 
-require github.com/go-redis/redis/v8 v8.11.5
+require (
+	github.com/buckket/go-blurhash v1.1.0
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/quic-go/quic-go v0.39.0
+	github.com/quic-go/webtransport-go v0.6.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	golang.org/x/crypto v0.14.0
+	golang.org/x/image v0.14.0
+	golang.org/x/text v0.14.0
+	google.golang.org/protobuf v1.34.2
+)
 
 require (
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/go-task/slim-sprig v0.0.0-20230315185526-52ccab3ef572 // indirect
+	github.com/google/pprof v0.0.0-20230821062121-407c9e7a662f // indirect
+	github.com/onsi/ginkgo/v2 v2.12.0 // indirect
+	github.com/quic-go/qpack v0.4.0 // indirect
+	github.com/quic-go/qtls-go1-20 v0.3.4 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	go.uber.org/mock v0.3.0 // indirect
+	golang.org/x/exp v0.0.0-20230817173708-d852ddb80c63 // indirect
+	golang.org/x/mod v0.12.0 // indirect
 	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/tools v0.12.1-0.20230815132531-74c255bcf846 // indirect
 )
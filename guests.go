@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"regexp"
+	"time"
+)
+
+// With GUEST_MODE, connections not signed in to an account are guests: the
+// server names them guest-1234 whatever name they send and tells them with
+// {"type":"guest","username":"guest-1234","token":"...","restrictions":[...]}
+// right before user_connected. Guests carry "guest":true in their identity
+// (presence, roster, user_connected) and in their messages; names of that form
+// are reserved for them. A guest reconnecting with /ws?guest=<token> keeps its
+// name while nobody else took it. GUEST_RESTRICTIONS limits guests:
+// "read-only" refuses their messages, reactions and votes with
+// guest_restricted, "no-uploads" their upload tokens.
+
+var (
+	guestMode         = envBool("GUEST_MODE", false)
+	guestRestrictions = guestRestrictionsFromEnv()
+)
+
+const (
+	guestReadOnly  = "read-only"
+	guestNoUploads = "no-uploads"
+)
+
+// Names the server gives guests, refused to everyone else
+var guestNamePattern = regexp.MustCompile(`(?i)^guest[-_. ]?[0-9]+$`)
+
+// GuestFrame tells a guest connection the name it was given
+type GuestFrame struct {
+	Type         string   `json:"type"`
+	Username     string   `json:"username"`
+	Token        string   `json:"token"` // keeps the name on reconnect, as /ws?guest=
+	Restrictions []string `json:"restrictions,omitempty"`
+}
+
+// guestClaims is the signed content of a guest token
+type guestClaims struct {
+	Username string `json:"u"`
+	Expires  int64  `json:"exp"`
+}
+
+func guestRestrictionsFromEnv() map[string]bool {
+	restrictions := make(map[string]bool)
+	for _, item := range envList("GUEST_RESTRICTIONS") {
+		switch item {
+		case guestReadOnly, guestNoUploads:
+			restrictions[item] = true
+		default:
+			log.Printf("Geçersiz GUEST_RESTRICTIONS öğesi %q, yok sayılıyor", item)
+		}
+	}
+	return restrictions
+}
+
+// isGuestName reports whether a name is of the form given to guests
+func isGuestName(name string) bool {
+	return guestNamePattern.MatchString(name)
+}
+
+// isGuest reports whether the connection is a guest
+func (c *Client) isGuest() bool {
+	return guestMode && c.account == ""
+}
+
+// guestRestricted reports whether a GUEST_RESTRICTIONS item applies to the
+// connection
+func (c *Client) guestRestricted(restriction string) bool {
+	return guestRestrictions[restriction] && c.isGuest()
+}
+
+// guestFromToken returns the name a guest token was issued for, "" when it
+// isn't valid
+func guestFromToken(token string) string {
+	var claims guestClaims
+	if token == "" || !parseClaims("guest", token, &claims) || time.Now().Unix() >= claims.Expires || !isGuestName(claims.Username) {
+		return ""
+	}
+	return claims.Username
+}
+
+// guestName returns the name of a guest connection: the one it has, the one
+// of its token while still free, or a new one
+func (h *Hub) guestName(c *Client) string {
+	if c.Username != "" {
+		return c.Username
+	}
+	if c.guestName != "" && h.usernameHolder(usernameSkeleton(c.guestName), c.guestName) == "" {
+		return c.guestName
+	}
+	for i := 0; i < 20; i++ {
+		limit := 10000
+		if i >= 10 {
+			limit = 1000000 // the short ones are taken
+		}
+		name := fmt.Sprintf("guest-%04d", rand.Intn(limit))
+		if h.claimGuestName(name) {
+			c.guestName = name
+			return name
+		}
+	}
+	log.Printf("Misafir adı bulunamadı, ID: %s", c.ID)
+	return ""
+}
+
+// claimGuestName claims a name nobody held so far
+func (h *Hub) claimGuestName(name string) bool {
+	skeleton := usernameSkeleton(name)
+	if h.redis == nil {
+		return h.usernameHolder(skeleton, name) == "" && !h.isOnline(name, nil)
+	}
+	claimed, err := h.redis.SetNX(context.Background(), usernameSkeletonKey(skeleton), name, usernameClaimTTL).Result()
+	return err == nil && claimed
+}
+
+// sendGuest tells a guest its name and how to keep it
+func (c *Client) sendGuest() {
+	frame := GuestFrame{
+		Type:     "guest",
+		Username: c.Username,
+		Token:    signClaims("guest", guestClaims{Username: c.Username, Expires: time.Now().Add(usernameClaimTTL).Unix()}),
+	}
+	for _, restriction := range []string{guestReadOnly, guestNoUploads} {
+		if guestRestrictions[restriction] {
+			frame.Restrictions = append(frame.Restrictions, restriction)
+		}
+	}
+	c.sendFrame(frame, PriorityHigh)
+}
+
+// guestsStage applies GUEST_RESTRICTIONS=read-only
+func guestsStage(h *Hub, m *pipelineMessage) bool {
+	if m.Msg.Type == "seen" || !m.Client.guestRestricted(guestReadOnly) {
+		return true
+	}
+	m.reject(ErrGuestRestricted, "Misafirler mesaj gönderemez, yazmak için giriş yapın")
+	return false
+}
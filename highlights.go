@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"unicode"
+)
+
+// Users keep highlight words per channel, like IRC highlights. They are stored
+// in Redis and loaded onto every connection of the user; when a live message
+// from someone else in that channel contains one of the words, the user's
+// connections get it with "highlight":true. History and resumed messages
+// aren't marked.
+
+const (
+	highlightMaxWords   = 20
+	highlightMaxWordLen = 32
+)
+
+// HighlightsRequest is the inbound {"type":"highlights","channel":"...","words":[...]}
+// frame; an empty list removes the channel's words
+type HighlightsRequest struct {
+	Type    string   `json:"type"`
+	Channel string   `json:"channel"`
+	Words   []string `json:"words"`
+}
+
+// HighlightsFrame lists the user's highlight words per channel, sent on
+// connect and after a change
+type HighlightsFrame struct {
+	Type     string              `json:"type"`
+	Channels map[string][]string `json:"channels"`
+}
+
+// Highlight words of a user, one hash field per channel with a JSON list
+func highlightsKey(username string) string {
+	return "websocket:highlights:" + username
+}
+
+// messageWords splits text into lowercase words
+func messageWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// cleanHighlights lowercases, dedupes and bounds the words of a request
+func cleanHighlights(words []string) []string {
+	var cleaned []string
+	for _, word := range words {
+		for _, w := range messageWords(word) {
+			w = truncate(w, highlightMaxWordLen)
+			if len(cleaned) < highlightMaxWords && !containsString(cleaned, w) {
+				cleaned = append(cleaned, w)
+			}
+		}
+	}
+	return cleaned
+}
+
+// highlighted reports whether a message contains one of the client's words
+// for its channel; the sender's own messages never count
+func (c *Client) highlighted(msg Message) bool {
+	if msg.Username == c.Username || msg.Message == "" {
+		return false
+	}
+	c.highlightsMutex.RLock()
+	words := c.highlights[msg.Channel]
+	c.highlightsMutex.RUnlock()
+	if len(words) == 0 {
+		return false
+	}
+	for _, word := range messageWords(msg.Message) {
+		if containsString(words, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) setHighlights(channels map[string][]string) {
+	c.highlightsMutex.Lock()
+	c.highlights = channels
+	c.highlightsMutex.Unlock()
+}
+
+// loadHighlights reads the user's highlight words after they connect and tells
+// the client about them
+func (h *Hub) loadHighlights(c *Client) {
+	if h.redis == nil || c.Username == "" {
+		return
+	}
+	stored, err := h.redis.HGetAll(context.Background(), highlightsKey(c.Username)).Result()
+	if err != nil || len(stored) == 0 {
+		return
+	}
+	channels := make(map[string][]string, len(stored))
+	for channel, raw := range stored {
+		var words []string
+		if json.Unmarshal([]byte(raw), &words) == nil && len(words) > 0 {
+			channels[channel] = words
+		}
+	}
+	c.setHighlights(channels)
+	c.sendFrame(HighlightsFrame{Type: "highlights", Channels: channels}, PriorityHigh)
+}
+
+// handleHighlights replaces the sender's highlight words of a channel on
+// every connection of the user
+func (h *Hub) handleHighlights(c *Client, raw []byte) {
+	var req HighlightsRequest
+	if err := json.Unmarshal(raw, &req); err != nil || c.Username == "" {
+		return
+	}
+	if req.Channel == "" {
+		c.sendError(ErrInvalidChannel, "Kanal belirtilmedi")
+		return
+	}
+	words := cleanHighlights(req.Words)
+
+	c.highlightsMutex.RLock()
+	channels := make(map[string][]string, len(c.highlights)+1)
+	for channel, existing := range c.highlights {
+		channels[channel] = existing
+	}
+	c.highlightsMutex.RUnlock()
+	if len(words) > 0 {
+		channels[req.Channel] = words
+	} else {
+		delete(channels, req.Channel)
+	}
+
+	if h.redis != nil {
+		ctx := context.Background()
+		var err error
+		if len(words) > 0 {
+			data, _ := json.Marshal(words)
+			err = h.redis.HSet(ctx, highlightsKey(c.Username), req.Channel, data).Err()
+		} else {
+			err = h.redis.HDel(ctx, highlightsKey(c.Username), req.Channel).Err()
+		}
+		if err != nil {
+			log.Printf("Vurgu kelimeleri kaydedilemedi: %v", err)
+		}
+	}
+
+	frame, err := encodeFrame(HighlightsFrame{Type: "highlights", Channels: channels})
+	if err != nil {
+		return
+	}
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for client := range h.clients {
+		if client == c || (client.Username == c.Username && client.Username != "") {
+			client.setHighlights(channels)
+			client.enqueue(frame, PriorityHigh)
+		}
+	}
+}
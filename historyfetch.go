@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// A client following many channels asks for all their histories at once with
+// {"type":"history","channels":[...]}. The snapshots are read by a bounded pool
+// of HISTORY_FETCH_WORKERS and each channel's history goes out as its own
+// history frame as soon as it is read, followed by the channel's pins, so one
+// slow channel doesn't hold back the others.
+
+var (
+	historyFetchWorkers     = envInt("HISTORY_FETCH_WORKERS", 4)
+	historyFetchMaxChannels = envInt("HISTORY_FETCH_MAX_CHANNELS", 50)
+)
+
+// HistoryRequest is the inbound {"type":"history","channels":["genel",...]} frame
+type HistoryRequest struct {
+	Type     string   `json:"type"`
+	Channels []string `json:"channels"`
+}
+
+// HistoryFrame carries the recent messages of one channel, oldest first. In a
+// text-only channel file and image messages are listed as stubs instead.
+type HistoryFrame struct {
+	Type      string           `json:"type"`
+	Channel   string           `json:"channel"`
+	Messages  []Message        `json:"messages"`
+	Stubs     []MediaStubFrame `json:"stubs,omitempty"`
+	Timezone  string           `json:"timezone"`       // the days are counted in, see daymarkers.go
+	Days      []DayMarker      `json:"days,omitempty"` // separators before messages and stubs
+	Timestamp time.Time        `json:"timestamp"`
+}
+
+// historyFrame reads a channel's history snapshot for a client, applying its
+// media filter and lite mode
+func (h *Hub) historyFrame(c *Client, channel string) (HistoryFrame, error) {
+	frame := HistoryFrame{Type: "history", Channel: channel, Messages: []Message{}}
+	frames, err := h.historyFrames(channel)
+	if err != nil {
+		return frame, err
+	}
+	var messages []Message
+	for _, raw := range frames {
+		var env struct {
+			Payload Message `json:"payload"`
+		}
+		if json.Unmarshal(raw, &env) == nil {
+			messages = append(messages, env.Payload)
+		}
+	}
+	frame.Messages, frame.Stubs = c.viewMessages(channel, messages)
+	location := h.dayLocation(c, channel)
+	frame.Timezone, frame.Days = location.String(), dayMarkers(messages, location)
+	frame.Timestamp = time.Now()
+	return frame, nil
+}
+
+// viewMessages prepares stored messages of a channel for a client: media
+// messages become stubs in its text-only channels, lite clients get them
+// without image placeholders and previews
+func (c *Client) viewMessages(channel string, stored []Message) ([]Message, []MediaStubFrame) {
+	messages := []Message{}
+	var stubs []MediaStubFrame
+	textOnly, lite := c.textOnly(channel), c.lite.Load()
+	for _, msg := range stored {
+		if textOnly && isMediaMessage(msg) && msg.MessageID != "" {
+			stubs = append(stubs, mediaStub(msg))
+			continue
+		}
+		if lite {
+			msg.Blurhash, msg.Renditions, msg.Previews = "", nil, nil
+		}
+		messages = append(messages, msg)
+	}
+	return messages, stubs
+}
+
+// handleHistory sends the histories of the requested channels, fetched in
+// parallel
+func (h *Hub) handleHistory(c *Client, raw []byte) {
+	var req HistoryRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return
+	}
+	var channels []string
+	for _, channel := range req.Channels {
+		if channel == "" || containsString(channels, channel) {
+			continue
+		}
+		if len(channels) == historyFetchMaxChannels {
+			break
+		}
+		if isConversation(channel) && !c.inChannel(channel) {
+			c.sendError(ErrNotMember, "Bu konuşmanın üyesi değilsiniz")
+			continue
+		}
+		channels = append(channels, channel)
+	}
+	if len(channels) == 0 {
+		return
+	}
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < min(max(historyFetchWorkers, 1), len(channels)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for channel := range jobs {
+				h.sendMOTD(c, channel)
+				frame, err := h.historyFrame(c, channel)
+				if err != nil {
+					log.Printf("Geçmiş mesajları alma hatası (%s): %v", channel, err)
+					continue
+				}
+				c.sendFrame(frame, PriorityNormal)
+				h.sendPins(c, channel)
+			}
+		}()
+	}
+	for _, channel := range channels {
+		jobs <- channel
+	}
+	close(jobs)
+	wg.Wait()
+	log.Printf("%d kanalın geçmişi gönderildi: kullanıcı=%s", len(channels), c.Username)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+)
+
+// Every user gets a display color and a short hash derived from their username,
+// the identity that persists across connections (user IDs change on every
+// connect). The server hands them out in presence and roster frames, so all
+// clients render a user the same way, and the hash tells apart names that look
+// alike ("melih" and "meIih").
+
+// Identity is how clients render a user
+type Identity struct {
+	Color string `json:"color"`           // "#rrggbb", readable on a light background
+	Hash  string `json:"hash"`            // 4 hex characters, shown next to the name
+	Guest bool   `json:"guest,omitempty"` // a guest the server named, see guests.go
+}
+
+// userIdentity derives the identity of a username
+func userIdentity(username string) Identity {
+	sum := sha256.Sum256([]byte("identity:" + username))
+	hue := float64(binary.BigEndian.Uint16(sum[2:4])) / 65536 * 360
+	return Identity{Color: hslColor(hue, 0.65, 0.42), Hash: hex.EncodeToString(sum[:2]), Guest: isGuestName(username)}
+}
+
+// hslColor converts a hue in degrees and saturation and lightness between 0
+// and 1 to a "#rrggbb" color
+func hslColor(h, s, l float64) string {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	channel := func(v float64) int { return int(math.Round((v + m) * 255)) }
+	return fmt.Sprintf("#%02x%02x%02x", channel(r), channel(g), channel(b))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+// Crockford base32 alphabet used by ULIDs
+const ulidAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a 26 character, lexicographically time-sortable identifier
+// (48 bit millisecond timestamp followed by 80 random bits)
+func newULID() string {
+	var raw [16]byte
+	binary.BigEndian.PutUint64(raw[:8], uint64(time.Now().UnixMilli())<<16)
+	if _, err := rand.Read(raw[6:]); err != nil {
+		panic(err)
+	}
+
+	// Encode 128 bits as 26 base32 characters, most significant first
+	var out [26]byte
+	hi := binary.BigEndian.Uint64(raw[:8])
+	lo := binary.BigEndian.Uint64(raw[8:])
+	for i := 25; i >= 0; i-- {
+		out[i] = ulidAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// isULID validates an identifier produced by newULID
+func isULID(id string) bool {
+	if len(id) != 26 {
+		return false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune(ulidAlphabet, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// ulidTime returns the creation time encoded in a ULID's first 10 characters
+func ulidTime(id string) time.Time {
+	var ms int64
+	for _, c := range id[:10] {
+		ms = ms<<5 | int64(strings.IndexRune(ulidAlphabet, c))
+	}
+	return time.UnixMilli(ms)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/buckket/go-blurhash"
+	"golang.org/x/image/draw"
+)
+
+// Responsive renditions of image uploads. They are keyed by the content hash,
+// so identical images uploaded several times share one set of renditions.
+var renditionWidths = []int{320, 640, 1280}
+
+var renditionsDir = filepath.Join(uploadsDir, "renditions")
+
+const (
+	// Larger images are served as uploaded, decoding them would take too much memory
+	imageMaxPixels = 50_000_000
+	// The blurhash is computed from a downscaled copy of this width
+	blurhashSampleWidth = 32
+)
+
+// Rendition is one resized copy of an image, listed in the image's Message
+type Rendition struct {
+	Width int    `json:"width"`
+	URL   string `json:"url"`
+}
+
+// renditionFormat picks the encoding of an image's renditions; animated GIFs
+// and unknown types get none
+func renditionFormat(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return "jpeg"
+	case "image/png":
+		return "png"
+	}
+	return ""
+}
+
+// renditionSizes returns the widths smaller than the original
+func renditionSizes(width int) []int {
+	var sizes []int
+	for _, w := range renditionWidths {
+		if w < width {
+			sizes = append(sizes, w)
+		}
+	}
+	return sizes
+}
+
+func renditionPath(blob string, width int, format string) string {
+	return filepath.Join(renditionsDir, blob+"_"+strconv.Itoa(width)+"."+format)
+}
+
+// decodeUploadImage decodes a stored image blob, refusing oversized images
+func decodeUploadImage(blob string) (image.Image, error) {
+	f, err := os.Open(filepath.Join(blobsDir, blob))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > imageMaxPixels {
+		return nil, fmt.Errorf("image too large: %dx%d", config.Width, config.Height)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// scaleImage resizes img to width, keeping the aspect ratio
+func scaleImage(img image.Image, width int, scaler draw.Scaler) *image.RGBA {
+	b := img.Bounds()
+	height := max(b.Dy()*width/b.Dx(), 1)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	scaler.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// describeImage fills the dimensions, blurhash and rendition URLs of an image
+// message. The renditions themselves are written by the processing stage; until
+// then their URLs serve the original.
+func describeImage(msg *Message, meta FileMeta) {
+	img, err := decodeUploadImage(meta.Blob)
+	if err != nil {
+		log.Printf("Görsel okunamadı (%s): %v", meta.ID, err)
+		return
+	}
+	b := img.Bounds()
+	msg.Width, msg.Height = b.Dx(), b.Dy()
+	if hash, err := blurhash.Encode(4, 3, scaleImage(img, min(blurhashSampleWidth, b.Dx()), draw.ApproxBiLinear)); err == nil {
+		msg.Blurhash = hash
+	}
+	if renditionFormat(meta.ContentType) == "" {
+		return
+	}
+	for _, w := range renditionSizes(b.Dx()) {
+		msg.Renditions = append(msg.Renditions, Rendition{
+			Width: w,
+			URL:   signedFileURL(meta.ID, meta.Channel) + "&w=" + strconv.Itoa(w),
+		})
+	}
+}
+
+// generateRenditions is the processing stage writing the resized copies. A
+// failure only costs the renditions, so it's logged instead of failing the upload.
+func generateRenditions(meta *FileMeta) error {
+	format := renditionFormat(meta.ContentType)
+	if format == "" {
+		return nil
+	}
+	var img image.Image
+	for _, w := range renditionWidths {
+		path := renditionPath(meta.Blob, w, format)
+		if _, err := os.Stat(path); err == nil {
+			continue // same content uploaded before
+		}
+		if img == nil {
+			decoded, err := decodeUploadImage(meta.Blob)
+			if err != nil {
+				log.Printf("Görsel boyutlandırılamadı (%s): %v", meta.ID, err)
+				return nil
+			}
+			img = decoded
+		}
+		if w >= img.Bounds().Dx() {
+			continue
+		}
+		if err := writeRendition(path, scaleImage(img, w, draw.CatmullRom), format); err != nil {
+			log.Printf("Görsel boyutu kaydedilemedi (%s, %d): %v", meta.ID, w, err)
+		}
+	}
+	return nil
+}
+
+// writeRendition encodes to a temporary file and renames it into place, so a
+// half written rendition is never served
+func writeRendition(path string, img image.Image, format string) error {
+	if err := os.MkdirAll(renditionsDir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(renditionsDir, ".rendition-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if format == "png" {
+		err = png.Encode(tmp, img)
+	} else {
+		err = jpeg.Encode(tmp, img, &jpeg.Options{Quality: 82})
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// renditionFile returns the stored rendition of an upload for a requested width
+func renditionFile(meta *FileMeta, width string) (string, string, bool) {
+	format := renditionFormat(meta.ContentType)
+	w, err := strconv.Atoi(width)
+	if format == "" || err != nil {
+		return "", "", false
+	}
+	path := renditionPath(meta.Blob, w, format)
+	if _, err := os.Stat(path); err != nil {
+		return "", "", false
+	}
+	return path, "image/" + format, true
+}
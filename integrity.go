@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Stored messages carry an HMAC of their content, author and timestamp keyed
+// with SIGNING_SECRET. The integrity_check job recomputes it for every copy
+// in Redis (history list, resume log, history snapshot) and in the archive of
+// the last two days, and writes a message_tampered audit entry for each
+// mismatch. Messages stored before hashing started, or under an earlier key,
+// can't be checked and are skipped.
+
+const (
+	// When messages started carrying hashes under the current key, Unix ms
+	integritySinceKey = "websocket:integrity:since"
+	// Fingerprint of the key the hashes are made with
+	integrityKeyKey = "websocket:integrity:key"
+)
+
+// integrityParts are the signed fields of a message
+func integrityParts(msg Message) []string {
+	return []string{"message", msg.MessageID, msg.Channel, msg.Username,
+		msg.Timestamp.UTC().Format(time.RFC3339Nano), msg.Type, msg.Message, msg.FileID}
+}
+
+// messageIntegrity computes the hash of a message
+func messageIntegrity(msg Message) string {
+	return signValue(integrityParts(msg)...)
+}
+
+// markIntegrityStart records when hashing started, and starts over when the
+// signing key changed since older hashes can't be checked any more
+func (h *Hub) markIntegrityStart() {
+	if h.redis == nil {
+		return
+	}
+	ctx := context.Background()
+	fingerprint := signValue("integrity-key")
+	previous, err := h.redis.GetSet(ctx, integrityKeyKey, fingerprint).Result()
+	if err != nil && err != redis.Nil {
+		log.Printf("Bütünlük anahtarı okunamadı: %v", err)
+		return
+	}
+	now := time.Now().UnixMilli()
+	if previous == fingerprint {
+		h.redis.SetNX(ctx, integritySinceKey, now, 0)
+		return
+	}
+	if previous != "" {
+		log.Println("İmza anahtarı değişti, önceki mesajların bütünlüğü doğrulanamayacak")
+	}
+	h.redis.Set(ctx, integritySinceKey, now, 0)
+}
+
+// integrityCheck counts the outcome of one check run
+type integrityCheck struct {
+	since    time.Time
+	checked  int
+	skipped  int
+	tampered int
+}
+
+// verifyCopy checks one stored copy of a message and reports a mismatch
+func (h *Hub) verifyCopy(check *integrityCheck, msg Message, copy string) {
+	if msg.MessageID == "" || msg.Timestamp.Before(check.since) {
+		check.skipped++
+		return
+	}
+	check.checked++
+	if verifySignature(msg.Integrity, integrityParts(msg)...) {
+		return
+	}
+	check.tampered++
+	log.Printf("Bütünlük hatası: mesaj=%s, kanal=%s, kopya=%s", msg.MessageID, msg.Channel, copy)
+	h.recordAudit("message_tampered", "system", msg.Channel, fmt.Sprintf("messageId=%s copy=%s", msg.MessageID, copy))
+}
+
+// storedChannels lists the channels that have a stored history
+func (h *Hub) storedChannels(ctx context.Context) ([]string, error) {
+	var channels []string
+	iter := h.redis.Scan(ctx, 0, "websocket:messages:*", 200).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		if !strings.HasPrefix(key, "websocket:messages:seq:") {
+			channels = append(channels, strings.TrimPrefix(key, "websocket:messages:"))
+		}
+	}
+	return channels, iter.Err()
+}
+
+// checkIntegrity is the integrity_check job
+func (h *Hub) checkIntegrity(period string) error {
+	ctx := context.Background()
+	since, err := h.redis.Get(ctx, integritySinceKey).Int64()
+	if err != nil {
+		return fmt.Errorf("integrity start unknown: %w", err)
+	}
+	check := &integrityCheck{since: time.UnixMilli(since)}
+
+	channels, err := h.storedChannels(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, channel := range channels {
+		list, _ := h.redis.LRange(ctx, "websocket:messages:"+channel, 0, -1).Result()
+		for _, raw := range list {
+			var msg Message
+			if json.Unmarshal([]byte(raw), &msg) == nil {
+				h.verifyCopy(check, msg, "history")
+			}
+		}
+		resumed, _ := h.redis.ZRange(ctx, resumeLogKey(channel), 0, -1).Result()
+		for _, raw := range resumed {
+			var msg Message
+			if json.Unmarshal([]byte(raw), &msg) == nil {
+				h.verifyCopy(check, msg, "resume")
+			}
+		}
+		h.verifySnapshot(ctx, check, channel)
+		if archiveDir != "" {
+			now := time.Now().UTC()
+			for _, day := range []string{now.AddDate(0, 0, -1).Format("2006-01-02"), now.Format("2006-01-02")} {
+				archived, err := readArchiveDay(channel, day)
+				if err != nil && !os.IsNotExist(err) {
+					log.Printf("Arşiv okunamadı (%s, %s): %v", channel, day, err)
+				}
+				for _, msg := range archived {
+					h.verifyCopy(check, msg, "archive:"+day)
+				}
+			}
+		}
+	}
+
+	h.recordAudit("integrity_checked", "system", period,
+		fmt.Sprintf("channels=%d checked=%d skipped=%d tampered=%d", len(channels), check.checked, check.skipped, check.tampered))
+	if check.tampered > 0 {
+		return fmt.Errorf("%d stored message copies failed the integrity check", check.tampered)
+	}
+	return nil
+}
+
+// verifySnapshot checks the history snapshot; a tampered one is rebuilt
+func (h *Hub) verifySnapshot(ctx context.Context, check *integrityCheck, channel string) {
+	blob, err := h.redis.Get(ctx, snapshotKey(channel)).Bytes()
+	if err != nil {
+		return
+	}
+	var snapshot historySnapshot
+	if json.Unmarshal(blob, &snapshot) != nil {
+		return
+	}
+	before := check.tampered
+	for _, frame := range snapshot.Messages {
+		var env struct {
+			Payload Message `json:"payload"`
+		}
+		if json.Unmarshal(frame, &env) == nil {
+			h.verifyCopy(check, env.Payload, "snapshot")
+		}
+	}
+	if check.tampered > before {
+		h.invalidateSnapshot(channel)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IP reputation checks for new connections. Listed IPs come from local CIDR
+// files (e.g. Tor exit lists) and, optionally, an external provider. Disabled
+// unless IP_REPUTATION_ACTION is "flag" (log and audit only) or "block".
+var (
+	ipReputationAction   = strings.ToLower(envString("IP_REPUTATION_ACTION", "off"))
+	ipBlocklistFiles     = envList("IP_BLOCKLIST_FILES")
+	ipAllowlistEntries   = envList("IP_ALLOWLIST")
+	ipReputationProvider = strings.ToLower(envString("IP_REPUTATION_PROVIDER", "")) // "abuseipdb"
+	ipReputationAPIKey   = envString("IP_REPUTATION_API_KEY", "")
+	ipReputationMinScore = envInt("IP_REPUTATION_MIN_SCORE", 75)
+	ipReputationCacheTTL = envDuration("IP_REPUTATION_CACHE_TTL", 6*time.Hour)
+)
+
+const abuseIPDBCheckURL = "https://api.abuseipdb.com/api/v2/check"
+
+// ipList is a set of networks loaded from configuration
+type ipList struct {
+	name string
+	nets []*net.IPNet
+}
+
+func (l ipList) contains(ip net.IP) bool {
+	for _, n := range l.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	ipAllowlist  ipList
+	ipBlocklists []ipList
+
+	// Fallback cache of provider lookups when Redis isn't available
+	ipReputationCache      = make(map[string]ipReputationCacheEntry)
+	ipReputationCacheMutex sync.Mutex
+)
+
+type ipReputationCacheEntry struct {
+	score   int
+	expires time.Time
+}
+
+// parseNetwork accepts a CIDR or a single address
+func parseNetwork(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", s)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
+
+// loadIPList reads one network per line; blank lines and # comments are skipped
+func loadIPList(path string) (ipList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ipList{}, err
+	}
+	defer f.Close()
+	list := ipList{name: path}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		n, err := parseNetwork(line)
+		if err != nil {
+			log.Printf("IP listesi %s: geçersiz satır atlandı: %q", path, line)
+			continue
+		}
+		list.nets = append(list.nets, n)
+	}
+	return list, scanner.Err()
+}
+
+// loadIPReputation reads the allow-list and blocklist files at startup
+func loadIPReputation() {
+	if ipReputationAction != "flag" && ipReputationAction != "block" {
+		return
+	}
+	ipAllowlist = ipList{name: "allowlist"}
+	for _, entry := range ipAllowlistEntries {
+		n, err := parseNetwork(entry)
+		if err != nil {
+			log.Printf("IP_ALLOWLIST geçersiz girdi atlandı: %q", entry)
+			continue
+		}
+		ipAllowlist.nets = append(ipAllowlist.nets, n)
+	}
+	for _, path := range ipBlocklistFiles {
+		list, err := loadIPList(path)
+		if err != nil {
+			log.Printf("IP listesi yüklenemedi (%s): %v", path, err)
+			continue
+		}
+		ipBlocklists = append(ipBlocklists, list)
+		log.Printf("IP listesi yüklendi: %s (%d ağ)", path, len(list.nets))
+	}
+}
+
+// ipReputationDecision is the outcome of checking one address
+type ipReputationDecision struct {
+	Action string // "allow", "flag" or "block"
+	Source string // list or provider that matched
+}
+
+// checkIPReputation decides what to do with a connection from ip and logs
+// every flag or block
+func (h *Hub) checkIPReputation(ip string) ipReputationDecision {
+	allow := ipReputationDecision{Action: "allow"}
+	if ipReputationAction != "flag" && ipReputationAction != "block" {
+		return allow
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return allow
+	}
+	if ipAllowlist.contains(addr) {
+		ipReputationDecisions.Add("allowlisted", 1)
+		return allow
+	}
+
+	source := ""
+	for _, list := range ipBlocklists {
+		if list.contains(addr) {
+			source = list.name
+			break
+		}
+	}
+	if source == "" && ipReputationProvider == "abuseipdb" && ipReputationAPIKey != "" {
+		if score, err := h.abuseScore(ip); err != nil {
+			log.Printf("IP itibar sorgusu başarısız (%s): %v", ip, err)
+		} else if score >= ipReputationMinScore {
+			source = fmt.Sprintf("abuseipdb:%d", score)
+		}
+	}
+	if source == "" {
+		ipReputationDecisions.Add("allow", 1)
+		return allow
+	}
+
+	decision := ipReputationDecision{Action: ipReputationAction, Source: source}
+	ipReputationDecisions.Add(decision.Action, 1)
+	h.recordAuditIncident("ip_"+decision.Action, ip, source, "kaynak="+source, time.Hour)
+	return decision
+}
+
+// abuseScore returns the provider's abuse confidence score (0-100), cached per IP
+func (h *Hub) abuseScore(ip string) (int, error) {
+	ctx := context.Background()
+	key := "websocket:ipreputation:" + ip
+	if h.redis != nil {
+		if score, err := h.redis.Get(ctx, key).Int(); err == nil {
+			return score, nil
+		}
+	} else {
+		ipReputationCacheMutex.Lock()
+		entry, ok := ipReputationCache[ip]
+		ipReputationCacheMutex.Unlock()
+		if ok && time.Now().Before(entry.expires) {
+			return entry.score, nil
+		}
+	}
+
+	req, err := http.NewRequest("GET", abuseIPDBCheckURL+"?"+url.Values{"ipAddress": {ip}, "maxAgeInDays": {"90"}}.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Key", ipReputationAPIKey)
+	req.Header.Set("Accept", "application/json")
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var result struct {
+		Data struct {
+			AbuseConfidenceScore int `json:"abuseConfidenceScore"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	score := result.Data.AbuseConfidenceScore
+
+	if h.redis != nil {
+		h.redis.Set(ctx, key, score, ipReputationCacheTTL)
+	} else {
+		ipReputationCacheMutex.Lock()
+		ipReputationCache[ip] = ipReputationCacheEntry{score: score, expires: time.Now().Add(ipReputationCacheTTL)}
+		ipReputationCacheMutex.Unlock()
+	}
+	return score, nil
+}
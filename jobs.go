@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// How often the scheduler checks for due jobs
+var jobCheckInterval = envDuration("JOB_CHECK_INTERVAL", time.Minute)
+
+// recurringJob runs once per period. Period maps a time to the period it falls
+// in (e.g. "2026-W42"); the job runs when a new period starts. Redis records the
+// runs so every period runs once across restarts and instances.
+type recurringJob struct {
+	Name   string
+	Period func(now time.Time) string
+	Run    func(h *Hub, period string) error
+}
+
+var recurringJobs = []recurringJob{
+	// Runs once a week is over, for the week before
+	{Name: "weekly_digest", Period: func(now time.Time) string { return weekID(now.AddDate(0, 0, -7)) }, Run: (*Hub).postWeeklyDigests},
+	// Hourly check of the stored messages against their hashes
+	{Name: "integrity_check", Period: func(now time.Time) string { return now.UTC().Format("2006-01-02T15") }, Run: (*Hub).checkIntegrity},
+}
+
+// JobStatus is the last run of a job as shown by the admin API
+type JobStatus struct {
+	Name       string    `json:"name"`
+	LastPeriod string    `json:"lastPeriod,omitempty"`
+	LastRunAt  time.Time `json:"lastRunAt"`
+	LastError  string    `json:"lastError,omitempty"`
+}
+
+func jobRunKey(name, period string) string {
+	return "websocket:jobs:run:" + name + ":" + period
+}
+
+func jobStatusKey(name string) string {
+	return "websocket:jobs:status:" + name
+}
+
+// runJobs checks for due jobs until the process exits
+func (h *Hub) runJobs() {
+	if h.redis == nil || len(recurringJobs) == 0 {
+		return
+	}
+	ticker := time.NewTicker(jobCheckInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if h.standbyPassive.Load() {
+			continue // the primary runs them
+		}
+		for _, job := range recurringJobs {
+			period := job.Period(now)
+			// The run marker doubles as a lock between instances
+			claimed, err := h.redis.SetNX(context.Background(), jobRunKey(job.Name, period), now.Unix(), 60*24*time.Hour).Result()
+			if err != nil || !claimed {
+				continue
+			}
+			h.runJob(job, period)
+		}
+	}
+}
+
+func (h *Hub) runJob(job recurringJob, period string) error {
+	log.Printf("Zamanlanmış iş çalışıyor: %s (%s)", job.Name, period)
+	err := job.Run(h, period)
+	status := JobStatus{Name: job.Name, LastPeriod: period, LastRunAt: time.Now()}
+	if err != nil {
+		log.Printf("Zamanlanmış iş hatası (%s): %v", job.Name, err)
+		status.LastError = err.Error()
+	}
+	if data, jsonErr := json.Marshal(status); jsonErr == nil {
+		h.redis.Set(context.Background(), jobStatusKey(job.Name), data, 0)
+	}
+	return err
+}
+
+// handleAdminJobs lists the recurring jobs (GET) or runs one now (POST {"name": "...", "period": "..."});
+// period defaults to the job's current period
+func handleAdminJobs(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if hub.redis == nil {
+		http.Error(w, "Jobs require Redis", http.StatusServiceUnavailable)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		statuses := make([]JobStatus, 0, len(recurringJobs))
+		for _, job := range recurringJobs {
+			status := JobStatus{Name: job.Name}
+			if data, err := hub.redis.Get(context.Background(), jobStatusKey(job.Name)).Bytes(); err == nil {
+				json.Unmarshal(data, &status)
+			}
+			statuses = append(statuses, status)
+		}
+		writeJSON(w, http.StatusOK, statuses)
+	case "POST":
+		var body struct {
+			Name   string `json:"name"`
+			Period string `json:"period"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		for _, job := range recurringJobs {
+			if job.Name != body.Name {
+				continue
+			}
+			if body.Period == "" {
+				body.Period = job.Period(time.Now())
+			}
+			admin, _ := adminFromRequest(r)
+			log.Printf("Zamanlanmış iş elle başlatıldı: %s (%s), admin=%s", job.Name, body.Period, admin)
+			if err := hub.runJob(job, body.Period); err != nil {
+				http.Error(w, "Job failed: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.Error(w, "Unknown job", http.StatusNotFound)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Public keys of end-to-end encrypting clients are pinned per conversation by
+// fingerprint, so participants are warned when someone's key changes (possible
+// man in the middle), like Signal's safety numbers. Until direct messages
+// exist, a conversation is a channel.
+
+const (
+	publicKeyMinBytes = 32
+	publicKeyMaxBytes = 1024
+)
+
+// KeyAnnouncement is the inbound {"type":"public_key","channel":"...","key":"<base64>"} frame
+type KeyAnnouncement struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+	Key     string `json:"key"`
+}
+
+// SecurityChangedFrame warns a conversation that a participant's key changed
+type SecurityChangedFrame struct {
+	Type                string    `json:"type"`
+	Channel             string    `json:"channel"`
+	Username            string    `json:"username"`
+	PreviousFingerprint string    `json:"previousFingerprint"`
+	Fingerprint         string    `json:"fingerprint"`
+	Timestamp           time.Time `json:"timestamp"`
+}
+
+// Pinned key fingerprints of a conversation, one hash field per username
+func keyPinsKey(channel string) string {
+	return "websocket:keys:" + channel
+}
+
+// keyFingerprint is the hex SHA-256 of the raw public key
+func keyFingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+// handleKeyAnnouncement pins the sender's key for the conversation and notifies
+// its participants when it differs from the pinned one
+func (h *Hub) handleKeyAnnouncement(c *Client, raw []byte) {
+	var req KeyAnnouncement
+	if err := json.Unmarshal(raw, &req); err != nil || c.Username == "" || req.Channel == "" {
+		return
+	}
+	key, err := base64.StdEncoding.DecodeString(req.Key)
+	if err != nil || len(key) < publicKeyMinBytes || len(key) > publicKeyMaxBytes {
+		c.sendError(ErrInvalidKey, "Geçersiz açık anahtar")
+		return
+	}
+	if h.redis == nil {
+		return
+	}
+
+	ctx := context.Background()
+	fingerprint := keyFingerprint(key)
+	previous, err := h.redis.HGet(ctx, keyPinsKey(req.Channel), c.Username).Result()
+	if err == nil && previous == fingerprint {
+		return
+	}
+	if err := h.redis.HSet(ctx, keyPinsKey(req.Channel), c.Username, fingerprint).Err(); err != nil {
+		log.Printf("Anahtar sabitleme hatası: %v", err)
+		return
+	}
+	if previous == "" {
+		log.Printf("Anahtar sabitlendi: kanal=%s, kullanıcı=%s, parmak izi=%s", req.Channel, c.Username, fingerprint)
+		return
+	}
+
+	h.recordAudit("key_changed", c.Username, req.Channel, fmt.Sprintf("önceki=%s, yeni=%s", previous, fingerprint))
+	frame, err := encodeFrame(SecurityChangedFrame{
+		Type:                "security_changed",
+		Channel:             req.Channel,
+		Username:            c.Username,
+		PreviousFingerprint: previous,
+		Fingerprint:         fingerprint,
+		Timestamp:           time.Now(),
+	})
+	if err != nil {
+		return
+	}
+	h.sendToChannel(req.Channel, frame, PriorityHigh, nil)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clients measure their round trip time with ping frames the server answers
+// right away, and now and then send the samples back in a latency_report. The
+// latest samples are kept per region and browser family and their percentiles
+// are published at /debug/vars as latency_rtt_ms, e.g. "DE/firefox". Each ping
+// also carries the client's last round trip, kept on the connection and listed
+// by /admin/connections.
+
+var (
+	// Header an edge proxy sets to the client's region; "unknown" without it
+	latencyRegionHeader = envString("LATENCY_REGION_HEADER", "CF-IPCountry")
+	// Samples kept per region and browser for the percentiles
+	latencyWindow = envInt("LATENCY_WINDOW", 1000)
+	// Reports arriving sooner after the previous one of the connection are ignored
+	latencyReportInterval = envDuration("LATENCY_REPORT_INTERVAL", 20*time.Second)
+)
+
+const (
+	latencyMaxSamples = 20    // per report, the rest are ignored
+	latencyMaxGroups  = 200   // region/browser pairs tracked
+	latencyMaxRTT     = 60000 // milliseconds, longer samples are bogus
+)
+
+// PingRequest is the inbound {"type":"ping","nonce":"...","clientTime":...} frame
+type PingRequest struct {
+	Type       string  `json:"type"`
+	Nonce      string  `json:"nonce,omitempty"` // echoed back to match the pong
+	ClientTime int64   `json:"clientTime"`      // client clock, echoed back
+	RTT        float64 `json:"rtt,omitempty"`   // the client's last round trip in milliseconds
+}
+
+// PongFrame answers a ping; nonce and clientTime are echoed so the client can
+// compute the round trip without keeping state, receivedAt and serverTime (when
+// the ping was read and answered) let it estimate clock offset
+type PongFrame struct {
+	Type       string    `json:"type"`
+	Nonce      string    `json:"nonce,omitempty"`
+	ClientTime int64     `json:"clientTime"`
+	ReceivedAt time.Time `json:"receivedAt"`
+	ServerTime time.Time `json:"serverTime"`
+}
+
+// LatencyReport is the inbound {"type":"latency_report","samples":[...]} frame
+// with round trip times in milliseconds
+type LatencyReport struct {
+	Type    string    `json:"type"`
+	Samples []float64 `json:"samples"`
+}
+
+// latencyLabels groups a connection's reports
+type latencyLabels struct {
+	Region string
+	Agent  string
+}
+
+func (l latencyLabels) String() string {
+	return l.Region + "/" + l.Agent
+}
+
+// requestLatencyLabels derives the region and browser family of a connection
+func requestLatencyLabels(r *http.Request) latencyLabels {
+	region := strings.ToUpper(strings.TrimSpace(r.Header.Get(latencyRegionHeader)))
+	if region == "" || len(region) > 8 || strings.IndexFunc(region, func(c rune) bool {
+		return (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-'
+	}) >= 0 {
+		region = "unknown"
+	}
+	return latencyLabels{Region: region, Agent: agentFamily(r.UserAgent())}
+}
+
+// agentFamily buckets a User-Agent into a handful of browser families, so the
+// metrics stay small
+func agentFamily(ua string) string {
+	ua = strings.ToLower(ua)
+	switch {
+	case ua == "":
+		return "unknown"
+	case strings.Contains(ua, "edg/"):
+		return "edge"
+	case strings.Contains(ua, "firefox/"):
+		return "firefox"
+	case strings.Contains(ua, "chrome/") || strings.Contains(ua, "crios/"):
+		return "chrome"
+	case strings.Contains(ua, "safari/"):
+		return "safari"
+	default:
+		return "other"
+	}
+}
+
+// latencyGroup is a ring of the latest samples of one region and browser
+type latencyGroup struct {
+	samples []float64
+	next    int
+	count   int64
+}
+
+// latencyStats aggregates reported round trip times
+type latencyStats struct {
+	mutex  sync.Mutex
+	groups map[string]*latencyGroup
+}
+
+// LatencyPercentiles is one group in the latency_rtt_ms metric
+type LatencyPercentiles struct {
+	Count int64   `json:"count"` // samples reported since start
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+}
+
+var rttStats = newLatencyStats()
+
+func newLatencyStats() *latencyStats {
+	s := &latencyStats{groups: make(map[string]*latencyGroup)}
+	expvar.Publish("latency_rtt_ms", expvar.Func(func() interface{} { return s.percentiles() }))
+	return s
+}
+
+// add records samples for a group; new groups beyond latencyMaxGroups are dropped
+func (s *latencyStats) add(key string, samples []float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	group := s.groups[key]
+	if group == nil {
+		if len(s.groups) >= latencyMaxGroups {
+			return
+		}
+		group = &latencyGroup{}
+		s.groups[key] = group
+	}
+	window := max(latencyWindow, 1)
+	for _, rtt := range samples {
+		if len(group.samples) < window {
+			group.samples = append(group.samples, rtt)
+		} else {
+			group.samples[group.next] = rtt
+			group.next = (group.next + 1) % window
+		}
+		group.count++
+	}
+}
+
+// percentiles computes the percentiles of every group's kept samples
+func (s *latencyStats) percentiles() map[string]LatencyPercentiles {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make(map[string]LatencyPercentiles, len(s.groups))
+	for key, group := range s.groups {
+		sorted := append([]float64(nil), group.samples...)
+		sort.Float64s(sorted)
+		result[key] = LatencyPercentiles{
+			Count: group.count,
+			P50:   percentile(sorted, 0.50),
+			P90:   percentile(sorted, 0.90),
+			P99:   percentile(sorted, 0.99),
+		}
+	}
+	return result
+}
+
+// percentile picks the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
+
+// handlePing answers a client's ping on the high priority lane, so queued chat
+// traffic doesn't count as network latency
+func (c *Client) handlePing(raw []byte) {
+	received := time.Now()
+	var req PingRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return
+	}
+	if req.RTT > 0 && req.RTT <= latencyMaxRTT {
+		c.rtt.Store(int64(req.RTT * 1000))
+		c.rttAt.Store(received.UnixNano())
+	}
+	c.sendFrame(PongFrame{
+		Type:       "pong",
+		Nonce:      truncate(req.Nonce, 64),
+		ClientTime: req.ClientTime,
+		ReceivedAt: received,
+		ServerTime: time.Now(),
+	}, PriorityHigh)
+}
+
+// handleLatencyReport adds a client's round trip samples to the metrics
+func (c *Client) handleLatencyReport(raw []byte) {
+	var req LatencyReport
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return
+	}
+	now := time.Now().UnixNano()
+	if last := c.lastLatencyReport.Load(); last != 0 && now-last < int64(latencyReportInterval) {
+		return
+	}
+	c.lastLatencyReport.Store(now)
+
+	samples := make([]float64, 0, min(len(req.Samples), latencyMaxSamples))
+	for _, rtt := range req.Samples {
+		if len(samples) == latencyMaxSamples {
+			break
+		}
+		if rtt >= 0 && rtt <= latencyMaxRTT {
+			samples = append(samples, rtt)
+		}
+	}
+	if len(samples) > 0 {
+		rttStats.add(c.latency.String(), samples)
+	}
+}
+
+// ConnectionInfo is one connection in the /admin/connections response
+type ConnectionInfo struct {
+	ID       string     `json:"id"`
+	Username string     `json:"username,omitempty"`
+	IP       string     `json:"ip"`
+	Region   string     `json:"region"`
+	Agent    string     `json:"agent"`
+	Encoding string     `json:"encoding"`
+	Protocol int32      `json:"protocol"`
+	Lite     bool       `json:"lite,omitempty"`
+	Quality  string     `json:"quality"`
+	RTT      *float64   `json:"rttMs,omitempty"` // last round trip the client reported
+	RTTAt    *time.Time `json:"rttAt,omitempty"`
+}
+
+// handleAdminConnections serves GET /admin/connections?username=, the
+// connections of this instance, slowest round trip first
+func handleAdminConnections(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	username := r.URL.Query().Get("username")
+
+	hub.mutex.RLock()
+	connections := make([]ConnectionInfo, 0, len(hub.clients))
+	for client := range hub.clients {
+		if username != "" && client.Username != username {
+			continue
+		}
+		info := ConnectionInfo{
+			ID:       client.ID,
+			Username: client.Username,
+			IP:       client.IP,
+			Region:   client.latency.Region,
+			Agent:    client.latency.Agent,
+			Encoding: client.wireEncoding(),
+			Protocol: client.protocol.Load(),
+			Lite:     client.lite.Load(),
+			Quality:  client.connectionQuality(),
+		}
+		if at := client.rttAt.Load(); at != 0 {
+			rtt, measured := float64(client.rtt.Load())/1000, time.Unix(0, at)
+			info.RTT, info.RTTAt = &rtt, &measured
+		}
+		connections = append(connections, info)
+	}
+	hub.mutex.RUnlock()
+
+	sort.SliceStable(connections, func(i, j int) bool {
+		a, b := connections[i].RTT, connections[j].RTT
+		return a != nil && (b == nil || *a > *b)
+	})
+	writeJSON(w, http.StatusOK, connections)
+}
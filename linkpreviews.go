@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"html"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// URLs in text messages are unfurled after the message went out: the first
+// few links to hosts in LINK_PREVIEW_HOSTS (or their subdomains) are fetched,
+// the head of the page is read for its OpenGraph title, description, image and
+// site name, and the channel gets a link_preview frame naming the message. The
+// previews are also stored with the message, so history has them. Fetched
+// previews, and pages without one, are cached in Redis by URL.
+
+var (
+	linkPreviewsEnabled = envBool("LINK_PREVIEWS", true)
+	linkPreviewHosts    = envListOr("LINK_PREVIEW_HOSTS", []string{"github.com", "wikipedia.org", "youtube.com", "youtu.be"})
+	linkPreviewTTL      = envDuration("LINK_PREVIEW_TTL", 24*time.Hour)
+	linkPreviewMaxBytes = envInt("LINK_PREVIEW_MAX_BYTES", 256<<10)
+
+	previewUpstream = newPreviewUpstream()
+)
+
+const (
+	linkPreviewsPerMessage = 3
+	linkPreviewMissTTL     = time.Hour // pages without a preview are tried again after this
+	linkPreviewTitleLen    = 200
+	linkPreviewTextLen     = 500
+	linkPreviewURLLen      = 2048
+)
+
+// Previews per outcome: "fetched", "cached", "none", "failed"
+var linkPreviewResults = expvar.NewMap("link_previews")
+
+var (
+	metaTagPattern  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	metaAttrPattern = regexp.MustCompile(`(?is)([a-z:_-]+)\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+	titleTagPattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	errPreviewHost  = errors.New("host not allowed for link previews")
+)
+
+// LinkPreview is the OpenGraph summary of a linked page
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"siteName,omitempty"`
+}
+
+// LinkPreviewFrame carries the previews of a message's links
+type LinkPreviewFrame struct {
+	Type      string        `json:"type"`
+	MessageID string        `json:"messageId"`
+	Channel   string        `json:"channel"`
+	Previews  []LinkPreview `json:"previews"`
+}
+
+// newPreviewUpstream fetches pages quickly and doesn't follow redirects off
+// the allowed hosts
+func newPreviewUpstream() *upstream {
+	u := newUpstream("preview", upstreamPolicy{
+		ConnectTimeout: 2 * time.Second,
+		ReadTimeout:    5 * time.Second,
+		Idempotent:     true,
+		MaxResponse:    int64(linkPreviewMaxBytes),
+	})
+	u.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 3 {
+			return http.ErrUseLastResponse
+		}
+		if !previewAllowed(req.URL) {
+			return errPreviewHost
+		}
+		return nil
+	}
+	return u
+}
+
+func linkPreviewKey(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return "websocket:preview:" + hex.EncodeToString(sum[:])
+}
+
+// previewAllowed reports whether a URL may be fetched for a preview
+func previewAllowed(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" || u.User != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range linkPreviewHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// previewURLs picks the links of a text worth unfurling, in order
+func previewURLs(text string) []string {
+	var urls []string
+	for _, raw := range urlPattern.FindAllString(text, -1) {
+		raw = trimLinkPunctuation(raw)
+		u, err := url.Parse(raw)
+		if err != nil || !safePreviewURL(raw) || !previewAllowed(u) || containsString(urls, raw) {
+			continue
+		}
+		urls = append(urls, raw)
+		if len(urls) == linkPreviewsPerMessage {
+			break
+		}
+	}
+	return urls
+}
+
+// trimLinkPunctuation drops sentence punctuation and the closing parenthesis
+// of a markdown link from the end of a URL; balanced parentheses stay
+func trimLinkPunctuation(raw string) string {
+	for {
+		trimmed := strings.TrimRight(raw, ".,;:!?'*")
+		if strings.HasSuffix(trimmed, ")") && strings.Count(trimmed, "(") < strings.Count(trimmed, ")") {
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+		if trimmed == raw {
+			return raw
+		}
+		raw = trimmed
+	}
+}
+
+// parseOpenGraph reads a preview from the head of an HTML page; nil without a title
+func parseOpenGraph(page []byte, base *url.URL) *LinkPreview {
+	meta := make(map[string]string)
+	for _, tag := range metaTagPattern.FindAll(page, -1) {
+		attrs := make(map[string]string)
+		for _, attr := range metaAttrPattern.FindAllSubmatch(tag, -1) {
+			attrs[strings.ToLower(string(attr[1]))] = strings.Trim(string(attr[2]), `"'`)
+		}
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(key)
+		if _, seen := meta[key]; key != "" && !seen {
+			meta[key] = previewText(attrs["content"], linkPreviewTextLen)
+		}
+	}
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if meta[key] != "" {
+				return meta[key]
+			}
+		}
+		return ""
+	}
+
+	preview := &LinkPreview{
+		URL:         base.String(),
+		Title:       truncate(first("og:title", "twitter:title"), linkPreviewTitleLen),
+		Description: first("og:description", "twitter:description", "description"),
+		SiteName:    truncate(first("og:site_name"), linkPreviewTitleLen),
+	}
+	if preview.Title == "" {
+		if m := titleTagPattern.FindSubmatch(page); m != nil {
+			preview.Title = previewText(string(m[1]), linkPreviewTitleLen)
+		}
+	}
+	if preview.Title == "" {
+		return nil
+	}
+	if raw := first("og:image", "og:image:url", "twitter:image"); raw != "" {
+		image, err := base.Parse(raw)
+		if err == nil && (image.Scheme == "http" || image.Scheme == "https") && safePreviewURL(image.String()) {
+			preview.Image = image.String()
+		}
+	}
+	return preview
+}
+
+// previewText unescapes and flattens a page text, dropping markup
+func previewText(text string, limit int) string {
+	text = sanitizeMarkdown(html.UnescapeString(text))
+	return truncate(strings.Join(strings.Fields(text), " "), limit)
+}
+
+// safePreviewURL keeps URLs that can't break out of an HTML attribute
+func safePreviewURL(raw string) bool {
+	return len(raw) <= linkPreviewURLLen && !strings.ContainsAny(raw, "\"'<> \\`")
+}
+
+// linkPreview returns the preview of a URL, from the cache or fetched; nil
+// when the page has none
+func (h *Hub) linkPreview(ctx context.Context, rawURL string) *LinkPreview {
+	if h.redis != nil {
+		cached, err := h.redis.Get(ctx, linkPreviewKey(rawURL)).Bytes()
+		if err == nil {
+			linkPreviewResults.Add("cached", 1)
+			var preview *LinkPreview
+			json.Unmarshal(cached, &preview) // "null" for pages without one
+			return preview
+		}
+		if err != redis.Nil {
+			log.Printf("Bağlantı önizlemesi önbellekten okunamadı: %v", err)
+		}
+	}
+
+	preview, err := fetchLinkPreview(ctx, rawURL)
+	switch {
+	case err != nil:
+		linkPreviewResults.Add("failed", 1)
+		log.Printf("Bağlantı önizlemesi alınamadı (%s): %v", rawURL, err)
+	case preview == nil:
+		linkPreviewResults.Add("none", 1)
+	default:
+		linkPreviewResults.Add("fetched", 1)
+	}
+	if h.redis != nil {
+		ttl := linkPreviewTTL
+		if preview == nil {
+			ttl = linkPreviewMissTTL
+		}
+		data, _ := json.Marshal(preview)
+		h.redis.Set(ctx, linkPreviewKey(rawURL), data, ttl)
+	}
+	return preview
+}
+
+// fetchLinkPreview downloads the head of a page and reads its preview
+func fetchLinkPreview(ctx context.Context, rawURL string) (*LinkPreview, error) {
+	base, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{
+		"Accept":     {"text/html,application/xhtml+xml"},
+		"User-Agent": {"Chatliyo-LinkPreview/1.0"},
+	}
+	resp, err := previewUpstream.do(ctx, "GET", rawURL, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != http.StatusOK {
+		return nil, previewUpstream.failure(resp, nil)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, nil
+	}
+	return parseOpenGraph(resp.Body, base), nil
+}
+
+// unfurlLinks fetches the previews of a message's links, stores them with the
+// message and sends them to its channel
+func (h *Hub) unfurlLinks(msg Message) {
+	urls := previewURLs(msg.Message)
+	if len(urls) == 0 {
+		return
+	}
+	ctx := context.Background()
+	var previews []LinkPreview
+	for _, rawURL := range urls {
+		if preview := h.linkPreview(ctx, rawURL); preview != nil {
+			previews = append(previews, *preview)
+		}
+	}
+	if len(previews) == 0 {
+		return
+	}
+
+	if h.redis != nil {
+		// Only while the text is the one unfurled: an edit in the meantime
+		// unfurls the new text itself
+		edited := false
+		addPreviews := func(stored *Message) bool {
+			if stored.Message != msg.Message {
+				edited = true
+				return false
+			}
+			stored.Previews = previews
+			return true
+		}
+		if _, err := h.rewriteMessage(ctx, msg, addPreviews, nil); err != nil {
+			log.Printf("Bağlantı önizlemeleri kaydedilemedi: %v", err)
+		}
+		if edited {
+			return
+		}
+	}
+
+	frame, err := encodeFrame(LinkPreviewFrame{
+		Type:      "link_preview",
+		MessageID: msg.MessageID,
+		Channel:   msg.Channel,
+		Previews:  previews,
+	})
+	if err != nil {
+		return
+	}
+	h.sendToChannel(msg.Channel, frame, PriorityLow, nil)
+}
+
+// previewsStage unfurls the links of text messages in the background
+func previewsStage(h *Hub, m *pipelineMessage) bool {
+	if m.Msg.Type == "text" && m.Msg.MessageID != "" && linkPreviewsEnabled {
+		go h.unfurlLinks(m.Msg)
+	}
+	return true
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// URLs longer than this are replaced with /l/{token} redirects; 0 disables shortening
+var linkShortenMinLength = envInt("LINK_SHORTEN_MIN_LENGTH", 0)
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+const linkTokenAlphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// ShortLink is a shortened URL together with its click statistics
+type ShortLink struct {
+	Token     string    `json:"token"`
+	URL       string    `json:"url"`
+	Author    string    `json:"author"`
+	Channel   string    `json:"channel"`
+	CreatedAt time.Time `json:"createdAt"`
+	Clicks    int64     `json:"clicks"`
+	Disabled  bool      `json:"disabled,omitempty"`
+}
+
+func linkKey(token string) string {
+	return fmt.Sprintf("websocket:link:%s", token)
+}
+
+func linksByAuthorKey(username string) string {
+	return fmt.Sprintf("websocket:links:author:%s", username)
+}
+
+const linkTokenLength = 8
+
+// newLinkToken returns a random token, every character equally likely: bytes
+// past the last whole multiple of the alphabet's size are drawn again
+func newLinkToken() (string, error) {
+	limit := 256 - 256%len(linkTokenAlphabet)
+	token := make([]byte, 0, linkTokenLength)
+	buf := make([]byte, 2*linkTokenLength)
+	for len(token) < linkTokenLength {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(token) < linkTokenLength {
+				token = append(token, linkTokenAlphabet[int(b)%len(linkTokenAlphabet)])
+			}
+		}
+	}
+	return string(token), nil
+}
+
+// shortenLinks replaces long URLs in the text with server-side redirects
+func (h *Hub) shortenLinks(text, author, channel string) string {
+	if h.redis == nil || linkShortenMinLength <= 0 {
+		return text
+	}
+	return urlPattern.ReplaceAllStringFunc(text, func(u string) string {
+		if len(u) < linkShortenMinLength {
+			return u
+		}
+		token, err := newLinkToken()
+		if err != nil {
+			log.Printf("Kısa link oluşturma hatası: %v", err)
+			return u
+		}
+		now := time.Now()
+		ctx := context.Background()
+		pipe := h.redis.TxPipeline()
+		pipe.HSet(ctx, linkKey(token), map[string]interface{}{
+			"url":       u,
+			"author":    author,
+			"channel":   channel,
+			"createdAt": now.Unix(),
+		})
+		pipe.ZAdd(ctx, linksByAuthorKey(author), &redis.Z{Score: float64(now.Unix()), Member: token})
+		pipe.ZAdd(ctx, "websocket:links", &redis.Z{Score: float64(now.Unix()), Member: token})
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("Kısa link kaydetme hatası: %v", err)
+			return u
+		}
+		return "/l/" + token
+	})
+}
+
+func (h *Hub) getShortLink(token string) (*ShortLink, bool) {
+	fields, err := h.redis.HGetAll(context.Background(), linkKey(token)).Result()
+	if err != nil || fields["url"] == "" {
+		return nil, false
+	}
+	createdAt, _ := strconv.ParseInt(fields["createdAt"], 10, 64)
+	clicks, _ := strconv.ParseInt(fields["clicks"], 10, 64)
+	return &ShortLink{
+		Token:     token,
+		URL:       fields["url"],
+		Author:    fields["author"],
+		Channel:   fields["channel"],
+		CreatedAt: time.Unix(createdAt, 0),
+		Clicks:    clicks,
+		Disabled:  fields["disabled"] == "1",
+	}, true
+}
+
+// listShortLinks resolves tokens from a sorted set, newest first
+func (h *Hub) listShortLinks(setKey string, limit int64) []ShortLink {
+	tokens, err := h.redis.ZRevRange(context.Background(), setKey, 0, limit-1).Result()
+	if err != nil {
+		return nil
+	}
+	links := make([]ShortLink, 0, len(tokens))
+	for _, token := range tokens {
+		if link, ok := h.getShortLink(token); ok {
+			links = append(links, *link)
+		}
+	}
+	return links
+}
+
+// handleShortLink redirects /l/{token} to the original URL and counts the click
+func handleShortLink(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, "/l/")
+	if hub.redis == nil || token == "" {
+		http.NotFound(w, r)
+		return
+	}
+	link, ok := hub.getShortLink(token)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if link.Disabled {
+		http.Error(w, "Link disabled", http.StatusGone)
+		return
+	}
+	hub.redis.HIncrBy(context.Background(), linkKey(token), "clicks", 1)
+	http.Redirect(w, r, link.URL, http.StatusFound)
+}
+
+// handleAdminLinks lists recent short links for abuse review (GET) and
+// disables or re-enables a link (POST {"token": "...", "disabled": true})
+func handleAdminLinks(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if hub.redis == nil {
+		http.Error(w, "Link shortener requires Redis", http.StatusServiceUnavailable)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		limit, err := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64)
+		if err != nil || limit <= 0 {
+			limit = 100
+		}
+		setKey := "websocket:links"
+		if author := r.URL.Query().Get("author"); author != "" {
+			setKey = linksByAuthorKey(author)
+		}
+		writeJSON(w, http.StatusOK, hub.listShortLinks(setKey, limit))
+	case "POST":
+		var body struct {
+			Token    string `json:"token"`
+			Disabled bool   `json:"disabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if _, ok := hub.getShortLink(body.Token); !ok {
+			http.NotFound(w, r)
+			return
+		}
+		value := "0"
+		if body.Disabled {
+			value = "1"
+		}
+		hub.redis.HSet(context.Background(), linkKey(body.Token), "disabled", value)
+		admin, _ := adminFromRequest(r)
+		log.Printf("Kısa link durumu değişti: %s, devre dışı=%v, admin=%s", body.Token, body.Disabled, admin)
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// sendLinkStats answers a "link_stats" request with the caller's own links
+func (h *Hub) sendLinkStats(c *Client) {
+	links := []ShortLink{}
+	if h.redis != nil && c.Username != "" {
+		links = h.listShortLinks(linksByAuthorKey(c.Username), 100)
+	}
+	c.sendFrame(LinkStatsFrame{Type: "link_stats", Links: links, Timestamp: time.Now()}, PriorityNormal)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Lite mode is a per-connection setting for clients on slow or metered links.
+// Presence traffic (the low priority lane) is not delivered, image previews and
+// the names behind reactions are stripped from outgoing frames, and normal
+// traffic is held back briefly so more frames share one WebSocket message.
+// Clients turn it on in their hello or at any time with a lite_mode frame.
+
+// How long lite clients wait for more frames before a chat batch is written
+var liteBatchDelay = envDuration("LITE_BATCH_DELAY", 500*time.Millisecond)
+
+// LiteModeRequest is the inbound {"type":"lite_mode","enabled":true} frame
+type LiteModeRequest struct {
+	Type    string `json:"type"`
+	Enabled bool   `json:"enabled"`
+}
+
+// LiteModeFrame confirms the lite mode setting of the connection
+type LiteModeFrame struct {
+	Type    string `json:"type"`
+	Enabled bool   `json:"enabled"`
+}
+
+// setLite switches lite mode for the connection and confirms the setting
+func (c *Client) setLite(enabled bool) {
+	c.lite.Store(enabled)
+	c.sendFrame(LiteModeFrame{Type: "lite_mode", Enabled: enabled}, PriorityHigh)
+}
+
+// handleLiteMode processes a client's lite_mode frame
+func (c *Client) handleLiteMode(raw []byte) {
+	var req LiteModeRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return
+	}
+	c.setLite(req.Enabled)
+}
+
+// liteFrame strips an encoded frame down for lite clients: messages lose their
+// blurhash and renditions, reactions the name of who reacted
+func liteFrame(frame []byte) []byte {
+	var env struct {
+		Type    string          `json:"type"`
+		ID      string          `json:"id"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(frame, &env); err != nil {
+		return frame
+	}
+	var payload interface{}
+	switch env.Type {
+	case "message":
+		var msg Message
+		if err := json.Unmarshal(env.Payload, &msg); err != nil || (msg.Blurhash == "" && msg.Renditions == nil && msg.Previews == nil) {
+			return frame
+		}
+		msg.Blurhash, msg.Renditions, msg.Previews = "", nil, nil
+		payload = msg
+	case "reaction":
+		var reaction ReactionFrame
+		if err := json.Unmarshal(env.Payload, &reaction); err != nil {
+			return frame
+		}
+		reaction.Username = ""
+		payload = reaction
+	default:
+		return frame
+	}
+	stripped, err := json.Marshal(Envelope{Type: env.Type, ID: env.ID, Payload: payload})
+	if err != nil {
+		return frame
+	}
+	return stripped
+}
@@ -8,11 +8,13 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -21,18 +23,46 @@ import (
 
 // Message represents a chat message
 type Message struct {
-	Username       string      `json:"username"`
-	Message        string      `json:"message"`
-	Timestamp      time.Time   `json:"timestamp"`
-	Channel        string      `json:"channel"`
-	Type           string      `json:"type,omitempty"` // "text", "file", "image", "seen", "numerology", "maya-astrology"
-	FileURL        string      `json:"fileUrl,omitempty"`
-	FileName       string      `json:"fileName,omitempty"`
-	FileSize       int64       `json:"fileSize,omitempty"`
-	SeenBy         []string    `json:"seenBy,omitempty"`         // Kullanıcı adları
-	ReplyTo        *ReplyInfo  `json:"replyTo,omitempty"`        // Yanıtlanan mesaj bilgisi
-	NumerologyData interface{} `json:"numerologyData,omitempty"` // Numeroloji API sonucu
-	MayaData       interface{} `json:"mayaData,omitempty"`       // Maya Astrolojisi API sonucu
+	MessageID      string              `json:"messageId,omitempty"`   // Sunucu tarafından atanan ULID
+	Seq            int64               `json:"seq,omitempty"`         // Kanal içi sıra numarası
+	ClientMsgID    string              `json:"clientMsgId,omitempty"` // İstemci idempotency anahtarı, yalnızca ack ve tekilleştirme için; saklanmaz
+	Username       string              `json:"username"`
+	Message        string              `json:"message"`
+	Timestamp      time.Time           `json:"timestamp"`
+	Channel        string              `json:"channel"`
+	Type           string              `json:"type,omitempty"` // "text", "file", "image", "seen", "numerology", "maya-astrology"
+	FileURL        string              `json:"fileUrl,omitempty"`
+	FileName       string              `json:"fileName,omitempty"`
+	FileSize       int64               `json:"fileSize,omitempty"`
+	FileID         string              `json:"fileId,omitempty"`         // Yükleme ID'si, file_status olayları ile eşleşir
+	Width          int                 `json:"width,omitempty"`          // Görsel genişliği (piksel)
+	Height         int                 `json:"height,omitempty"`         // Görsel yüksekliği (piksel)
+	Blurhash       string              `json:"blurhash,omitempty"`       // Görsel yüklenirken gösterilecek yer tutucu
+	Renditions     []Rendition         `json:"renditions,omitempty"`     // Küçültülmüş görsel boyutları
+	DeliveredTo    []string            `json:"deliveredTo,omitempty"`    // Mesajın ulaştığı kullanıcı adları
+	SeenBy         []string            `json:"seenBy,omitempty"`         // Mesajı okuyan kullanıcı adları
+	Reactions      map[string][]string `json:"reactions,omitempty"`      // Emoji -> kullanıcı adları
+	Mentions       []string            `json:"mentions,omitempty"`       // Bahsedilen kullanıcı adları
+	ReplyTo        *ReplyInfo          `json:"replyTo,omitempty"`        // Yanıtlanan mesaj bilgisi
+	NumerologyData *NumerologyResult   `json:"numerologyData,omitempty"` // Numeroloji sonucu, bkz. numerology.go
+	MayaData       interface{}         `json:"mayaData,omitempty"`       // Maya Astrolojisi API sonucu
+	Edited         bool                `json:"edited,omitempty"`         // Gönderildikten sonra düzenlendi mi
+	EditedAt       *time.Time          `json:"editedAt,omitempty"`       // Son düzenleme zamanı
+	Attachments    []MessageAttachment `json:"attachments,omitempty"`    // Bot mesajlarının rozet ve düğmeleri
+	Bot            string              `json:"bot,omitempty"`            // Mesajı gönderen komutun adı
+	ForwardedFrom  *ForwardInfo        `json:"forwardedFrom,omitempty"`  // Tetikleyiciyle başka kanaldan aktarıldıysa kaynağı
+	ThreadID       string              `json:"threadId,omitempty"`       // Ait olduğu konunun ilk mesajının ID'si
+	ThreadReplies  int                 `json:"threadReplies,omitempty"`  // Konunun ilk mesajındaki yanıt sayısı
+	Poll           *Poll               `json:"poll,omitempty"`           // Anket seçenekleri ve oy sayıları
+	ExpiresIn      int                 `json:"expiresIn,omitempty"`      // İstemcinin istediği ömür (saniye), saklanmaz
+	ExpiresAt      *time.Time          `json:"expiresAt,omitempty"`      // Mesajın silineceği zaman
+	Integrity      string              `json:"integrity,omitempty"`      // Saklanan içeriğin HMAC'i, bkz. integrity.go
+	Highlight      bool                `json:"highlight,omitempty"`      // Alıcının vurgu kelimelerinden birini içeriyor, yalnızca canlı teslimde
+	Previews       []LinkPreview       `json:"previews,omitempty"`       // Bağlantı önizlemeleri, bkz. linkpreviews.go
+	Segments       []MessageSegment    `json:"segments,omitempty"`       // Kod blokları ve spoiler'lar ile bölünmüş metin, bkz. segments.go
+	TraceID        string              `json:"traceId,omitempty"`        // Mesajın izleme kimliği, bkz. traces.go
+	Pending        bool                `json:"pending,omitempty"`        // Moderatör onayı bekliyor, yalnızca yazara ve moderatörlere gönderilir, bkz. review.go
+	Guest          bool                `json:"guest,omitempty"`          // Misafir kullanıcının mesajı, bkz. guests.go
 }
 
 // ReplyInfo contains information about the message being replied to
@@ -46,22 +76,162 @@ type ReplyInfo struct {
 // Client represents a connected WebSocket client
 type Client struct {
 	ID       string
-	Conn     *websocket.Conn
+	Conn     clientConn
 	Username string
-	Send     chan []byte
+	Send     chan []byte // normal priority: chat messages, history, receipts
+	IP       string
+
+	sendHigh  chan []byte   // acks, errors and system frames, never dropped
+	sendLow   chan []byte   // presence frames, dropped when the client falls behind
+	closed    chan struct{} // closed once the client is unregistered
+	closeOnce sync.Once
+
+	captchaOK bool         // IP passed the CAPTCHA gate, cached after the first check
+	protocol  atomic.Int32 // negotiated protocol version, 0 until a hello
+	encoding  atomic.Value // wire encoding (string), see negotiateEncoding
+	lite      atomic.Bool  // low-bandwidth mode, see lite.go
+	deepLink  string       // link from the hello frame, opened once the user connected; read pump only
+	// Channel sequence numbers of the poll cursor the session was opened with,
+	// resumed once the user connected; read pump only
+	resumeFrom map[string]int64
+
+	lastActive atomic.Int64 // unix nanoseconds of the last user activity, see presence.go
+
+	latency           latencyLabels // region and browser its latency reports count for
+	lastLatencyReport atomic.Int64  // unix nanoseconds of the last accepted report
+	rtt               atomic.Int64  // last round trip the client reported, microseconds
+	rttAt             atomic.Int64  // unix nanoseconds of that report
+
+	pingAt         atomic.Int64 // unix nanoseconds of the last WebSocket ping, see connquality.go
+	pongAt         atomic.Int64 // unix nanoseconds of the last pong
+	latePongs      atomic.Int32 // late pongs in a row
+	dropped        atomic.Int64 // low priority frames dropped because the lane was full
+	droppedChecked int64        // dropped at the previous quality check, only used by that check
+	quality        atomic.Value // current connection quality (string)
+
+	channelsMutex sync.RWMutex
+	channels      map[string]bool // joined channels, nil until the first join
+
+	mediaFilterMutex sync.RWMutex
+	mediaFilter      map[string]bool // text-only channels, replaced as a whole on change
+
+	highlightsMutex sync.RWMutex
+	highlights      map[string][]string // highlight words per channel, replaced as a whole on change
+
+	notificationPrefsMutex sync.RWMutex
+	notificationPrefs      NotificationPrefs // the user's, replaced as a whole on change
+
+	account   string // username of the session the connection was opened with, see accounts.go
+	guestName string // name given to or kept by a guest, see guests.go
+
+	motdMutex sync.Mutex
+	motdSent  map[string]bool // messages of the day sent, "" for the global one
+
+	egress *byteRateLimiter // paces writes to CLIENT_EGRESS_RATE, nil without a limit
+}
+
+// Priority selects the per-client delivery lane of an outgoing frame
+type Priority int
+
+const (
+	// PriorityLow frames (presence, user counts) are dropped when the lane is full
+	PriorityLow Priority = iota
+	// PriorityNormal frames (chat traffic) disconnect a client that can't keep up
+	PriorityNormal
+	// PriorityHigh frames (acks, errors, system notices) must never be dropped
+	PriorityHigh
+)
+
+func newClient(id string, conn clientConn, ip string) *Client {
+	c := &Client{
+		ID:       id,
+		Conn:     conn,
+		Send:     make(chan []byte, 256),
+		IP:       ip,
+		sendHigh: make(chan []byte, 64),
+		sendLow:  make(chan []byte, 32),
+		closed:   make(chan struct{}),
+	}
+	if clientEgressRate > 0 {
+		c.egress = &byteRateLimiter{rate: clientEgressRate}
+	}
+	c.touch()
+	return c
+}
+
+// enqueue queues a frame on the lane for the given priority without blocking.
+// It returns false if the frame could not be queued; a client whose normal or
+// high priority lane overflows is closed, since it would otherwise miss frames
+// that must be delivered.
+func (c *Client) enqueue(frame []byte, p Priority) bool {
+	lane := c.Send
+	switch p {
+	case PriorityHigh:
+		lane = c.sendHigh
+	case PriorityLow:
+		lane = c.sendLow
+	}
+	select {
+	case <-c.closed:
+		return false
+	default:
+	}
+	// Lite clients don't get presence traffic
+	if p == PriorityLow && c.lite.Load() {
+		return false
+	}
+	select {
+	case lane <- frame:
+		return true
+	default:
+		if p != PriorityLow {
+			log.Printf("İstemci kuyruğu dolu, bağlantı kapatılıyor. ID: %s", c.ID)
+			c.close()
+		} else {
+			c.dropped.Add(1)
+		}
+		return false
+	}
+}
+
+// close stops the write pump; the read pump then unregisters the client
+func (c *Client) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
 }
 
 // Hub maintains the set of active clients and broadcasts messages to the clients
 type Hub struct {
 	clients    map[*Client]bool
-	broadcast  chan []byte
+	broadcast  chan inboundMessage
 	register   chan *Client
 	unregister chan *Client
 	mutex      sync.RWMutex
 	redis      *redis.Client
+
+	replica        *redis.Client // history reads, see replica.go
+	replicaHealthy atomic.Bool
+	recentWrites   sync.Map // channel -> time.Time of the last write here
+
+	standbyLog     chan [][]string // writes waiting for the standby log, see standby.go
+	standbyPrimary *redis.Client   // the primary's Redis a standby tails
+	standbyPassive atomic.Bool
+	standby        standbyState
+
+	presenceMutex sync.Mutex
+	pendingLeaves map[string]*time.Timer // username -> delayed leave announcement
+	presence      map[string]PresenceState
+
+	uploadQueue chan FileMeta       // uploads waiting for post-processing
+	receipts    chan messageReceipt // delivered and seen receipts waiting for the batched write
+	archive     chan Message        // stored messages waiting to be appended to the archive
+
+	receiptsPruned map[string]time.Time // channel -> last receipt prune, used by the receipt writer only
 }
 
 var upgrader = websocket.Upgrader{
+	EnableCompression: wsCompression,
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow connections from any origin
 	},
@@ -90,71 +260,73 @@ func newHub() *Hub {
 	} else {
 		log.Println("Redis bağlantısı başarılı - websocket-chat-app")
 	}
+	var replica *redis.Client
+	if rdb != nil {
+		replica = newReplica()
+	}
 
-	return &Hub{
-		broadcast:  make(chan []byte),
+	hub := &Hub{
+		broadcast:  make(chan inboundMessage),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		clients:    make(map[*Client]bool),
 		redis:      rdb,
+		replica:    replica,
+
+		pendingLeaves:  make(map[string]*time.Timer),
+		presence:       make(map[string]PresenceState),
+		uploadQueue:    make(chan FileMeta, 100),
+		receipts:       make(chan messageReceipt, 4096),
+		receiptsPruned: make(map[string]time.Time),
+		archive:        make(chan Message, 1024),
 	}
+	hub.setupStandby(redisAddr)
+	hub.loadMaintenance()
+	hub.markIntegrityStart()
+	return hub
 }
 
-// Store message in Redis
-func (h *Hub) storeMessage(msg Message) {
+// Messages kept in a channel's history list
+const historyLength = 100
+
+// Store message in Redis, reporting whether it was persisted
+func (h *Hub) storeMessage(msg Message) bool {
 	if h.redis == nil {
-		return
+		return false
 	}
 	ctx := context.Background()
 	messageJSON, err := json.Marshal(msg)
 	if err != nil {
 		log.Printf("Mesaj serialize hatası: %v", err)
-		return
+		return false
 	}
 	key := fmt.Sprintf("websocket:messages:%s", msg.Channel)
 	pipe := h.redis.Pipeline()
 	pipe.LPush(ctx, key, messageJSON)
-	pipe.LTrim(ctx, key, 0, 99)
+	pipe.LTrim(ctx, key, 0, historyLength-1)
 	pipe.Expire(ctx, key, 24*time.Hour)
+	indexMessage(ctx, pipe, msg)
+	renewReceipts(ctx, pipe, msg.Channel)
+	appendResumeLog(ctx, pipe, msg, messageJSON)
+	appendThread(ctx, pipe, msg)
+	appendExpiry(ctx, pipe, msg)
+	if msg.MessageID != "" {
+		pipe.Set(ctx, messageAuthorKey(msg.MessageID), msg.Username, 24*time.Hour)
+		if msg.Seq != 0 {
+			pipe.Set(ctx, messageLocationKey(msg.MessageID), fmt.Sprintf("%d:%s", msg.Seq, msg.Channel), 24*time.Hour)
+		}
+	}
 	_, err = pipe.Exec(ctx)
 	if err != nil {
 		log.Printf("Redis mesaj kaydetme hatası: %v", err)
+		return false
 	}
-}
-
-// Update seenBy for a message in Redis
-func (h *Hub) markMessageSeen(channel string, timestamp time.Time, username string) {
-	if h.redis == nil {
-		return
-	}
-	ctx := context.Background()
-	key := fmt.Sprintf("websocket:messages:%s", channel)
-	msgs, err := h.redis.LRange(ctx, key, 0, 49).Result()
-	if err != nil {
-		return
-	}
-	for i, raw := range msgs {
-		var msg Message
-		if err := json.Unmarshal([]byte(raw), &msg); err == nil {
-			// Compare timestamp (to seconds)
-			if msg.Timestamp.Unix() == timestamp.Unix() {
-				// Add username to SeenBy if not already present
-				found := false
-				for _, u := range msg.SeenBy {
-					if u == username {
-						found = true
-						break
-					}
-				}
-				if !found {
-					msg.SeenBy = append(msg.SeenBy, username)
-					updated, _ := json.Marshal(msg)
-					h.redis.LSet(ctx, key, int64(i), updated)
-				}
-				break
-			}
-		}
-	}
+	h.invalidateSnapshot(msg.Channel)
+	h.recordActivity(msg.Channel, msg.Timestamp)
+	h.recordStorage(storageMessages, msg.Channel, msg.Username, int64(len(messageJSON)))
+	h.recordDigestMessage(msg)
+	h.recordUserStats(msg)
+	return true
 }
 
 // Get recent messages from Redis for a channel
@@ -182,13 +354,22 @@ func (h *Hub) getRecentMessages(channel string, limit int) ([]Message, error) {
 			messages = append(messages, msg)
 		}
 	}
+	applyReceipts(messages, h.channelReceipts(channel))
+	applyReactions(messages, h.channelReactions(channel))
+	applyThreadCounts(messages, h.channelThreadCounts(channel))
+	h.applyPolls(messages)
 
 	return messages, nil
 }
 
 // Send recent messages to a client
 func (h *Hub) sendRecentMessages(client *Client, channel string) {
-	messages, err := h.getRecentMessages(channel, 50) // Send last 50 messages
+	if isConversation(channel) && !client.inChannel(channel) {
+		client.sendError(ErrNotMember, "Bu konuşmanın üyesi değilsiniz")
+		return
+	}
+	h.sendMOTD(client, channel)
+	messages, err := h.historyFrames(channel) // Last 50 messages from the snapshot
 	if err != nil {
 		log.Printf("Geçmiş mesajları alma hatası: %v", err)
 		return
@@ -196,19 +377,14 @@ func (h *Hub) sendRecentMessages(client *Client, channel string) {
 
 	log.Printf("Kanal %s için %d geçmiş mesaj gönderiliyor", channel, len(messages))
 
-	for _, msg := range messages {
-		messageJSON, err := json.Marshal(msg)
-		if err != nil {
-			continue
-		}
-
-		select {
-		case client.Send <- messageJSON:
-		default:
-			// Client's send buffer is full, skip this message
-			log.Printf("İstemci gönderim buffer'ı dolu, mesaj atlandı")
+	for _, messageJSON := range messages {
+		if !client.enqueue(client.filterFrame(messageJSON), PriorityNormal) {
+			log.Printf("İstemci gönderim buffer'ı dolu, geçmiş gönderimi durduruldu")
+			return
 		}
 	}
+	h.sendDayMarkers(client, channel, messages)
+	h.sendPins(client, channel)
 }
 
 // Broadcast active user count to all clients
@@ -217,58 +393,84 @@ func (h *Hub) broadcastUserCount() {
 	count := len(h.clients)
 	h.mutex.RUnlock()
 
-	userCountMessage := map[string]interface{}{
-		"type":      "user_count",
-		"count":     count,
-		"timestamp": time.Now(),
-	}
+	userCountMessage := UserCountFrame{Type: "user_count", Count: count, Timestamp: time.Now()}
 
-	messageJSON, err := json.Marshal(userCountMessage)
+	messageJSON, err := encodeFrame(userCountMessage)
 	if err != nil {
 		log.Printf("User count message serialize hatası: %v", err)
 		return
 	}
 
+	h.sendToAll(messageJSON, PriorityLow, nil)
+}
+
+// sendToAll queues a frame for every connected client except the given one
+func (h *Hub) sendToAll(frame []byte, p Priority, except *Client) {
 	h.mutex.RLock()
+	defer h.mutex.RUnlock()
 	for client := range h.clients {
-		select {
-		case client.Send <- messageJSON:
-		default:
-			// Skip if client buffer is full
+		if client != except {
+			client.enqueue(frame, p)
 		}
 	}
-	h.mutex.RUnlock()
 }
 
-func (c *Client) writePump() {
+// sendFrame encodes a control frame and queues it for this client only
+func (c *Client) sendFrame(frame interface{}, p Priority) {
+	payload, err := encodeFrame(frame)
+	if err != nil {
+		log.Printf("Frame serialize hatası: %v", err)
+		return
+	}
+	c.enqueue(payload, p)
+}
+
+// sendError rejects a client frame with a structured error
+func (c *Client) sendError(code, reason string) {
+	c.sendFrame(ErrorFrame{Type: "error", Code: code, Reason: reason}, PriorityHigh)
+}
+
+// sendLimitError rejects a frame over a size limit, naming the limit
+func (c *Client) sendLimitError(code, reason string, limit int) {
+	c.sendFrame(ErrorFrame{Type: "error", Code: code, Reason: reason, Limit: limit}, PriorityHigh)
+}
+
+func (c *Client) writePump(hub *Hub) {
 	ticker := time.NewTicker(54 * time.Second)
 	defer func() {
 		ticker.Stop()
 		c.Conn.Close()
 	}()
 	for {
+		// High priority frames always go out before anything else
 		select {
-		case message, ok := <-c.Send:
-			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+		case message := <-c.sendHigh:
+			if !c.writeFrames(hub, message, c.sendHigh) {
 				return
 			}
+			continue
+		default:
+		}
 
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+		select {
+		case <-c.closed:
+			// Notices queued right before closing, e.g. session_replaced, still go out
+			if len(c.sendHigh) > 0 {
+				c.writeFrames(hub, <-c.sendHigh, c.sendHigh)
+			}
+			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
+		case message := <-c.sendHigh:
+			if !c.writeFrames(hub, message, c.sendHigh) {
 				return
 			}
-			w.Write(message)
-
-			// Add queued chat messages to the current WebSocket message.
-			n := len(c.Send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.Send)
+		case message := <-c.Send:
+			if !c.writeFrames(hub, message, c.Send) {
+				return
 			}
-
-			if err := w.Close(); err != nil {
+		case message := <-c.sendLow:
+			if !c.writeFrames(hub, message, c.sendLow) {
 				return
 			}
 		case <-ticker.C:
@@ -276,23 +478,104 @@ func (c *Client) writePump() {
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+			c.pingSent()
+		}
+	}
+}
+
+// writeFrames writes a frame plus everything already queued on the same lane
+// as one newline separated WebSocket message. Chat messages in a batch that was
+// written are recorded as delivered to the user.
+func (c *Client) writeFrames(hub *Hub, message []byte, lane chan []byte) bool {
+	encoding := c.wireEncoding()
+	binary := encoding != encodingJSON
+	var batch bytes.Buffer
+	var delivered []deliveredMessage
+	c.writeFrame(&batch, message, encoding)
+	delivered = c.collectDelivered(delivered, message, lane)
+
+	// Lite clients trade latency for fewer, larger messages
+	if lane != c.sendHigh && c.lite.Load() {
+		time.Sleep(liteBatchDelay)
+	}
+
+	// Add queued chat messages to the current WebSocket message.
+	n := len(lane)
+	for i := 0; i < n; i++ {
+		if !binary {
+			batch.WriteByte('\n') // binary frames are self-delimiting
 		}
+		frame := <-lane
+		c.writeFrame(&batch, frame, encoding)
+		delivered = c.collectDelivered(delivered, frame, lane)
+	}
+
+	messageType := websocket.TextMessage
+	if binary {
+		messageType = websocket.BinaryMessage
+	}
+	// Small batches aren't worth compressing
+	if conn, ok := c.Conn.(*websocket.Conn); ok && wsCompression {
+		conn.EnableWriteCompression(batch.Len() >= wsCompressionThreshold)
+	}
+	c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := c.Conn.WriteMessage(messageType, batch.Bytes()); err != nil {
+		return false
+	}
+	for _, msg := range delivered {
+		hub.markMessageDelivered(msg.Channel, msg.MessageID, msg.Username, c.Username)
+		traces.record(msg.TraceID, TraceWritten, c.Username+" "+c.ID)
 	}
+	// The next write waits until this one fits the client's rate
+	if c.egress != nil && !c.egress.wait(c.closed, batch.Len()) {
+		return false
+	}
+	return true
 }
 
+// writeFrame writes one frame in the client's protocol version and encoding
+func (c *Client) writeFrame(w io.Writer, frame []byte, encoding string) {
+	if c.lite.Load() {
+		frame = liteFrame(frame)
+	}
+	// The protobuf schema only has enveloped frames
+	if c.negotiatedVersion() < 2 && encoding != encodingProtobuf {
+		frame = legacyFrame(frame)
+	}
+	if encoding != encodingJSON {
+		encoded, err := encodeWire(frame, encoding)
+		if err != nil {
+			log.Printf("%s dönüşüm hatası: %v", encoding, err)
+			return
+		}
+		frame = encoded
+	}
+	w.Write(frame)
+}
+
+var (
+	// Larger client frames are rejected with a message_too_large error
+	maxMessageBytes = envInt("MAX_FRAME_BYTES", 8192)
+	// Frames beyond this close the connection, the server won't buffer them
+	readLimitBytes = max(envInt("READ_LIMIT_BYTES", 64*1024), maxMessageBytes)
+	// Longer message text is rejected with a message_too_long error
+	maxMessageLength = envInt("MAX_MESSAGE_LENGTH", 4000)
+)
+
 func (c *Client) readPump(hub *Hub) {
 	defer func() {
 		hub.unregister <- c
 		c.Conn.Close()
 	}()
-	c.Conn.SetReadLimit(8192)
+	c.Conn.SetReadLimit(int64(readLimitBytes))
 	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.Conn.SetPongHandler(func(string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		c.pongReceived()
 		return nil
 	})
 	for {
-		_, messageBytes, err := c.Conn.ReadMessage()
+		messageType, messageBytes, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket hatası: %v", err)
@@ -300,10 +583,52 @@ func (c *Client) readPump(hub *Hub) {
 			break
 		}
 
+		if len(messageBytes) > maxMessageBytes {
+			c.sendLimitError(ErrMessageTooLarge, fmt.Sprintf("Mesaj çok büyük (en fazla %d bayt)", maxMessageBytes), maxMessageBytes)
+			continue
+		}
+
+		// Binary frames are MessagePack or protobuf, everything below works on JSON
+		if messageType == websocket.BinaryMessage {
+			decoded, code, err := decodeWire(messageBytes, c.wireEncoding())
+			if err != nil {
+				c.sendError(code, "İkili mesaj çözümlenemedi")
+				continue
+			}
+			messageBytes = decoded
+		}
+
 		// Parse JSON message
 		var msg Message
 		if err := json.Unmarshal(messageBytes, &msg); err != nil {
 			log.Printf("Mesaj parse hatası: %v", err)
+			c.sendError(ErrInvalidJSON, "Mesaj JSON olarak okunamadı")
+			continue
+		}
+		msg.TraceID = traceID(msg.TraceID)
+		msg.Username = normalizeUsername(msg.Username)
+		// Guests use the name the server gives them
+		if c.isGuest() && (msg.Username != "" || msg.Message == "__USER_CONNECT__") {
+			msg.Username = hub.guestName(c)
+		}
+
+		if msg.Type == "hello" {
+			c.handleHello(messageBytes)
+			continue
+		}
+
+		if msg.Type == "lite_mode" {
+			c.handleLiteMode(messageBytes)
+			continue
+		}
+
+		// Latency measurement, answered before anything else is looked at
+		if msg.Type == "ping" {
+			c.handlePing(messageBytes)
+			continue
+		}
+		if msg.Type == "latency_report" {
+			c.handleLatencyReport(messageBytes)
 			continue
 		}
 
@@ -312,34 +637,53 @@ func (c *Client) readPump(hub *Hub) {
 			// Create persistent user ID based on username and timestamp
 			persistentID := fmt.Sprintf("user_%s_%d", msg.Username, time.Now().Unix())
 			c.ID = persistentID
-			c.Username = msg.Username
+			if !hub.claimUsername(c, msg.Username) || !hub.claimSession(c, msg.Username) {
+				continue
+			}
+			if msg.Channel != "" && hub.canJoin(c, msg.Channel) {
+				c.joinChannel(msg.Channel)
+			}
 
 			log.Printf("Kullanıcı bağlandı. Kalıcı ID: %s, Kullanıcı: %s", c.ID, c.Username)
+			hub.loadMediaFilter(c)
+			hub.loadHighlights(c)
+			hub.loadNotificationPrefs(c)
+			hub.recordFirstSeen(c.Username)
+			if c.isGuest() {
+				c.sendGuest()
+			}
 
 			// Send user connection confirmation back to the client
-			connectionMsg := map[string]interface{}{
-				"type":      "user_connected",
-				"username":  c.Username,
-				"userId":    c.ID,
-				"timestamp": time.Now(),
+			connectionMsg := PresenceFrame{Type: "user_connected", Username: c.Username, UserID: c.ID, Identity: userIdentity(c.Username), CustomStatus: hub.customStatus(c.Username), Timestamp: time.Now()}
+			confirmationJSON, _ := encodeFrame(connectionMsg)
+			c.enqueue(confirmationJSON, PriorityHigh)
+			hub.sendMOTD(c, "")
+			hub.updatePresence(c.Username)
+			if !c.followsAll() {
+				go hub.sendMembers(c, msg.Channel)
+			}
+			if downloadMode == downloadModeStream {
+				c.sendDownloadToken()
+			}
+			go hub.sendConversations(c)
+			go hub.sendUnreadCounts(c)
+			if c.deepLink != "" {
+				go hub.openDeepLink(c, c.deepLink)
+				c.deepLink = ""
 			}
-			confirmationJSON, _ := json.Marshal(connectionMsg)
-			select {
-			case c.Send <- confirmationJSON:
-			default:
+			for channel, since := range c.resumeFrom {
+				go hub.resume(c, ResumeRequest{Type: "resume", Channel: channel, Since: since})
 			}
+			c.resumeFrom = nil
 
-			// Broadcast user connection to other clients
-			hub.mutex.RLock()
-			for client := range hub.clients {
-				if client != c {
-					select {
-					case client.Send <- confirmationJSON:
-					default:
-					}
-				}
+			// Reconnects within the grace window are not announced again
+			if !hub.markJoined(c) {
+				continue
 			}
-			hub.mutex.RUnlock()
+
+			// Broadcast user connection to other clients
+			hub.sendToAll(confirmationJSON, PriorityLow, c)
+			hub.broadcastRosterDiff([]RosterEntry{{Username: c.Username, UserID: c.ID, Identity: userIdentity(c.Username)}}, []RosterEntry{})
 			continue
 		}
 
@@ -351,11 +695,14 @@ func (c *Client) readPump(hub *Hub) {
 		}
 
 		// Update client username and log if first time setting
-		if msg.Username != "" && c.Username == "" {
-			c.Username = msg.Username
-			log.Printf("Kullanıcı adı belirlendi. ID: %s, Kullanıcı: %s", c.ID, c.Username)
-		} else if msg.Username != "" {
-			c.Username = msg.Username
+		if msg.Username != "" && msg.Username != c.Username {
+			first := c.Username == ""
+			if !hub.claimUsername(c, msg.Username) || !hub.claimSession(c, msg.Username) {
+				continue
+			}
+			if first {
+				log.Printf("Kullanıcı adı belirlendi. ID: %s, Kullanıcı: %s", c.ID, c.Username)
+			}
 		}
 
 		// Ensure username is set
@@ -366,6 +713,7 @@ func (c *Client) readPump(hub *Hub) {
 		// Skip messages without username
 		if msg.Username == "" {
 			log.Printf("Mesaj kullanıcı adı olmadan atlandı: %s", msg.Message)
+			c.sendError(ErrUsernameRequired, "Mesaj göndermek için kullanıcı adı gerekli")
 			continue
 		}
 
@@ -374,7 +722,13 @@ func (c *Client) readPump(hub *Hub) {
 			// Always set server timestamp for new messages
 			if msg.Message != "__GET_RECENT_MESSAGES__" && msg.Message != "__USER_CONNECT__" {
 				msg.Timestamp = time.Now()
+				msg.MessageID = newULID()
 			}
+		} else if isULID(msg.MessageID) {
+			msg.Timestamp = ulidTime(msg.MessageID)
+		} else {
+			// Receipts for messages stored before IDs fall back to the timestamp
+			msg.MessageID = ""
 		}
 		if msg.Channel == "" {
 			msg.Channel = "genel"
@@ -383,16 +737,150 @@ func (c *Client) readPump(hub *Hub) {
 			msg.Type = "text"
 		}
 
-		log.Printf("Gelen mesaj: %s, Tip: %s, Kullanıcı: %s, Kanal: %s", msg.Message, msg.Type, msg.Username, msg.Channel)
+		// Channel-scoped delivery: only subscribed channels' traffic is sent
+		if msg.Type == "join" || msg.Type == "subscribe" || msg.Type == "unsubscribe" {
+			var req ChannelRequest
+			json.Unmarshal(messageBytes, &req)
+			if req.Type != "unsubscribe" && !hub.canJoin(c, req.Channel) {
+				c.sendError(ErrNotMember, "Bu konuşmanın üyesi değilsiniz")
+				continue
+			}
+			c.handleChannelRequest(req)
+			if req.Channel != "" && req.Type != "unsubscribe" {
+				go hub.sendPins(c, req.Channel)
+			}
+			c.touch()
+			hub.updatePresence(c.Username)
+			if req.Channel != "" && req.Type != "unsubscribe" {
+				go hub.sendMembers(c, req.Channel)
+			}
+			continue
+		}
 
-		// Broadcast the enriched message
-		enrichedMessage, err := json.Marshal(msg)
-		if err != nil {
-			log.Printf("Mesaj JSON encode hatası: %v", err)
+		// Paginated roster of online users
+		if msg.Type == "roster" {
+			go hub.sendRosterPage(c, messageBytes)
+			continue
+		}
+
+		// All messages of a thread
+		if msg.Type == "thread_fetch" {
+			go hub.handleThreadFetch(c, messageBytes)
+			continue
+		}
+
+		// Private conversations: create, change members, list
+		switch msg.Type {
+		case "conversation_create", "conversation_add", "conversation_remove", "conversations":
+			go hub.handleConversationFrame(c, msg.Type, messageBytes)
+			continue
+		}
+
+		// Short link statistics for the author
+		if msg.Type == "link_stats" {
+			go hub.sendLinkStats(c)
+			continue
+		}
+
+		// Replay of messages missed while disconnected
+		if msg.Type == "resume" {
+			go hub.handleResume(c, messageBytes)
+			continue
+		}
+
+		// Shared link followed while connected
+		if msg.Type == "open_link" {
+			go hub.handleOpenLink(c, messageBytes)
+			continue
+		}
+
+		// Recent history of several channels at once
+		if msg.Type == "history" {
+			go hub.handleHistory(c, messageBytes)
+			continue
+		}
+
+		// Delivered receipts are recorded and forwarded to the author
+		if msg.Type == "delivered" {
+			go hub.handleDelivered(c, messageBytes)
+			continue
+		}
+
+		// Text-only channel preference
+		if msg.Type == "media_filter" {
+			go hub.handleMediaFilter(c, messageBytes)
+			continue
+		}
+
+		// Per-channel highlight words
+		if msg.Type == "highlights" {
+			go hub.handleHighlights(c, messageBytes)
+			continue
+		}
+
+		// Muted channels and do-not-disturb windows
+		if msg.Type == "notification_prefs" {
+			go hub.handleNotificationPrefs(c, messageBytes)
+			continue
+		}
+
+		// Online/away status reported by the client
+		if msg.Type == "presence" {
+			hub.handlePresence(c, messageBytes)
 			continue
 		}
 
-		hub.broadcast <- enrichedMessage
+		// Custom status text and emoji
+		if msg.Type == "status" {
+			go hub.handleStatus(c, messageBytes)
+			continue
+		}
+
+		// Admin announcement to every channel
+		if msg.Type == "announcement" {
+			go hub.handleAnnouncement(c, messageBytes)
+			continue
+		}
+
+		// Last-read marker of a channel
+		if msg.Type == "mark_read" {
+			go hub.handleMarkRead(c, messageBytes)
+			continue
+		}
+
+		// Moderators approving the first messages of new accounts
+		if msg.Type == "review" {
+			go hub.handleReview(c, messageBytes)
+			continue
+		}
+		if msg.Type == "review_queue" {
+			go hub.handleReviewQueue(c, messageBytes)
+			continue
+		}
+
+		// Token for an HTTP upload to a joined channel
+		if msg.Type == "upload_token" {
+			c.handleUploadToken(messageBytes)
+			continue
+		}
+
+		// Token for stream mode downloads, renewed before it expires
+		if msg.Type == "download_token" {
+			c.sendDownloadToken()
+			continue
+		}
+
+		// Personal statistics preference
+		if msg.Type == "stats_opt_in" {
+			go hub.handleStatsOptIn(c, messageBytes)
+			continue
+		}
+
+		// Messages, receipts and reactions from here on are user activity
+		hub.activity(c)
+
+		// Maintenance, CAPTCHA, commands, enrichment, then the hand-over to the hub
+		hub.processMessage(c, msg, messageBytes)
 	}
 }
 
@@ -400,12 +888,19 @@ func (h *Hub) run() {
 	for {
 		select {
 		case client := <-h.register:
+			// Clients only reach a standby once the load balancer switched to it
+			h.promoteStandby("ilk bağlantı")
 			h.mutex.Lock()
 			h.clients[client] = true
 			h.mutex.Unlock()
 			// İlk bağlantıda kullanıcı adı henüz bilinmiyor
 			log.Printf("Yeni bağlantı kuruldu. ID: %s", client.ID)
 
+			// Show the maintenance banner to clients connecting during maintenance
+			if state := currentMaintenance(); state.Enabled {
+				client.sendFrame(maintenanceFrame(state), PriorityHigh)
+			}
+
 			// Broadcast updated user count
 			go h.broadcastUserCount()
 
@@ -413,24 +908,12 @@ func (h *Hub) run() {
 			h.mutex.Lock()
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
-				close(client.Send)
+				client.close()
 				if client.Username != "" {
 					log.Printf("Kullanıcı ayrıldı. ID: %s, Kullanıcı: %s", client.ID, client.Username)
 
-					// Send user disconnection message to all clients
-					disconnectionMsg := map[string]interface{}{
-						"type":      "user_disconnected",
-						"username":  client.Username,
-						"userId":    client.ID,
-						"timestamp": time.Now(),
-					}
-					msgJSON, _ := json.Marshal(disconnectionMsg)
-					for remainingClient := range h.clients {
-						select {
-						case remainingClient.Send <- msgJSON:
-						default:
-						}
-					}
+					// Announce the disconnection only if the user doesn't come back soon
+					h.scheduleLeave(client)
 				} else {
 					log.Printf("Bağlantı kapatıldı. ID: %s", client.ID)
 				}
@@ -440,80 +923,74 @@ func (h *Hub) run() {
 			// Broadcast updated user count
 			go h.broadcastUserCount()
 
-		case message := <-h.broadcast:
+		case in := <-h.broadcast:
 			// Parse message to store in Redis
 			var msg Message
-			if err := json.Unmarshal(message, &msg); err == nil {
-				// Skip storing system messages like __USER_CONNECT__
-				if msg.Message == "__USER_CONNECT__" {
-					continue
-				}
-
-				// Handle "seen" message type
-				if msg.Type == "seen" && msg.Timestamp.Unix() > 0 && msg.Username != "" {
-					h.markMessageSeen(msg.Channel, msg.Timestamp, msg.Username)
-					// Broadcast seen update to all clients
-					seenUpdate := map[string]interface{}{
-						"type":      "seen",
-						"channel":   msg.Channel,
-						"timestamp": msg.Timestamp,
-						"username":  msg.Username,
-					}
-					seenJSON, _ := json.Marshal(seenUpdate)
-					h.mutex.RLock()
-					for client := range h.clients {
-						select {
-						case client.Send <- seenJSON:
-						default:
-						}
-					}
-					h.mutex.RUnlock()
-					continue
-				}
-
-				// Store regular messages (not "seen" messages or system messages)
-				if msg.Type != "seen" && msg.Message != "__USER_CONNECT__" && msg.Message != "__GET_RECENT_MESSAGES__" {
-					h.storeMessage(msg)
-				}
+			if err := json.Unmarshal(in.data, &msg); err != nil {
+				log.Printf("Mesaj JSON decode hatası: %v", err)
+				continue
+			}
+			// Skip storing system messages like __USER_CONNECT__
+			if msg.Message == "__USER_CONNECT__" {
+				continue
 			}
 
-			// Broadcast to all clients
-			h.mutex.RLock()
-			for client := range h.clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.clients, client)
+			// Handle "seen" message type
+			if msg.Type == "seen" {
+				if (msg.MessageID != "" || msg.Timestamp.Unix() > 0) && msg.Username != "" {
+					// The seen update is broadcast to the channel once recorded
+					h.markMessageSeen(msg.Channel, msg.MessageID, msg.Timestamp, msg.Username)
 				}
+				continue
 			}
-			h.mutex.RUnlock()
+
+			// Deduplication, persistence, fan-out and follow-up work
+			h.runStages(&pipelineMessage{Msg: msg, Client: in.sender, ClientMsgID: in.clientMsgID, in: in}, true)
 		}
 	}
 }
 
 func serveWS(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if hub.checkIPReputation(clientIP(r)).Action == "block" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	account, ok := hub.handshakeAccount(w, r)
+	if !ok {
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade hatası: %v", err)
 		return
 	}
 
-	// Generate temporary client ID - will be updated when user connects
-	tempID := fmt.Sprintf("temp_%d_%.3f", time.Now().Unix(), time.Now().Sub(time.Unix(time.Now().Unix(), 0)).Seconds())
-	client := &Client{
-		ID:   tempID,
-		Conn: conn,
-		Send: make(chan []byte, 256),
+	if wsCompression {
+		if err := conn.SetCompressionLevel(wsCompressionLevel); err != nil {
+			log.Printf("Geçersiz WS_COMPRESSION_LEVEL: %v", err)
+		}
+	}
+	transportConnections.Add("websocket", 1)
+	client := newClient(tempClientID(), conn, clientIP(r))
+	client.account = account
+	client.guestName = guestFromToken(r.URL.Query().Get("guest"))
+	client.latency = requestLatencyLabels(r)
+	if v, encoding, ok := requestedProtocol(r); ok {
+		client.negotiate(v, encoding)
 	}
 
 	hub.register <- client
 
 	// Allow collection of memory referenced by the caller by doing all work in new goroutines.
-	go client.writePump()
+	go client.writePump(hub)
 	go client.readPump(hub)
 }
 
+// tempClientID generates a temporary client ID - updated when the user connects
+func tempClientID() string {
+	return fmt.Sprintf("temp_%d_%.3f", time.Now().Unix(), time.Now().Sub(time.Unix(time.Now().Unix(), 0)).Seconds())
+}
+
 func serveHome(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		http.Error(w, "Not found", http.StatusNotFound)
@@ -534,7 +1011,79 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, indexPath)
 }
 
+// writeJSON encodes v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Proxies whose forwarding headers are believed, such as the Nginx in front of
+// the app; clients can send any X-Forwarded-For themselves
+var trustedProxies = trustedProxiesFromEnv()
+
+func trustedProxiesFromEnv() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, item := range envList("TRUSTED_PROXIES") {
+		network, err := parseNetwork(item)
+		if err != nil {
+			log.Printf("Geçersiz TRUSTED_PROXIES öğesi %q, yok sayılıyor", item)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// isTrustedProxy reports whether an address is one of TRUSTED_PROXIES
+func isTrustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the originating client address. The proxy headers are only
+// read when the connection comes from a trusted proxy: X-Forwarded-For is
+// walked from the right past the trusted hops, as every hop appends the
+// address it saw.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+	fwd := r.Header.Get("X-Forwarded-For")
+	if fwd == "" {
+		if real := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); real != nil {
+			return real.String()
+		}
+		return ip
+	}
+	hops := strings.Split(fwd, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break // garbage left of here was written by the client
+		}
+		ip = hop.String()
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
 func (h *Hub) clearChannelHistory(channel string) error {
+	removeArchive(channel)
 	if h.redis == nil {
 		log.Printf("Redis bağlantısı yok, kanal geçmişi temizlenemedi: %s", channel)
 		return nil
@@ -542,11 +1091,13 @@ func (h *Hub) clearChannelHistory(channel string) error {
 	ctx := context.Background()
 	// Use "websocket:" prefix to separate from question-chat-app
 	key := fmt.Sprintf("websocket:messages:%s", channel)
-	err := h.redis.Del(ctx, key).Err()
+	// The sequence counter is kept, so sequence numbers never repeat
+	err := h.redis.Del(ctx, key, resumeLogKey(channel), messageIndexKey(channel), receiptsKey(channel), reactionsKey(channel)).Err()
 	if err != nil {
 		log.Printf("Kanal geçmişi temizleme hatası: %v", err)
 		return err
 	}
+	h.invalidateSnapshot(channel)
 	log.Printf("Kanal geçmişi temizlendi: %s", channel)
 	return nil
 }
@@ -571,33 +1122,56 @@ func ensureSSLFiles(certFile, keyFile string) error {
 }
 
 func main() {
+	loadIPReputation()
 	hub := newHub()
 	go hub.run()
+	go hub.runSnapshotCompactor()
+	go hub.runReplicaCheck()
+	go hub.runOutboxRelay()
+	go hub.runStandbyLog()
+	go hub.runStandby()
+	go hub.runReceiptWriter()
+	go hub.runArchiveWriter()
+	go hub.runJobs()
+	go hub.runExpirySweeper()
+	go hub.runPresence()
+	go hub.runConnectionQuality()
+	go hub.runWebTransport()
+	go runPollReaper()
+	hub.runUploadWorkers()
 
 	// Uploads klasörünü oluştur
-	uploadsDir := "./uploads"
 	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
 		log.Printf("Uploads klasörü oluşturulamadı: %v", err)
 	}
 
 	// Static dosyalar için handler ekle
+	http.HandleFunc("/api/transport", handleTransportDiscovery)
+	http.HandleFunc("/static/protocol.d.ts", handleProtocolDefinitions)
+	http.HandleFunc("/static/chat.proto", handleProtobufSchema)
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static/"))))
 
-	// Uploads klasörü için handler ekle
-	http.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("./uploads/"))))
+	// Uploads klasörü için indirme handler'ı (yetki kontrolü, sayaç, ETag ve Range desteği)
+	http.HandleFunc("/uploads/", func(w http.ResponseWriter, r *http.Request) {
+		serveUploads(hub, w, r)
+	})
 
 	http.HandleFunc("/", serveHome)
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		serveWS(hub, w, r)
 	})
+	http.HandleFunc("/poll", func(w http.ResponseWriter, r *http.Request) {
+		handlePoll(hub, w, r)
+	})
+	http.HandleFunc("/send", handlePollSend)
 
 	// Dosya yükleme endpoint'i
 	http.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
 		handleFileUpload(hub, w, r)
 	})
 
-	// Yeni endpoint: POST /clear-history
-	http.HandleFunc("/clear-history", func(w http.ResponseWriter, r *http.Request) {
+	// Yeni endpoint: POST /clear-history (admin + 2FA gerektirir)
+	http.HandleFunc("/clear-history", requireElevated(hub, func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -615,6 +1189,117 @@ func main() {
 			return
 		}
 		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Admin 2FA (TOTP) endpoint'leri
+	http.HandleFunc("/admin/2fa/enroll", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleTOTPEnroll(hub, w, r)
+	}))
+	http.HandleFunc("/admin/2fa/verify", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleTOTPVerify(hub, w, r)
+	}))
+
+	// Bakım modu (salt okunur)
+	http.HandleFunc("/admin/maintenance", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminMaintenance(hub, w, r)
+	}))
+
+	// Kanal API'leri (aktivite vb.)
+	http.HandleFunc("/api/channels/", func(w http.ResponseWriter, r *http.Request) {
+		handleChannelAPI(hub, w, r)
+	})
+	http.HandleFunc("/api/messages/", func(w http.ResponseWriter, r *http.Request) {
+		handleMessageAPI(hub, w, r)
+	})
+
+	// Salt okunur arşiv sayfaları ve admin için imzalı bağlantılar
+	http.HandleFunc("/archive/", handleArchive)
+	http.HandleFunc("/admin/archive", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminArchive(hub, w, r)
+	}))
+
+	// Kısa link yönlendirmeleri ve admin incelemesi
+	http.HandleFunc("/l/", func(w http.ResponseWriter, r *http.Request) {
+		handleShortLink(hub, w, r)
+	})
+	http.HandleFunc("/admin/links", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminLinks(hub, w, r)
+	}))
+	http.HandleFunc("/admin/storage", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminStorage(hub, w, r)
+	}))
+	http.HandleFunc("/admin/audit", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminAudit(hub, w, r)
+	}))
+	http.HandleFunc("/admin/jobs", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminJobs(hub, w, r)
+	}))
+	http.HandleFunc("/admin/exports", requireElevated(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminExports(hub, w, r)
+	}))
+	http.HandleFunc("/admin/exports/", requireElevated(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminExport(hub, w, r)
+	}))
+	http.HandleFunc("/api/export-key", handleExportKey)
+	http.HandleFunc("/admin/analytics", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminAnalytics(hub, w, r)
+	}))
+	http.HandleFunc("/admin/pipeline", requireAdmin(hub, handleAdminPipeline))
+	http.HandleFunc("/admin/traces", requireAdmin(hub, handleAdminTraces))
+	http.HandleFunc("/admin/connections", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminConnections(hub, w, r)
+	}))
+	http.HandleFunc("/admin/moderators", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminModerators(hub, w, r)
+	}))
+	http.HandleFunc("/admin/triggers", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminTriggers(hub, w, r)
+	}))
+	http.HandleFunc("/admin/channels/", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminChannel(hub, w, r)
+	}))
+	http.HandleFunc("/admin/channel-templates", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminChannelTemplates(hub, w, r)
+	}))
+	http.HandleFunc("/admin/outbox", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminOutbox(hub, w, r)
+	}))
+	http.HandleFunc("/admin/standby", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminStandby(hub, w, r)
+	}))
+	http.HandleFunc("/admin/commands", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminCommands(hub, w, r)
+	}))
+
+	http.HandleFunc("/admin/motd", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminMOTD(hub, w, r)
+	}))
+
+	http.HandleFunc("/api/announce", requireAdmin(hub, func(w http.ResponseWriter, r *http.Request) {
+		handleAnnounce(hub, w, r)
+	}))
+	http.HandleFunc("/api/register", func(w http.ResponseWriter, r *http.Request) {
+		handleRegister(hub, w, r)
+	})
+	http.HandleFunc("/api/login", func(w http.ResponseWriter, r *http.Request) {
+		handleLogin(hub, w, r)
+	})
+	http.HandleFunc("/api/logout", func(w http.ResponseWriter, r *http.Request) {
+		handleLogout(hub, w, r)
+	})
+	http.HandleFunc("/api/presence", func(w http.ResponseWriter, r *http.Request) {
+		handlePresenceAPI(hub, w, r)
+	})
+	http.HandleFunc("/internal/capacity", func(w http.ResponseWriter, r *http.Request) {
+		handleCapacity(hub, w, r)
+	})
+	http.HandleFunc("/api/me/stats", func(w http.ResponseWriter, r *http.Request) {
+		handleMyStats(hub, w, r)
+	})
+
+	// CAPTCHA doğrulama endpoint'i
+	http.HandleFunc("/api/captcha/verify", func(w http.ResponseWriter, r *http.Request) {
+		handleCaptchaVerify(hub, w, r)
 	})
 
 	// Numerology API proxy endpoint
@@ -655,44 +1340,33 @@ func handleNumerologyProxy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create request to numerology API - Nginx üzerinden yönlendir
+	// Call numerology API - Nginx üzerinden yönlendir
 	numerologyURL := "https://api.melihboyaci.xyz/numerology"
-	req, err := http.NewRequest("POST", numerologyURL, bytes.NewBuffer(body))
+	resp, err := numerologyUpstream.do(r.Context(), "POST", numerologyURL, body, http.Header{"Content-Type": {"application/json"}})
 	if err != nil {
-		log.Printf("Numerology API request creation error: %v", err)
-		http.Error(w, "Error creating request", http.StatusInternalServerError)
+		log.Printf("Numerology API request error: %v", err)
+		http.Error(w, "Error calling numerology API", http.StatusServiceUnavailable)
 		return
 	}
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-
-	// Make request
-	client := &http.Client{Timeout: 120 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Numerology API request error: %v", err)
-		http.Error(w, "Error calling numerology API", http.StatusServiceUnavailable)
+	if resp.Status != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.Status)
+		w.Write(resp.Body)
+		log.Printf("Numerology API request completed with status: %d", resp.Status)
 		return
 	}
-	defer resp.Body.Close()
 
-	// Read response
-	respBody, err := ioutil.ReadAll(resp.Body)
+	// Clients get the reading in the schema messages carry
+	result, err := parseNumerology(resp.Body)
 	if err != nil {
-		log.Printf("Numerology API response read error: %v", err)
-		http.Error(w, "Error reading API response", http.StatusInternalServerError)
+		log.Printf("Numerology API response invalid: %v", err)
+		http.Error(w, "Invalid numerology API response", http.StatusBadGateway)
 		return
 	}
+	writeJSON(w, http.StatusOK, result)
 
-	// Set response headers
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(resp.StatusCode)
-
-	// Write response
-	w.Write(respBody)
-
-	log.Printf("Numerology API request completed with status: %d", resp.StatusCode)
+	log.Printf("Numerology API request completed with status: %d", resp.Status)
 }
 
 // handleMayaAstrologyProxy proxies requests to the Maya Astrology API
@@ -729,223 +1403,21 @@ func handleMayaAstrologyProxy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create request to Maya Astrology API - Container adını kullan
+	// Call Maya Astrology API - Container adını kullan
 	mayaURL := fmt.Sprintf("http://mayan-astrology-api:8001/kin-hesapla?birth_date=%s", birthDate)
-	req, err := http.NewRequest("GET", mayaURL, nil)
-	if err != nil {
-		log.Printf("Maya Astrology API request creation error: %v", err)
-		http.Error(w, "Error creating request", http.StatusInternalServerError)
-		return
-	}
-
-	// Make request
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := mayaUpstream.do(r.Context(), "GET", mayaURL, nil, nil)
 	if err != nil {
 		log.Printf("Maya Astrology API request error: %v", err)
 		http.Error(w, "Error calling Maya Astrology API", http.StatusServiceUnavailable)
 		return
 	}
-	defer resp.Body.Close()
-
-	// Read response
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Maya Astrology API response read error: %v", err)
-		http.Error(w, "Error reading API response", http.StatusInternalServerError)
-		return
-	}
 
 	// Set response headers
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(resp.StatusCode)
+	w.WriteHeader(resp.Status)
 
 	// Write response
-	w.Write(respBody)
-
-	log.Printf("Maya Astrology API request completed with status: %d", resp.StatusCode)
-}
-
-func handleFileUpload(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// CORS headers
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	// Parse multipart form (max 32MB)
-	err := r.ParseMultipartForm(32 << 20)
-	if err != nil {
-		log.Printf("Dosya parse hatası: %v", err)
-		http.Error(w, "File too large", http.StatusBadRequest)
-		return
-	}
-
-	// Get file from form
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		log.Printf("Dosya alma hatası: %v", err)
-		http.Error(w, "Error retrieving file", http.StatusBadRequest)
-		return
-	}
-	defer file.Close()
-
-	// Get other form data
-	username := r.FormValue("username")
-	channel := r.FormValue("channel")
-
-	if username == "" || channel == "" {
-		http.Error(w, "Missing username or channel", http.StatusBadRequest)
-		return
-	}
-
-	// Validate file size (max 10MB)
-	if header.Size > 10*1024*1024 {
-		log.Printf("Dosya çok büyük: %d bytes", header.Size)
-		http.Error(w, "File size too large (max 10MB)", http.StatusBadRequest)
-		return
-	}
-
-	// Enhanced file type validation
-	allowedTypes := map[string]bool{
-		"image/jpeg":                   true,
-		"image/png":                    true,
-		"image/gif":                    true,
-		"image/webp":                   true,
-		"image/bmp":                    true,
-		"text/plain":                   true,
-		"application/pdf":              true,
-		"application/zip":              true,
-		"application/x-zip-compressed": true,
-		"application/rar":              true,
-		"application/msword":           true,
-		"application/vnd.openxmlformats-officedocument.wordprocessingml.document": true,
-		"application/vnd.ms-excel": true,
-		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": true,
-	}
-
-	contentType := header.Header.Get("Content-Type")
-	if contentType == "" {
-		// Dosya uzantısından MIME type'ı tahmin et
-		ext := strings.ToLower(filepath.Ext(header.Filename))
-		switch ext {
-		case ".jpg", ".jpeg":
-			contentType = "image/jpeg"
-		case ".png":
-			contentType = "image/png"
-		case ".gif":
-			contentType = "image/gif"
-		case ".pdf":
-			contentType = "application/pdf"
-		case ".txt":
-			contentType = "text/plain"
-		case ".zip":
-			contentType = "application/zip"
-		case ".doc":
-			contentType = "application/msword"
-		case ".docx":
-			contentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
-		case ".xls":
-			contentType = "application/vnd.ms-excel"
-		case ".xlsx":
-			contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
-		default:
-			log.Printf("Bilinmeyen dosya uzantısı: %s", ext)
-			http.Error(w, "Unsupported file type", http.StatusBadRequest)
-			return
-		}
-	}
-
-	if !allowedTypes[contentType] {
-		log.Printf("İzin verilmeyen dosya tipi: %s", contentType)
-		http.Error(w, "File type not allowed", http.StatusBadRequest)
-		return
-	}
+	w.Write(resp.Body)
 
-	// Generate unique filename with timestamp and sanitization
-	timestamp := time.Now().Unix()
-	ext := filepath.Ext(header.Filename)
-	baseName := strings.TrimSuffix(header.Filename, ext)
-	// Dosya adını temizle (güvenlik için)
-	baseName = strings.ReplaceAll(baseName, " ", "_")
-	baseName = strings.ReplaceAll(baseName, "..", "")
-	baseName = strings.ReplaceAll(baseName, "/", "_")
-	baseName = strings.ReplaceAll(baseName, "\\", "_")
-
-	fileName := fmt.Sprintf("%d_%s%s", timestamp, baseName, ext)
-
-	// Create uploads directory structure
-	uploadsDir := "./uploads"
-	dateDir := time.Now().Format("2006-01-02") // YYYY-MM-DD format
-	fullUploadDir := filepath.Join(uploadsDir, dateDir)
-
-	if err := os.MkdirAll(fullUploadDir, 0755); err != nil {
-		log.Printf("Upload klasörü oluşturma hatası: %v", err)
-		http.Error(w, "Error creating uploads directory", http.StatusInternalServerError)
-		return
-	}
-
-	// Create file on server
-	filePath := filepath.Join(fullUploadDir, fileName)
-	dst, err := os.Create(filePath)
-	if err != nil {
-		log.Printf("Dosya oluşturma hatası: %v", err)
-		http.Error(w, "Error saving file", http.StatusInternalServerError)
-		return
-	}
-	defer dst.Close()
-
-	// Copy file content
-	written, err := io.Copy(dst, file)
-	if err != nil {
-		log.Printf("Dosya kopyalama hatası: %v", err)
-		http.Error(w, "Error saving file", http.StatusInternalServerError)
-		return
-	}
-
-	log.Printf("Dosya başarıyla kaydedildi: %s (%d bytes)", filePath, written)
-
-	// Determine message type
-	messageType := "file"
-	if strings.HasPrefix(contentType, "image/") {
-		messageType = "image"
-	}
-
-	// Create file message
-	fileURL := fmt.Sprintf("/uploads/%s/%s", dateDir, fileName)
-	fileMessage := Message{
-		Username:  username,
-		Message:   fmt.Sprintf("Dosya paylaştı: %s", header.Filename),
-		Timestamp: time.Now(),
-		Channel:   channel,
-		Type:      messageType,
-		FileURL:   fileURL,
-		FileName:  header.Filename,
-		FileSize:  header.Size,
-	}
-
-	// Broadcast file message
-	messageJSON, err := json.Marshal(fileMessage)
-	if err != nil {
-		log.Printf("Dosya mesajı marshalling hatası: %v", err)
-		http.Error(w, "Error processing file message", http.StatusInternalServerError)
-		return
-	}
-
-	hub.broadcast <- messageJSON
-
-	// Return success response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success":  true,
-		"message":  "File uploaded successfully",
-		"fileUrl":  fileURL,
-		"fileName": header.Filename,
-		"fileSize": header.Size,
-		"filePath": filePath, // Sunucudaki tam dosya yolu (log için)
-	})
+	log.Printf("Maya Astrology API request completed with status: %d", resp.Status)
 }
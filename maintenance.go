@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MaintenanceState describes the read-only maintenance mode
+type MaintenanceState struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+const maintenanceKey = "websocket:maintenance"
+
+const defaultMaintenanceMessage = "Sunucu bakım modunda, mesaj gönderimi geçici olarak kapalı."
+
+var (
+	maintenance      MaintenanceState
+	maintenanceMutex sync.RWMutex
+)
+
+func currentMaintenance() MaintenanceState {
+	maintenanceMutex.RLock()
+	defer maintenanceMutex.RUnlock()
+	return maintenance
+}
+
+// loadMaintenance restores the maintenance state persisted in Redis at startup
+func (h *Hub) loadMaintenance() {
+	if h.redis == nil {
+		return
+	}
+	raw, err := h.redis.Get(context.Background(), maintenanceKey).Result()
+	if err != nil {
+		return
+	}
+	var state MaintenanceState
+	if json.Unmarshal([]byte(raw), &state) == nil {
+		maintenanceMutex.Lock()
+		maintenance = state
+		maintenanceMutex.Unlock()
+		if state.Enabled {
+			log.Printf("Sunucu bakım modunda başlatıldı: %s", state.Message)
+		}
+	}
+}
+
+// setMaintenance switches maintenance mode and broadcasts the banner event
+func (h *Hub) setMaintenance(state MaintenanceState) {
+	maintenanceMutex.Lock()
+	maintenance = state
+	maintenanceMutex.Unlock()
+
+	if h.redis != nil {
+		data, _ := json.Marshal(state)
+		if err := h.redis.Set(context.Background(), maintenanceKey, data, 0).Err(); err != nil {
+			log.Printf("Bakım modu kaydedilemedi: %v", err)
+		}
+	}
+
+	frame, _ := encodeFrame(maintenanceFrame(state))
+	h.sendToAll(frame, PriorityHigh, nil)
+}
+
+func maintenanceFrame(state MaintenanceState) MaintenanceFrame {
+	return MaintenanceFrame{Type: "maintenance", Enabled: state.Enabled, Message: state.Message, Timestamp: time.Now()}
+}
+
+// handleAdminMaintenance shows (GET) or toggles (POST {"enabled": true, "message": "..."})
+// the read-only maintenance mode
+func handleAdminMaintenance(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, currentMaintenance())
+	case "POST":
+		var body struct {
+			Enabled bool   `json:"enabled"`
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		state := MaintenanceState{Enabled: body.Enabled}
+		if body.Enabled {
+			state.Message = body.Message
+			if state.Message == "" {
+				state.Message = defaultMaintenanceMessage
+			}
+			now := time.Now()
+			state.Since = &now
+		}
+		hub.setMaintenance(state)
+
+		admin, _ := adminFromRequest(r)
+		log.Printf("Bakım modu değişti: %v (admin: %s)", state.Enabled, admin)
+		writeJSON(w, http.StatusOK, state)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
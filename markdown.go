@@ -0,0 +1,235 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Message text is sanitized on the server, so no client has to be trusted to
+// escape it. HTML is stripped (script and style elements with their content,
+// and the "<" of a tag left unclosed, which a client's own markup would close),
+// as are control and bidirectional override characters. A small markdown
+// subset is kept in one canonical form: **bold**, *italic*, `code`, ```code
+// blocks``` and [label](url) links; __bold__ and _italic_ are rewritten to the
+// asterisk forms, and links that aren't http, https, mailto or site-relative
+// are reduced to their label, reference definitions with such targets are
+// dropped. Code is kept verbatim. Zero-width characters are only kept between
+// two visible characters, and at most SANITIZE_MAX_ZERO_WIDTH of them that
+// aren't joiners. TEXT_SANITIZE=strict also drops every stray zero-width and
+// format character and the angle brackets left outside code, so not even half
+// a tag gets through, at the cost of "a < b" losing its bracket.
+
+const (
+	sanitizeStandard = "standard"
+	sanitizeStrict   = "strict"
+)
+
+var (
+	sanitizeLevel = sanitizeLevelFromEnv()
+	// Zero-width characters other than joiners kept in a message
+	maxZeroWidth = envInt("SANITIZE_MAX_ZERO_WIDTH", 8)
+)
+
+func sanitizeLevelFromEnv() string {
+	switch level := envString("TEXT_SANITIZE", sanitizeStandard); level {
+	case sanitizeStandard, sanitizeStrict:
+		return level
+	default:
+		log.Printf("Geçersiz TEXT_SANITIZE %q, %s kullanılıyor", level, sanitizeStandard)
+		return sanitizeStandard
+	}
+}
+
+var (
+	markdownCode      = regexp.MustCompile("(?s)```.*?```|`[^`\n]+`")
+	markdownAutolink  = regexp.MustCompile(`<((?:https?|mailto):[^\s<>]+)>`)
+	markdownDangerous = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)\s*>|<!--.*?-->`)
+	markdownTag       = regexp.MustCompile(`</?[a-zA-Z][^>]*>|<[!?][^>]*>`)
+	markdownOpenTag   = regexp.MustCompile(`<([a-zA-Z/!?])`)
+	markdownLink      = regexp.MustCompile(`\[([^\[\]\n]*)\]\(\s*((?:[^()\s]|\([^()\s]*\))*)\s*\)`)
+	markdownReference = regexp.MustCompile(`(?m)^ {0,3}\[[^\[\]\n]+\]:[ \t]*(\S+).*$`)
+	markdownBold      = regexp.MustCompile(`__([^_\n]+)__`)
+	markdownItalic    = regexp.MustCompile(`_([^_\n]+)_`)
+)
+
+// sanitizeMarkdown returns the safe canonical form of a message text
+func sanitizeMarkdown(text string) string {
+	var out strings.Builder
+	last := 0
+	for _, loc := range markdownCode.FindAllStringIndex(text, -1) {
+		out.WriteString(sanitizeProse(text[last:loc[0]]))
+		out.WriteString(stripControls(text[loc[0]:loc[1]]))
+		last = loc[1]
+	}
+	out.WriteString(sanitizeProse(text[last:]))
+	return strings.TrimSpace(limitZeroWidth(out.String(), sanitizeLevel == sanitizeStrict))
+}
+
+// sanitizeProse cleans text outside code
+func sanitizeProse(text string) string {
+	text = stripControls(text)
+	text = markdownAutolink.ReplaceAllString(text, "$1")
+	text = markdownDangerous.ReplaceAllString(text, "")
+	// Until nothing changes, as removing a tag may join the pieces of another
+	for stripped := ""; stripped != text; {
+		stripped, text = text, markdownTag.ReplaceAllString(text, "")
+	}
+	// What is left has no ">" to end the tag
+	text = markdownOpenTag.ReplaceAllString(text, "$1")
+	text = markdownLink.ReplaceAllStringFunc(text, func(link string) string {
+		m := markdownLink.FindStringSubmatch(link)
+		label, target := strings.TrimSpace(m[1]), m[2]
+		if !safeLinkTarget(target) {
+			return label
+		}
+		if label == "" {
+			label = target
+		}
+		return "[" + label + "](" + target + ")"
+	})
+	text = markdownReference.ReplaceAllStringFunc(text, func(definition string) string {
+		if safeLinkTarget(markdownReference.FindStringSubmatch(definition)[1]) {
+			return definition
+		}
+		return ""
+	})
+	text = replaceDelimited(text, markdownBold, "**")
+	text = replaceDelimited(text, markdownItalic, "*")
+	if sanitizeLevel == sanitizeStrict {
+		text = strings.NewReplacer("<", "", ">", "").Replace(text)
+	}
+	return text
+}
+
+// replaceDelimited rewrites the matches of an underscore pattern with the
+// given delimiter, unless they are inside a word (snake_case) or a URL, or the
+// marked text starts or ends with a space
+func replaceDelimited(text string, pattern *regexp.Regexp, delimiter string) string {
+	urls := urlPattern.FindAllStringIndex(text, -1)
+	for _, link := range markdownLink.FindAllStringSubmatchIndex(text, -1) {
+		urls = append(urls, link[4:6])
+	}
+	var out strings.Builder
+	last := 0
+	for _, loc := range pattern.FindAllStringSubmatchIndex(text, -1) {
+		inner := text[loc[2]:loc[3]]
+		before, _ := utf8.DecodeLastRuneInString(text[:loc[0]])
+		after, _ := utf8.DecodeRuneInString(text[loc[1]:])
+		if isWordRune(before) || isWordRune(after) || strings.TrimSpace(inner) != inner || overlaps(urls, loc[0], loc[1]) {
+			continue
+		}
+		out.WriteString(text[last:loc[0]])
+		out.WriteString(delimiter + inner + delimiter)
+		last = loc[1]
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
+
+// overlaps reports whether start:end overlaps one of the spans
+func overlaps(spans [][]int, start, end int) bool {
+	for _, span := range spans {
+		if start < span[1] && span[0] < end {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// stripControls removes control characters other than newlines and tabs, and
+// the bidirectional overrides that make text read differently than it is
+func stripControls(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		case unicode.IsControl(r), r >= 0x202A && r <= 0x202E, r >= 0x2066 && r <= 0x2069:
+			return -1
+		}
+		return r
+	}, text)
+}
+
+func isZeroWidth(r rune) bool {
+	switch r {
+	case 0x180E, 0x200B, 0x200C, 0x200D, 0x2060, 0xFEFF:
+		return true
+	}
+	return r >= 0x2061 && r <= 0x2064 // invisible operators
+}
+
+// limitZeroWidth drops zero-width characters that don't sit between two
+// visible characters, and those beyond maxZeroWidth that aren't joiners, which
+// emoji sequences and some scripts need. Strict drops every format character
+// but joiners.
+func limitZeroWidth(text string, strict bool) string {
+	runes := []rune(text)
+	visible := func(i int) bool {
+		if i < 0 || i >= len(runes) {
+			return false
+		}
+		r := runes[i]
+		return !unicode.IsSpace(r) && !isZeroWidth(r) && !unicode.Is(unicode.Cf, r)
+	}
+	var out strings.Builder
+	stray := 0
+	for i, r := range runes {
+		joiner := r == 0x200C || r == 0x200D
+		switch {
+		case !isZeroWidth(r) && !(strict && unicode.Is(unicode.Cf, r)):
+		case !visible(i-1) || !visible(i+1):
+			continue
+		case joiner:
+		case strict || stray >= maxZeroWidth:
+			continue
+		default:
+			stray++
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+// safeLinkTarget allows web and mail links and paths on this site. Protocol
+// relative targets ("//host", and "/\host" which browsers read the same) point
+// off-site and aren't paths.
+func safeLinkTarget(target string) bool {
+	if target == "" {
+		return false
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return true
+	case "":
+		return u.Host == "" && strings.HasPrefix(target, "/") &&
+			!strings.HasPrefix(target, "//") && !strings.HasPrefix(target, `/\`)
+	}
+	return false
+}
+
+// markdownStage sanitizes the text of a message before anything else reads it
+func markdownStage(h *Hub, m *pipelineMessage) bool {
+	if m.Msg.Type == "seen" || m.Msg.Message == "" {
+		return true
+	}
+	m.Msg.Message = sanitizeMarkdown(m.Msg.Message)
+	if m.Msg.Message == "" && m.Msg.Type == "text" {
+		m.reject(ErrEmptyMessage, "Mesajda gösterilebilecek bir şey kalmadı")
+		return false
+	}
+	return true
+}
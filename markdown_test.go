@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestSanitizeMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain", "selam herkese", "selam herkese"},
+		{"closed tag", "<b>kalın</b> metin", "kalın metin"},
+		{"script", "<script>alert(1)</script>selam", "selam"},
+		{"comment", "a<!-- gizli -->b", "ab"},
+		{"unclosed tag", "hi <img src=x onerror=alert(1)", "hi img src=x onerror=alert(1)"},
+		{"unclosed closing tag", "selam </div", "selam /div"},
+		{"unclosed comment", "selam <!-- gizli", "selam !-- gizli"},
+		{"unclosed declaration", "<?xml", "?xml"},
+		{"tag split by a tag", "<im<b>g src=x onerror=alert(1)>", "g src=x onerror=alert(1)>"},
+		{"less than", "a < b", "a < b"},
+		{"less than digit", "1 <2", "1 <2"},
+		{"autolink", "<https://example.com>", "https://example.com"},
+		{"unclosed tag in code", "`<img src=x`", "`<img src=x`"},
+		{"link", "[site](https://example.com)", "[site](https://example.com)"},
+		{"empty label", "[](https://example.com)", "[https://example.com](https://example.com)"},
+		{"mailto", "[yaz](mailto:ali@example.com)", "[yaz](mailto:ali@example.com)"},
+		{"site path", "[kanal](/#/channel/genel)", "[kanal](/#/channel/genel)"},
+		{"javascript", "[tıkla](javascript:alert(1))", "tıkla"},
+		{"javascript upper case", "[tıkla](JavaScript:alert(1))", "tıkla"},
+		{"data", "[tıkla](data:text/html,x)", "tıkla"},
+		{"protocol relative", "[tıkla](//evil.example)", "tıkla"},
+		{"backslash protocol relative", `[tıkla](/\evil.example)`, "tıkla"},
+		{"http without host", "[tıkla](http:evil)", "tıkla"},
+		{"javascript reference", "[a]: javascript:alert(1)", ""},
+		{"protocol relative reference", "[a]: //evil.example", ""},
+		{"safe reference", "[a]: https://example.com", "[a]: https://example.com"},
+		{"underscore bold", "__kalın__", "**kalın**"},
+		{"snake case", "snake_case_name", "snake_case_name"},
+		{"bidi override", "abc‮def", "abcdef"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeMarkdown(tt.text); got != tt.want {
+				t.Errorf("sanitizeMarkdown(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSafeLinkTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"https://example.com/a", true},
+		{"http://example.com", true},
+		{"mailto:ali@example.com", true},
+		{"/#/channel/genel", true},
+		{"/api/links/abc", true},
+		{"", false},
+		{"javascript:alert(1)", false},
+		{"JAVASCRIPT:alert(1)", false},
+		{"vbscript:msgbox(1)", false},
+		{"data:text/html,x", false},
+		{"//evil.example", false},
+		{"///evil.example", false},
+		{`/\evil.example`, false},
+		{"https:evil", false},
+		{"relative/path", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			if got := safeLinkTarget(tt.target); got != tt.want {
+				t.Errorf("safeLinkTarget(%q) = %v, want %v", tt.target, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+)
+
+// Users can switch channels to text only. File and image messages in those
+// channels are then delivered as a media_stub frame without previews,
+// renditions or blurhash; the full message is fetched on demand from FetchURL.
+// Stubs need the stored message, so without Redis the full message is sent.
+
+// MediaFilterRequest is the inbound {"type":"media_filter","channel":"...","textOnly":true} frame
+type MediaFilterRequest struct {
+	Type     string `json:"type"`
+	Channel  string `json:"channel"`
+	TextOnly bool   `json:"textOnly"`
+}
+
+// MediaFilterFrame lists the user's text-only channels after a change
+type MediaFilterFrame struct {
+	Type     string   `json:"type"`
+	Channels []string `json:"channels"`
+}
+
+// MediaStubFrame stands in for a file or image message in a text-only channel
+type MediaStubFrame struct {
+	Type      string    `json:"type"`
+	MessageID string    `json:"messageId"`
+	Seq       int64     `json:"seq,omitempty"`
+	Channel   string    `json:"channel"`
+	Username  string    `json:"username"`
+	MediaType string    `json:"mediaType"` // "file" or "image"
+	FileName  string    `json:"fileName"`
+	FileSize  int64     `json:"fileSize"`
+	FetchURL  string    `json:"fetchUrl"` // full message, see GET /api/messages/{id}
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Text-only channels of a user, one hash field per channel
+func mediaFilterKey(username string) string {
+	return "websocket:mediafilter:" + username
+}
+
+func isMediaMessage(msg Message) bool {
+	return msg.Type == "file" || msg.Type == "image"
+}
+
+// mediaStub builds the stub frame of a file or image message
+func mediaStub(msg Message) MediaStubFrame {
+	return MediaStubFrame{
+		Type:      "media_stub",
+		MessageID: msg.MessageID,
+		Seq:       msg.Seq,
+		Channel:   msg.Channel,
+		Username:  msg.Username,
+		MediaType: msg.Type,
+		FileName:  msg.FileName,
+		FileSize:  msg.FileSize,
+		FetchURL:  "/api/messages/" + msg.MessageID,
+		Timestamp: msg.Timestamp,
+	}
+}
+
+// textOnly reports whether the client filters media in a channel
+func (c *Client) textOnly(channel string) bool {
+	c.mediaFilterMutex.RLock()
+	defer c.mediaFilterMutex.RUnlock()
+	return c.mediaFilter[channel]
+}
+
+func (c *Client) setMediaFilter(channels map[string]bool) {
+	c.mediaFilterMutex.Lock()
+	c.mediaFilter = channels
+	c.mediaFilterMutex.Unlock()
+}
+
+// filterFrame replaces an encoded media message by its stub for text-only
+// channels; other frames are returned unchanged
+func (c *Client) filterFrame(frame []byte) []byte {
+	c.mediaFilterMutex.RLock()
+	filtering := len(c.mediaFilter) > 0
+	c.mediaFilterMutex.RUnlock()
+	if !filtering {
+		return frame
+	}
+	var env struct {
+		Type    string  `json:"type"`
+		Payload Message `json:"payload"`
+	}
+	if err := json.Unmarshal(frame, &env); err != nil || env.Type != "message" {
+		return frame
+	}
+	msg := env.Payload
+	if !isMediaMessage(msg) || msg.MessageID == "" || !c.textOnly(msg.Channel) {
+		return frame
+	}
+	stub, err := encodeFrame(mediaStub(msg))
+	if err != nil {
+		return frame
+	}
+	return stub
+}
+
+// sendMessageToChannel delivers a chat message, as a stub to clients that
+// filter media in its channel and marked to clients it highlights for. It
+// returns the number of connections the message was queued for.
+func (h *Hub) sendMessageToChannel(msg Message, frame []byte, p Priority) int {
+	stubbing := isMediaMessage(msg) && msg.MessageID != "" && h.redis != nil
+	var stub, highlighted []byte
+	queued := 0
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for client := range h.clients {
+		if !client.inChannel(msg.Channel) {
+			continue
+		}
+		out := frame
+		switch {
+		case stubbing && client.textOnly(msg.Channel):
+			if stub == nil {
+				var err error
+				if stub, err = encodeFrame(mediaStub(msg)); err != nil {
+					stub = frame
+				}
+			}
+			out = stub
+		case client.highlighted(msg):
+			if highlighted == nil {
+				marked := msg
+				marked.Highlight = true
+				var err error
+				if highlighted, err = encodeFrame(marked); err != nil {
+					highlighted = frame
+				}
+			}
+			out = highlighted
+		}
+		if client.enqueue(out, p) {
+			queued++
+		}
+	}
+	return queued
+}
+
+// loadMediaFilter reads the user's text-only channels after they connect and
+// tells the client about them
+func (h *Hub) loadMediaFilter(c *Client) {
+	if h.redis == nil || c.Username == "" {
+		return
+	}
+	channels, err := h.redis.HKeys(context.Background(), mediaFilterKey(c.Username)).Result()
+	if err != nil || len(channels) == 0 {
+		return
+	}
+	filter := make(map[string]bool, len(channels))
+	for _, channel := range channels {
+		filter[channel] = true
+	}
+	c.setMediaFilter(filter)
+	sort.Strings(channels)
+	c.sendFrame(MediaFilterFrame{Type: "media_filter", Channels: channels}, PriorityHigh)
+}
+
+// handleMediaFilter switches a channel between text only and full media for
+// every connection of the sender
+func (h *Hub) handleMediaFilter(c *Client, raw []byte) {
+	var req MediaFilterRequest
+	if err := json.Unmarshal(raw, &req); err != nil || c.Username == "" {
+		return
+	}
+	if req.Channel == "" {
+		c.sendError(ErrInvalidChannel, "Kanal belirtilmedi")
+		return
+	}
+
+	c.mediaFilterMutex.RLock()
+	filter := make(map[string]bool, len(c.mediaFilter)+1)
+	for channel := range c.mediaFilter {
+		filter[channel] = true
+	}
+	c.mediaFilterMutex.RUnlock()
+	if req.TextOnly {
+		filter[req.Channel] = true
+	} else {
+		delete(filter, req.Channel)
+	}
+
+	if h.redis != nil {
+		ctx := context.Background()
+		var err error
+		if req.TextOnly {
+			err = h.redis.HSet(ctx, mediaFilterKey(c.Username), req.Channel, 1).Err()
+		} else {
+			err = h.redis.HDel(ctx, mediaFilterKey(c.Username), req.Channel).Err()
+		}
+		if err != nil {
+			log.Printf("Medya filtresi kaydedilemedi: %v", err)
+		}
+	}
+
+	channels := make([]string, 0, len(filter))
+	for channel := range filter {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	frame, err := encodeFrame(MediaFilterFrame{Type: "media_filter", Channels: channels})
+	if err != nil {
+		return
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for client := range h.clients {
+		if client == c || (client.Username == c.Username && client.Username != "") {
+			client.setMediaFilter(filter)
+			client.enqueue(frame, PriorityHigh)
+		}
+	}
+}
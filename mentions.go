@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// At most this many distinct users are notified for one message
+const mentionLimit = 20
+
+// @name preceded by the start of the text or a non-word character, so e-mail
+// addresses don't count as mentions
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_][\p{L}\p{N}_.-]*)`)
+
+// MentionFrame notifies a user that they were mentioned
+type MentionFrame struct {
+	Type         string           `json:"type"`
+	Channel      string           `json:"channel"`
+	From         string           `json:"from"`
+	Excerpt      string           `json:"excerpt"`
+	Timestamp    time.Time        `json:"timestamp"` // timestamp of the mentioning message
+	Notification NotificationHint `json:"notification"`
+}
+
+// parseMentions returns the distinct usernames mentioned in text. Mentions inside
+// ``` code fences, `inline code` and quoted ("> ") lines are ignored, so pasted
+// logs and reply excerpts don't notify anyone.
+func parseMentions(text string) []string {
+	var mentions []string
+	seen := make(map[string]bool)
+	inFence := false
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inFence = !inFence
+			continue
+		}
+		if inFence || strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		for _, match := range mentionPattern.FindAllStringSubmatch(stripInlineCode(line), -1) {
+			name := strings.TrimRight(match[1], ".-")
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			mentions = append(mentions, name)
+			if len(mentions) == mentionLimit {
+				return mentions
+			}
+		}
+	}
+	return mentions
+}
+
+// mentionedUsers returns the users mentioned in text who have connected before,
+// so mentions of names nobody uses don't collect unread counts. Without Redis,
+// or when it fails, every mention is kept.
+func (h *Hub) mentionedUsers(text string) []string {
+	mentions := parseMentions(text)
+	if h.redis == nil || len(mentions) == 0 {
+		return mentions
+	}
+	firstSeen, err := h.redis.HMGet(context.Background(), firstSeenKey, mentions...).Result()
+	if err != nil {
+		return mentions
+	}
+	known := make(map[string]bool, len(mentions))
+	for i, name := range mentions {
+		known[name] = firstSeen[i] != nil
+	}
+	return knownMentions(mentions, known)
+}
+
+// knownMentions keeps the mentions of known users, in order
+func knownMentions(mentions []string, known map[string]bool) []string {
+	var users []string
+	for _, name := range mentions {
+		if known[name] {
+			users = append(users, name)
+		}
+	}
+	return users
+}
+
+// stripInlineCode blanks out `code spans`; an unmatched backtick is kept as text
+func stripInlineCode(line string) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(line, '`')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(line[start+1:], '`')
+		if end < 0 {
+			break
+		}
+		b.WriteString(line[:start])
+		b.WriteByte(' ')
+		line = line[start+1+end+1:]
+	}
+	b.WriteString(line)
+	return b.String()
+}
+
+// notifyMentions counts the mention as unread for every mentioned user and
+// sends a mention frame to those who are online, unless the user muted the
+// channel or is in a do-not-disturb window
+func (h *Hub) notifyMentions(msg Message) {
+	excerpt := msg.Message
+	if utf8.RuneCountInString(excerpt) > 140 {
+		excerpt = string([]rune(excerpt)[:140]) + "…"
+	}
+	for _, username := range msg.Mentions {
+		if username == msg.Username {
+			continue
+		}
+		if isConversation(msg.Channel) && !h.isConversationMember(msg.Channel, username) {
+			continue
+		}
+		prefs := h.notificationPrefs(username)
+		if prefs.muted(msg.Channel) {
+			notificationsSuppressed.Add("muted", 1)
+			continue
+		}
+		badge := h.bumpUnread(username, msg.Channel)
+		if prefs.inDND(time.Now()) {
+			notificationsSuppressed.Add("dnd", 1)
+			continue
+		}
+		h.notifyUser(username, MentionFrame{
+			Type:      "mention",
+			Channel:   msg.Channel,
+			From:      msg.Username,
+			Excerpt:   excerpt,
+			Timestamp: msg.Timestamp,
+			Notification: NotificationHint{
+				Title:       fmt.Sprintf("%s sizden bahsetti (#%s)", msg.Username, msg.Channel),
+				Body:        excerpt,
+				Badge:       badge,
+				Sound:       SoundMention,
+				CollapseKey: "mention:" + msg.Channel,
+				Link:        notificationLink(msg.Channel, messageKey(msg.MessageID, msg.Timestamp)),
+			},
+		}, PriorityNormal)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	var many []string
+	for i := 0; i < mentionLimit+5; i++ {
+		many = append(many, fmt.Sprintf("@user%d", i))
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"start", "@ali selam", []string{"ali"}},
+		{"middle", "selam @ali nasılsın", []string{"ali"}},
+		{"end", "selam @ali", []string{"ali"}},
+		{"whole text", "@ali", []string{"ali"}},
+		{"several", "@ali ve @ayşe", []string{"ali", "ayşe"}},
+		{"comma", "@ali, bakar mısın", []string{"ali"}},
+		{"exclamation", "tebrikler @ali!", []string{"ali"}},
+		{"question", "@ali?", []string{"ali"}},
+		{"colon", "@ali: bak", []string{"ali"}},
+		{"trailing period", "sorun @ali.", []string{"ali"}},
+		{"trailing dash", "@ali- bak", []string{"ali"}},
+		{"parentheses", "(@ali)", []string{"ali"}},
+		{"dotted name", "@ali.veli bak", []string{"ali.veli"}},
+		{"turkish letters", "@çağrı selam", []string{"çağrı"}},
+		{"email", "ali@example.com adresine yaz", nil},
+		{"email and mention", "ali@example.com @veli", []string{"veli"}},
+		{"double at", "@@ali", nil},
+		{"bare at", "@ yalnız", nil},
+		{"duplicates", "@ali @ali, @ali!", []string{"ali"}},
+		{"duplicates keep order", "@veli @ali @veli", []string{"veli", "ali"}},
+		{"unknown users are parsed", "@kimseyok", []string{"kimseyok"}},
+		{"inline code", "`@ali` ve @veli", []string{"veli"}},
+		{"unmatched backtick", "`@ali", []string{"ali"}},
+		{"code fence", "```\n@ali hata verdi\n```\n@veli", []string{"veli"}},
+		{"unclosed fence", "@veli\n```\n@ali", []string{"veli"}},
+		{"quoted line", "> @ali yazmıştı\n@veli", []string{"veli"}},
+		{"indented quote", "  > @ali\n@veli", []string{"veli"}},
+		{"limit", strings.Join(many, " "), mentionNames(many[:mentionLimit])},
+		{"none", "selam herkese", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseMentions(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMentions(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func mentionNames(mentions []string) []string {
+	names := make([]string, len(mentions))
+	for i, mention := range mentions {
+		names[i] = strings.TrimPrefix(mention, "@")
+	}
+	return names
+}
+
+func TestKnownMentions(t *testing.T) {
+	known := map[string]bool{"ali": true, "veli": true}
+
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"known", "@ali @veli", []string{"ali", "veli"}},
+		{"unknown dropped", "@ali @kimseyok @veli", []string{"ali", "veli"}},
+		{"only unknown", "@kimseyok", nil},
+		{"case sensitive", "@Ali", nil},
+		{"unknown email", "kimseyok@example.com", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := knownMentions(parseMentions(tt.text), known); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("knownMentions(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import "expvar"
+
+// Runtime counters, published by the expvar package at /debug/vars
+var (
+	authFailures = expvar.NewMap("auth_failures")
+	authLockouts = expvar.NewInt("auth_lockouts")
+
+	// Accepted client connections per transport ("websocket", "webtransport")
+	transportConnections = expvar.NewMap("transport_connections")
+
+	// IP reputation outcomes ("allow", "allowlisted", "flag", "block")
+	ipReputationDecisions = expvar.NewMap("ip_reputation_decisions")
+)
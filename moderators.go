@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// Moderators are usernames an admin appointed for a channel. They may pin and
+// unpin its messages.
+
+// Moderator usernames of a channel
+func moderatorsKey(channel string) string {
+	return "websocket:moderators:" + channel
+}
+
+// ModeratorRequest is the body of POST and DELETE /admin/moderators
+type ModeratorRequest struct {
+	Channel  string `json:"channel"`
+	Username string `json:"username"`
+}
+
+// isModerator reports whether username moderates channel
+func (h *Hub) isModerator(channel, username string) bool {
+	if h.redis == nil || username == "" {
+		return false
+	}
+	ok, err := h.redis.SIsMember(context.Background(), moderatorsKey(channel), username).Result()
+	return err == nil && ok
+}
+
+// handleAdminModerators serves /admin/moderators: GET ?channel= lists a
+// channel's moderators, POST appoints one, DELETE removes one
+func handleAdminModerators(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if hub.redis == nil {
+		http.Error(w, "Moderators require Redis", http.StatusServiceUnavailable)
+		return
+	}
+	ctx := context.Background()
+	admin, _ := adminFromRequest(r)
+	switch r.Method {
+	case "GET":
+		channel := r.URL.Query().Get("channel")
+		if channel == "" {
+			http.Error(w, "channel required", http.StatusBadRequest)
+			return
+		}
+		users, err := hub.redis.SMembers(ctx, moderatorsKey(channel)).Result()
+		if err != nil {
+			http.Error(w, "Error reading moderators", http.StatusInternalServerError)
+			return
+		}
+		sort.Strings(users)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"channel": channel, "moderators": users})
+	case "POST", "DELETE":
+		var req ModeratorRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Channel == "" || req.Username == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if r.Method == "POST" {
+			if err := hub.redis.SAdd(ctx, moderatorsKey(req.Channel), req.Username).Err(); err != nil {
+				http.Error(w, "Error saving moderator", http.StatusInternalServerError)
+				return
+			}
+			hub.recordAudit("moderator_added", admin, req.Channel, req.Username)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		removed, err := hub.redis.SRem(ctx, moderatorsKey(req.Channel), req.Username).Result()
+		if err != nil {
+			http.Error(w, "Error removing moderator", http.StatusInternalServerError)
+			return
+		}
+		if removed == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		hub.recordAudit("moderator_removed", admin, req.Channel, req.Username)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Admins set a message of the day, one for the whole server and one per
+// channel, with POST /admin/motd {"channel":"genel","message":"..."} (no
+// channel for the global one) and clear it with DELETE. A client gets the
+// global one as {"type":"motd",...} right after user_connected, and a
+// channel's before the first history of that channel it is sent on the
+// connection. The messages are kept in Redis and sanitized like message text.
+
+// The global message of the day, a JSON MOTD
+const motdKey = "websocket:motd"
+
+// Messages of the day of channels, channel -> JSON MOTD
+const channelMOTDKey = "websocket:motd:channels"
+
+// MOTD is a message of the day
+type MOTD struct {
+	Message   string    `json:"message"`
+	UpdatedBy string    `json:"updatedBy"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// MOTDRequest is the body of POST and DELETE /admin/motd
+type MOTDRequest struct {
+	Channel string `json:"channel,omitempty"` // the global message when empty
+	Message string `json:"message"`
+}
+
+// MOTDFrame sends a message of the day to a client
+type MOTDFrame struct {
+	Type      string    `json:"type"`
+	Channel   string    `json:"channel,omitempty"` // empty for the global message
+	Message   string    `json:"message"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// motd reads the message of the day of a channel, or the global one
+func (h *Hub) motd(channel string) (MOTD, bool) {
+	var motd MOTD
+	if h.redis == nil {
+		return motd, false
+	}
+	ctx := context.Background()
+	var raw []byte
+	var err error
+	if channel == "" {
+		raw, err = h.redis.Get(ctx, motdKey).Bytes()
+	} else {
+		raw, err = h.redis.HGet(ctx, channelMOTDKey, channel).Bytes()
+	}
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Günün mesajı okunamadı: %v", err)
+		}
+		return motd, false
+	}
+	return motd, json.Unmarshal(raw, &motd) == nil && motd.Message != ""
+}
+
+// sendMOTD sends the message of the day of a channel, or the global one, the
+// first time it is asked for on the connection
+func (h *Hub) sendMOTD(c *Client, channel string) {
+	c.motdMutex.Lock()
+	if c.motdSent[channel] {
+		c.motdMutex.Unlock()
+		return
+	}
+	if c.motdSent == nil {
+		c.motdSent = make(map[string]bool)
+	}
+	c.motdSent[channel] = true
+	c.motdMutex.Unlock()
+
+	if motd, ok := h.motd(channel); ok {
+		c.sendFrame(MOTDFrame{Type: "motd", Channel: channel, Message: motd.Message, UpdatedAt: motd.UpdatedAt}, PriorityHigh)
+	}
+}
+
+// handleAdminMOTD lists, sets and clears messages of the day
+func handleAdminMOTD(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if hub.redis == nil {
+		http.Error(w, "Messages of the day require Redis", http.StatusServiceUnavailable)
+		return
+	}
+	ctx := context.Background()
+	admin, _ := adminFromRequest(r)
+	switch r.Method {
+	case "GET":
+		result := struct {
+			Global   *MOTD           `json:"global"`
+			Channels map[string]MOTD `json:"channels"`
+		}{Channels: make(map[string]MOTD)}
+		if motd, ok := hub.motd(""); ok {
+			result.Global = &motd
+		}
+		stored, err := hub.redis.HGetAll(ctx, channelMOTDKey).Result()
+		if err != nil {
+			http.Error(w, "Error reading messages of the day", http.StatusInternalServerError)
+			return
+		}
+		for channel, raw := range stored {
+			var motd MOTD
+			if json.Unmarshal([]byte(raw), &motd) == nil {
+				result.Channels[channel] = motd
+			}
+		}
+		writeJSON(w, http.StatusOK, result)
+	case "POST":
+		var req MOTDRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		text := sanitizeMarkdown(strings.TrimSpace(req.Message))
+		if text == "" || utf8.RuneCountInString(text) > maxMessageLength {
+			http.Error(w, fmt.Sprintf("message must be 1 to %d characters", maxMessageLength), http.StatusBadRequest)
+			return
+		}
+		motd := MOTD{Message: text, UpdatedBy: admin, UpdatedAt: time.Now()}
+		data, _ := json.Marshal(motd)
+		var err error
+		if req.Channel == "" {
+			err = hub.redis.Set(ctx, motdKey, data, 0).Err()
+		} else {
+			err = hub.redis.HSet(ctx, channelMOTDKey, req.Channel, data).Err()
+		}
+		if err != nil {
+			http.Error(w, "Error saving message of the day", http.StatusInternalServerError)
+			return
+		}
+		hub.recordAudit("motd_updated", admin, req.Channel, truncate(text, 100))
+		writeJSON(w, http.StatusOK, motd)
+	case "DELETE":
+		var req MOTDRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		var removed int64
+		var err error
+		if req.Channel == "" {
+			removed, err = hub.redis.Del(ctx, motdKey).Result()
+		} else {
+			removed, err = hub.redis.HDel(ctx, channelMOTDKey, req.Channel).Result()
+		}
+		if err != nil {
+			http.Error(w, "Error removing message of the day", http.StatusInternalServerError)
+			return
+		}
+		if removed == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		hub.recordAudit("motd_cleared", admin, req.Channel, "")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Wire encodings of the /ws protocol. Binary encodings are negotiated per
+// connection: MessagePack clients get binary WebSocket messages holding one or
+// more concatenated MessagePack values, one per frame, protobuf clients get
+// length-delimited chatpb.Envelope messages (see protobuf.go). Both may send
+// binary frames themselves. Frames are still built as JSON once per broadcast
+// and converted per connection on the way out.
+const (
+	encodingJSON     = "json"
+	encodingMsgpack  = "msgpack"
+	encodingProtobuf = "protobuf"
+)
+
+// negotiateEncoding picks the wire encoding for a requested one. WebTransport
+// streams and long polling are line based and always use JSON.
+func (c *Client) negotiateEncoding(requested string) string {
+	if requested != encodingMsgpack && requested != encodingProtobuf {
+		return encodingJSON
+	}
+	if _, ok := c.Conn.(*websocket.Conn); !ok {
+		return encodingJSON
+	}
+	return requested
+}
+
+// wireEncoding returns the negotiated wire encoding of the client
+func (c *Client) wireEncoding() string {
+	if encoding, ok := c.encoding.Load().(string); ok {
+		return encoding
+	}
+	return encodingJSON
+}
+
+// encodeWire converts an encoded JSON frame to a binary wire encoding
+func encodeWire(frame []byte, encoding string) ([]byte, error) {
+	if encoding == encodingProtobuf {
+		return jsonToProtobuf(frame)
+	}
+	return jsonToMsgpack(frame)
+}
+
+// decodeWire converts a binary client frame to JSON, with the error code for
+// frames that can't be decoded
+func decodeWire(frame []byte, encoding string) ([]byte, string, error) {
+	if encoding == encodingProtobuf {
+		data, err := protobufToJSON(frame)
+		return data, ErrInvalidProtobuf, err
+	}
+	data, err := msgpackToJSON(frame)
+	return data, ErrInvalidMsgpack, err
+}
+
+// jsonToMsgpack re-encodes one JSON frame as MessagePack
+func jsonToMsgpack(frame []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(frame))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(msgpackValue(v))
+}
+
+// msgpackValue turns JSON numbers into integers where possible, so sequence
+// numbers and sizes don't arrive as floats
+func msgpackValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = msgpackValue(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = msgpackValue(item)
+		}
+	}
+	return v
+}
+
+// msgpackToJSON converts an inbound MessagePack frame to the JSON the read pump
+// understands
+func msgpackToJSON(frame []byte) ([]byte, error) {
+	var v interface{}
+	if err := msgpack.Unmarshal(frame, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
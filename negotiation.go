@@ -0,0 +1,138 @@
+package main
+
+import (
+	"compress/flate"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// Protocol versions: 1 sent every frame bare, 2 wraps them in an Envelope.
+// Clients declare theirs with a hello frame or /ws?v=N; without either they
+// get the current version.
+const (
+	protocolVersion    = 2
+	minProtocolVersion = 1
+)
+
+// permessage-deflate for WebSocket connections whose browser offers it. History
+// replays and API payloads compress well; batches below the threshold are sent
+// uncompressed since deflating them costs more CPU than it saves bandwidth.
+var (
+	wsCompression          = envBool("WS_COMPRESSION", true)
+	wsCompressionLevel     = envInt("WS_COMPRESSION_LEVEL", flate.BestSpeed)
+	wsCompressionThreshold = envInt("WS_COMPRESSION_THRESHOLD", 512)
+)
+
+// HelloRequest is the inbound {"type":"hello","version":N,"encoding":"msgpack","lite":true} frame
+type HelloRequest struct {
+	Type     string `json:"type"`
+	Version  int    `json:"version"`
+	Encoding string `json:"encoding,omitempty"` // "json" (default), "msgpack" or "protobuf"
+	Lite     bool   `json:"lite,omitempty"`     // low-bandwidth mode, see lite.go
+	Link     string `json:"link,omitempty"`     // deep link the client was opened with, see deeplinks.go
+}
+
+// HelloFrame answers a hello with the version used from now on, the range the
+// server supports, the optional features it has enabled and the transports to
+// fall back to when the current one fails
+type HelloFrame struct {
+	Type             string   `json:"type"`
+	Version          int      `json:"version"`
+	ServerVersion    int      `json:"serverVersion"`
+	MinVersion       int      `json:"minVersion"`
+	Encoding         string   `json:"encoding"` // wire encoding of the following frames
+	Capabilities     []string `json:"capabilities"`
+	Lite             bool     `json:"lite"`
+	MaxFrameBytes    int      `json:"maxFrameBytes"`    // larger frames get message_too_large
+	MaxMessageLength int      `json:"maxMessageLength"` // longer texts get message_too_long
+	Transports       []string `json:"transports"`       // preferred first, URLs at /api/transport
+}
+
+// serverCapabilities lists the features a client may rely on
+func serverCapabilities() []string {
+	capabilities := []string{"acks", "mentions", "reactions", "receipts", "replies", "resume", "history", "custom_status", "deep_links", "notification_prefs", "first_message_review", "read_markers", "announcements", "motd", "guests", "day_markers", "subscriptions", "key_pinning", "stats", "image_renditions", "msgpack", "protobuf", "lite", "latency"}
+	if wsCompression {
+		capabilities = append(capabilities, "compression")
+	}
+	if webTransportEnabled {
+		capabilities = append(capabilities, "webtransport")
+	}
+	if longPollingEnabled {
+		capabilities = append(capabilities, "long_polling")
+	}
+	return capabilities
+}
+
+// negotiateVersion picks the version for a client's requested one; false when
+// the client is too old to be served
+func negotiateVersion(requested int) (int, bool) {
+	if requested <= 0 || requested > protocolVersion {
+		return protocolVersion, true
+	}
+	return requested, requested >= minProtocolVersion
+}
+
+// negotiatedVersion returns the protocol version of the client
+func (c *Client) negotiatedVersion() int {
+	if v := c.protocol.Load(); v != 0 {
+		return int(v)
+	}
+	return protocolVersion
+}
+
+// negotiate settles the client's protocol version and wire encoding and answers
+// with a hello frame
+func (c *Client) negotiate(requested int, encoding string) {
+	version, ok := negotiateVersion(requested)
+	if !ok {
+		c.sendError(ErrUnsupportedVersion, "Bu istemci sürümü artık desteklenmiyor, lütfen sayfayı yenileyin")
+		return
+	}
+	encoding = c.negotiateEncoding(encoding)
+	c.protocol.Store(int32(version))
+	c.encoding.Store(encoding)
+	c.sendFrame(HelloFrame{
+		Type:             "hello",
+		Version:          version,
+		ServerVersion:    protocolVersion,
+		MinVersion:       minProtocolVersion,
+		Encoding:         encoding,
+		Capabilities:     serverCapabilities(),
+		Lite:             c.lite.Load(),
+		MaxFrameBytes:    maxMessageBytes,
+		MaxMessageLength: maxMessageLength,
+		Transports:       serverTransports(),
+	}, PriorityHigh)
+}
+
+// handleHello processes a client's hello frame
+func (c *Client) handleHello(raw []byte) {
+	var req HelloRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return
+	}
+	c.lite.Store(req.Lite)
+	c.deepLink = req.Link
+	c.negotiate(req.Version, req.Encoding)
+}
+
+// requestedProtocol reads the ?v= and ?encoding= query parameters of a
+// connection request; false when neither is given
+func requestedProtocol(r *http.Request) (int, string, bool) {
+	query := r.URL.Query()
+	v, err := strconv.Atoi(query.Get("v"))
+	encoding := query.Get("encoding")
+	return v, encoding, err == nil || encoding != ""
+}
+
+// legacyFrame unwraps an encoded envelope for version 1 clients
+func legacyFrame(frame []byte) []byte {
+	var env struct {
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(frame, &env); err != nil || len(env.Payload) == 0 {
+		return frame
+	}
+	return env.Payload
+}
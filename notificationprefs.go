@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Users choose when they are notified: channels they muted never notify them,
+// and during their do-not-disturb windows nothing does. The preferences are
+// kept per username on the server, so they hold on every device, and are set
+// with {"type":"notification_prefs","mutedChannels":[...],"dnd":[{"start":"22:00",
+// "end":"07:00"}],"timezone":"Europe/Istanbul"}, which replaces them as a whole.
+// The time zone also sets the days of the history's day markers.
+// Only mention frames are held back; the messages themselves are delivered as
+// usual. A mention in a muted channel isn't counted as unread, one during
+// do-not-disturb is, so the badge is right once the window ends. Security
+// notices always go out.
+
+const (
+	maxMutedChannels = 100
+	maxDNDWindows    = 7
+)
+
+// Custom notification preferences, username -> JSON NotificationPrefs
+const notificationPrefsKey = "websocket:notification_prefs"
+
+// Mentions not notified, per reason ("muted" or "dnd")
+var notificationsSuppressed = expvar.NewMap("notifications_suppressed")
+
+// DNDWindow is a daily do-not-disturb window in the user's time zone; an end
+// before the start spans midnight
+type DNDWindow struct {
+	Start string `json:"start"` // "22:00"
+	End   string `json:"end"`   // "07:00"
+}
+
+// NotificationPrefs is what a user wants to be notified about
+type NotificationPrefs struct {
+	MutedChannels []string    `json:"mutedChannels"`
+	DND           []DNDWindow `json:"dnd"`
+	Timezone      string      `json:"timezone,omitempty"` // IANA name, UTC when empty
+}
+
+// NotificationPrefsRequest is the inbound {"type":"notification_prefs",...} frame
+type NotificationPrefsRequest struct {
+	Type          string      `json:"type"`
+	MutedChannels []string    `json:"mutedChannels"`
+	DND           []DNDWindow `json:"dnd"`
+	Timezone      string      `json:"timezone"`
+}
+
+// NotificationPrefsFrame tells the user's connections their preferences, sent
+// on connect and after a change
+type NotificationPrefsFrame struct {
+	Type          string      `json:"type"`
+	MutedChannels []string    `json:"mutedChannels"`
+	DND           []DNDWindow `json:"dnd"`
+	Timezone      string      `json:"timezone,omitempty"`
+}
+
+func (p NotificationPrefs) frame() NotificationPrefsFrame {
+	return NotificationPrefsFrame{Type: "notification_prefs", MutedChannels: p.MutedChannels, DND: p.DND, Timezone: p.Timezone}
+}
+
+// cleanNotificationPrefs dedupes the muted channels and checks the windows and
+// time zone of a request
+func cleanNotificationPrefs(req NotificationPrefsRequest) (NotificationPrefs, bool) {
+	prefs := NotificationPrefs{MutedChannels: []string{}, DND: []DNDWindow{}, Timezone: strings.TrimSpace(req.Timezone)}
+	for _, channel := range req.MutedChannels {
+		if channel = strings.TrimSpace(channel); channel != "" && !containsString(prefs.MutedChannels, channel) {
+			prefs.MutedChannels = append(prefs.MutedChannels, channel)
+		}
+	}
+	if len(prefs.MutedChannels) > maxMutedChannels || len(req.DND) > maxDNDWindows {
+		return prefs, false
+	}
+	for _, window := range req.DND {
+		start, err1 := time.Parse("15:04", window.Start)
+		end, err2 := time.Parse("15:04", window.End)
+		if err1 != nil || err2 != nil || start.Equal(end) {
+			return prefs, false
+		}
+		prefs.DND = append(prefs.DND, DNDWindow{Start: start.Format("15:04"), End: end.Format("15:04")})
+	}
+	if prefs.Timezone != "" {
+		if _, err := time.LoadLocation(prefs.Timezone); err != nil {
+			return prefs, false
+		}
+	}
+	return prefs, true
+}
+
+// empty reports whether the preferences are the defaults, kept as no entry
+func (p NotificationPrefs) empty() bool {
+	return len(p.MutedChannels) == 0 && len(p.DND) == 0 && p.Timezone == ""
+}
+
+func (p NotificationPrefs) muted(channel string) bool {
+	return containsString(p.MutedChannels, channel)
+}
+
+// inDND reports whether t falls in one of the do-not-disturb windows
+func (p NotificationPrefs) inDND(t time.Time) bool {
+	if len(p.DND) == 0 {
+		return false
+	}
+	location, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := t.In(location).Format("15:04")
+	for _, window := range p.DND {
+		if window.Start < window.End {
+			if local >= window.Start && local < window.End {
+				return true
+			}
+		} else if local >= window.Start || local < window.End {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) setNotificationPrefs(prefs NotificationPrefs) {
+	c.notificationPrefsMutex.Lock()
+	c.notificationPrefs = prefs
+	c.notificationPrefsMutex.Unlock()
+}
+
+// notificationPrefs reads a user's preferences; without Redis they are known
+// only while the user is connected
+func (h *Hub) notificationPrefs(username string) NotificationPrefs {
+	if h.redis == nil {
+		h.mutex.RLock()
+		defer h.mutex.RUnlock()
+		for client := range h.clients {
+			if client.Username == username {
+				client.notificationPrefsMutex.RLock()
+				defer client.notificationPrefsMutex.RUnlock()
+				return client.notificationPrefs
+			}
+		}
+		return NotificationPrefs{}
+	}
+	var prefs NotificationPrefs
+	raw, err := h.redis.HGet(context.Background(), notificationPrefsKey, username).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Bildirim tercihleri okunamadı: %v", err)
+		}
+		return prefs
+	}
+	json.Unmarshal(raw, &prefs)
+	return prefs
+}
+
+// loadNotificationPrefs tells a client that just connected the preferences of
+// its user, when they set any
+func (h *Hub) loadNotificationPrefs(c *Client) {
+	if h.redis == nil || c.Username == "" {
+		return
+	}
+	prefs := h.notificationPrefs(c.Username)
+	if prefs.empty() {
+		return
+	}
+	c.setNotificationPrefs(prefs)
+	c.sendFrame(prefs.frame(), PriorityHigh)
+}
+
+// handleNotificationPrefs replaces the sender's preferences on every
+// connection of the user
+func (h *Hub) handleNotificationPrefs(c *Client, raw []byte) {
+	var req NotificationPrefsRequest
+	if err := json.Unmarshal(raw, &req); err != nil || c.Username == "" {
+		return
+	}
+	prefs, ok := cleanNotificationPrefs(req)
+	if !ok {
+		c.sendError(ErrInvalidPrefs, "Geçersiz bildirim tercihleri")
+		return
+	}
+
+	if h.redis != nil {
+		ctx := context.Background()
+		var err error
+		if prefs.empty() {
+			err = h.redis.HDel(ctx, notificationPrefsKey, c.Username).Err()
+		} else {
+			data, _ := json.Marshal(prefs)
+			err = h.redis.HSet(ctx, notificationPrefsKey, c.Username, data).Err()
+		}
+		if err != nil {
+			log.Printf("Bildirim tercihleri kaydedilemedi: %v", err)
+			return
+		}
+	}
+	log.Printf("Bildirim tercihleri güncellendi: %s, %d sessiz kanal, %d rahatsız etme aralığı", c.Username, len(prefs.MutedChannels), len(prefs.DND))
+
+	frame, err := encodeFrame(prefs.frame())
+	if err != nil {
+		return
+	}
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for client := range h.clients {
+		if client == c || (client.Username == c.Username && client.Username != "") {
+			client.setNotificationPrefs(prefs)
+			client.enqueue(frame, PriorityHigh)
+		}
+	}
+}
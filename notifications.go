@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// Sound categories of notification hints; clients map them to platform sounds
+const (
+	SoundMention = "mention"
+	SoundMessage = "message"
+	SoundAlert   = "alert"
+)
+
+// NotificationHint is the single server-produced description of how a frame
+// should be shown as a notification, so every client platform renders the same
+type NotificationHint struct {
+	Title       string `json:"title"`
+	Body        string `json:"body"`
+	Badge       int64  `json:"badge"`       // unread mentions across all channels
+	Sound       string `json:"sound"`       // SoundMention, SoundMessage or SoundAlert
+	CollapseKey string `json:"collapseKey"` // newer notifications replace older ones with the same key
+	Link        string `json:"link"`        // deep link into the web client
+}
+
+// Unread mention counters of a user, one hash field per channel
+func unreadKey(username string) string {
+	return "websocket:unread:" + username
+}
+
+// notificationLink deep-links to a channel, and to a message when given
+func notificationLink(channel, message string) string {
+	q := url.Values{"channel": {channel}}
+	if message != "" {
+		q.Set("message", message)
+	}
+	return "/?" + q.Encode()
+}
+
+// bumpUnread counts an unread mention and returns the user's new badge count
+func (h *Hub) bumpUnread(username, channel string) int64 {
+	if h.redis == nil {
+		return 0
+	}
+	h.redis.HIncrBy(context.Background(), unreadKey(username), channel, 1)
+	return h.unreadBadge(username)
+}
+
+// unreadBadge sums the user's unread mentions over all channels
+func (h *Hub) unreadBadge(username string) int64 {
+	if h.redis == nil {
+		return 0
+	}
+	values, err := h.redis.HVals(context.Background(), unreadKey(username)).Result()
+	if err != nil {
+		return 0
+	}
+	var total int64
+	for _, v := range values {
+		n, _ := strconv.ParseInt(v, 10, 64)
+		total += n
+	}
+	return total
+}
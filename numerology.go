@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// A numerology message carries its reading as a NumerologyResult, the schema
+// clients render. /api/numerology returns the upstream answer in this form,
+// and the numerology stage validates what clients send before it is stored,
+// so a malformed upstream answer never reaches the history. Readings in the
+// upstream's own shape, from older clients or stored before the schema, are
+// converted when decoded.
+
+const (
+	numerologyResultVersion = 1
+	numerologyNameMaxLen    = 100
+	numerologyTextMaxLen    = 4000
+	numerologyMaxNumber     = 33 // master numbers 11, 22 and 33
+)
+
+var errInvalidNumerology = errors.New("invalid numerology result")
+
+// NumerologyNumber is one number of a reading with its meaning
+type NumerologyNumber struct {
+	Number         int64  `json:"number"`
+	Interpretation string `json:"interpretation"`
+}
+
+// NumerologyResult is a numerology reading, version numerologyResultVersion
+type NumerologyResult struct {
+	Version        int              `json:"version"`
+	FullName       string           `json:"fullName"`
+	BirthDate      string           `json:"birthDate,omitempty"`
+	LifePath       NumerologyNumber `json:"lifePath"`
+	NameNumber     NumerologyNumber `json:"nameNumber"`
+	AuraColor      NumerologyNumber `json:"auraColor"`
+	Interpretation string           `json:"interpretation,omitempty"` // AI yorumu
+}
+
+// upstreamNumerology is the answer of the numerology API
+type upstreamNumerology struct {
+	RequestData struct {
+		FullName  string `json:"full_name"`
+		BirthDate string `json:"birth_date"`
+	} `json:"request_data"`
+	Report *struct {
+		LifePath   NumerologyNumber `json:"life_path_number"`
+		NameNumber NumerologyNumber `json:"name_number"`
+		AuraColor  NumerologyNumber `json:"aura_color"`
+	} `json:"numerology_report"`
+	AIInterpretation string `json:"ai_interpretation"`
+}
+
+// UnmarshalJSON reads the schema or the upstream shape
+func (r *NumerologyResult) UnmarshalJSON(data []byte) error {
+	type plain NumerologyResult
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+	if r.Version != 0 {
+		return nil
+	}
+	var upstream upstreamNumerology
+	if json.Unmarshal(data, &upstream) != nil || upstream.Report == nil {
+		return nil // left invalid, rejected by validate
+	}
+	*r = NumerologyResult{
+		Version:        numerologyResultVersion,
+		FullName:       upstream.RequestData.FullName,
+		BirthDate:      upstream.RequestData.BirthDate,
+		LifePath:       upstream.Report.LifePath,
+		NameNumber:     upstream.Report.NameNumber,
+		AuraColor:      upstream.Report.AuraColor,
+		Interpretation: upstream.AIInterpretation,
+	}
+	return nil
+}
+
+// validate checks a reading and trims its text
+func (r *NumerologyResult) validate() error {
+	if r.Version != numerologyResultVersion {
+		return errInvalidNumerology
+	}
+	r.FullName = strings.TrimSpace(r.FullName)
+	if r.FullName == "" || utf8.RuneCountInString(r.FullName) > numerologyNameMaxLen {
+		return errInvalidNumerology
+	}
+	for _, n := range []*NumerologyNumber{&r.LifePath, &r.NameNumber, &r.AuraColor} {
+		n.Interpretation = strings.TrimSpace(n.Interpretation)
+		if n.Number < 1 || n.Number > numerologyMaxNumber || n.Interpretation == "" {
+			return errInvalidNumerology
+		}
+		n.Interpretation = truncate(n.Interpretation, numerologyTextMaxLen)
+	}
+	r.Interpretation = truncate(strings.TrimSpace(r.Interpretation), numerologyTextMaxLen)
+	return nil
+}
+
+// parseNumerology converts and validates an answer of the numerology API
+func parseNumerology(data []byte) (*NumerologyResult, error) {
+	var result NumerologyResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, errInvalidNumerology
+	}
+	if err := result.validate(); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// numerologyStage only lets valid readings through, and only on numerology
+// messages
+func numerologyStage(h *Hub, m *pipelineMessage) bool {
+	if m.Msg.Type != "numerology" {
+		m.Msg.NumerologyData = nil
+		return true
+	}
+	if m.Msg.NumerologyData == nil || m.Msg.NumerologyData.validate() != nil {
+		m.reject(ErrInvalidNumerology, "Geçersiz numeroloji sonucu")
+		return false
+	}
+	return true
+}
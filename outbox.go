@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Events for other systems go through an outbox: they are stored in Redis in
+// the same transaction as the state change that caused them, and a relay
+// POSTs them from there, retrying with backoff until the receiver answers
+// 2xx. An event is lost neither when the server stops before sending it nor
+// when the receiver is down. Delivery is at least once, as a relay that
+// stopped mid-request leaves the event to the next one after OUTBOX_LEASE;
+// events carry an eventId receivers dedupe on. Events that failed
+// OUTBOX_MAX_ATTEMPTS times are set aside for /admin/outbox, where they can be
+// queued again.
+
+var (
+	outboxPollInterval = envDuration("OUTBOX_POLL_INTERVAL", time.Second)
+	outboxLease        = envDuration("OUTBOX_LEASE", 30*time.Second)
+	outboxMaxAttempts  = envInt("OUTBOX_MAX_ATTEMPTS", 10)
+	outboxMaxBackoff   = envDuration("OUTBOX_MAX_BACKOFF", 10*time.Minute)
+)
+
+const outboxBatch = 20
+
+const (
+	// Queued events, ID -> JSON OutboxEvent
+	outboxKey = "websocket:outbox"
+	// When queued events are due, ID -> unix milliseconds
+	outboxDueKey = "websocket:outbox:due"
+	// Events given up on, ID -> JSON OutboxEvent
+	outboxDeadKey = "websocket:outbox:dead"
+)
+
+// Outbox deliveries, per "delivered", "retried" and "dead"
+var outboxDeliveries = expvar.NewMap("outbox_deliveries")
+
+// OutboxEvent is an event waiting to be POSTed, signed with Secret like a
+// slash command request
+type OutboxEvent struct {
+	ID        string          `json:"id"`
+	URL       string          `json:"url"`
+	Secret    string          `json:"secret,omitempty"`
+	Body      json.RawMessage `json:"body"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"lastError,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// queueOutboxEvent adds an event to the outbox within pipe, the transaction
+// that stores the state change causing it
+func queueOutboxEvent(ctx context.Context, pipe redis.Pipeliner, event OutboxEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	pipe.HSet(ctx, outboxKey, event.ID, data)
+	pipe.ZAdd(ctx, outboxDueKey, &redis.Z{Score: float64(time.Now().UnixMilli()), Member: event.ID})
+	return nil
+}
+
+// runOutboxRelay delivers the due events of the outbox
+func (h *Hub) runOutboxRelay() {
+	if h.redis == nil {
+		return
+	}
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if h.standbyPassive.Load() {
+			continue // the primary delivers them
+		}
+		ctx := context.Background()
+		due, err := h.redis.ZRangeByScore(ctx, outboxDueKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+			Count: outboxBatch,
+		}).Result()
+		if err != nil {
+			log.Printf("Giden kutusu okunamadı: %v", err)
+			continue
+		}
+		for _, id := range due {
+			if h.claimOutboxEvent(ctx, id) {
+				h.deliverOutboxEvent(ctx, id)
+			}
+		}
+	}
+}
+
+// claimOutboxEvent moves a due event OUTBOX_LEASE ahead so no other relay
+// takes it meanwhile; false when another one was first
+func (h *Hub) claimOutboxEvent(ctx context.Context, id string) bool {
+	claimed := false
+	err := h.redis.Watch(ctx, func(tx *redis.Tx) error {
+		score, err := tx.ZScore(ctx, outboxDueKey, id).Result()
+		if err != nil || int64(score) > time.Now().UnixMilli() {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZAdd(ctx, outboxDueKey, &redis.Z{Score: float64(time.Now().Add(outboxLease).UnixMilli()), Member: id})
+			return nil
+		})
+		claimed = err == nil
+		return err
+	}, outboxDueKey)
+	return claimed && err == nil
+}
+
+// deliverOutboxEvent POSTs a claimed event and removes it, or schedules the
+// next attempt
+func (h *Hub) deliverOutboxEvent(ctx context.Context, id string) {
+	data, err := h.redis.HGet(ctx, outboxKey, id).Bytes()
+	var event OutboxEvent
+	if err == nil {
+		err = json.Unmarshal(data, &event)
+	}
+	if err != nil {
+		h.redis.ZRem(ctx, outboxDueKey, id) // delivered meanwhile
+		return
+	}
+
+	resp, err := postSigned(event.URL, event.Secret, event.Body, time.Now())
+	if err == nil {
+		resp.Body.Close()
+		pipe := h.redis.TxPipeline()
+		pipe.HDel(ctx, outboxKey, id)
+		pipe.ZRem(ctx, outboxDueKey, id)
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("Giden kutusundan silinemedi (%s): %v", id, err)
+		}
+		outboxDeliveries.Add("delivered", 1)
+		return
+	}
+
+	event.Attempts++
+	event.LastError = err.Error()
+	data, _ = json.Marshal(event)
+	pipe := h.redis.TxPipeline()
+	if event.Attempts >= outboxMaxAttempts {
+		pipe.HDel(ctx, outboxKey, id)
+		pipe.ZRem(ctx, outboxDueKey, id)
+		pipe.HSet(ctx, outboxDeadKey, id, data)
+		outboxDeliveries.Add("dead", 1)
+		log.Printf("Olay %d denemede teslim edilemedi, bırakıldı: %s (%s): %v", event.Attempts, id, event.URL, err)
+	} else {
+		next := time.Now().Add(outboxBackoff(event.Attempts))
+		pipe.HSet(ctx, outboxKey, id, data)
+		pipe.ZAdd(ctx, outboxDueKey, &redis.Z{Score: float64(next.UnixMilli()), Member: id})
+		outboxDeliveries.Add("retried", 1)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Giden kutusu güncellenemedi (%s): %v", id, err)
+	}
+}
+
+// outboxBackoff doubles the wait after every failed attempt, from one second
+// up to OUTBOX_MAX_BACKOFF
+func outboxBackoff(attempts int) time.Duration {
+	wait := time.Duration(math.Pow(2, float64(attempts-1))) * time.Second
+	if wait > outboxMaxBackoff || wait <= 0 {
+		return outboxMaxBackoff
+	}
+	return wait
+}
+
+// handleAdminOutbox serves /admin/outbox: GET counts the queued events and
+// lists those given up on, POST {"id":"..."} queues one of them again
+func handleAdminOutbox(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if hub.redis == nil {
+		http.Error(w, "The outbox requires Redis", http.StatusServiceUnavailable)
+		return
+	}
+	ctx := context.Background()
+	switch r.Method {
+	case "GET":
+		queued, err := hub.redis.HLen(ctx, outboxKey).Result()
+		stored, err2 := hub.redis.HVals(ctx, outboxDeadKey).Result()
+		if err != nil || err2 != nil {
+			http.Error(w, "Error reading the outbox", http.StatusInternalServerError)
+			return
+		}
+		dead := make([]OutboxEvent, 0, len(stored))
+		for _, raw := range stored {
+			var event OutboxEvent
+			if json.Unmarshal([]byte(raw), &event) == nil {
+				event.Secret = ""
+				dead = append(dead, event)
+			}
+		}
+		sort.Slice(dead, func(i, j int) bool { return dead[i].ID < dead[j].ID })
+		writeJSON(w, http.StatusOK, map[string]interface{}{"queued": queued, "dead": dead})
+	case "POST":
+		var req struct {
+			ID string `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		data, err := hub.redis.HGet(ctx, outboxDeadKey, req.ID).Bytes()
+		var event OutboxEvent
+		if err == nil {
+			err = json.Unmarshal(data, &event)
+		}
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		event.Attempts, event.LastError = 0, ""
+		data, _ = json.Marshal(event)
+		pipe := hub.redis.TxPipeline()
+		pipe.HDel(ctx, outboxDeadKey, req.ID)
+		pipe.HSet(ctx, outboxKey, req.ID, data)
+		pipe.ZAdd(ctx, outboxDueKey, &redis.Z{Score: float64(time.Now().UnixMilli()), Member: req.ID})
+		if _, err := pipe.Exec(ctx); err != nil {
+			http.Error(w, "Error queuing the event", http.StatusInternalServerError)
+			return
+		}
+		admin, _ := adminFromRequest(r)
+		hub.recordAudit("outbox_requeued", admin, "", fmt.Sprintf("%s %s", req.ID, event.URL))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Channels keep a list of pinned messages clients show in a bar above the
+// chat. Moderators pin and unpin with pin/unpin frames, reaction triggers pin
+// too (see triggers.go). Joining clients get the channel's pins in a pins frame.
+
+// Most messages pinned in a channel at once
+var maxPins = envInt("PINS_MAX", 50)
+
+// PinRequest is the inbound {"type":"pin"|"unpin","channel":"...","messageId":"..."} frame
+type PinRequest struct {
+	Type      string `json:"type"`
+	Channel   string `json:"channel"`
+	MessageID string `json:"messageId"`
+}
+
+// PinFrame is broadcast when a message gets pinned or unpinned
+type PinFrame struct {
+	Type      string    `json:"type"` // "pin" or "unpin"
+	Channel   string    `json:"channel"`
+	MessageID string    `json:"messageId"`
+	Username  string    `json:"username"` // who pinned or unpinned it
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// PinnedMessage is an entry of a channel's pins
+type PinnedMessage struct {
+	Message  Message   `json:"message"`
+	PinnedBy string    `json:"pinnedBy"`
+	PinnedAt time.Time `json:"pinnedAt"`
+}
+
+// PinsFrame lists a channel's pins, sent on join
+type PinsFrame struct {
+	Type      string          `json:"type"`
+	Channel   string          `json:"channel"`
+	Pins      []PinnedMessage `json:"pins"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// Pinned message IDs of a channel, as JSON pin values
+func pinsKey(channel string) string {
+	return "websocket:pins:" + channel
+}
+
+type pin struct {
+	PinnedBy string    `json:"pinnedBy"`
+	PinnedAt time.Time `json:"pinnedAt"`
+}
+
+// handlePinRequest pins or unpins a message for a moderator of its channel
+func (h *Hub) handlePinRequest(c *Client, raw []byte) {
+	var req PinRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.Channel == "" || !isULID(req.MessageID) {
+		c.sendError(ErrInvalidPin, "Geçersiz sabitleme isteği")
+		return
+	}
+	if !c.inChannel(req.Channel) || !h.isModerator(req.Channel, c.Username) {
+		c.sendError(ErrNotModerator, "Mesajları yalnızca kanal moderatörleri sabitleyebilir")
+		return
+	}
+	ctx := context.Background()
+	if req.Type == "unpin" {
+		removed, err := h.redis.HDel(ctx, pinsKey(req.Channel), req.MessageID).Result()
+		if err != nil || removed == 0 {
+			c.sendError(ErrInvalidPin, "Mesaj sabitlenmemiş")
+			return
+		}
+		h.recordAudit("message_unpinned", c.Username, req.Channel, req.MessageID)
+		h.sendPinFrame("unpin", req.Channel, req.MessageID, c.Username, time.Now())
+		return
+	}
+	msg, ok := h.storedMessage(ctx, req.Channel, req.MessageID)
+	if !ok {
+		c.sendError(ErrInvalidPin, "Mesaj bulunamadı")
+		return
+	}
+	if count, _ := h.redis.HLen(ctx, pinsKey(req.Channel)).Result(); count >= int64(maxPins) {
+		c.sendError(ErrInvalidPin, "Bu kanalda çok fazla sabitlenmiş mesaj var")
+		return
+	}
+	if err := h.pinMessage(msg, c.Username); err != nil {
+		log.Printf("Mesaj sabitlenemedi: %v", err)
+		return
+	}
+	h.recordAudit("message_pinned", c.Username, req.Channel, req.MessageID)
+}
+
+// pinMessage adds a message to its channel's pins and tells the channel
+func (h *Hub) pinMessage(msg Message, username string) error {
+	now := time.Now()
+	data, err := json.Marshal(pin{PinnedBy: username, PinnedAt: now})
+	if err != nil {
+		return err
+	}
+	if err := h.redis.HSet(context.Background(), pinsKey(msg.Channel), msg.MessageID, data).Err(); err != nil {
+		return err
+	}
+	h.sendPinFrame("pin", msg.Channel, msg.MessageID, username, now)
+	return nil
+}
+
+func (h *Hub) sendPinFrame(kind, channel, messageID, username string, at time.Time) {
+	frame, _ := encodeFrame(PinFrame{
+		Type:      kind,
+		Channel:   channel,
+		MessageID: messageID,
+		Username:  username,
+		Timestamp: at,
+	})
+	h.sendToChannel(channel, frame, PriorityNormal, nil)
+}
+
+// channelPins returns the pinned messages of a channel, latest pin first. Pins
+// of messages no longer stored are dropped.
+func (h *Hub) channelPins(channel string) ([]PinnedMessage, error) {
+	result := []PinnedMessage{}
+	if h.redis == nil {
+		return result, nil
+	}
+	ctx := context.Background()
+	pins, err := h.redis.HGetAll(ctx, pinsKey(channel)).Result()
+	if err != nil {
+		return nil, err
+	}
+	for id, raw := range pins {
+		var p pin
+		if json.Unmarshal([]byte(raw), &p) != nil {
+			continue
+		}
+		msg, ok := h.storedMessage(ctx, channel, id)
+		if !ok {
+			h.redis.HDel(ctx, pinsKey(channel), id)
+			continue
+		}
+		result = append(result, PinnedMessage{Message: msg, PinnedBy: p.PinnedBy, PinnedAt: p.PinnedAt})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].PinnedAt.After(result[j].PinnedAt) })
+	return result, nil
+}
+
+// sendPins sends a channel's pins to a client that joined it
+func (h *Hub) sendPins(c *Client, channel string) {
+	pins, err := h.channelPins(channel)
+	if err != nil {
+		log.Printf("Sabitlenmiş mesajlar okunamadı: %v", err)
+		return
+	}
+	c.sendFrame(PinsFrame{Type: "pins", Channel: channel, Pins: pins, Timestamp: time.Now()}, PriorityNormal)
+}
+
+// handleChannelPins serves GET /api/channels/{channel}/pins
+func handleChannelPins(hub *Hub, channel string, w http.ResponseWriter, r *http.Request) {
+	pins, err := hub.channelPins(channel)
+	if err != nil {
+		http.Error(w, "Error reading pins", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, pins)
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Inbound chat messages pass an ordered list of stages. Connection stages run
+// in the sender's read pump and end with the hand-over to the hub; hub stages
+// run in the hub goroutine for messages from connections and from the upload
+// handler alike. A stage returns false to stop the message.
+//
+// MESSAGE_PIPELINE reorders or drops optional stages (order applies within each
+// side), MESSAGE_PIPELINE_DISABLE turns stages off per channel
+// ("ephemeral:persist,bots:captcha"). Required stages always run. Custom stages
+// are added with registerMessageStage from an init function.
+
+// pipelineMessage is a chat message on its way through the stages
+type pipelineMessage struct {
+	Msg         Message
+	Raw         []byte  // frame as the client sent it, nil on the hub side
+	Client      *Client // sending connection, nil for server generated messages
+	ClientMsgID string  // idempotency key, only travels back in the ack
+
+	in        inboundMessage // hub side: the hand-over, for acknowledgements
+	persisted bool
+}
+
+// messageStage is one step of the pipeline
+type messageStage struct {
+	Name     string
+	Hub      bool // runs in the hub after the hand-over
+	Required bool // can't be reordered away or disabled per channel
+	Run      func(h *Hub, m *pipelineMessage) bool
+}
+
+var messageStages = []messageStage{
+	{Name: "validate", Required: true, Run: validateStage},
+	{Name: "members", Required: true, Run: membersStage},
+	{Name: "maintenance", Run: maintenanceStage},
+	{Name: "guests", Run: guestsStage},
+	{Name: "captcha", Run: captchaStage},
+	{Name: "commands", Required: true, Run: commandsStage},
+	{Name: "slash", Run: slashStage},
+	{Name: "markdown", Required: true, Run: markdownStage},
+	{Name: "segments", Required: true, Run: segmentsStage},
+	{Name: "content", Run: contentModeStage},
+	{Name: "polls", Required: true, Run: pollStage},
+	{Name: "numerology", Required: true, Run: numerologyStage},
+	{Name: "links", Run: linksStage},
+	{Name: "mentions", Run: mentionsStage},
+	{Name: "replies", Run: repliesStage},
+	{Name: "expiry", Run: expiryStage},
+	{Name: "dedupe", Hub: true, Run: dedupeStage},
+	{Name: "review", Hub: true, Run: reviewStage},
+	{Name: "persist", Hub: true, Run: persistStage},
+	{Name: "fanout", Hub: true, Required: true, Run: fanoutStage},
+	{Name: "notify", Hub: true, Run: notifyStage},
+	{Name: "previews", Hub: true, Run: previewsStage},
+	{Name: "uploads", Hub: true, Required: true, Run: uploadsStage},
+}
+
+// registerMessageStage inserts a custom stage after the named one, or at the
+// end of its side when after is empty or unknown. Call it from init.
+func registerMessageStage(stage messageStage, after string) {
+	for i, existing := range messageStages {
+		if existing.Name == after {
+			messageStages = append(messageStages[:i+1], append([]messageStage{stage}, messageStages[i+1:]...)...)
+			return
+		}
+	}
+	messageStages = append(messageStages, stage)
+}
+
+var (
+	pipelineOnce      sync.Once
+	activeStages      []messageStage
+	disabledByChannel map[string]map[string]bool // channel -> stage names
+)
+
+// stages returns the configured pipeline, built on first use
+func stages() []messageStage {
+	pipelineOnce.Do(func() {
+		activeStages = configureStages(messageStages, envList("MESSAGE_PIPELINE"))
+		disabledByChannel = make(map[string]map[string]bool)
+		for _, item := range envList("MESSAGE_PIPELINE_DISABLE") {
+			channel, stage, ok := strings.Cut(item, ":")
+			if !ok {
+				log.Printf("MESSAGE_PIPELINE_DISABLE geçersiz: %q", item)
+				continue
+			}
+			if disabledByChannel[channel] == nil {
+				disabledByChannel[channel] = make(map[string]bool)
+			}
+			disabledByChannel[channel][stage] = true
+		}
+	})
+	return activeStages
+}
+
+// configureStages orders the stages by the configured names. Required stages
+// missing from the list keep their default position relative to the others.
+func configureStages(all []messageStage, order []string) []messageStage {
+	if len(order) == 0 {
+		return all
+	}
+	byName := make(map[string]messageStage, len(all))
+	for _, stage := range all {
+		byName[stage.Name] = stage
+	}
+	listed := make(map[string]bool, len(order))
+	var configured []messageStage
+	for _, name := range order {
+		stage, ok := byName[name]
+		if !ok {
+			log.Printf("MESSAGE_PIPELINE: bilinmeyen aşama %q", name)
+			continue
+		}
+		if !listed[name] {
+			listed[name] = true
+			configured = append(configured, stage)
+		}
+	}
+	for i, stage := range all {
+		if !stage.Required || listed[stage.Name] {
+			continue
+		}
+		// Insert after the stage that precedes it by default
+		pos := 0
+		for j := i - 1; j >= 0; j-- {
+			if k := stageIndex(configured, all[j].Name); k >= 0 {
+				pos = k + 1
+				break
+			}
+		}
+		configured = append(configured[:pos], append([]messageStage{stage}, configured[pos:]...)...)
+	}
+	return configured
+}
+
+func stageIndex(list []messageStage, name string) int {
+	for i, stage := range list {
+		if stage.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// stageEnabled reports whether a stage runs for a channel
+func stageEnabled(stage messageStage, channel string) bool {
+	return stage.Required || !disabledByChannel[channel][stage.Name]
+}
+
+// runStages runs the stages of one side; false when a stage stopped the message
+func (h *Hub) runStages(m *pipelineMessage, hubSide bool) bool {
+	for _, stage := range stages() {
+		if stage.Hub != hubSide || !stageEnabled(stage, m.Msg.Channel) {
+			continue
+		}
+		if !stage.Run(h, m) {
+			traces.record(m.Msg.TraceID, TraceStopped, stage.Name)
+			return false
+		}
+	}
+	return true
+}
+
+// processMessage runs the connection stages for a client's message and hands
+// it to the hub
+func (h *Hub) processMessage(c *Client, msg Message, raw []byte) {
+	m := &pipelineMessage{Msg: msg, Raw: raw, Client: c}
+	if msg.Type != "seen" {
+		traces.start(msg)
+		traces.record(msg.TraceID, TraceReceived, msg.Type)
+	}
+	if !h.runStages(m, false) {
+		return
+	}
+	log.Printf("Gelen mesaj: %s, Tip: %s, Kullanıcı: %s, Kanal: %s, İz: %s", m.Msg.Message, m.Msg.Type, m.Msg.Username, m.Msg.Channel, m.Msg.TraceID)
+	data, err := json.Marshal(m.Msg)
+	if err != nil {
+		log.Printf("Mesaj JSON encode hatası: %v", err)
+		return
+	}
+	c.submit(h, m.Msg, data, m.ClientMsgID)
+}
+
+// Connection stages
+
+// validateStage strips fields only the server may set
+func validateStage(h *Hub, m *pipelineMessage) bool {
+	// Attachments are only announced by the upload handler
+	m.Msg.FileID = ""
+	m.Msg.Edited, m.Msg.EditedAt = false, nil
+	m.Msg.Mentions = nil
+	m.Msg.DeliveredTo, m.Msg.SeenBy = nil, nil
+	m.Msg.Attachments, m.Msg.Bot = nil, "" // only bots post them, see commands.go
+	m.Msg.ForwardedFrom = nil
+	m.Msg.ThreadReplies = 0
+	m.Msg.ExpiresAt = nil
+	m.Msg.Integrity = ""
+	m.Msg.Highlight = false
+	m.Msg.Pending = false
+	m.Msg.Previews = nil
+	m.Msg.Guest = isGuestName(m.Msg.Username)
+	if m.Msg.Type == "system" {
+		m.Msg.Type = "text" // system notices only come from the server
+	}
+	if utf8.RuneCountInString(m.Msg.Message) > maxMessageLength {
+		m.rejectLimit(ErrMessageTooLong, fmt.Sprintf("Mesaj çok uzun (en fazla %d karakter)", maxMessageLength), maxMessageLength)
+		return false
+	}
+
+	// The idempotency key only travels back in the ack
+	m.ClientMsgID = m.Msg.ClientMsgID
+	m.Msg.ClientMsgID = ""
+	if len(m.ClientMsgID) > clientMsgIDMaxLen {
+		m.ClientMsgID = ""
+	}
+	return true
+}
+
+// maintenanceStage rejects new messages and drops receipts in read-only mode
+func maintenanceStage(h *Hub, m *pipelineMessage) bool {
+	state := currentMaintenance()
+	if !state.Enabled {
+		return true
+	}
+	if m.Msg.Type != "seen" {
+		m.reject(ErrMaintenance, state.Message)
+	}
+	return false
+}
+
+// captchaStage gates the first message from a new IP
+func captchaStage(h *Hub, m *pipelineMessage) bool {
+	c := m.Client
+	if m.Msg.Type == "seen" || c.captchaOK {
+		return true
+	}
+	if !h.captchaVerified(c.IP) {
+		c.sendFrame(ErrorFrame{
+			Type:        "error",
+			Code:        ErrCaptchaRequired,
+			Reason:      "Mesaj göndermeden önce doğrulama gerekli",
+			Provider:    captchaProvider,
+			SiteKey:     captchaSiteKey,
+			TraceID:     m.Msg.TraceID,
+			ClientMsgID: m.ClientMsgID,
+		}, PriorityHigh)
+		return false
+	}
+	c.captchaOK = true
+	return true
+}
+
+// commandsStage handles frames that act on existing messages or the sender
+// instead of being sent
+func commandsStage(h *Hub, m *pipelineMessage) bool {
+	switch m.Msg.Type {
+	case "reaction":
+		h.handleReaction(m.Client, m.Raw)
+	case "edit":
+		go h.handleEdit(m.Client, m.Raw)
+	case "public_key":
+		go h.handleKeyAnnouncement(m.Client, m.Raw)
+	case "interaction":
+		go h.handleInteraction(m.Client, m.Raw)
+	case "pin", "unpin":
+		go h.handlePinRequest(m.Client, m.Raw)
+	case "vote":
+		go h.handleVote(m.Client, m.Raw)
+	default:
+		return true
+	}
+	return false
+}
+
+// linksStage shortens long URLs in text messages; segments derived before are
+// derived again from the shortened text, as an edit does
+func linksStage(h *Hub, m *pipelineMessage) bool {
+	if m.Msg.Type != "text" {
+		return true
+	}
+	shortened := h.shortenLinks(m.Msg.Message, m.Msg.Username, m.Msg.Channel)
+	if shortened != m.Msg.Message && len(m.Msg.Segments) > 0 {
+		m.Msg.Segments = parseSegments(shortened)
+	}
+	m.Msg.Message = shortened
+	return true
+}
+
+// mentionsStage records who a text message mentions
+func mentionsStage(h *Hub, m *pipelineMessage) bool {
+	if m.Msg.Type == "text" {
+		m.Msg.Mentions = h.mentionedUsers(m.Msg.Message)
+	}
+	return true
+}
+
+// repliesStage replaces the quote of a reply with the stored original and puts
+// the reply into the original's thread, or the thread the client named
+func repliesStage(h *Hub, m *pipelineMessage) bool {
+	m.Msg.ReplyTo = h.resolveReply(m.Msg.Channel, m.Msg.ReplyTo)
+	if m.Msg.ReplyTo != nil {
+		m.Msg.ThreadID = h.threadRoot(m.Msg.Channel, m.Msg.ReplyTo.MessageID)
+	} else if m.Msg.ThreadID != "" {
+		m.Msg.ThreadID = h.threadRoot(m.Msg.Channel, m.Msg.ThreadID)
+	}
+	return true
+}
+
+// Hub stages
+
+// dedupeStage drops resends of a message carrying the same clientMsgId
+func dedupeStage(h *Hub, m *pipelineMessage) bool {
+	original, fresh := h.claimClientMsgID(m.Msg.Username, m.in.clientMsgID, m.Msg.MessageID)
+	if !fresh {
+		log.Printf("Tekrarlanan mesaj atlandı: kullanıcı=%s, clientMsgId=%s, iz=%s", m.Msg.Username, m.in.clientMsgID, m.Msg.TraceID)
+		traces.record(m.Msg.TraceID, TraceDuplicate, original)
+		h.acknowledge(m.in, m.Msg.Channel, original, "duplicate", true)
+		return false
+	}
+	return true
+}
+
+// persistStage numbers the message and stores it in the channel history
+func persistStage(h *Hub, m *pipelineMessage) bool {
+	if m.Msg.Message != "__GET_RECENT_MESSAGES__" {
+		m.Msg.Seq = h.nextSequence(m.Msg.Channel)
+		m.Msg.Integrity = messageIntegrity(m.Msg)
+		m.persisted = h.storeMessage(m.Msg)
+		h.queueArchive(m.Msg)
+		if m.persisted {
+			traces.record(m.Msg.TraceID, TracePersisted, fmt.Sprintf("seq %d", m.Msg.Seq))
+		} else {
+			traces.record(m.Msg.TraceID, TraceNotStored, "")
+		}
+	}
+	return true
+}
+
+// fanoutStage delivers the message to the channel's clients and acknowledges
+// it; clients that can't keep up are closed
+func fanoutStage(h *Hub, m *pipelineMessage) bool {
+	frame, err := encodeFrame(m.Msg)
+	if err != nil {
+		log.Printf("Mesaj JSON encode hatası: %v", err)
+		return false
+	}
+	queued := h.sendMessageToChannel(m.Msg, frame, PriorityNormal)
+	traces.record(m.Msg.TraceID, TraceFanout, fmt.Sprintf("%d connections", queued))
+	h.acknowledge(m.in, m.Msg.Channel, m.Msg.MessageID, "accepted", m.persisted)
+	return true
+}
+
+// notifyStage notifies mentioned users
+func notifyStage(h *Hub, m *pipelineMessage) bool {
+	if len(m.Msg.Mentions) > 0 {
+		go h.notifyMentions(m.Msg)
+	}
+	return true
+}
+
+// uploadsStage processes an upload once its message reached the clients
+func uploadsStage(h *Hub, m *pipelineMessage) bool {
+	if m.Msg.FileID != "" {
+		h.startUploadProcessing(m.Msg.FileID)
+	}
+	return true
+}
+
+// PipelineStage describes a stage in the /admin/pipeline response
+type PipelineStage struct {
+	Name       string   `json:"name"`
+	Side       string   `json:"side"` // "connection" or "hub"
+	Required   bool     `json:"required,omitempty"`
+	DisabledIn []string `json:"disabledIn,omitempty"` // channels
+}
+
+// handleAdminPipeline serves GET /admin/pipeline with the configured stages in order
+func handleAdminPipeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var result []PipelineStage
+	for _, stage := range stages() {
+		info := PipelineStage{Name: stage.Name, Side: "connection", Required: stage.Required}
+		if stage.Hub {
+			info.Side = "hub"
+		}
+		for channel, disabled := range disabledByChannel {
+			if disabled[stage.Name] && !stage.Required {
+				info.DisabledIn = append(info.DisabledIn, channel)
+			}
+		}
+		sort.Strings(info.DisabledIn)
+		result = append(result, info)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
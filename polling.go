@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Long-polling transport for networks that block WebSockets and streaming
+// responses alike. GET /poll opens a session and answers at once with its id;
+// GET /poll?session=<id>&cursor=C then waits up to POLL_TIMEOUT for frames and
+// returns them with the next cursor. The cursor holds the channels' sequence
+// numbers, "<channel>:<seq>" pairs separated by commas with the channel query
+// escaped, like the since of a resume frame: chat messages are kept until a
+// cursor covers them, so an answer lost on the way is sent again, while other
+// frames are sent once, as a dropped WebSocket would lose them. A session
+// opened with ?cursor=C replays what the channels got after it from the resume
+// log once the user connected, so a client falling back from /ws, or whose
+// session expired, continues where it left off. POST /send?session=<id> takes
+// client frames, one per line. Sessions share the hub with /ws.
+var (
+	longPollingEnabled = envBool("LONG_POLLING", true)
+	pollTimeout        = envDuration("POLL_TIMEOUT", 25*time.Second)
+	// Sessions not polled for this long are closed
+	pollSessionTimeout = envDuration("POLL_SESSION_TIMEOUT", 60*time.Second)
+	// Sessions with more unacknowledged frames are closed, like a full send buffer
+	pollMaxBuffered = envInt("POLL_MAX_BUFFERED", 1000)
+)
+
+const (
+	// Largest POST /send body; single frames are held to the connection's read limit
+	pollMaxSendBytes = 1 << 20
+	// Most channels a cursor may name
+	pollMaxCursorChannels = 100
+)
+
+var (
+	pollSessions      = make(map[string]*pollConn)
+	pollSessionsMutex sync.Mutex
+)
+
+var errPollOverflow = errors.New("poll buffer full")
+
+// PollResponse answers GET /poll
+type PollResponse struct {
+	Session string            `json:"session"`
+	Cursor  string            `json:"cursor"` // sequence numbers of the messages received, the next poll's cursor
+	Frames  []json.RawMessage `json:"frames"`
+	Closed  bool              `json:"closed,omitempty"` // the server ended the session after these frames
+}
+
+// pollCursor is the last sequence number a client received per channel
+type pollCursor map[string]int64
+
+// parsePollCursor reads a cursor; "" is the empty cursor
+func parsePollCursor(raw string) (pollCursor, bool) {
+	cursor := make(pollCursor)
+	if raw == "" {
+		return cursor, true
+	}
+	pairs := strings.Split(raw, ",")
+	if len(pairs) > pollMaxCursorChannels {
+		return nil, false
+	}
+	for _, pair := range pairs {
+		i := strings.LastIndexByte(pair, ':')
+		if i < 0 {
+			return nil, false
+		}
+		channel, err := url.QueryUnescape(pair[:i])
+		if err != nil || channel == "" {
+			return nil, false
+		}
+		seq, err := strconv.ParseInt(pair[i+1:], 10, 64)
+		if err != nil || seq < 0 {
+			return nil, false
+		}
+		cursor[channel] = seq
+	}
+	return cursor, true
+}
+
+func (p pollCursor) String() string {
+	pairs := make([]string, 0, len(p))
+	for channel, seq := range p {
+		pairs = append(pairs, url.QueryEscape(channel)+":"+strconv.FormatInt(seq, 10))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// pollFrame is a frame waiting for a poll; chat messages carry the channel and
+// sequence number a cursor acknowledges them by
+type pollFrame struct {
+	data    []byte
+	channel string
+	seq     int64
+	sent    bool
+}
+
+// newPollFrame copies a frame, which is shared with other connections
+func newPollFrame(frame []byte) pollFrame {
+	f := pollFrame{data: append([]byte(nil), frame...)}
+	if !bytes.HasPrefix(frame, messageFramePrefix) && !bytes.HasPrefix(frame, mediaStubFramePrefix) {
+		return f
+	}
+	var env struct {
+		Payload struct {
+			Channel string `json:"channel"`
+			Seq     int64  `json:"seq"`
+		} `json:"payload"`
+	}
+	if json.Unmarshal(frame, &env) == nil {
+		f.channel, f.seq = env.Payload.Channel, env.Payload.Seq
+	}
+	return f
+}
+
+// acked reports whether a frame that was sent needs no second delivery
+func (f pollFrame) acked(cursor pollCursor) bool {
+	return f.sent && (f.seq == 0 || f.seq <= cursor[f.channel])
+}
+
+// pollConn buffers a session's frames until the client polls for them and
+// hands its sent frames to the read pump. Liveness is the polling itself, so
+// pings and read deadlines are no-ops.
+type pollConn struct {
+	id    string
+	inbox chan []byte
+	ready chan struct{}
+	done  chan struct{}
+	limit int64
+
+	mu       sync.Mutex
+	frames   []pollFrame // not yet sent or not yet acknowledged
+	cursor   pollCursor  // what the client received, grows with every answer
+	lastPoll time.Time
+	closed   bool
+}
+
+func newPollConn(id string, cursor pollCursor) *pollConn {
+	return &pollConn{
+		id:       id,
+		inbox:    make(chan []byte, 64),
+		ready:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		cursor:   cursor,
+		lastPoll: time.Now(),
+	}
+}
+
+func (c *pollConn) ReadMessage() (int, []byte, error) {
+	select {
+	case frame := <-c.inbox:
+		if c.limit > 0 && int64(len(frame)) > c.limit {
+			return 0, nil, errFrameTooLarge
+		}
+		return websocket.TextMessage, frame, nil
+	case <-c.done:
+		return 0, nil, io.EOF
+	}
+}
+
+func (c *pollConn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case websocket.CloseMessage:
+		return c.Close()
+	case websocket.PingMessage, websocket.PongMessage:
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	// Batches are newline separated
+	for _, frame := range bytes.Split(data, []byte{'\n'}) {
+		if len(bytes.TrimSpace(frame)) == 0 {
+			continue
+		}
+		c.frames = append(c.frames, newPollFrame(frame))
+	}
+	if len(c.frames) > pollMaxBuffered {
+		return errPollOverflow
+	}
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (c *pollConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	return &pollWriter{conn: c, messageType: messageType}, nil
+}
+
+func (c *pollConn) SetReadLimit(limit int64)          { c.limit = limit }
+func (c *pollConn) SetReadDeadline(time.Time) error   { return nil }
+func (c *pollConn) SetWriteDeadline(time.Time) error  { return nil }
+func (c *pollConn) SetPongHandler(func(string) error) {}
+
+// Close ends the session; frames already buffered can still be polled until
+// the session expires
+func (c *pollConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+	return nil
+}
+
+// pending drops the frames the cursor acknowledges and returns the rest with
+// the cursor that covers them
+func (c *pollConn) pending(cursor pollCursor) ([]json.RawMessage, string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastPoll = time.Now()
+	kept := c.frames[:0]
+	for _, frame := range c.frames {
+		if !frame.acked(cursor) {
+			kept = append(kept, frame)
+		}
+	}
+	clear(c.frames[len(kept):])
+	c.frames = kept
+
+	frames := make([]json.RawMessage, len(c.frames))
+	for i := range c.frames {
+		frame := &c.frames[i]
+		frame.sent = true
+		frames[i] = frame.data
+		if frame.seq > c.cursor[frame.channel] {
+			c.cursor[frame.channel] = frame.seq
+		}
+	}
+	for channel, seq := range cursor {
+		if seq > c.cursor[channel] {
+			c.cursor[channel] = seq
+		}
+	}
+	return frames, c.cursor.String(), c.closed
+}
+
+// poll waits up to wait for frames the cursor doesn't acknowledge
+func (c *pollConn) poll(r *http.Request, cursor pollCursor, wait time.Duration) PollResponse {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		frames, next, closed := c.pending(cursor)
+		if len(frames) > 0 || closed || wait <= 0 {
+			return PollResponse{Session: c.id, Cursor: next, Frames: frames, Closed: closed}
+		}
+		select {
+		case <-c.ready:
+		case <-c.done:
+		case <-r.Context().Done():
+			return PollResponse{Session: c.id, Cursor: next, Frames: frames}
+		case <-timer.C:
+			wait = 0
+		}
+	}
+}
+
+// pollWriter buffers one batch of frames for the session
+type pollWriter struct {
+	conn        *pollConn
+	messageType int
+	buf         bytes.Buffer
+}
+
+func (w *pollWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func (w *pollWriter) Close() error { return w.conn.WriteMessage(w.messageType, w.buf.Bytes()) }
+
+// pollSession returns the session a request names, nil when it is unknown
+func pollSession(r *http.Request) *pollConn {
+	pollSessionsMutex.Lock()
+	defer pollSessionsMutex.Unlock()
+	return pollSessions[r.URL.Query().Get("session")]
+}
+
+// handlePoll serves GET /poll
+func handlePoll(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if !longPollingEnabled {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Proxies of restrictive networks must not answer polls from their cache
+	w.Header().Set("Cache-Control", "no-store")
+	cursor, ok := parsePollCursor(r.URL.Query().Get("cursor"))
+	if !ok {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("session") == "" {
+		hub.openPollSession(w, r, cursor)
+		return
+	}
+	conn := pollSession(r)
+	if conn == nil {
+		http.Error(w, "Unknown session", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, conn.poll(r, cursor, pollTimeout))
+}
+
+// openPollSession attaches a new polling session to the hub, checked like a
+// WebSocket handshake. The channels of the cursor are resumed once the user
+// connected.
+func (h *Hub) openPollSession(w http.ResponseWriter, r *http.Request, cursor pollCursor) {
+	if h.checkIPReputation(clientIP(r)).Action == "block" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	account, ok := h.handshakeAccount(w, r)
+	if !ok {
+		return
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("Polling oturumu oluşturulamadı: %v", err)
+		http.Error(w, "Failed to open session", http.StatusInternalServerError)
+		return
+	}
+	conn := newPollConn(hex.EncodeToString(buf), cursor)
+	pollSessionsMutex.Lock()
+	pollSessions[conn.id] = conn
+	pollSessionsMutex.Unlock()
+
+	transportConnections.Add("polling", 1)
+	client := newClient(tempClientID(), conn, clientIP(r))
+	client.account = account
+	client.guestName = guestFromToken(r.URL.Query().Get("guest"))
+	client.latency = requestLatencyLabels(r)
+	if len(cursor) > 0 {
+		client.resumeFrom = maps.Clone(cursor)
+	}
+	if v, encoding, ok := requestedProtocol(r); ok {
+		client.negotiate(v, encoding)
+	}
+	h.register <- client
+	go client.writePump(h)
+	go client.readPump(h)
+
+	writeJSON(w, http.StatusOK, conn.poll(r, cursor, 0))
+}
+
+// handlePollSend serves POST /send
+func handlePollSend(w http.ResponseWriter, r *http.Request) {
+	if !longPollingEnabled {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	conn := pollSession(r)
+	if conn == nil {
+		http.Error(w, "Unknown session", http.StatusNotFound)
+		return
+	}
+	scanner := bufio.NewScanner(http.MaxBytesReader(w, r.Body, pollMaxSendBytes))
+	scanner.Buffer(make([]byte, 0, 4096), pollMaxSendBytes)
+	for scanner.Scan() {
+		frame := bytes.TrimSpace(scanner.Bytes())
+		if len(frame) == 0 {
+			continue
+		}
+		select {
+		case conn.inbox <- append([]byte(nil), frame...):
+		case <-conn.done:
+			http.Error(w, "Unknown session", http.StatusNotFound)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		http.Error(w, "Frames too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runPollReaper closes sessions their client stopped polling
+func runPollReaper() {
+	if !longPollingEnabled {
+		return
+	}
+	ticker := time.NewTicker(pollSessionTimeout / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		pollSessionsMutex.Lock()
+		for id, conn := range pollSessions {
+			conn.mu.Lock()
+			idle := time.Since(conn.lastPoll) > pollSessionTimeout
+			conn.mu.Unlock()
+			if idle {
+				conn.Close()
+				delete(pollSessions, id)
+			}
+		}
+		pollSessionsMutex.Unlock()
+	}
+}
+
+// serverTransports lists the transports a client can fall back to, preferred
+// first
+func serverTransports() []string {
+	var transports []string
+	if webTransportEnabled && webTransportCert != "" && webTransportKey != "" {
+		transports = append(transports, "webtransport")
+	}
+	transports = append(transports, "websocket")
+	if longPollingEnabled {
+		transports = append(transports, "polling")
+	}
+	return transports
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A poll is a message of type "poll" whose text is the question. Users vote
+// with vote frames naming option indexes; each user's latest vote is kept in
+// Redis and the channel gets the new tally after every vote.
+
+const (
+	pollMinOptions   = 2
+	pollMaxOptions   = 10
+	pollMaxOptionLen = 100
+)
+
+// Poll holds the options of a poll message. Counts and Voters are the tally,
+// filled in by the server.
+type Poll struct {
+	Options  []string `json:"options"`
+	Multiple bool     `json:"multiple,omitempty"` // voters may pick several options
+	Counts   []int    `json:"counts,omitempty"`   // votes per option
+	Voters   int      `json:"voters,omitempty"`
+}
+
+// VoteRequest is the inbound {"type":"vote","messageId":"...","options":[0]}
+// frame; an empty list takes the vote back
+type VoteRequest struct {
+	Type      string `json:"type"`
+	MessageID string `json:"messageId"`
+	Options   []int  `json:"options"`
+}
+
+// PollResultsFrame carries the tally of a poll after a vote
+type PollResultsFrame struct {
+	Type      string    `json:"type"`
+	Channel   string    `json:"channel"`
+	MessageID string    `json:"messageId"`
+	Counts    []int     `json:"counts"`
+	Voters    int       `json:"voters"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Votes of a poll by username, as comma separated option indexes
+func pollVotesKey(messageID string) string {
+	return "websocket:poll:votes:" + messageID
+}
+
+// pollStage checks the options of new polls; other messages can't carry one
+func pollStage(h *Hub, m *pipelineMessage) bool {
+	if m.Msg.Type != "poll" {
+		m.Msg.Poll = nil
+		return true
+	}
+	if m.Msg.Poll == nil || strings.TrimSpace(m.Msg.Message) == "" {
+		m.reject(ErrInvalidPoll, "Anket için soru ve seçenekler gerekli")
+		return false
+	}
+	options := make([]string, 0, len(m.Msg.Poll.Options))
+	seen := make(map[string]bool)
+	for _, option := range m.Msg.Poll.Options {
+		option = truncate(strings.TrimSpace(option), pollMaxOptionLen)
+		if option == "" || seen[option] {
+			continue
+		}
+		seen[option] = true
+		options = append(options, option)
+	}
+	if len(options) < pollMinOptions || len(options) > pollMaxOptions {
+		m.reject(ErrInvalidPoll, "Anketin 2 ile 10 arasında farklı seçeneği olmalı")
+		return false
+	}
+	m.Msg.Poll = &Poll{Options: options, Multiple: m.Msg.Poll.Multiple}
+	return true
+}
+
+// handleVote records a user's vote and broadcasts the poll's new tally
+func (h *Hub) handleVote(c *Client, raw []byte) {
+	var req VoteRequest
+	if err := json.Unmarshal(raw, &req); err != nil || !isULID(req.MessageID) {
+		c.sendError(ErrInvalidVote, "Geçersiz oy")
+		return
+	}
+	if h.redis == nil {
+		c.sendError(ErrInvalidVote, "Mesaj geçmişi olmadan oy verilemez")
+		return
+	}
+	ctx := context.Background()
+	channel, _, ok := h.locateMessage(ctx, req.MessageID)
+	if !ok || !c.inChannel(channel) {
+		c.sendError(ErrInvalidVote, "Anket bulunamadı")
+		return
+	}
+	msg, ok := h.storedMessage(ctx, channel, req.MessageID)
+	if !ok || msg.Type != "poll" || msg.Poll == nil {
+		c.sendError(ErrInvalidVote, "Anket bulunamadı")
+		return
+	}
+	if len(req.Options) > 1 && !msg.Poll.Multiple {
+		c.sendError(ErrInvalidVote, "Bu ankette yalnızca bir seçenek işaretlenebilir")
+		return
+	}
+	picked := make([]string, 0, len(req.Options))
+	chosen := make(map[int]bool)
+	for _, option := range req.Options {
+		if option < 0 || option >= len(msg.Poll.Options) {
+			c.sendError(ErrInvalidVote, "Geçersiz seçenek")
+			return
+		}
+		if !chosen[option] {
+			chosen[option] = true
+			picked = append(picked, strconv.Itoa(option))
+		}
+	}
+
+	key := pollVotesKey(req.MessageID)
+	pipe := h.redis.Pipeline()
+	if len(picked) == 0 {
+		pipe.HDel(ctx, key, c.Username)
+	} else {
+		pipe.HSet(ctx, key, c.Username, strings.Join(picked, ","))
+	}
+	pipe.Expire(ctx, key, 24*time.Hour)
+	tally := pipe.HGetAll(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Oy kaydetme hatası: %v", err)
+		return
+	}
+	h.invalidateSnapshot(channel)
+
+	counts, voters := countVotes(tally.Val(), len(msg.Poll.Options))
+	frame, _ := encodeFrame(PollResultsFrame{
+		Type:      "poll_results",
+		Channel:   channel,
+		MessageID: req.MessageID,
+		Counts:    counts,
+		Voters:    voters,
+		Timestamp: time.Now(),
+	})
+	h.sendToChannel(channel, frame, PriorityNormal, nil)
+}
+
+// countVotes tallies stored votes over n options
+func countVotes(votes map[string]string, n int) ([]int, int) {
+	counts := make([]int, n)
+	for _, vote := range votes {
+		for _, raw := range strings.Split(vote, ",") {
+			if option, err := strconv.Atoi(raw); err == nil && option >= 0 && option < n {
+				counts[option]++
+			}
+		}
+	}
+	return counts, len(votes)
+}
+
+// applyPolls fills the tally of poll messages from the stored votes
+func (h *Hub) applyPolls(messages []Message) {
+	ctx := context.Background()
+	for i := range messages {
+		poll := messages[i].Poll
+		if poll == nil || messages[i].MessageID == "" {
+			continue
+		}
+		votes, err := h.redis.HGetAll(ctx, pollVotesKey(messages[i].MessageID)).Result()
+		if err != nil {
+			continue
+		}
+		tallied := *poll
+		tallied.Counts, tallied.Voters = countVotes(votes, len(poll.Options))
+		messages[i].Poll = &tallied
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// A user who reconnects within this window doesn't generate leave/join messages
+var presenceGracePeriod = envDuration("PRESENCE_GRACE_PERIOD", 10*time.Second)
+
+// isOnline reports whether any connection other than except belongs to username
+func (h *Hub) isOnline(username string, except *Client) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for client := range h.clients {
+		if client != except && client.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+// markJoined is called when a named user connects. It returns false if the join
+// should not be announced: the user came back within the grace window or is
+// already connected from another tab.
+func (h *Hub) markJoined(c *Client) bool {
+	h.presenceMutex.Lock()
+	timer, pending := h.pendingLeaves[c.Username]
+	if pending {
+		timer.Stop()
+		delete(h.pendingLeaves, c.Username)
+	}
+	h.presenceMutex.Unlock()
+
+	if pending {
+		log.Printf("Kullanıcı bekleme süresi içinde geri döndü: %s", c.Username)
+		return false
+	}
+	return !h.isOnline(c.Username, c)
+}
+
+// scheduleLeave announces the disconnection of a named user after the grace
+// window, unless the user has reconnected by then
+func (h *Hub) scheduleLeave(client *Client) {
+	h.delayLeave(client.Username, client.ID)
+}
+
+// delayLeave announces the disconnection of a user after the grace window
+func (h *Hub) delayLeave(username, userID string) {
+	h.presenceMutex.Lock()
+	defer h.presenceMutex.Unlock()
+	if timer, ok := h.pendingLeaves[username]; ok {
+		timer.Stop()
+	}
+	h.pendingLeaves[username] = time.AfterFunc(presenceGracePeriod, func() {
+		h.presenceMutex.Lock()
+		delete(h.pendingLeaves, username)
+		h.presenceMutex.Unlock()
+
+		if h.isOnline(username, nil) {
+			return
+		}
+		log.Printf("Kullanıcı ayrılışı duyuruldu: %s", username)
+		h.setOffline(username)
+
+		disconnectionMsg := PresenceFrame{Type: "user_disconnected", Username: username, UserID: userID, Identity: userIdentity(username), Timestamp: time.Now()}
+		msgJSON, _ := encodeFrame(disconnectionMsg)
+		h.sendToAll(msgJSON, PriorityLow, nil)
+		h.broadcastRosterDiff([]RosterEntry{}, []RosterEntry{{Username: username, UserID: userID, Identity: userIdentity(username)}})
+	})
+}
+
+// Presence states of a user: online while any connection was active recently,
+// away while connected but idle, offline once the last connection is gone and
+// the grace window has passed. States are kept per username in the hub and
+// mirrored to Redis, so GET /api/presence sees the users of every instance.
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+var (
+	// Connections without user activity for this long count as away
+	presenceAwayAfter = envDuration("PRESENCE_AWAY_AFTER", 5*time.Minute)
+	// How often idle connections are checked and Redis entries refreshed
+	presenceRefreshInterval = envDuration("PRESENCE_REFRESH_INTERVAL", 30*time.Second)
+)
+
+// Offline users are listed this long after they left
+const presenceOfflineRetention = 24 * time.Hour
+
+// Presence states of all users, username -> JSON PresenceState
+const presenceKey = "websocket:presence"
+
+// PresenceState is the presence of one user. Channels are the channels the
+// user's connections follow; empty for legacy clients that get every channel.
+type PresenceState struct {
+	Username     string        `json:"username"`
+	Identity     Identity      `json:"identity"`
+	Status       string        `json:"status"`
+	Since        time.Time     `json:"since"`
+	Channels     []string      `json:"channels,omitempty"`
+	CustomStatus *CustomStatus `json:"customStatus,omitempty"`
+}
+
+// storedPresence is a PresenceState in Redis; Updated tells readers whether the
+// instance that wrote it is still refreshing it
+type storedPresence struct {
+	PresenceState
+	Updated time.Time `json:"updated"`
+}
+
+// PresenceRequest is the inbound {"type":"presence","status":"away"} frame,
+// sent e.g. when the browser tab is hidden or shown again
+type PresenceRequest struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+}
+
+// PresenceStateFrame announces a user's change to online, away or offline, or
+// of their custom status
+type PresenceStateFrame struct {
+	Type         string        `json:"type"`
+	Username     string        `json:"username"`
+	Identity     Identity      `json:"identity"`
+	Status       string        `json:"status"`
+	Since        time.Time     `json:"since"`
+	CustomStatus *CustomStatus `json:"customStatus,omitempty"` // see userstatus.go
+}
+
+// touch records user activity on the connection
+func (c *Client) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// activity records user activity, bringing an away user back online at once
+func (h *Hub) activity(c *Client) {
+	idle := !c.active(time.Now())
+	c.touch()
+	if idle {
+		h.updatePresence(c.Username)
+	}
+}
+
+// active reports whether the connection had user activity recently
+func (c *Client) active(now time.Time) bool {
+	return now.Sub(time.Unix(0, c.lastActive.Load())) < presenceAwayAfter
+}
+
+// currentPresence derives a user's status and channels from their connections
+func (h *Hub) currentPresence(username string) (string, []string) {
+	now := time.Now()
+	status := PresenceOffline
+	channels := make(map[string]bool)
+	legacy := false
+
+	h.mutex.RLock()
+	for client := range h.clients {
+		if client.Username != username {
+			continue
+		}
+		if client.active(now) {
+			status = PresenceOnline
+		} else if status == PresenceOffline {
+			status = PresenceAway
+		}
+		legacy = legacy || client.followsAll()
+		for _, channel := range client.subscriptions() {
+			// Who is in a conversation is only told to its members
+			if !isConversation(channel) {
+				channels[channel] = true
+			}
+		}
+	}
+	h.mutex.RUnlock()
+
+	if legacy {
+		return status, nil
+	}
+	list := make([]string, 0, len(channels))
+	for channel := range channels {
+		list = append(list, channel)
+	}
+	sort.Strings(list)
+	return status, list
+}
+
+// updatePresence recomputes a user's presence, announcing status changes and
+// mirroring the state to Redis. Offline is only set by setOffline, after the
+// grace window.
+func (h *Hub) updatePresence(username string) {
+	if username == "" {
+		return
+	}
+	status, channels := h.currentPresence(username)
+	if status == PresenceOffline {
+		return
+	}
+	h.storePresence(username, status, channels)
+}
+
+// setOffline marks a user offline once their last connection is gone
+func (h *Hub) setOffline(username string) {
+	h.storePresence(username, PresenceOffline, nil)
+}
+
+func (h *Hub) storePresence(username, status string, channels []string) {
+	now := time.Now()
+	h.presenceMutex.Lock()
+	previous, known := h.presence[username]
+	state := PresenceState{Username: username, Identity: userIdentity(username), Status: status, Since: now, Channels: channels}
+	if known && previous.Status == status {
+		state.Since = previous.Since
+	}
+	if status == PresenceOffline && known {
+		state.Channels = previous.Channels // where the user was last seen
+	}
+	state.CustomStatus = previous.CustomStatus
+	h.presence[username] = state
+	h.presenceMutex.Unlock()
+
+	if !known {
+		previous, _ = h.lastPresence(username)
+		if state.CustomStatus = h.customStatus(username); state.CustomStatus != nil {
+			h.presenceMutex.Lock()
+			if current, ok := h.presence[username]; ok {
+				current.CustomStatus = state.CustomStatus
+				h.presence[username] = current
+			}
+			h.presenceMutex.Unlock()
+		}
+	}
+	h.postChannelNotices(username, previous, state)
+	h.savePresence(state)
+	h.announceMembers(previous, state)
+	if known && previous.Status == status {
+		return
+	}
+	frame, err := encodeFrame(PresenceStateFrame{Type: "presence", Username: username, Identity: state.Identity, Status: status, Since: state.Since, CustomStatus: state.CustomStatus})
+	if err != nil {
+		return
+	}
+	h.sendToAll(frame, PriorityLow, nil)
+}
+
+// savePresence mirrors a state to Redis
+func (h *Hub) savePresence(state PresenceState) {
+	if h.redis == nil {
+		return
+	}
+	data, err := json.Marshal(storedPresence{PresenceState: state, Updated: time.Now()})
+	if err != nil {
+		return
+	}
+	if err := h.redis.HSet(context.Background(), presenceKey, state.Username, data).Err(); err != nil {
+		log.Printf("Durum bilgisi kaydedilemedi: %v", err)
+	}
+}
+
+// handlePresence applies a status the client reports for itself
+func (h *Hub) handlePresence(c *Client, raw []byte) {
+	var req PresenceRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return
+	}
+	switch req.Status {
+	case PresenceOnline:
+		c.touch()
+	case PresenceAway:
+		c.lastActive.Store(0)
+	default:
+		return
+	}
+	h.updatePresence(c.Username)
+}
+
+// runPresence turns idle users away, refreshes the Redis entries of connected
+// users and forgets users that have been offline for long
+func (h *Hub) runPresence() {
+	ticker := time.NewTicker(presenceRefreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.presenceMutex.Lock()
+		var connected []string
+		for username, state := range h.presence {
+			if state.Status != PresenceOffline {
+				connected = append(connected, username)
+			} else if time.Since(state.Since) > presenceOfflineRetention {
+				delete(h.presence, username)
+			}
+		}
+		h.presenceMutex.Unlock()
+
+		for _, username := range connected {
+			h.updatePresence(username)
+		}
+		h.prunePresence()
+	}
+}
+
+// prunePresence removes old offline entries from Redis
+func (h *Hub) prunePresence() {
+	if h.redis == nil {
+		return
+	}
+	ctx := context.Background()
+	for _, state := range h.storedPresence(ctx) {
+		if state.Status == PresenceOffline && time.Since(state.Since) > presenceOfflineRetention {
+			h.redis.HDel(ctx, presenceKey, state.Username)
+		}
+	}
+}
+
+// storedPresence reads the presence of every instance's users from Redis.
+// Entries of an instance that stopped refreshing them count as offline.
+func (h *Hub) storedPresence(ctx context.Context) []PresenceState {
+	entries, err := h.redis.HGetAll(ctx, presenceKey).Result()
+	if err != nil {
+		log.Printf("Durum bilgisi okunamadı: %v", err)
+		return nil
+	}
+	states := make([]PresenceState, 0, len(entries))
+	for _, raw := range entries {
+		var stored storedPresence
+		if err := json.Unmarshal([]byte(raw), &stored); err != nil {
+			continue
+		}
+		state := stored.PresenceState
+		if state.Status != PresenceOffline && time.Since(stored.Updated) > 3*presenceRefreshInterval {
+			state.Status, state.Since = PresenceOffline, stored.Updated
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// presenceList returns the presence of users in a channel, or of everyone for
+// an empty channel, sorted by username
+func (h *Hub) presenceList(channel string) []PresenceState {
+	var states []PresenceState
+	if h.redis != nil {
+		states = h.storedPresence(context.Background())
+	} else {
+		h.presenceMutex.Lock()
+		for _, state := range h.presence {
+			states = append(states, state)
+		}
+		h.presenceMutex.Unlock()
+	}
+
+	result := make([]PresenceState, 0, len(states))
+	for _, state := range states {
+		if channel == "" || len(state.Channels) == 0 || containsString(state.Channels, channel) {
+			state.Identity = userIdentity(state.Username) // entries saved before identities
+			result = append(result, state)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Username < result[j].Username })
+	return result
+}
+
+// handlePresenceAPI serves GET /api/presence?channel=
+func handlePresenceAPI(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"channel": r.URL.Query().Get("channel"),
+		"users":   hub.presenceList(r.URL.Query().Get("channel")),
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File processing states reported with file_status frames
+const (
+	FileStatusQueued     = "queued"
+	FileStatusProcessing = "processing"
+	FileStatusReady      = "ready"
+	FileStatusFailed     = "failed"
+)
+
+// Number of concurrent upload processing workers
+var uploadWorkers = envInt("UPLOAD_WORKERS", 2)
+
+// uploadProcessor is one post-upload processing stage. Stages run in order and
+// may update the metadata; an error marks the upload as failed.
+type uploadProcessor struct {
+	Name string
+	Run  func(meta *FileMeta) error
+}
+
+var uploadProcessors = []uploadProcessor{
+	{Name: "verify", Run: verifyUploadContent},
+	{Name: "renditions", Run: generateRenditions},
+}
+
+// startUploadProcessing queues a freshly announced upload
+func (h *Hub) startUploadProcessing(id string) {
+	meta, ok := getFileMeta(id)
+	if !ok || meta.Status != FileStatusQueued {
+		return
+	}
+	h.enqueueUpload(*meta)
+}
+
+// enqueueUpload reports the upload as queued and hands it to the workers
+func (h *Hub) enqueueUpload(meta FileMeta) {
+	h.sendFileStatus(meta, FileStatusQueued, "")
+	select {
+	case h.uploadQueue <- meta:
+	default:
+		// Don't block the upload request when the workers are saturated
+		go func() { h.uploadQueue <- meta }()
+	}
+}
+
+// runUploadWorkers processes queued uploads until the process exits
+func (h *Hub) runUploadWorkers() {
+	for i := 0; i < max(uploadWorkers, 1); i++ {
+		go func() {
+			for meta := range h.uploadQueue {
+				h.processUpload(meta)
+			}
+		}()
+	}
+}
+
+func (h *Hub) processUpload(meta FileMeta) {
+	meta.Status = FileStatusProcessing
+	saveFileMeta(meta)
+	h.sendFileStatus(meta, FileStatusProcessing, "")
+
+	for _, stage := range uploadProcessors {
+		if err := stage.Run(&meta); err != nil {
+			log.Printf("Dosya işleme hatası (%s, %s): %v", meta.ID, stage.Name, err)
+			meta.Status = FileStatusFailed
+			saveFileMeta(meta)
+			h.sendFileStatus(meta, FileStatusFailed, err.Error())
+			return
+		}
+	}
+
+	meta.Status = FileStatusReady
+	if err := saveFileMeta(meta); err != nil {
+		log.Printf("Dosya bilgisi kaydetme hatası: %v", err)
+	}
+	h.sendFileStatus(meta, FileStatusReady, "")
+}
+
+// sendFileStatus reports the processing state of an attachment to its channel
+func (h *Hub) sendFileStatus(meta FileMeta, status, reason string) {
+	frame := FileStatusFrame{
+		Type:      "file_status",
+		FileID:    meta.ID,
+		Channel:   meta.Channel,
+		Status:    status,
+		Error:     reason,
+		Timestamp: time.Now(),
+	}
+	payload, err := encodeFrame(frame)
+	if err != nil {
+		return
+	}
+	h.sendToChannel(meta.Channel, payload, PriorityNormal, nil)
+}
+
+// verifyUploadContent sniffs the stored bytes and rejects images whose content
+// isn't actually an image
+func verifyUploadContent(meta *FileMeta) error {
+	f, err := os.Open(filepath.Join(blobsDir, meta.Blob))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, _ := f.Read(head)
+	sniffed := http.DetectContentType(head[:n])
+	if strings.HasPrefix(meta.ContentType, "image/") && !strings.HasPrefix(sniffed, "image/") {
+		return fmt.Errorf("içerik bir resim değil (%s)", sniffed)
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"websocket-chat-app/chatpb"
+)
+
+// Protobuf clients exchange the messages of chatpb/chat.proto in binary
+// WebSocket messages. Like MessagePack, frames are converted at the edge of a
+// connection: the schema's fields carry the JSON names of the protocol, so
+// conversion walks the descriptors instead of mapping every frame by hand.
+
+// jsonToProtobuf re-encodes one JSON envelope as a length-delimited
+// chatpb.Envelope. Frame types the schema doesn't know are sent as JSON text.
+func jsonToProtobuf(frame []byte) ([]byte, error) {
+	var env struct {
+		Type    string          `json:"type"`
+		ID      string          `json:"id"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(frame, &env); err != nil {
+		return nil, err
+	}
+	out := &chatpb.Envelope{Type: env.Type, Id: env.ID}
+	m := out.ProtoReflect()
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(env.Type))
+	if fd == nil || fd.ContainingOneof() == nil || fd.Message() == nil {
+		out.Payload = &chatpb.Envelope_Json{Json: string(env.Payload)}
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(env.Payload))
+		decoder.UseNumber()
+		var payload map[string]interface{}
+		if err := decoder.Decode(&payload); err != nil {
+			return nil, err
+		}
+		payloadMsg := m.NewField(fd).Message()
+		if err := fillProto(payloadMsg, payload); err != nil {
+			return nil, err
+		}
+		m.Set(fd, protoreflect.ValueOfMessage(payloadMsg))
+	}
+	data, err := proto.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	return protowire.AppendBytes(nil, data), nil
+}
+
+// protobufToJSON converts an inbound chatpb.ClientFrame to the JSON frame the
+// read pump understands
+func protobufToJSON(data []byte) ([]byte, error) {
+	var frame chatpb.ClientFrame
+	if err := proto.Unmarshal(data, &frame); err != nil {
+		return nil, err
+	}
+	m := frame.ProtoReflect()
+	fd := m.WhichOneof(m.Descriptor().Oneofs().ByName("frame"))
+	if fd == nil {
+		return nil, fmt.Errorf("empty frame")
+	}
+	obj := protoToMap(m.Get(fd).Message())
+	if fd.Name() != "message" {
+		obj["type"] = string(fd.Name())
+	}
+	return json.Marshal(obj)
+}
+
+// fillProto sets the fields of m from a decoded JSON object, by JSON name.
+// Unknown keys are ignored.
+func fillProto(m protoreflect.Message, obj map[string]interface{}) error {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		raw, ok := obj[fd.JSONName()]
+		if !ok || raw == nil {
+			continue
+		}
+		switch {
+		case fd.IsMap():
+			entries, ok := raw.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%s: expected object", fd.JSONName())
+			}
+			mp := m.Mutable(fd).Map()
+			for key, item := range entries {
+				value, err := protoValue(fd.MapValue(), mp.NewValue, item)
+				if err != nil {
+					return err
+				}
+				mp.Set(protoreflect.ValueOfString(key).MapKey(), value)
+			}
+		case fd.IsList():
+			items, ok := raw.([]interface{})
+			if !ok {
+				return fmt.Errorf("%s: expected array", fd.JSONName())
+			}
+			list := m.Mutable(fd).List()
+			for _, item := range items {
+				value, err := protoValue(fd, list.NewElement, item)
+				if err != nil {
+					return err
+				}
+				list.Append(value)
+			}
+		default:
+			value, err := protoValue(fd, func() protoreflect.Value { return m.NewField(fd) }, raw)
+			if err != nil {
+				return err
+			}
+			m.Set(fd, value)
+		}
+	}
+	return nil
+}
+
+// protoValue converts one JSON value for a field; newMessage returns an empty
+// message value for message fields
+func protoValue(fd protoreflect.FieldDescriptor, newMessage func() protoreflect.Value, raw interface{}) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		s, _ := raw.(string)
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BoolKind:
+		b, _ := raw.(bool)
+		return protoreflect.ValueOfBool(b), nil
+	case protoreflect.Int64Kind:
+		n, _ := raw.(json.Number)
+		i, _ := n.Int64()
+		return protoreflect.ValueOfInt64(i), nil
+	case protoreflect.MessageKind:
+		switch fd.Message().FullName() {
+		case "google.protobuf.Timestamp":
+			s, _ := raw.(string)
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return protoreflect.Value{}, fmt.Errorf("%s: %v", fd.JSONName(), err)
+			}
+			return protoreflect.ValueOfMessage(timestamppb.New(t).ProtoReflect()), nil
+		case "google.protobuf.Value":
+			v, err := structpb.NewValue(plainJSON(raw))
+			if err != nil {
+				return protoreflect.Value{}, err
+			}
+			return protoreflect.ValueOfMessage(v.ProtoReflect()), nil
+		}
+		value := newMessage()
+		switch raw := raw.(type) {
+		case map[string]interface{}:
+			return value, fillProto(value.Message(), raw)
+		case []interface{}:
+			// Wrapper messages like Usernames stand for a bare JSON array
+			inner := value.Message().Descriptor().Fields().Get(0)
+			return value, fillProto(value.Message(), map[string]interface{}{inner.JSONName(): raw})
+		}
+		return value, nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("%s: unsupported kind %s", fd.JSONName(), fd.Kind())
+}
+
+// plainJSON turns json.Number values back into float64 for structpb
+func plainJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = plainJSON(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = plainJSON(item)
+		}
+	}
+	return v
+}
+
+// protoToMap converts a message to a JSON object keyed by JSON names, the
+// inverse of fillProto
+func protoToMap(m protoreflect.Message) map[string]interface{} {
+	obj := make(map[string]interface{})
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap():
+			entries := make(map[string]interface{})
+			v.Map().Range(func(k protoreflect.MapKey, item protoreflect.Value) bool {
+				entries[k.String()] = protoFieldValue(fd.MapValue(), item, true)
+				return true
+			})
+			obj[fd.JSONName()] = entries
+		case fd.IsList():
+			items := make([]interface{}, v.List().Len())
+			for i := range items {
+				items[i] = protoFieldValue(fd, v.List().Get(i), false)
+			}
+			obj[fd.JSONName()] = items
+		default:
+			obj[fd.JSONName()] = protoFieldValue(fd, v, false)
+		}
+		return true
+	})
+	return obj
+}
+
+func protoFieldValue(fd protoreflect.FieldDescriptor, v protoreflect.Value, unwrap bool) interface{} {
+	if fd.Kind() != protoreflect.MessageKind {
+		return v.Interface()
+	}
+	switch msg := v.Message(); msg.Descriptor().FullName() {
+	case "google.protobuf.Timestamp":
+		return msg.Interface().(*timestamppb.Timestamp).AsTime().Format(time.RFC3339Nano)
+	case "google.protobuf.Value":
+		return msg.Interface().(*structpb.Value).AsInterface()
+	default:
+		obj := protoToMap(msg)
+		if unwrap {
+			inner := msg.Descriptor().Fields().Get(0)
+			if items, ok := obj[inner.JSONName()]; ok {
+				return items
+			}
+			return []interface{}{}
+		}
+		return obj
+	}
+}
+
+// handleProtobufSchema serves the chat.proto the protobuf encoding follows
+func handleProtobufSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(chatpb.Schema)
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Envelope wraps every server -> client frame. Type is the payload's "type"
+// field, or "message" for chat messages; ID is unique per frame and equals the
+// messageId of chat messages.
+type Envelope struct {
+	Type    string      `json:"type"`
+	ID      string      `json:"id"`
+	Payload interface{} `json:"payload"`
+}
+
+// newEnvelope wraps a frame struct, or a Message, for sending
+func newEnvelope(frame interface{}) Envelope {
+	if msg, ok := frame.(Message); ok {
+		id := msg.MessageID
+		if id == "" {
+			id = newULID() // stored before messages had IDs
+		}
+		return Envelope{Type: "message", ID: id, Payload: msg}
+	}
+	env := Envelope{ID: newULID(), Payload: frame}
+	if v := reflect.Indirect(reflect.ValueOf(frame)); v.Kind() == reflect.Struct {
+		if t := v.FieldByName("Type"); t.IsValid() && t.Kind() == reflect.String {
+			env.Type = t.String()
+		}
+	}
+	return env
+}
+
+// encodeFrame marshals a frame in its envelope
+func encodeFrame(frame interface{}) ([]byte, error) {
+	return json.Marshal(newEnvelope(frame))
+}
+
+// Server -> client frames, sent as the payload of an Envelope. Chat messages
+// themselves are sent as Message.
+type (
+	UserCountFrame struct {
+		Type      string    `json:"type"`
+		Count     int       `json:"count"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+
+	// PresenceFrame announces user_connected / user_disconnected
+	PresenceFrame struct {
+		Type         string        `json:"type"`
+		Username     string        `json:"username"`
+		UserID       string        `json:"userId"`
+		Identity     Identity      `json:"identity"`
+		CustomStatus *CustomStatus `json:"customStatus,omitempty"` // user_connected only
+		Timestamp    time.Time     `json:"timestamp"`
+	}
+
+	SeenFrame struct {
+		Type      string    `json:"type"`
+		Channel   string    `json:"channel"`
+		MessageID string    `json:"messageId,omitempty"`
+		Timestamp time.Time `json:"timestamp"`
+		Username  string    `json:"username"`
+	}
+
+	ErrorFrame struct {
+		Type     string `json:"type"`
+		Code     string `json:"code"`
+		Reason   string `json:"reason"`
+		Provider string `json:"provider,omitempty"` // captcha_required only
+		SiteKey  string `json:"siteKey,omitempty"`  // captcha_required only
+		Limit    int    `json:"limit,omitempty"`    // message_too_large and message_too_long only
+		TraceID  string `json:"traceId,omitempty"`  // trace of the rejected message
+		// captcha_required only: the rejected message, to send again once verified
+		ClientMsgID string `json:"clientMsgId,omitempty"`
+	}
+
+	MaintenanceFrame struct {
+		Type      string    `json:"type"`
+		Enabled   bool      `json:"enabled"`
+		Message   string    `json:"message"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+
+	SecurityNoticeFrame struct {
+		Type         string           `json:"type"`
+		Reason       string           `json:"reason"`
+		Message      string           `json:"message"`
+		Timestamp    time.Time        `json:"timestamp"`
+		Notification NotificationHint `json:"notification"`
+	}
+
+	FileStatusFrame struct {
+		Type      string    `json:"type"`
+		FileID    string    `json:"fileId"`
+		Channel   string    `json:"channel"`
+		Status    string    `json:"status"`
+		Error     string    `json:"error,omitempty"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+
+	RosterPageFrame struct {
+		Type       string        `json:"type"`
+		Users      []RosterEntry `json:"users"`
+		Cursor     string        `json:"cursor"`
+		NextCursor string        `json:"nextCursor"`
+		Timestamp  time.Time     `json:"timestamp"`
+	}
+
+	RosterDiffFrame struct {
+		Type      string        `json:"type"`
+		Added     []RosterEntry `json:"added"`
+		Removed   []RosterEntry `json:"removed"`
+		Timestamp time.Time     `json:"timestamp"`
+	}
+
+	LinkStatsFrame struct {
+		Type      string      `json:"type"`
+		Links     []ShortLink `json:"links"`
+		Timestamp time.Time   `json:"timestamp"`
+	}
+)
+
+// Codes of ErrorFrame. Every frame a client sends is either processed, answered
+// with an ack, or rejected with one of these, never dropped silently.
+const (
+	ErrInvalidJSON          = "invalid_json"      // frame is not valid JSON
+	ErrInvalidMsgpack       = "invalid_msgpack"   // binary frame is not valid MessagePack
+	ErrInvalidProtobuf      = "invalid_protobuf"  // binary frame is not a valid chatpb.ClientFrame
+	ErrMessageTooLarge      = "message_too_large" // frame exceeds maxMessageBytes
+	ErrMessageTooLong       = "message_too_long"  // text exceeds maxMessageLength characters
+	ErrUsernameRequired     = "username_required" // no username sent yet
+	ErrDropped              = "dropped"           // hub busy, message without clientMsgId was not sent
+	ErrMaintenance          = "maintenance"
+	ErrCaptchaRequired      = "captcha_required"
+	ErrInvalidChannel       = "invalid_channel"
+	ErrTooManySubscriptions = "too_many_subscriptions"
+	ErrInvalidReaction      = "invalid_reaction"
+	ErrRateLimited          = "rate_limited"
+	ErrInvalidKey           = "invalid_key"
+	ErrStatsUnavailable     = "stats_unavailable"
+	ErrUnsupportedVersion   = "unsupported_version"  // client protocol older than minProtocolVersion
+	ErrInvalidEdit          = "invalid_edit"         // not the author's stored text message
+	ErrCommandFailed        = "command_failed"       // slash command endpoint failed or timed out
+	ErrInvalidInteraction   = "invalid_interaction"  // unknown message or button, or bot no longer registered
+	ErrInvalidThread        = "invalid_thread"       // thread root not stored or in a channel not joined
+	ErrSessionExists        = "session_exists"       // user already connected and SESSION_POLICY is deny-new
+	ErrInvalidPin           = "invalid_pin"          // message not stored, not pinned, or too many pins
+	ErrNotModerator         = "not_moderator"        // pinning needs a moderator of the channel
+	ErrContentMode          = "content_mode"         // message doesn't fit the channel's media-only or emoji-only mode
+	ErrInvalidPoll          = "invalid_poll"         // poll without a question or with too few or many options
+	ErrInvalidVote          = "invalid_vote"         // unknown poll or option, or several options in a single choice poll
+	ErrNotMember            = "not_member"           // conversation the user isn't a member of
+	ErrInvalidConversation  = "invalid_conversation" // bad member list, member limit, or change not allowed
+	ErrInvalidNumerology    = "invalid_numerology"   // numerology message without a valid result
+	ErrEmptyMessage         = "empty_message"        // nothing left of the text once markup was removed
+	ErrInvalidSegments      = "invalid_segments"     // unknown segment type, bad code language, or delimiters inside a segment
+	ErrInvalidUsername      = "invalid_username"     // length, characters or mixed scripts against the username policy
+	ErrUsernameReserved     = "username_reserved"    // looks like a name in USERNAME_RESERVED
+	ErrUsernameTaken        = "username_taken"       // looks like the name another user holds
+	ErrInvalidStatus        = "invalid_status"       // custom status text too long or emoji invalid
+	ErrInvalidLink          = "invalid_link"         // deep link malformed, or its message no longer stored
+	ErrInvalidPrefs         = "invalid_prefs"        // notification preferences malformed or over a limit
+	ErrInvalidReview        = "invalid_review"       // no such held message, or already reviewed
+	ErrInvalidReadMarker    = "invalid_read_marker"  // mark_read names a message not stored in the channel
+	ErrNotAdmin             = "not_admin"            // announcement without a valid admin API key
+	ErrInvalidAnnouncement  = "invalid_announcement" // announcement text empty or too long
+	ErrLoginRequired        = "login_required"       // name of a registered account the connection isn't signed in to
+	ErrGuestRestricted      = "guest_restricted"     // refused to guests by GUEST_RESTRICTIONS
+)
+
+// Client -> server control frames besides Message
+type (
+	RosterRequest struct {
+		Type   string `json:"type"`
+		Cursor string `json:"cursor"`
+		Limit  int    `json:"limit"`
+	}
+
+	LinkStatsRequest struct {
+		Type string `json:"type"`
+	}
+)
+
+// protocolFrame registers a frame type for the generated TypeScript definitions.
+// Types lists the values of its "type" field; empty means any string, which for
+// server frames is a chat message enveloped as "message".
+type protocolFrame struct {
+	Value interface{}
+	Types []string
+}
+
+var serverFrames = []protocolFrame{
+	{Message{}, nil},
+	{UserCountFrame{}, []string{"user_count"}},
+	{PresenceFrame{}, []string{"user_connected", "user_disconnected"}},
+	{SeenFrame{}, []string{"seen"}},
+	{ErrorFrame{}, []string{"error"}},
+	{MaintenanceFrame{}, []string{"maintenance"}},
+	{SecurityNoticeFrame{}, []string{"security_notice"}},
+	{FileStatusFrame{}, []string{"file_status"}},
+	{RosterPageFrame{}, []string{"roster_page"}},
+	{RosterDiffFrame{}, []string{"roster_diff"}},
+	{LinkStatsFrame{}, []string{"link_stats"}},
+	{ReactionFrame{}, []string{"reaction"}},
+	{MentionFrame{}, []string{"mention"}},
+	{SubscriptionsFrame{}, []string{"subscriptions"}},
+	{AckFrame{}, []string{"ack"}},
+	{DeliveredFrame{}, []string{"delivered"}},
+	{SecurityChangedFrame{}, []string{"security_changed"}},
+	{ResumedFrame{}, []string{"resumed"}},
+	{StatsTokenFrame{}, []string{"stats_token"}},
+	{HelloFrame{}, []string{"hello"}},
+	{MediaFilterFrame{}, []string{"media_filter"}},
+	{MediaStubFrame{}, []string{"media_stub"}},
+	{LiteModeFrame{}, []string{"lite_mode"}},
+	{UploadTokenFrame{}, []string{"upload_token"}},
+	{DownloadTokenFrame{}, []string{"download_token"}},
+	{PresenceStateFrame{}, []string{"presence"}},
+	{MessageEditFrame{}, []string{"edited"}},
+	{PongFrame{}, []string{"pong"}},
+	{CommandResultFrame{}, []string{"command_result"}},
+	{PinFrame{}, []string{"pin", "unpin"}},
+	{PinsFrame{}, []string{"pins"}},
+	{PollResultsFrame{}, []string{"poll_results"}},
+	{ExpiredFrame{}, []string{"expired"}},
+	{ThreadFrame{}, []string{"thread"}},
+	{SessionReplacedFrame{}, []string{"session_replaced"}},
+	{ConversationFrame{}, []string{"conversation"}},
+	{ConversationsFrame{}, []string{"conversations"}},
+	{MembersFrame{}, []string{"members"}},
+	{HighlightsFrame{}, []string{"highlights"}},
+	{ConnectionQualityFrame{}, []string{"connection_quality"}},
+	{LinkPreviewFrame{}, []string{"link_preview"}},
+	{HistoryFrame{}, []string{"history"}},
+	{MessageContextFrame{}, []string{"message_context"}},
+	{NotificationPrefsFrame{}, []string{"notification_prefs"}},
+	{ReviewFrame{}, []string{"review"}},
+	{ReviewQueueFrame{}, []string{"review_queue"}},
+	{UnreadCountsFrame{}, []string{"unread_counts"}},
+	{AnnouncementResult{}, []string{"announcement_result"}},
+	{MOTDFrame{}, []string{"motd"}},
+	{GuestFrame{}, []string{"guest"}},
+	{DayMarkersFrame{}, []string{"day_markers"}},
+}
+
+var clientFrames = []protocolFrame{
+	{Message{}, nil},
+	{RosterRequest{}, []string{"roster"}},
+	{LinkStatsRequest{}, []string{"link_stats"}},
+	{ReactionRequest{}, []string{"reaction"}},
+	{ChannelRequest{}, []string{"join", "subscribe", "unsubscribe"}},
+	{DeliveredRequest{}, []string{"delivered"}},
+	{KeyAnnouncement{}, []string{"public_key"}},
+	{ResumeRequest{}, []string{"resume"}},
+	{HistoryRequest{}, []string{"history"}},
+	{OpenLinkRequest{}, []string{"open_link"}},
+	{NotificationPrefsRequest{}, []string{"notification_prefs"}},
+	{ReviewRequest{}, []string{"review"}},
+	{ReviewQueueRequest{}, []string{"review_queue"}},
+	{MarkReadRequest{}, []string{"mark_read"}},
+	{AnnouncementRequest{}, []string{"announcement"}},
+	{StatsOptInRequest{}, []string{"stats_opt_in"}},
+	{HelloRequest{}, []string{"hello"}},
+	{MediaFilterRequest{}, []string{"media_filter"}},
+	{HighlightsRequest{}, []string{"highlights"}},
+	{LiteModeRequest{}, []string{"lite_mode"}},
+	{UploadTokenRequest{}, []string{"upload_token"}},
+	{DownloadTokenRequest{}, []string{"download_token"}},
+	{PresenceRequest{}, []string{"presence"}},
+	{StatusRequest{}, []string{"status"}},
+	{EditRequest{}, []string{"edit"}},
+	{PingRequest{}, []string{"ping"}},
+	{LatencyReport{}, []string{"latency_report"}},
+	{InteractionRequest{}, []string{"interaction"}},
+	{ThreadFetchRequest{}, []string{"thread_fetch"}},
+	{PinRequest{}, []string{"pin", "unpin"}},
+	{VoteRequest{}, []string{"vote"}},
+	{ConversationCreateRequest{}, []string{"conversation_create"}},
+	{ConversationMembersRequest{}, []string{"conversation_add", "conversation_remove"}},
+	{ConversationsRequest{}, []string{"conversations"}},
+}
+
+var (
+	protocolDefinitionsOnce sync.Once
+	protocolDefinitions     string
+)
+
+// handleProtocolDefinitions serves the TypeScript definitions generated from the
+// Go protocol types, so the web client can't drift from the server
+func handleProtocolDefinitions(w http.ResponseWriter, r *http.Request) {
+	protocolDefinitionsOnce.Do(func() {
+		protocolDefinitions = generateTypeScript(serverFrames, clientFrames)
+	})
+	w.Header().Set("Content-Type", "application/typescript; charset=utf-8")
+	w.Write([]byte(protocolDefinitions))
+}
+
+// generateTypeScript renders interfaces for the frames and every struct they reference
+func generateTypeScript(server, client []protocolFrame) string {
+	gen := &tsGenerator{defs: make(map[string]string)}
+	literals := make(map[reflect.Type][]string)
+	for _, frame := range append(append([]protocolFrame{}, server...), client...) {
+		t := reflect.TypeOf(frame.Value)
+		literals[t] = frame.Types
+	}
+	gen.literals = literals
+	for t := range literals {
+		gen.define(t)
+	}
+
+	var b strings.Builder
+	b.WriteString("// Generated from the server's Go protocol types. Do not edit.\n\n")
+	names := make([]string, 0, len(gen.defs))
+	for name := range gen.defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString(gen.defs[name])
+		b.WriteString("\n")
+	}
+	b.WriteString("export interface Envelope<K extends string, T> {\n  type: K;\n  id: string;\n  payload: T;\n}\n\n")
+	b.WriteString(envelopeUnion("ServerEnvelope", server))
+	b.WriteString(unionType("ServerFrame", server))
+	b.WriteString(unionType("ClientFrame", client))
+	return b.String()
+}
+
+// envelopeUnion lists the envelope of every server frame by its type
+func envelopeUnion(name string, frames []protocolFrame) string {
+	members := make([]string, len(frames))
+	for i, frame := range frames {
+		types := `"message"`
+		if len(frame.Types) > 0 {
+			types = `"` + strings.Join(frame.Types, `" | "`) + `"`
+		}
+		members[i] = fmt.Sprintf("Envelope<%s, %s>", types, reflect.TypeOf(frame.Value).Name())
+	}
+	return fmt.Sprintf("export type %s =\n  | %s;\n\n", name, strings.Join(members, "\n  | "))
+}
+
+func unionType(name string, frames []protocolFrame) string {
+	members := make([]string, len(frames))
+	for i, frame := range frames {
+		members[i] = reflect.TypeOf(frame.Value).Name()
+	}
+	return fmt.Sprintf("export type %s =\n  | %s;\n\n", name, strings.Join(members, "\n  | "))
+}
+
+type tsGenerator struct {
+	defs     map[string]string
+	literals map[reflect.Type][]string
+}
+
+// define emits an interface for a struct type once
+func (g *tsGenerator) define(t reflect.Type) {
+	if _, done := g.defs[t.Name()]; done {
+		return
+	}
+	g.defs[t.Name()] = "" // guards against recursive types
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "export interface %s {\n", t.Name())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		optional := strings.Contains(opts, "omitempty")
+		tsType := g.typeOf(field.Type)
+		if name == "type" && len(g.literals[t]) > 0 {
+			tsType = `"` + strings.Join(g.literals[t], `" | "`) + `"`
+		}
+		if optional {
+			name += "?"
+		}
+		fmt.Fprintf(&b, "  %s: %s;\n", name, tsType)
+	}
+	b.WriteString("}\n")
+	g.defs[t.Name()] = b.String()
+}
+
+// typeOf maps a Go type to its JSON representation in TypeScript
+func (g *tsGenerator) typeOf(t reflect.Type) string {
+	if t == reflect.TypeOf(time.Time{}) {
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Ptr:
+		return g.typeOf(t.Elem())
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string" // base64
+		}
+		return g.typeOf(t.Elem()) + "[]"
+	case reflect.Map:
+		return fmt.Sprintf("Record<string, %s>", g.typeOf(t.Elem()))
+	case reflect.Struct:
+		g.define(t)
+		return t.Name()
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Reaction operations are limited per user per minute and per user per message
+// per minute, so a single user can't hammer the hot key of a viral message
+var (
+	reactionRateLimit      = envInt("REACTION_RATE_LIMIT", 30)
+	reactionMessageLimit   = envInt("REACTION_MESSAGE_LIMIT", 6)
+	reactionIncidentWindow = envDuration("REACTION_INCIDENT_WINDOW", 10*time.Minute)
+)
+
+const (
+	reactionMaxEmojiBytes = 32
+	reactionMaxEmoji      = 50 // distinct emoji per message
+)
+
+// ReactionRequest is the inbound {"type":"reaction",...} frame; MessageID
+// identifies the message like seen receipts do, Timestamp for older messages
+type ReactionRequest struct {
+	Type      string    `json:"type"`
+	Channel   string    `json:"channel"`
+	MessageID string    `json:"messageId,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Emoji     string    `json:"emoji"`
+	Action    string    `json:"action"` // "add" or "remove"
+}
+
+// ReactionFrame is broadcast after a reaction was added or removed
+type ReactionFrame struct {
+	Type      string    `json:"type"`
+	Channel   string    `json:"channel"`
+	MessageID string    `json:"messageId,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Emoji     string    `json:"emoji"`
+	Username  string    `json:"username"`
+	Action    string    `json:"action"`
+}
+
+// Reactions of a channel live in one hash with "<message key>|<emoji>|<username>"
+// fields holding the time the reaction was added
+func reactionsKey(channel string) string {
+	return "websocket:reactions:" + channel
+}
+
+func reactionField(message, emoji, username string) string {
+	return message + "|" + emoji + "|" + username
+}
+
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > reactionMaxEmojiBytes || strings.Contains(emoji, "|") {
+		return false
+	}
+	return strings.IndexFunc(emoji, unicode.IsSpace) < 0
+}
+
+// handleReaction applies a reaction frame from a client
+func (h *Hub) handleReaction(c *Client, raw []byte) {
+	var req ReactionRequest
+	if err := json.Unmarshal(raw, &req); err != nil || c.Username == "" || req.Channel == "" {
+		return
+	}
+	if !validEmoji(req.Emoji) || (req.Action != "add" && req.Action != "remove") {
+		c.sendError(ErrInvalidReaction, "Geçersiz tepki")
+		return
+	}
+	if req.MessageID != "" {
+		if !isULID(req.MessageID) {
+			c.sendError(ErrInvalidReaction, "Geçersiz mesaj")
+			return
+		}
+		req.Timestamp = ulidTime(req.MessageID)
+	}
+	if h.redis == nil {
+		return
+	}
+	message := messageKey(req.MessageID, req.Timestamp)
+
+	if !h.allowReaction(c.Username, req.Channel, message) {
+		c.sendError(ErrRateLimited, "Çok fazla tepki gönderdiniz, lütfen biraz bekleyin")
+		h.recordAuditIncident("reaction_spam", c.Username, req.Channel,
+			fmt.Sprintf("mesaj=%s", message), reactionIncidentWindow)
+		return
+	}
+
+	ctx := context.Background()
+	key := reactionsKey(req.Channel)
+	field := reactionField(message, req.Emoji, c.Username)
+	var changed bool
+	if req.Action == "add" {
+		if h.reactionEmojiCount(req.Channel, message) >= reactionMaxEmoji {
+			c.sendError(ErrInvalidReaction, "Bu mesajda çok fazla farklı tepki var")
+			return
+		}
+		added, err := h.addReaction(ctx, req.Channel, req.MessageID, field, req.Emoji, c.Username)
+		if err != nil {
+			log.Printf("Tepki kaydetme hatası: %v", err)
+			return
+		}
+		changed = added
+	} else {
+		removed, err := h.redis.HDel(ctx, key, field).Result()
+		if err != nil {
+			log.Printf("Tepki silme hatası: %v", err)
+			return
+		}
+		changed = removed > 0
+	}
+	if !changed {
+		return
+	}
+
+	h.invalidateSnapshot(req.Channel)
+	if req.Action == "add" {
+		h.recordDigestReaction(req.Channel, req.Timestamp, message, 1)
+	} else {
+		h.recordDigestReaction(req.Channel, req.Timestamp, message, -1)
+	}
+	frame, _ := encodeFrame(ReactionFrame{
+		Type:      "reaction",
+		Channel:   req.Channel,
+		MessageID: req.MessageID,
+		Timestamp: req.Timestamp,
+		Emoji:     req.Emoji,
+		Username:  c.Username,
+		Action:    req.Action,
+	})
+	h.sendToChannel(req.Channel, frame, PriorityNormal, nil)
+	if req.Action == "add" && req.MessageID != "" {
+		go h.fireReactionTriggers(req.Channel, req.MessageID, req.Emoji, c.Username)
+	}
+}
+
+// Times a reaction is tried before giving up, as every other reaction in the
+// channel meanwhile starts it over
+const reactionAttempts = 10
+
+var errReactionContended = errors.New("reaction write contended")
+
+// addReaction stores a reaction unless it's there already. The events of the
+// webhook triggers it fires are queued in the same transaction, each with its
+// trigger's fired marker, so they are in the outbox once the reaction is
+// stored. It reports whether the reaction is new.
+func (h *Hub) addReaction(ctx context.Context, channel, messageID, field, emoji, username string) (bool, error) {
+	var webhooks []ReactionTrigger
+	var msg Message
+	if messageID != "" {
+		webhooks, msg = h.webhookTriggers(channel, messageID, emoji, username)
+	}
+	key := reactionsKey(channel)
+	watched := []string{key}
+	for _, t := range webhooks {
+		watched = append(watched, triggerFiredKey(t.ID, messageID))
+	}
+	for attempt := 0; attempt < reactionAttempts; attempt++ {
+		added := false
+		var fired []ReactionTrigger
+		err := h.redis.Watch(ctx, func(tx *redis.Tx) error {
+			if exists, err := tx.HExists(ctx, key, field).Result(); err != nil || exists {
+				return err
+			}
+			for _, t := range webhooks {
+				n, err := tx.Exists(ctx, triggerFiredKey(t.ID, messageID)).Result()
+				if err != nil {
+					return err
+				}
+				if n == 0 {
+					fired = append(fired, t)
+				}
+			}
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, key, field, time.Now().UnixNano())
+				pipe.Expire(ctx, key, 24*time.Hour)
+				for _, t := range fired {
+					event, err := triggerEvent(t, msg, username)
+					if err != nil {
+						return err
+					}
+					pipe.Set(ctx, triggerFiredKey(t.ID, messageID), username, triggerFiredTTL)
+					if err := queueOutboxEvent(ctx, pipe, event); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			added = err == nil
+			return err
+		}, watched...)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil || !added {
+			return false, err
+		}
+		for _, t := range fired {
+			h.recordTriggerFired(t, username, messageID)
+		}
+		return true, nil
+	}
+	return false, errReactionContended
+}
+
+// allowReaction counts the operation in fixed one-minute windows and reports
+// whether the user is still within both limits
+func (h *Hub) allowReaction(username, channel, message string) bool {
+	ctx := context.Background()
+	minute := strconv.FormatInt(time.Now().Unix()/60, 10)
+	userKey := "websocket:reactions:rate:" + username + ":" + minute
+	msgKey := "websocket:reactions:rate:" + username + ":" + channel + ":" + message + ":" + minute
+
+	pipe := h.redis.Pipeline()
+	perUser := pipe.Incr(ctx, userKey)
+	pipe.Expire(ctx, userKey, 2*time.Minute)
+	perMessage := pipe.Incr(ctx, msgKey)
+	pipe.Expire(ctx, msgKey, 2*time.Minute)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return true // don't block reactions when the counters are unavailable
+	}
+	return perUser.Val() <= int64(reactionRateLimit) && perMessage.Val() <= int64(reactionMessageLimit)
+}
+
+// reactionEmojiCount returns how many distinct emoji a message already has
+func (h *Hub) reactionEmojiCount(channel, message string) int {
+	emoji := make(map[string]bool)
+	for field := range h.scanReactions(channel, message+"|*") {
+		parts := strings.SplitN(field, "|", 3)
+		if len(parts) == 3 {
+			emoji[parts[1]] = true
+		}
+	}
+	return len(emoji)
+}
+
+func (h *Hub) scanReactions(channel, match string) map[string]string {
+	out := make(map[string]string)
+	iter := h.redis.HScan(context.Background(), reactionsKey(channel), 0, match, 100).Iterator()
+	for iter.Next(context.Background()) {
+		field := iter.Val()
+		if !iter.Next(context.Background()) {
+			break
+		}
+		out[field] = iter.Val()
+	}
+	return out
+}
+
+// channelReactions returns emoji -> users per message key, users in reaction order
+func (h *Hub) channelReactions(channel string) map[string]map[string][]string {
+	fields, err := h.redis.HGetAll(context.Background(), reactionsKey(channel)).Result()
+	if err != nil || len(fields) == 0 {
+		return nil
+	}
+	type reaction struct {
+		user string
+		at   int64
+	}
+	grouped := make(map[string]map[string][]reaction)
+	for field, value := range fields {
+		parts := strings.SplitN(field, "|", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		message, emoji, user := parts[0], parts[1], parts[2]
+		at, _ := strconv.ParseInt(value, 10, 64)
+		if grouped[message] == nil {
+			grouped[message] = make(map[string][]reaction)
+		}
+		grouped[message][emoji] = append(grouped[message][emoji], reaction{user, at})
+	}
+
+	out := make(map[string]map[string][]string, len(grouped))
+	for message, byEmoji := range grouped {
+		out[message] = make(map[string][]string, len(byEmoji))
+		for emoji, list := range byEmoji {
+			sort.Slice(list, func(i, j int) bool { return list[i].at < list[j].at })
+			users := make([]string, len(list))
+			for i, r := range list {
+				users[i] = r.user
+			}
+			out[message][emoji] = users
+		}
+	}
+	return out
+}
+
+// applyReactions fills the Reactions of messages from the stored reactions
+func applyReactions(messages []Message, reactions map[string]map[string][]string) {
+	for i := range messages {
+		if r := reactions[messageKey(messages[i].MessageID, messages[i].Timestamp)]; len(r) > 0 {
+			messages[i].Reactions = r
+		}
+	}
+}
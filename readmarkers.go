@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Users keep a last-read marker per channel, the newest message they read,
+// moved forward with {"type":"mark_read","channel":"...","messageId":"..."}.
+// Marking a channel read also clears its unread mentions. On connect, and for
+// every connection of the user after a marker moved, the server sends
+// unread_counts with the messages after the marker and the unread mentions of
+// each channel the user has a marker or mentions in. Unread messages are
+// counted by sequence number, so they include the user's own and the
+// server's join notices.
+
+// Last-read markers of a user, one hash field per channel with a JSON ReadMarker
+func readMarkersKey(username string) string {
+	return "websocket:read:" + username
+}
+
+// ReadMarker is the last message a user read in a channel
+type ReadMarker struct {
+	MessageID string    `json:"messageId"`
+	Seq       int64     `json:"seq"`
+	At        time.Time `json:"at"`
+}
+
+// MarkReadRequest is the inbound {"type":"mark_read","channel":"...","messageId":"..."} frame
+type MarkReadRequest struct {
+	Type      string `json:"type"`
+	Channel   string `json:"channel"`
+	MessageID string `json:"messageId"`
+}
+
+// UnreadCount is what a user hasn't read of a channel
+type UnreadCount struct {
+	LastReadMessageID string `json:"lastReadMessageId,omitempty"`
+	Unread            int64  `json:"unread"`   // messages after the last-read one
+	Mentions          int64  `json:"mentions"` // unread mentions, see notifications.go
+}
+
+// UnreadCountsFrame maps channels to their unread counts
+type UnreadCountsFrame struct {
+	Type      string                 `json:"type"`
+	Channels  map[string]UnreadCount `json:"channels"`
+	Timestamp time.Time              `json:"timestamp"`
+}
+
+// unreadCounts computes the counts of the given channels, or of every channel
+// the user has a marker or mentions in when channels is nil
+func (h *Hub) unreadCounts(username string, channels []string) (map[string]UnreadCount, error) {
+	ctx := context.Background()
+	markers, err := h.redis.HGetAll(ctx, readMarkersKey(username)).Result()
+	if err != nil {
+		return nil, err
+	}
+	mentions, err := h.redis.HGetAll(ctx, unreadKey(username)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if channels == nil {
+		for channel := range markers {
+			channels = append(channels, channel)
+		}
+		for channel := range mentions {
+			if _, ok := markers[channel]; !ok {
+				channels = append(channels, channel)
+			}
+		}
+	}
+
+	pipe := h.redis.Pipeline()
+	latest := make([]*redis.StringCmd, len(channels))
+	for i, channel := range channels {
+		latest[i] = pipe.Get(ctx, sequenceKey(channel))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	counts := make(map[string]UnreadCount, len(channels))
+	for i, channel := range channels {
+		var count UnreadCount
+		var marker ReadMarker
+		if raw, ok := markers[channel]; ok && json.Unmarshal([]byte(raw), &marker) == nil {
+			count.LastReadMessageID = marker.MessageID
+			if seq, err := latest[i].Int64(); err == nil && seq > marker.Seq {
+				count.Unread = seq - marker.Seq
+			}
+		}
+		count.Mentions, _ = strconv.ParseInt(mentions[channel], 10, 64)
+		counts[channel] = count
+	}
+	return counts, nil
+}
+
+// sendUnreadCounts tells a client that just connected what its user missed
+func (h *Hub) sendUnreadCounts(c *Client) {
+	if h.redis == nil || c.Username == "" {
+		return
+	}
+	counts, err := h.unreadCounts(c.Username, nil)
+	if err != nil {
+		log.Printf("Okunmamış sayıları alınamadı: %v", err)
+		return
+	}
+	if len(counts) == 0 {
+		return
+	}
+	c.sendFrame(UnreadCountsFrame{Type: "unread_counts", Channels: counts, Timestamp: time.Now()}, PriorityNormal)
+}
+
+// handleMarkRead moves the sender's last-read marker of a channel forward and
+// tells every connection of the user the channel's new counts
+func (h *Hub) handleMarkRead(c *Client, raw []byte) {
+	var req MarkReadRequest
+	if err := json.Unmarshal(raw, &req); err != nil || c.Username == "" || h.redis == nil {
+		return
+	}
+	if !h.canJoin(c, req.Channel) {
+		c.sendError(ErrNotMember, "Bu konuşmanın üyesi değilsiniz")
+		return
+	}
+	ctx := context.Background()
+	channel, seq, ok := h.locateMessage(ctx, req.MessageID)
+	if !ok || channel != req.Channel {
+		c.sendError(ErrInvalidReadMarker, "Okundu işareti için mesaj bulunamadı")
+		return
+	}
+
+	key := readMarkersKey(c.Username)
+	var current ReadMarker
+	if raw, err := h.redis.HGet(ctx, key, req.Channel).Bytes(); err == nil {
+		json.Unmarshal(raw, &current)
+	}
+	if seq > current.Seq {
+		data, _ := json.Marshal(ReadMarker{MessageID: req.MessageID, Seq: seq, At: time.Now()})
+		pipe := h.redis.TxPipeline()
+		pipe.HSet(ctx, key, req.Channel, data)
+		pipe.HDel(ctx, unreadKey(c.Username), req.Channel)
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("Okundu işareti kaydedilemedi: %v", err)
+			return
+		}
+	}
+
+	counts, err := h.unreadCounts(c.Username, []string{req.Channel})
+	if err != nil {
+		log.Printf("Okunmamış sayıları alınamadı: %v", err)
+		return
+	}
+	h.notifyUser(c.Username, UnreadCountsFrame{Type: "unread_counts", Channels: counts, Timestamp: time.Now()}, PriorityNormal)
+}
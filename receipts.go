@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Receipts are buffered and written in one pipeline per flush, so a burst of
+// receipts costs a handful of round trips instead of a list scan per receipt.
+// A message has two receipt states per recipient: delivered, recorded by the
+// server once a frame carrying it was written to one of the user's connections,
+// and read, sent by the client as a seen frame. Reading implies delivery.
+//
+// Receipts are only recorded for messages in the channel's history, looked up
+// in an index of its message keys kept and trimmed along with the list, and
+// receipts of messages that left the history are removed at most once per
+// RECEIPT_PRUNE_INTERVAL, so the hashes stay as large as the history they
+// describe.
+var (
+	receiptFlushInterval = envDuration("RECEIPT_FLUSH_INTERVAL", 50*time.Millisecond)
+	receiptBatchSize     = envInt("RECEIPT_BATCH_SIZE", 500)
+	receiptPruneInterval = envDuration("RECEIPT_PRUNE_INTERVAL", time.Minute)
+)
+
+// messageReceipt is one user having received or seen one message
+type messageReceipt struct {
+	Channel   string
+	Message   string
+	Username  string
+	At        time.Time
+	Delivered bool      // delivered rather than read
+	Author    string    // delivered only: gets a delivered frame when the receipt is new
+	Sent      time.Time // seen only: timestamp of the message, echoed in the seen frame
+}
+
+// Receipts of a channel live in one hash with "<message key>:<username>" fields
+// holding the time the message was seen
+func receiptsKey(channel string) string {
+	return "websocket:receipts:" + channel
+}
+
+// Delivered receipts of a channel, in the same layout as the seen receipts
+func deliveredKey(channel string) string {
+	return "websocket:delivered:" + channel
+}
+
+// Message keys in a channel's history, scored by timestamp in milliseconds
+func messageIndexKey(channel string) string {
+	return "websocket:message:ids:" + channel
+}
+
+// indexMessage adds a stored message to its channel's index, trimmed to the
+// history list's length
+func indexMessage(ctx context.Context, pipe redis.Pipeliner, msg Message) {
+	key := messageIndexKey(msg.Channel)
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(msg.Timestamp.UnixMilli()), Member: messageKey(msg.MessageID, msg.Timestamp)})
+	pipe.ZRemRangeByRank(ctx, key, 0, -historyLength-1)
+	pipe.Expire(ctx, key, 24*time.Hour)
+}
+
+// messageKey identifies a stored message within its channel: its server-assigned
+// ID, or the second of its timestamp for messages stored before IDs existed
+func messageKey(id string, t time.Time) string {
+	if id != "" {
+		return id
+	}
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// markMessageSeen queues a receipt for the next batched write; the channel is
+// told once the receipt was recorded. Without Redis the seen update is only
+// broadcast.
+func (h *Hub) markMessageSeen(channel, messageID string, sent time.Time, username string) {
+	receipt := messageReceipt{
+		Channel:  channel,
+		Message:  messageKey(messageID, sent),
+		Username: username,
+		At:       time.Now(),
+		Sent:     sent,
+	}
+	if h.redis == nil {
+		h.broadcastSeen(receipt)
+		return
+	}
+	h.queueReceipt(receipt)
+}
+
+// broadcastSeen tells a channel that a user has seen one of its messages
+func (h *Hub) broadcastSeen(receipt messageReceipt) {
+	seen := SeenFrame{Type: "seen", Channel: receipt.Channel, Timestamp: receipt.Sent, Username: receipt.Username}
+	if isULID(receipt.Message) {
+		seen.MessageID = receipt.Message
+	}
+	frame, _ := encodeFrame(seen)
+	h.sendToChannel(receipt.Channel, frame, PriorityNormal, nil)
+}
+
+// markMessageDelivered queues a delivered receipt of a message written to one
+// of the user's connections
+func (h *Hub) markMessageDelivered(channel, messageID, author, username string) {
+	if h.redis == nil || author == username {
+		return
+	}
+	h.queueReceipt(messageReceipt{
+		Channel:   channel,
+		Message:   messageID,
+		Username:  username,
+		At:        time.Now(),
+		Delivered: true,
+		Author:    author,
+	})
+}
+
+// deliveredMessage identifies the chat message carried by an outgoing frame
+type deliveredMessage struct {
+	MessageID string `json:"messageId"`
+	Channel   string `json:"channel"`
+	Username  string `json:"username"`
+	TraceID   string `json:"traceId"`
+}
+
+var (
+	messageFramePrefix   = []byte(`{"type":"message"`)
+	mediaStubFramePrefix = []byte(`{"type":"media_stub"`)
+)
+
+// collectDelivered adds the chat message of a frame taken from the normal lane
+// to list. Frames are recognized by their envelope prefix, so other traffic
+// isn't decoded.
+func (c *Client) collectDelivered(list []deliveredMessage, frame []byte, lane chan []byte) []deliveredMessage {
+	if lane != c.Send || c.Username == "" {
+		return list
+	}
+	if !bytes.HasPrefix(frame, messageFramePrefix) && !bytes.HasPrefix(frame, mediaStubFramePrefix) {
+		return list
+	}
+	var env struct {
+		Payload deliveredMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(frame, &env); err != nil || !isULID(env.Payload.MessageID) {
+		return list
+	}
+	return append(list, env.Payload)
+}
+
+func (h *Hub) queueReceipt(receipt messageReceipt) {
+	select {
+	case h.receipts <- receipt:
+	default:
+		log.Printf("Okundu kuyruğu dolu, bilgi atlandı: %s", receipt.Channel)
+	}
+}
+
+// runReceiptWriter flushes queued receipts when the batch is full or the flush
+// interval has passed
+func (h *Hub) runReceiptWriter() {
+	if h.redis == nil {
+		return
+	}
+	ticker := time.NewTicker(receiptFlushInterval)
+	defer ticker.Stop()
+	batch := make([]messageReceipt, 0, receiptBatchSize)
+	for {
+		select {
+		case receipt := <-h.receipts:
+			batch = append(batch, receipt)
+			if len(batch) < receiptBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		h.flushReceipts(batch)
+		batch = batch[:0]
+	}
+}
+
+// flushReceipts writes a batch in one pipeline, tells authors about new
+// deliveries and channels about new seen receipts, and invalidates the history
+// snapshots of channels that got new receipts. Receipts of messages that aren't
+// in the channel's history are dropped.
+func (h *Hub) flushReceipts(batch []messageReceipt) {
+	ctx := context.Background()
+	history := h.storedMessages(ctx, batch)
+	if history == nil {
+		return
+	}
+	pipe := h.redis.Pipeline()
+	added := make([]*redis.BoolCmd, len(batch))
+	ttls := make(map[string]*redis.DurationCmd)
+	for i, receipt := range batch {
+		if !history[receipt.Channel][receipt.Message] {
+			continue
+		}
+		key := receiptsKey(receipt.Channel)
+		if receipt.Delivered {
+			key = deliveredKey(receipt.Channel)
+		}
+		added[i] = pipe.HSetNX(ctx, key, receipt.Message+":"+receipt.Username, receipt.At.UnixNano())
+		if ttls[key] == nil {
+			ttls[key] = pipe.TTL(ctx, key)
+		}
+		// Reading a channel clears its unread mentions
+		if !receipt.Delivered {
+			pipe.HDel(ctx, unreadKey(receipt.Username), receipt.Channel)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Okundu bilgisi kaydetme hatası: %v", err)
+		return
+	}
+	h.expireNewReceipts(ctx, ttls)
+
+	changed := make(map[string]bool)
+	for i, cmd := range added {
+		if cmd == nil || !cmd.Val() {
+			continue
+		}
+		receipt := batch[i]
+		changed[receipt.Channel] = true
+		if receipt.Delivered {
+			h.notifyUser(receipt.Author, DeliveredFrame{
+				Type:      "delivered",
+				Channel:   receipt.Channel,
+				MessageID: receipt.Message,
+				Username:  receipt.Username,
+				Timestamp: receipt.At,
+			}, PriorityLow)
+		} else {
+			h.broadcastSeen(receipt)
+		}
+	}
+	for channel := range changed {
+		h.invalidateSnapshot(channel)
+	}
+	h.pruneReceipts(ctx, history)
+}
+
+// storedMessages reports per channel which messages of the batch are in the
+// history, one index lookup per message. It returns nil when Redis fails.
+func (h *Hub) storedMessages(ctx context.Context, batch []messageReceipt) map[string]map[string]bool {
+	pipe := h.redis.Pipeline()
+	listed := make(map[string]*redis.IntCmd)
+	indexed := make(map[string]*redis.IntCmd)
+	scores := make(map[string]map[string]*redis.FloatCmd)
+	for _, receipt := range batch {
+		if scores[receipt.Channel] == nil {
+			listed[receipt.Channel] = pipe.LLen(ctx, "websocket:messages:"+receipt.Channel)
+			indexed[receipt.Channel] = pipe.ZCard(ctx, messageIndexKey(receipt.Channel))
+			scores[receipt.Channel] = make(map[string]*redis.FloatCmd)
+		}
+		if scores[receipt.Channel][receipt.Message] == nil {
+			scores[receipt.Channel][receipt.Message] = pipe.ZScore(ctx, messageIndexKey(receipt.Channel), receipt.Message)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		log.Printf("Okundu bilgisi kaydetme hatası: %v", err)
+		return nil
+	}
+	stored := make(map[string]map[string]bool, len(scores))
+	for channel, messages := range scores {
+		if indexed[channel].Val() < listed[channel].Val() {
+			// Messages stored before the index existed
+			keys, err := h.indexHistory(ctx, channel)
+			if err != nil {
+				log.Printf("Okundu bilgisi kaydetme hatası: %v", err)
+				return nil
+			}
+			stored[channel] = make(map[string]bool, len(messages))
+			for message := range messages {
+				stored[channel][message] = keys[message]
+			}
+			continue
+		}
+		stored[channel] = make(map[string]bool, len(messages))
+		for message, score := range messages {
+			stored[channel][message] = score.Err() == nil
+		}
+	}
+	return stored
+}
+
+// indexHistory builds the index of a channel's history from the list and
+// returns its message keys
+func (h *Hub) indexHistory(ctx context.Context, channel string) (map[string]bool, error) {
+	list, err := h.redis.LRange(ctx, "websocket:messages:"+channel, 0, -1).Result()
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	keys := make(map[string]bool, len(list))
+	pipe := h.redis.Pipeline()
+	for _, raw := range list {
+		var msg Message
+		if json.Unmarshal([]byte(raw), &msg) == nil {
+			keys[messageKey(msg.MessageID, msg.Timestamp)] = true
+			indexMessage(ctx, pipe, msg)
+		}
+	}
+	_, err = pipe.Exec(ctx)
+	return keys, err
+}
+
+// indexedMessages reads the message keys of a channel's history
+func (h *Hub) indexedMessages(ctx context.Context, channel string) (map[string]bool, error) {
+	members, err := h.redis.ZRange(ctx, messageIndexKey(channel), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool, len(members))
+	for _, member := range members {
+		keys[member] = true
+	}
+	return keys, nil
+}
+
+// expireNewReceipts gives receipt hashes created by a flush the lifetime of the
+// history; after that renewReceipts renews it along with the history
+func (h *Hub) expireNewReceipts(ctx context.Context, ttls map[string]*redis.DurationCmd) {
+	pipe := h.redis.Pipeline()
+	for key, ttl := range ttls {
+		if ttl.Val() < 0 {
+			pipe.Expire(ctx, key, 24*time.Hour)
+		}
+	}
+	if pipe.Len() > 0 {
+		pipe.Exec(ctx)
+	}
+}
+
+// renewReceipts keeps the receipts of a channel as long as its history
+func renewReceipts(ctx context.Context, pipe redis.Pipeliner, channel string) {
+	pipe.Expire(ctx, receiptsKey(channel), 24*time.Hour)
+	pipe.Expire(ctx, deliveredKey(channel), 24*time.Hour)
+}
+
+// pruneReceipts removes the receipts of messages that left the history of the
+// given channels, at most once per receiptPruneInterval per channel
+func (h *Hub) pruneReceipts(ctx context.Context, history map[string]map[string]bool) {
+	now := time.Now()
+	for channel := range history {
+		if now.Sub(h.receiptsPruned[channel]) < receiptPruneInterval {
+			continue
+		}
+		h.receiptsPruned[channel] = now
+		stored, err := h.indexedMessages(ctx, channel)
+		if err != nil {
+			continue
+		}
+		for _, key := range []string{receiptsKey(channel), deliveredKey(channel)} {
+			h.pruneReceiptHash(ctx, key, stored)
+		}
+	}
+}
+
+// pruneReceiptHash deletes the fields of a receipt hash whose message isn't stored
+func (h *Hub) pruneReceiptHash(ctx context.Context, key string, stored map[string]bool) {
+	var stale []string
+	iter := h.redis.HScan(ctx, key, 0, "", 1000).Iterator()
+	for iter.Next(ctx) {
+		field := iter.Val()
+		if !iter.Next(ctx) {
+			break
+		}
+		if message, _, _ := strings.Cut(field, ":"); !stored[message] {
+			stale = append(stale, field)
+		}
+	}
+	if iter.Err() != nil || len(stale) == 0 {
+		return
+	}
+	if err := h.redis.HDel(ctx, key, stale...).Err(); err != nil {
+		log.Printf("Okundu bilgisi temizleme hatası: %v", err)
+	}
+}
+
+// receiptSet holds who received and who has seen each message of a channel, in
+// the order they did
+type receiptSet struct {
+	Delivered map[string][]string
+	Seen      map[string][]string
+}
+
+// channelReceipts reads both receipt states of a channel's messages
+func (h *Hub) channelReceipts(channel string) receiptSet {
+	return receiptSet{
+		Delivered: h.receiptUsers(deliveredKey(channel)),
+		Seen:      h.receiptUsers(receiptsKey(channel)),
+	}
+}
+
+// receiptUsers returns the users in a receipt hash per message, ordered by time
+func (h *Hub) receiptUsers(key string) map[string][]string {
+	fields, err := h.redis.HGetAll(context.Background(), key).Result()
+	if err != nil || len(fields) == 0 {
+		return nil
+	}
+	type seen struct {
+		user string
+		at   int64
+	}
+	byMessage := make(map[string][]seen)
+	for field, value := range fields {
+		message, user, ok := strings.Cut(field, ":")
+		if !ok {
+			continue
+		}
+		at, _ := strconv.ParseInt(value, 10, 64)
+		byMessage[message] = append(byMessage[message], seen{user, at})
+	}
+
+	receipts := make(map[string][]string, len(byMessage))
+	for message, list := range byMessage {
+		sort.Slice(list, func(i, j int) bool { return list[i].at < list[j].at })
+		users := make([]string, len(list))
+		for i, s := range list {
+			users[i] = s.user
+		}
+		receipts[message] = users
+	}
+	return receipts
+}
+
+// applyReceipts merges stored receipts into the DeliveredTo and SeenBy lists of
+// messages; users who have seen a message count as delivered too
+func applyReceipts(messages []Message, receipts receiptSet) {
+	for i := range messages {
+		msg := &messages[i]
+		key := messageKey(msg.MessageID, msg.Timestamp)
+		for _, user := range receipts.Seen[key] {
+			if !containsString(msg.SeenBy, user) {
+				msg.SeenBy = append(msg.SeenBy, user)
+			}
+		}
+		for _, users := range [][]string{receipts.Delivered[key], msg.SeenBy} {
+			for _, user := range users {
+				if user != msg.Username && !containsString(msg.DeliveredTo, user) {
+					msg.DeliveredTo = append(msg.DeliveredTo, user)
+				}
+			}
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
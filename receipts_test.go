@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// benchmarkHub connects to REDIS_ADDR, skipping the benchmark without Redis
+func benchmarkHub(b *testing.B) *Hub {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		b.Skipf("Redis not reachable at %s: %v", addr, err)
+	}
+	b.Cleanup(func() { rdb.Close() })
+	return &Hub{
+		redis:          rdb,
+		clients:        make(map[*Client]bool),
+		receiptsPruned: make(map[string]time.Time),
+	}
+}
+
+// receiptStorm stores a full history in channel and returns a seen receipt of
+// every user for every message, as when a busy channel is opened by everyone
+func receiptStorm(b *testing.B, h *Hub, channel string, users int) []messageReceipt {
+	ctx := context.Background()
+	key := "websocket:messages:" + channel
+	var batch []messageReceipt
+	for i := 0; i < 100; i++ {
+		msg := Message{Username: "author", Message: "hello", Channel: channel, Timestamp: time.Now(), MessageID: newULID()}
+		data, _ := json.Marshal(msg)
+		h.redis.LPush(ctx, key, data)
+		for u := 0; u < users; u++ {
+			batch = append(batch, messageReceipt{
+				Channel:  channel,
+				Message:  msg.MessageID,
+				Username: fmt.Sprintf("user%d", u),
+				At:       time.Now(),
+				Sent:     msg.Timestamp,
+			})
+		}
+	}
+	b.Cleanup(func() {
+		h.redis.Del(ctx, key, messageIndexKey(channel), receiptsKey(channel), snapshotVersionKey(channel))
+		h.redis.SRem(ctx, snapshotDirtyKey, channel)
+		for u := 0; u < users; u++ {
+			h.redis.HDel(ctx, unreadKey(fmt.Sprintf("user%d", u)), channel)
+		}
+	})
+	return batch
+}
+
+// BenchmarkFlushReceipts writes a storm of seen receipts in batches of
+// RECEIPT_BATCH_SIZE, and one receipt per flush for comparison
+func BenchmarkFlushReceipts(b *testing.B) {
+	for _, size := range []int{1, receiptBatchSize} {
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			h := benchmarkHub(b)
+			channel := "bench-receipts-" + newULID()
+			storm := receiptStorm(b, h, channel, 20)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.redis.Del(context.Background(), receiptsKey(channel))
+				for start := 0; start < len(storm); start += size {
+					end := start + size
+					if end > len(storm) {
+						end = len(storm)
+					}
+					h.flushReceipts(storm[start:end])
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(storm)), "ns/receipt")
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// History can be read from a Redis replica at REDIS_REPLICA_ADDR, so joining
+// and reconnecting clients don't load the primary that takes the writes. The
+// history snapshots and the missed messages of resumes are read from it; the
+// snapshots are still rebuilt from the primary, so a stale replica never ends
+// up in a cache. Every REDIS_REPLICA_CHECK_INTERVAL the primary gets a
+// heartbeat, and the replica is only read while the heartbeat reaches it
+// within REDIS_REPLICA_MAX_LAG. A channel this instance wrote to reads from
+// the primary for REDIS_REPLICA_MAX_LAG afterwards, so senders see their own
+// messages.
+
+var (
+	replicaAddr          = envString("REDIS_REPLICA_ADDR", "")
+	replicaMaxLag        = envDuration("REDIS_REPLICA_MAX_LAG", time.Second)
+	replicaCheckInterval = envDuration("REDIS_REPLICA_CHECK_INTERVAL", 5*time.Second)
+)
+
+// Unix nanoseconds of the last heartbeat written to the primary
+const replicaHeartbeatKey = "websocket:replica:heartbeat"
+
+// History reads, per "primary" and "replica"
+var historyReads = expvar.NewMap("history_reads")
+
+// newReplica connects to the read replica, nil when none is set or it can't
+// be reached
+func newReplica() *redis.Client {
+	if replicaAddr == "" {
+		return nil
+	}
+	replica := redis.NewClient(&redis.Options{
+		Addr:     replicaAddr,
+		Password: "",
+		DB:       0,
+	})
+	if err := replica.Ping(context.Background()).Err(); err != nil {
+		log.Printf("Redis okuma kopyasına bağlanılamadı, geçmiş birincilden okunacak: %v", err)
+		return nil
+	}
+	log.Printf("Redis okuma kopyası kullanılıyor: %s", replicaAddr)
+	return replica
+}
+
+// historyReader returns where history of a channel is read from: the replica
+// while it keeps up, unless the channel was written to here within the lag
+func (h *Hub) historyReader(channel string) *redis.Client {
+	if h.replica == nil || !h.replicaHealthy.Load() {
+		historyReads.Add("primary", 1)
+		return h.redis
+	}
+	if at, ok := h.recentWrites.Load(channel); ok && time.Since(at.(time.Time)) < replicaMaxLag {
+		historyReads.Add("primary", 1)
+		return h.redis
+	}
+	historyReads.Add("replica", 1)
+	return h.replica
+}
+
+// markWritten sends reads of a channel to the primary for the allowed lag
+func (h *Hub) markWritten(channel string) {
+	if h.replica != nil {
+		h.recentWrites.Store(channel, time.Now())
+	}
+}
+
+// runReplicaCheck writes heartbeats to the primary and reads them back from the
+// replica, which is used while they arrive within REDIS_REPLICA_MAX_LAG
+func (h *Hub) runReplicaCheck() {
+	if h.redis == nil || h.replica == nil {
+		return
+	}
+	ctx := context.Background()
+	for {
+		sent := time.Now().UnixNano()
+		if err := h.redis.Set(ctx, replicaHeartbeatKey, sent, 0).Err(); err != nil {
+			log.Printf("Okuma kopyası sinyali yazılamadı: %v", err)
+		}
+		time.Sleep(replicaMaxLag)
+
+		received, _ := h.replica.Get(ctx, replicaHeartbeatKey).Int64()
+		healthy := received >= sent
+		if h.replicaHealthy.Swap(healthy) != healthy {
+			if healthy {
+				log.Printf("Okuma kopyası yetişti, geçmiş okuma kopyasından okunuyor")
+			} else {
+				log.Printf("Okuma kopyası %s içinde yetişmedi, geçmiş birincilden okunuyor", replicaMaxLag)
+			}
+		}
+
+		h.recentWrites.Range(func(channel, at any) bool {
+			if time.Since(at.(time.Time)) >= replicaMaxLag {
+				h.recentWrites.Delete(channel)
+			}
+			return true
+		})
+		time.Sleep(max(replicaCheckInterval-replicaMaxLag, 0))
+	}
+}
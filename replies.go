@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Longer originals are quoted shortened
+const replySnippetRunes = 200
+
+// replySnippet shortens the quoted text of an original message
+func replySnippet(msg Message) string {
+	text := msg.Message
+	if text == "" {
+		text = msg.FileName
+	}
+	if utf8.RuneCountInString(text) > replySnippetRunes {
+		text = string([]rune(text)[:replySnippetRunes]) + "…"
+	}
+	return text
+}
+
+// resolveReply replaces the quote a client sent with the stored original, so
+// replies can't put words in someone else's mouth. Replies to messages that
+// can't be found in the channel history are sent without a quote.
+func (h *Hub) resolveReply(channel string, reply *ReplyInfo) *ReplyInfo {
+	if reply == nil || h.redis == nil || !isULID(reply.MessageID) {
+		return nil
+	}
+	stored, err := h.redis.LRange(context.Background(), fmt.Sprintf("websocket:messages:%s", channel), 0, -1).Result()
+	if err != nil {
+		log.Printf("Yanıtlanan mesaj okunamadı: %v", err)
+		return nil
+	}
+	for _, raw := range stored {
+		var original Message
+		if err := json.Unmarshal([]byte(raw), &original); err != nil || original.MessageID != reply.MessageID {
+			continue
+		}
+		return &ReplyInfo{
+			MessageID: original.MessageID,
+			Username:  original.Username,
+			Message:   replySnippet(original),
+			Type:      original.Type,
+		}
+	}
+	return nil
+}
+
+const (
+	// Surrounding messages returned by the context endpoint, by default and at most
+	contextDefaultMessages = 5
+	contextMaxMessages     = 50
+	// Quoted replies followed back from a message
+	replyChainMaxDepth = 10
+)
+
+// MessageContext is the /api/messages/{id}/context response. Ancestors are the
+// messages quoted by the reply chain, nearest first.
+type MessageContext struct {
+	Message   Message   `json:"message"`
+	Before    []Message `json:"before"`
+	After     []Message `json:"after"`
+	Ancestors []Message `json:"ancestors"`
+}
+
+// Channel and sequence of a stored message as "<seq>:<channel>", kept as long
+// as the resume log
+func messageLocationKey(id string) string {
+	return "websocket:message:location:" + id
+}
+
+// locateMessage finds the channel and sequence number of a stored message
+func (h *Hub) locateMessage(ctx context.Context, id string) (string, int64, bool) {
+	location, err := h.redis.Get(ctx, messageLocationKey(id)).Result()
+	if err != nil {
+		return "", 0, false
+	}
+	rawSeq, channel, ok := strings.Cut(location, ":")
+	seq, err := strconv.ParseInt(rawSeq, 10, 64)
+	return channel, seq, ok && err == nil
+}
+
+// storedMessage loads a message of channel from the resume log
+func (h *Hub) storedMessage(ctx context.Context, channel, id string) (Message, bool) {
+	located, seq, ok := h.locateMessage(ctx, id)
+	if !ok || located != channel {
+		return Message{}, false
+	}
+	stored, err := h.messagesBySeq(ctx, channel, seq, seq)
+	if err != nil || len(stored) == 0 || stored[0].MessageID != id {
+		return Message{}, false
+	}
+	return stored[0], true
+}
+
+// messagesBySeq reads the messages of a channel's resume log between two
+// sequence numbers, inclusive
+func (h *Hub) messagesBySeq(ctx context.Context, channel string, from, to int64) ([]Message, error) {
+	stored, err := h.redis.ZRangeByScore(ctx, resumeLogKey(channel), &redis.ZRangeBy{
+		Min: strconv.FormatInt(from, 10),
+		Max: strconv.FormatInt(to, 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]Message, 0, len(stored))
+	for _, raw := range stored {
+		var msg Message
+		if err := json.Unmarshal([]byte(raw), &msg); err == nil {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+// messageContext loads a message with its neighbours and reply ancestors; false
+// when the message is no longer stored
+func (h *Hub) messageContext(id string, before, after int) (MessageContext, bool, error) {
+	ctx := context.Background()
+	channel, seq, ok := h.locateMessage(ctx, id)
+	if !ok {
+		return MessageContext{}, false, nil
+	}
+	window, err := h.messagesBySeq(ctx, channel, seq-int64(before), seq+int64(after))
+	if err != nil {
+		return MessageContext{}, false, err
+	}
+	result := MessageContext{Before: []Message{}, After: []Message{}, Ancestors: []Message{}}
+	found := false
+	for _, msg := range window {
+		switch {
+		case msg.Seq < seq:
+			result.Before = append(result.Before, msg)
+		case msg.Seq > seq:
+			result.After = append(result.After, msg)
+		default:
+			result.Message, found = msg, true
+		}
+	}
+	if !found {
+		return MessageContext{}, false, nil
+	}
+
+	// Follow the quotes back while the originals are still stored
+	seen := map[string]bool{id: true}
+	for reply := result.Message.ReplyTo; reply != nil && len(result.Ancestors) < replyChainMaxDepth; {
+		if seen[reply.MessageID] {
+			break
+		}
+		seen[reply.MessageID] = true
+		ancestorChannel, ancestorSeq, ok := h.locateMessage(ctx, reply.MessageID)
+		if !ok {
+			break
+		}
+		ancestors, err := h.messagesBySeq(ctx, ancestorChannel, ancestorSeq, ancestorSeq)
+		if err != nil || len(ancestors) == 0 {
+			break
+		}
+		result.Ancestors = append(result.Ancestors, ancestors[0])
+		reply = ancestors[0].ReplyTo
+	}
+
+	current := []Message{result.Message}
+	receipts, reactions := h.channelReceipts(channel), h.channelReactions(channel)
+	threads := h.channelThreadCounts(channel)
+	for _, messages := range [][]Message{result.Before, current, result.After} {
+		applyReceipts(messages, receipts)
+		applyReactions(messages, reactions)
+		applyThreadCounts(messages, threads)
+		h.applyPolls(messages)
+	}
+	result.Message = current[0]
+	return result, true, nil
+}
+
+// handleMessageAPI routes /api/messages/{id}[/{resource}]
+func handleMessageAPI(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/messages/"), "/"), "/")
+	if len(parts) > 2 || !isULID(parts[0]) {
+		http.NotFound(w, r)
+		return
+	}
+	id, resource := parts[0], ""
+	if len(parts) == 2 {
+		resource = parts[1]
+	}
+	if hub.redis != nil {
+		if channel, _, ok := hub.locateMessage(r.Context(), id); ok && isConversation(channel) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	switch resource {
+	case "":
+		handleMessage(hub, id, w, r)
+	case "context":
+		handleMessageContext(hub, id, w, r)
+	case "history":
+		handleMessageHistory(hub, id, w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleMessageContext serves GET /api/messages/{id}/context?before=N&after=N
+func handleMessageContext(hub *Hub, id string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	before, after := contextDefaultMessages, contextDefaultMessages
+	if n, err := strconv.Atoi(r.URL.Query().Get("before")); err == nil && n >= 0 {
+		before = min(n, contextMaxMessages)
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("after")); err == nil && n >= 0 {
+		after = min(n, contextMaxMessages)
+	}
+	if hub.redis == nil {
+		http.Error(w, "History unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	result, ok, err := hub.messageContext(id, before, after)
+	if err != nil {
+		log.Printf("Mesaj bağlamı okunamadı: %v", err)
+		http.Error(w, "History unavailable", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleMessage serves GET /api/messages/{id}, e.g. the full message behind a media stub
+func handleMessage(hub *Hub, id string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if hub.redis == nil {
+		http.Error(w, "History unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	result, ok, err := hub.messageContext(id, 0, 0)
+	if err != nil {
+		log.Printf("Mesaj okunamadı: %v", err)
+		http.Error(w, "History unavailable", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, result.Message)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Channels can review first messages: with firstMessageReview set to N in a
+// channel's settings, messages from an account first seen less than
+// REVIEW_ACCOUNT_AGE ago are held until N of its messages in the channel were
+// approved. A held message isn't stored or delivered; its author and the
+// channel's connected moderators get it with "pending":true, and the author's
+// ack says "held". A moderator sends {"type":"review","channel":"...",
+// "messageId":"...","approve":true} to let it through, as if it was just
+// sent, or approve:false to drop it; either way the author and the moderators
+// get a review frame. {"type":"review_queue","channel":"..."} lists the held
+// messages. Reviewing needs Redis. Accounts are dated from the first connect
+// the server saw, so existing users count as new for REVIEW_ACCOUNT_AGE after
+// the feature is turned on.
+
+var reviewAccountAge = envDuration("REVIEW_ACCOUNT_AGE", 72*time.Hour)
+
+const maxFirstMessageReview = 100
+
+// When usernames first connected, username -> RFC 3339 time
+const firstSeenKey = "websocket:user:first_seen"
+
+// Held messages of a channel, message ID -> JSON Message
+func reviewQueueKey(channel string) string {
+	return "websocket:review:" + channel
+}
+
+// Approved messages of new users in a channel, username -> count
+func reviewApprovedKey(channel string) string {
+	return "websocket:review:approved:" + channel
+}
+
+// ReviewRequest is the inbound {"type":"review","channel":"...","messageId":"...","approve":true} frame
+type ReviewRequest struct {
+	Type      string `json:"type"`
+	Channel   string `json:"channel"`
+	MessageID string `json:"messageId"`
+	Approve   bool   `json:"approve"`
+}
+
+// ReviewQueueRequest is the inbound {"type":"review_queue","channel":"..."} frame
+type ReviewQueueRequest struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+}
+
+// ReviewFrame tells the author and the moderators how a held message was reviewed
+type ReviewFrame struct {
+	Type      string    `json:"type"`
+	Channel   string    `json:"channel"`
+	MessageID string    `json:"messageId"`
+	Approved  bool      `json:"approved"`
+	Moderator string    `json:"moderator"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ReviewQueueFrame lists the held messages of a channel, oldest first
+type ReviewQueueFrame struct {
+	Type     string    `json:"type"`
+	Channel  string    `json:"channel"`
+	Messages []Message `json:"messages"`
+}
+
+// recordFirstSeen dates a username the first time it connects
+func (h *Hub) recordFirstSeen(username string) {
+	if h.redis == nil || username == "" {
+		return
+	}
+	h.redis.HSetNX(context.Background(), firstSeenKey, username, time.Now().UTC().Format(time.RFC3339))
+}
+
+// needsReview reports whether a message of username in channel is held when
+// the channel reviews the first limit messages of new accounts
+func (h *Hub) needsReview(channel, username string, limit int) bool {
+	ctx := context.Background()
+	raw, err := h.redis.HGet(ctx, firstSeenKey, username).Result()
+	if err != nil {
+		return false // unknown to the server, like bots
+	}
+	firstSeen, err := time.Parse(time.RFC3339, raw)
+	if err != nil || time.Since(firstSeen) >= reviewAccountAge {
+		return false
+	}
+	approved, err := h.redis.HGet(ctx, reviewApprovedKey(channel), username).Int()
+	if err != nil && err != redis.Nil {
+		return false
+	}
+	return approved < limit && !h.isModerator(channel, username)
+}
+
+// reviewStage holds the messages of new accounts in channels that review them
+func reviewStage(h *Hub, m *pipelineMessage) bool {
+	if m.in.reviewed || h.redis == nil || m.Msg.Type == "system" || m.Msg.Message == "__GET_RECENT_MESSAGES__" {
+		return true
+	}
+	limit := h.channelSettings(m.Msg.Channel).FirstMessageReview
+	if limit <= 0 || !h.needsReview(m.Msg.Channel, m.Msg.Username, limit) {
+		return true
+	}
+	data, err := json.Marshal(m.Msg)
+	if err != nil {
+		return false
+	}
+	if err := h.redis.HSet(context.Background(), reviewQueueKey(m.Msg.Channel), m.Msg.MessageID, data).Err(); err != nil {
+		log.Printf("Mesaj onaya alınamadı: %v", err)
+		return false
+	}
+	log.Printf("Mesaj onaya alındı: kullanıcı=%s, kanal=%s, mesaj=%s", m.Msg.Username, m.Msg.Channel, m.Msg.MessageID)
+	traces.record(m.Msg.TraceID, TraceHeld, "")
+	h.acknowledge(m.in, m.Msg.Channel, m.Msg.MessageID, "held", false)
+
+	pending := m.Msg
+	pending.Pending = true
+	frame, err := encodeFrame(pending)
+	if err != nil {
+		return false
+	}
+	for _, client := range h.reviewAudience(m.Msg.Channel, m.Msg.Username) {
+		client.enqueue(frame, PriorityNormal)
+	}
+	return false
+}
+
+// reviewAudience returns the connections of a held message's author and of
+// the channel's moderators
+func (h *Hub) reviewAudience(channel, author string) []*Client {
+	h.mutex.RLock()
+	var authors, others []*Client
+	for client := range h.clients {
+		switch {
+		case client.Username == author:
+			authors = append(authors, client)
+		case client.Username != "" && client.inChannel(channel):
+			others = append(others, client)
+		}
+	}
+	h.mutex.RUnlock()
+	moderators := make(map[string]bool)
+	for _, client := range others {
+		ok, checked := moderators[client.Username]
+		if !checked {
+			ok = h.isModerator(channel, client.Username)
+			moderators[client.Username] = ok
+		}
+		if ok {
+			authors = append(authors, client)
+		}
+	}
+	return authors
+}
+
+// handleReview approves or drops a held message for a moderator of its channel
+func (h *Hub) handleReview(c *Client, raw []byte) {
+	var req ReviewRequest
+	if err := json.Unmarshal(raw, &req); err != nil || c.Username == "" {
+		return
+	}
+	if !h.isModerator(req.Channel, c.Username) {
+		c.sendError(ErrNotModerator, "Mesajları yalnızca kanal moderatörleri onaylayabilir")
+		return
+	}
+	ctx := context.Background()
+	data, err := h.redis.HGet(ctx, reviewQueueKey(req.Channel), req.MessageID).Bytes()
+	var msg Message
+	if err == nil {
+		err = json.Unmarshal(data, &msg)
+	}
+	if err != nil {
+		c.sendError(ErrInvalidReview, "Onay bekleyen böyle bir mesaj yok")
+		return
+	}
+	// Another moderator may have been faster
+	if removed, err := h.redis.HDel(ctx, reviewQueueKey(req.Channel), req.MessageID).Result(); err != nil || removed == 0 {
+		c.sendError(ErrInvalidReview, "Onay bekleyen böyle bir mesaj yok")
+		return
+	}
+	if req.Approve {
+		h.redis.HIncrBy(ctx, reviewApprovedKey(req.Channel), msg.Username, 1)
+	}
+	h.recordAudit("message_reviewed", c.Username, req.Channel, fmt.Sprintf("messageId=%s, approved=%t", req.MessageID, req.Approve))
+
+	// Clients drop the pending copy before the message itself arrives
+	frame, err := encodeFrame(ReviewFrame{
+		Type:      "review",
+		Channel:   req.Channel,
+		MessageID: req.MessageID,
+		Approved:  req.Approve,
+		Moderator: c.Username,
+		Timestamp: time.Now(),
+	})
+	if err == nil {
+		for _, client := range h.reviewAudience(req.Channel, msg.Username) {
+			client.enqueue(frame, PriorityNormal)
+		}
+	}
+	if req.Approve {
+		h.broadcast <- inboundMessage{data: data, traceID: msg.TraceID, reviewed: true}
+	}
+}
+
+// handleReviewQueue lists the held messages of a channel for a moderator
+func (h *Hub) handleReviewQueue(c *Client, raw []byte) {
+	var req ReviewQueueRequest
+	if err := json.Unmarshal(raw, &req); err != nil || c.Username == "" {
+		return
+	}
+	if !h.isModerator(req.Channel, c.Username) {
+		c.sendError(ErrNotModerator, "Onay bekleyen mesajları yalnızca kanal moderatörleri görebilir")
+		return
+	}
+	stored, err := h.redis.HVals(context.Background(), reviewQueueKey(req.Channel)).Result()
+	if err != nil {
+		log.Printf("Onay kuyruğu okunamadı: %v", err)
+		return
+	}
+	frame := ReviewQueueFrame{Type: "review_queue", Channel: req.Channel, Messages: []Message{}}
+	for _, raw := range stored {
+		var msg Message
+		if json.Unmarshal([]byte(raw), &msg) == nil {
+			msg.Pending = true
+			frame.Messages = append(frame.Messages, msg)
+		}
+	}
+	sort.Slice(frame.Messages, func(i, j int) bool {
+		return frame.Messages[i].MessageID < frame.Messages[j].MessageID
+	})
+	c.sendFrame(frame, PriorityNormal)
+}
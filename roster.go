@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+const (
+	rosterDefaultPageSize = 50
+	rosterMaxPageSize     = 200
+)
+
+// RosterEntry is one online user in a roster page
+type RosterEntry struct {
+	Username string   `json:"username"`
+	UserID   string   `json:"userId"`
+	Identity Identity `json:"identity"`
+}
+
+// rosterPage returns up to limit online users sorted by username, starting after
+// cursor, plus the cursor for the next page ("" when there is none)
+func (h *Hub) rosterPage(cursor string, limit int) ([]RosterEntry, string) {
+	if limit <= 0 {
+		limit = rosterDefaultPageSize
+	}
+	limit = min(limit, rosterMaxPageSize)
+
+	h.mutex.RLock()
+	users := make(map[string]string)
+	for client := range h.clients {
+		if client.Username != "" && client.Username > cursor {
+			users[client.Username] = client.ID
+		}
+	}
+	h.mutex.RUnlock()
+
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	next := ""
+	if len(names) > limit {
+		names = names[:limit]
+		next = names[limit-1]
+	}
+	entries := make([]RosterEntry, len(names))
+	for i, name := range names {
+		entries[i] = RosterEntry{Username: name, UserID: users[name], Identity: userIdentity(name)}
+	}
+	return entries, next
+}
+
+// sendRosterPage answers a roster request with a single roster_page frame
+func (h *Hub) sendRosterPage(c *Client, raw []byte) {
+	var req RosterRequest
+	json.Unmarshal(raw, &req)
+
+	entries, next := h.rosterPage(req.Cursor, req.Limit)
+	c.sendFrame(RosterPageFrame{
+		Type:       "roster_page",
+		Users:      entries,
+		Cursor:     req.Cursor,
+		NextCursor: next,
+		Timestamp:  time.Now(),
+	}, PriorityNormal)
+}
+
+// broadcastRosterDiff pushes an incremental roster change to all clients
+func (h *Hub) broadcastRosterDiff(added, removed []RosterEntry) {
+	frame, err := encodeFrame(RosterDiffFrame{Type: "roster_diff", Added: added, Removed: removed, Timestamp: time.Now()})
+	if err != nil {
+		return
+	}
+	h.sendToAll(frame, PriorityLow, nil)
+}
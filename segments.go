@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Text messages with code blocks or spoilers carry their text split into
+// segments, so clients render them without parsing backticks. The text stays
+// the source of truth: segments are derived from the sanitized text, fenced
+// code blocks (```go ... ```) becoming code segments with their language and
+// ||...|| spoilers spoiler segments. A client may send segments instead of
+// markdown; they are validated, written out as canonical text and derived
+// again. Stages that rewrite the text later, like links shortening URLs, derive
+// the segments again too, so text and segments always agree.
+
+const (
+	SegmentText    = "text"
+	SegmentCode    = "code"
+	SegmentSpoiler = "spoiler"
+
+	maxMessageSegments = 50
+)
+
+var (
+	segmentFence    = regexp.MustCompile("(?s)```(.*?)```")
+	segmentSpoiler  = regexp.MustCompile(`(?s)\|\|(.+?)\|\|`)
+	segmentInline   = regexp.MustCompile("`[^`\n]+`")
+	segmentLanguage = regexp.MustCompile(`^[a-z0-9][a-z0-9+#._-]{0,19}$`)
+
+	errInvalidSegments = errors.New("invalid message segments")
+)
+
+// MessageSegment is one part of a formatted text message
+type MessageSegment struct {
+	Type     string `json:"type"`               // "text", "code" or "spoiler"
+	Text     string `json:"text"`               // markdown for text and spoilers, verbatim for code
+	Language string `json:"language,omitempty"` // code only, lowercase
+}
+
+// parseSegments splits a sanitized text into segments; nil when it has no
+// code block or spoiler
+func parseSegments(text string) []MessageSegment {
+	var segments []MessageSegment
+	formatted := false
+	last := 0
+	for _, loc := range segmentFence.FindAllStringSubmatchIndex(text, -1) {
+		segments = appendProse(segments, text[last:loc[0]])
+		segments = append(segments, codeSegment(text[loc[2]:loc[3]]))
+		formatted = true
+		last = loc[1]
+	}
+	segments = appendProse(segments, text[last:])
+	for _, segment := range segments {
+		formatted = formatted || segment.Type == SegmentSpoiler
+	}
+	if !formatted {
+		return nil
+	}
+	return segments
+}
+
+// appendProse adds the text and spoiler segments of text outside code
+// blocks; bars inside inline code don't count
+func appendProse(segments []MessageSegment, prose string) []MessageSegment {
+	inline := segmentInline.FindAllStringIndex(prose, -1)
+	last := 0
+	for _, loc := range segmentSpoiler.FindAllStringSubmatchIndex(prose, -1) {
+		if strings.TrimSpace(prose[loc[2]:loc[3]]) == "" || overlaps(inline, loc[0], loc[2]) || overlaps(inline, loc[3], loc[1]) {
+			continue
+		}
+		if loc[0] > last {
+			segments = append(segments, MessageSegment{Type: SegmentText, Text: prose[last:loc[0]]})
+		}
+		segments = append(segments, MessageSegment{Type: SegmentSpoiler, Text: prose[loc[2]:loc[3]]})
+		last = loc[1]
+	}
+	if last < len(prose) {
+		segments = append(segments, MessageSegment{Type: SegmentText, Text: prose[last:]})
+	}
+	return segments
+}
+
+// codeSegment reads the body of a fenced block; a first line that looks like
+// a language name is the language
+func codeSegment(body string) MessageSegment {
+	segment := MessageSegment{Type: SegmentCode, Text: body}
+	if first, rest, found := strings.Cut(body, "\n"); found {
+		if language := strings.ToLower(strings.TrimSpace(first)); language == "" || segmentLanguage.MatchString(language) {
+			segment.Language, segment.Text = language, rest
+		}
+	}
+	segment.Text = strings.TrimSuffix(segment.Text, "\n")
+	return segment
+}
+
+// composeSegments writes client segments out as canonical text
+func composeSegments(segments []MessageSegment) (string, error) {
+	if len(segments) > maxMessageSegments {
+		return "", errInvalidSegments
+	}
+	var text strings.Builder
+	for _, segment := range segments {
+		switch segment.Type {
+		case SegmentText:
+			text.WriteString(segment.Text)
+		case SegmentSpoiler:
+			if strings.Contains(segment.Text, "||") || strings.TrimSpace(segment.Text) == "" {
+				return "", errInvalidSegments
+			}
+			text.WriteString("||" + segment.Text + "||")
+		case SegmentCode:
+			language := strings.ToLower(segment.Language)
+			if strings.Contains(segment.Text, "```") || (language != "" && !segmentLanguage.MatchString(language)) {
+				return "", errInvalidSegments
+			}
+			text.WriteString("```" + language + "\n" + segment.Text + "\n```")
+		default:
+			return "", errInvalidSegments
+		}
+	}
+	return text.String(), nil
+}
+
+// segmentsStage derives the segments of text messages, from the client's
+// segments when it sent some
+func segmentsStage(h *Hub, m *pipelineMessage) bool {
+	if m.Msg.Type != "text" {
+		m.Msg.Segments = nil
+		return true
+	}
+	if len(m.Msg.Segments) > 0 {
+		text, err := composeSegments(m.Msg.Segments)
+		if err != nil {
+			m.reject(ErrInvalidSegments, "Geçersiz mesaj biçimi")
+			return false
+		}
+		m.Msg.Message = sanitizeMarkdown(text)
+		if m.Msg.Message == "" {
+			m.reject(ErrEmptyMessage, "Mesajda gösterilebilecek bir şey kalmadı")
+			return false
+		}
+		if utf8.RuneCountInString(m.Msg.Message) > maxMessageLength {
+			m.rejectLimit(ErrMessageTooLong, fmt.Sprintf("Mesaj çok uzun (en fazla %d karakter)", maxMessageLength), maxMessageLength)
+			return false
+		}
+	}
+	m.Msg.Segments = parseSegments(m.Msg.Message)
+	return true
+}
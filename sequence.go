@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Every stored message gets a per-channel sequence number. Besides the recent
+// history list, messages are kept in a sorted set by sequence so reconnecting
+// clients can fetch exactly what they missed.
+var (
+	resumeBacklog     = envInt("RESUME_BACKLOG", 1000)
+	resumeMaxMessages = envInt("RESUME_MAX_MESSAGES", 500)
+)
+
+// ResumeRequest is the inbound {"type":"resume","channel":"...","since":N} frame
+type ResumeRequest struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+	Since   int64  `json:"since"`
+}
+
+// ResumedFrame follows the replayed messages. Complete is false when messages
+// after Since were no longer available, or more were missed than are replayed
+// at once; the client should then reload the history instead.
+type ResumedFrame struct {
+	Type      string    `json:"type"`
+	Channel   string    `json:"channel"`
+	Since     int64     `json:"since"`
+	Latest    int64     `json:"latest"`
+	Complete  bool      `json:"complete"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func sequenceKey(channel string) string {
+	return "websocket:seq:" + channel
+}
+
+func resumeLogKey(channel string) string {
+	return "websocket:messages:seq:" + channel
+}
+
+// nextSequence reserves the next sequence number of a channel; 0 without Redis
+func (h *Hub) nextSequence(channel string) int64 {
+	if h.redis == nil {
+		return 0
+	}
+	seq, err := h.redis.Incr(context.Background(), sequenceKey(channel)).Result()
+	if err != nil {
+		log.Printf("Sıra numarası alınamadı: %v", err)
+		return 0
+	}
+	return seq
+}
+
+// appendResumeLog queues the encoded message into the channel's resume log
+func appendResumeLog(ctx context.Context, pipe redis.Pipeliner, msg Message, data []byte) {
+	if msg.Seq == 0 {
+		return
+	}
+	key := resumeLogKey(msg.Channel)
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(msg.Seq), Member: data})
+	pipe.ZRemRangeByRank(ctx, key, 0, int64(-resumeBacklog-1))
+	pipe.Expire(ctx, key, 24*time.Hour)
+}
+
+// handleResume replays the messages of a channel after the given sequence
+func (h *Hub) handleResume(c *Client, raw []byte) {
+	var req ResumeRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.Channel == "" || req.Since < 0 {
+		return
+	}
+	h.resume(c, req)
+}
+
+// resume replays the messages of req.Channel after req.Since from the resume
+// log, followed by a resumed frame
+func (h *Hub) resume(c *Client, req ResumeRequest) {
+	if isConversation(req.Channel) && !c.inChannel(req.Channel) {
+		c.sendError(ErrNotMember, "Bu konuşmanın üyesi değilsiniz")
+		return
+	}
+	done := ResumedFrame{Type: "resumed", Channel: req.Channel, Since: req.Since}
+	if h.redis == nil {
+		done.Timestamp = time.Now()
+		c.sendFrame(done, PriorityNormal)
+		return
+	}
+
+	ctx := context.Background()
+	pipe := h.historyReader(req.Channel).Pipeline()
+	latest := pipe.Get(ctx, sequenceKey(req.Channel))
+	oldest := pipe.ZRangeWithScores(ctx, resumeLogKey(req.Channel), 0, 0)
+	missed := pipe.ZRangeByScore(ctx, resumeLogKey(req.Channel), &redis.ZRangeBy{
+		Min:   "(" + strconv.FormatInt(req.Since, 10),
+		Max:   "+inf",
+		Count: int64(resumeMaxMessages),
+	})
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		log.Printf("Devam etme hatası: %v", err)
+		return
+	}
+	done.Latest, _ = latest.Int64()
+
+	for _, data := range missed.Val() {
+		var msg Message
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			continue
+		}
+		frame, err := encodeFrame(msg)
+		if err != nil {
+			continue
+		}
+		if !c.enqueue(c.filterFrame(frame), PriorityNormal) {
+			log.Printf("İstemci gönderim buffer'ı dolu, devam gönderimi durduruldu")
+			return
+		}
+	}
+
+	// Complete when nothing between Since and the oldest kept message was
+	// trimmed, and the whole gap fit into this reply
+	first := done.Latest + 1
+	if o := oldest.Val(); len(o) > 0 {
+		first = int64(o[0].Score)
+	}
+	done.Complete = first <= req.Since+1 && int64(len(missed.Val())) == max(done.Latest-req.Since, 0)
+	done.Timestamp = time.Now()
+	c.sendFrame(done, PriorityNormal)
+}
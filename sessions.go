@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// SESSION_POLICY decides what happens when a user connects again while
+// already connected to this instance: "allow-all" keeps every connection,
+// "newest-wins" closes the older ones with a session_replaced notice and
+// "deny-new" refuses the new connection with a session_exists error.
+var sessionPolicy = sessionPolicyFromEnv()
+
+const (
+	sessionAllowAll   = "allow-all"
+	sessionNewestWins = "newest-wins"
+	sessionDenyNew    = "deny-new"
+)
+
+// SessionReplacedFrame tells a connection it was closed for a newer login
+type SessionReplacedFrame struct {
+	Type      string    `json:"type"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func sessionPolicyFromEnv() string {
+	switch policy := envString("SESSION_POLICY", sessionAllowAll); policy {
+	case sessionAllowAll, sessionNewestWins, sessionDenyNew:
+		return policy
+	default:
+		log.Printf("Geçersiz SESSION_POLICY %q, %s kullanılıyor", policy, sessionAllowAll)
+		return sessionAllowAll
+	}
+}
+
+// claimSession binds username to c under the session policy. It returns false
+// when the policy refuses the connection, which is then closed.
+func (h *Hub) claimSession(c *Client, username string) bool {
+	h.mutex.Lock()
+	var others []*Client
+	if sessionPolicy != sessionAllowAll {
+		for client := range h.clients {
+			if client != c && client.Username == username {
+				others = append(others, client)
+			}
+		}
+	}
+	if len(others) > 0 && sessionPolicy == sessionDenyNew {
+		h.mutex.Unlock()
+		log.Printf("Aynı kullanıcı zaten bağlı, yeni bağlantı reddedildi. ID: %s, Kullanıcı: %s", c.ID, username)
+		c.sendError(ErrSessionExists, "Bu kullanıcı başka bir yerden zaten bağlı")
+		c.close()
+		return false
+	}
+	c.Username = username
+	h.mutex.Unlock()
+
+	for _, old := range others {
+		log.Printf("Oturum yeni bağlantıyla değiştirildi. ID: %s, Kullanıcı: %s", old.ID, username)
+		old.sendFrame(SessionReplacedFrame{
+			Type:      "session_replaced",
+			Message:   "Başka bir yerden giriş yapıldığı için bu oturum kapatıldı",
+			Timestamp: time.Now(),
+		}, PriorityHigh)
+		old.close()
+	}
+	return true
+}
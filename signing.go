@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"strings"
+)
+
+// signingKey authenticates server issued links and tokens. It must be set via
+// SIGNING_SECRET in production, otherwise signatures don't survive a restart.
+var signingKey = loadSigningKey()
+
+func loadSigningKey() []byte {
+	if secret := envString("SIGNING_SECRET", ""); secret != "" {
+		return []byte(secret)
+	}
+	log.Println("SIGNING_SECRET ayarlanmamış, geçici anahtar kullanılıyor")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("İmza anahtarı oluşturulamadı: %v", err)
+	}
+	return key
+}
+
+// exportSigningKey signs compliance export manifests. It is derived from the
+// signing key, so it changes with SIGNING_SECRET, and unlike an HMAC its public
+// half lets anyone verify an archive without the secret.
+var exportSigningKey = ed25519.NewKeyFromSeed(deriveKey("export-signing-key"))
+
+// deriveKey returns a 32-byte key for one purpose from the signing key
+func deriveKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// signValue returns a URL-safe HMAC-SHA256 signature over the given parts
+func signValue(parts ...string) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(strings.Join(parts, "\x00")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature checks a signature produced by signValue
+func verifySignature(sig string, parts ...string) bool {
+	return hmac.Equal([]byte(sig), []byte(signValue(parts...)))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Recent history of a channel is compacted into one snapshot blob so a joining
+// client costs a single GET instead of LRANGE plus per-message decoding
+var (
+	historySnapshotTTL      = envDuration("HISTORY_SNAPSHOT_TTL", 10*time.Minute)
+	historySnapshotInterval = envDuration("HISTORY_SNAPSHOT_INTERVAL", 30*time.Second)
+)
+
+const (
+	historySize      = 50
+	snapshotDirtyKey = "websocket:snapshot:dirty"
+)
+
+// Bumped when the encoding of stored frames changes, so blobs written by older
+// servers are rebuilt instead of served
+const snapshotFormat = 2
+
+// historySnapshot is the stored blob; Version guards against a write racing a rebuild
+type historySnapshot struct {
+	Format   int               `json:"f"`
+	Version  int64             `json:"v"`
+	Messages []json.RawMessage `json:"messages"`
+}
+
+func snapshotKey(channel string) string {
+	return fmt.Sprintf("websocket:snapshot:%s", channel)
+}
+
+func snapshotVersionKey(channel string) string {
+	return fmt.Sprintf("websocket:snapshot:version:%s", channel)
+}
+
+// invalidateSnapshot bumps the channel's history version after a write and
+// queues the snapshot for rebuilding
+func (h *Hub) invalidateSnapshot(channel string) {
+	if h.redis == nil {
+		return
+	}
+	h.markWritten(channel)
+	ctx := context.Background()
+	pipe := h.redis.Pipeline()
+	pipe.Incr(ctx, snapshotVersionKey(channel))
+	pipe.SAdd(ctx, snapshotDirtyKey, channel)
+	pipe.Exec(ctx)
+}
+
+func (h *Hub) snapshotVersion(channel string) int64 {
+	v, _ := h.redis.Get(context.Background(), snapshotVersionKey(channel)).Int64()
+	return v
+}
+
+// buildSnapshot reads the recent history and stores it as one blob
+func (h *Hub) buildSnapshot(channel string) ([]json.RawMessage, error) {
+	version := h.snapshotVersion(channel)
+	messages, err := h.getRecentMessages(channel, historySize)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := historySnapshot{Format: snapshotFormat, Version: version, Messages: make([]json.RawMessage, 0, len(messages))}
+	for _, msg := range messages {
+		data, err := encodeFrame(msg)
+		if err != nil {
+			continue
+		}
+		snapshot.Messages = append(snapshot.Messages, data)
+	}
+
+	blob, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.redis.Set(context.Background(), snapshotKey(channel), blob, historySnapshotTTL).Err(); err != nil {
+		log.Printf("Geçmiş snapshot kaydetme hatası: %v", err)
+	}
+	return snapshot.Messages, nil
+}
+
+// historyFrames returns the enveloped recent messages of a channel, oldest first.
+// A current snapshot is served with a single round trip, otherwise it's rebuilt.
+func (h *Hub) historyFrames(channel string) ([]json.RawMessage, error) {
+	if h.redis == nil {
+		return nil, nil
+	}
+	values, err := h.historyReader(channel).MGet(context.Background(), snapshotKey(channel), snapshotVersionKey(channel)).Result()
+	if err == nil {
+		blob, _ := values[0].(string)
+		rawVersion, _ := values[1].(string)
+		current, _ := strconv.ParseInt(rawVersion, 10, 64) // missing version counts as 0
+		var snapshot historySnapshot
+		if blob != "" && json.Unmarshal([]byte(blob), &snapshot) == nil &&
+			snapshot.Format == snapshotFormat && snapshot.Version == current {
+			return snapshot.Messages, nil
+		}
+	}
+	return h.buildSnapshot(channel)
+}
+
+// runSnapshotCompactor periodically rebuilds snapshots of channels written since
+// the last run, so joining clients rarely hit a cold snapshot
+func (h *Hub) runSnapshotCompactor() {
+	if h.redis == nil || historySnapshotInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(historySnapshotInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx := context.Background()
+		for {
+			channel, err := h.redis.SPop(ctx, snapshotDirtyKey).Result()
+			if err != nil {
+				break
+			}
+			if _, err := h.buildSnapshot(channel); err != nil {
+				log.Printf("Geçmiş snapshot oluşturma hatası (%s): %v", channel, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Two nodes with their own Redis can run as a warm primary and standby, for
+// small deployments that fail over by switching the load balancer. With
+// STANDBY_LOG the primary records every write to its websocket: keys in a
+// Redis stream, and a node started with STANDBY_OF set to the primary's Redis
+// address replays that stream into its own Redis. Messages, resume logs,
+// username claims, account sessions, read markers and presence are therefore
+// on the standby within moments. The standby stays passive, running no jobs
+// and delivering no outbox events, until the first client connects to it or
+// an admin promotes it with POST /admin/standby. It then stops tailing and
+// takes over the users the primary had online as if they had just
+// disconnected, so clients reconnecting within PRESENCE_GRACE_PERIOD don't
+// announce a join. Both nodes need the same SIGNING_SECRET and a shared
+// uploads directory; switching back needs the primary's Redis restored from
+// the standby's.
+
+var (
+	standbyLogEnabled = envBool("STANDBY_LOG", false)
+	standbyLogLength  = envInt("STANDBY_LOG_LENGTH", 100000)
+	standbyOf         = envString("STANDBY_OF", "")
+)
+
+const (
+	// Writes of the primary, entries with "cmds", a JSON list of commands
+	standbyLogKey = "websocket:standby:log"
+	// ID of the last entry the standby applied, in the standby's Redis
+	standbyCursorKey = "websocket:standby:cursor"
+)
+
+// Standby log entries, per "logged", "dropped", "applied" and "failed"
+var standbyEntries = expvar.NewMap("standby_entries")
+
+// Commands whose writes are replayed on the standby. Counters are logged as
+// the value they reached, so a standby that missed earlier writes doesn't
+// count from zero, and SPOP as SREM of what it popped.
+var standbyWrites = map[string]bool{
+	"set": true, "setnx": true, "getset": true, "del": true, "incr": true, "expire": true,
+	"hset": true, "hsetnx": true, "hdel": true, "hincrby": true,
+	"lpush": true, "ltrim": true, "lset": true, "lrem": true,
+	"sadd": true, "srem": true, "spop": true,
+	"zadd": true, "zrem": true, "zincrby": true, "zremrangebyrank": true, "zremrangebyscore": true,
+}
+
+// StandbyStatus is the replication state shown by GET /admin/standby
+type StandbyStatus struct {
+	Role        string     `json:"role"` // "primary", "standby" or "" when not replicating
+	Passive     bool       `json:"passive"`
+	Cursor      string     `json:"cursor,omitempty"`
+	LastEntryAt *time.Time `json:"lastEntryAt,omitempty"` // when the last applied entry was written
+	CaughtUp    bool       `json:"caughtUp"`
+}
+
+// standbyState tracks the standby's position in the primary's log
+type standbyState struct {
+	mutex       sync.Mutex
+	cursor      string
+	lastEntryAt time.Time
+}
+
+// setupStandby connects to the primary when this node is a standby, or starts
+// recording writes when it is a primary with STANDBY_LOG
+func (h *Hub) setupStandby(redisAddr string) {
+	if h.redis == nil {
+		return
+	}
+	if standbyOf != "" {
+		if standbyOf == redisAddr {
+			log.Printf("STANDBY_OF kendi Redis adresi, yedek mod kapalı")
+			return
+		}
+		h.standbyPrimary = redis.NewClient(&redis.Options{
+			Addr:     standbyOf,
+			Password: "",
+			DB:       0,
+		})
+		h.standbyPassive.Store(true)
+		log.Printf("Yedek sunucu olarak başlatıldı, birincil Redis: %s", standbyOf)
+		return
+	}
+	if standbyLogEnabled {
+		h.standbyLog = make(chan [][]string, 4096)
+		h.redis.AddHook(standbyHook{h})
+	}
+}
+
+// standbyHook passes the successful writes of the primary's client to the log
+type standbyHook struct {
+	h *Hub
+}
+
+func (standbyHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (s standbyHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	s.h.logWrites([]redis.Cmder{cmd})
+	return nil
+}
+
+func (standbyHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (s standbyHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	s.h.logWrites(cmds)
+	return nil
+}
+
+// logWrites queues the writes among the commands as one log entry, so the
+// commands of a pipeline or transaction are replayed together
+func (h *Hub) logWrites(cmds []redis.Cmder) {
+	var writes [][]string
+	for _, cmd := range cmds {
+		if args := standbyCommand(cmd); args != nil {
+			writes = append(writes, args)
+		}
+	}
+	if len(writes) == 0 {
+		return
+	}
+	select {
+	case h.standbyLog <- writes:
+	default:
+		standbyEntries.Add("dropped", 1)
+	}
+}
+
+// standbyCommand returns the arguments a successful write is replayed with,
+// nil for anything else
+func standbyCommand(cmd redis.Cmder) []string {
+	name := strings.ToLower(cmd.Name())
+	if !standbyWrites[name] || (cmd.Err() != nil && cmd.Err() != redis.Nil) {
+		return nil
+	}
+	args := cmd.Args()
+	if len(args) < 2 {
+		return nil
+	}
+	key := standbyArg(args[1])
+	if !strings.HasPrefix(key, "websocket:") || strings.HasPrefix(key, "websocket:standby:") {
+		return nil
+	}
+	switch name {
+	case "incr":
+		if counter, ok := cmd.(*redis.IntCmd); ok {
+			return []string{"set", key, strconv.FormatInt(counter.Val(), 10), "keepttl"}
+		}
+	case "hincrby":
+		if counter, ok := cmd.(*redis.IntCmd); ok && len(args) == 4 {
+			return []string{"hset", key, standbyArg(args[2]), strconv.FormatInt(counter.Val(), 10)}
+		}
+	case "zincrby":
+		if score, ok := cmd.(*redis.FloatCmd); ok && len(args) == 4 {
+			return []string{"zadd", key, strconv.FormatFloat(score.Val(), 'f', -1, 64), standbyArg(args[3])}
+		}
+	case "spop":
+		if popped, ok := cmd.(*redis.StringCmd); ok && popped.Err() == nil {
+			return []string{"srem", key, popped.Val()}
+		}
+		return nil
+	}
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = standbyArg(arg)
+	}
+	return out
+}
+
+// standbyArg formats an argument the way go-redis sends it
+func standbyArg(arg interface{}) string {
+	switch v := arg.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return strconv.FormatInt(v.Nanoseconds(), 10)
+	case encoding.BinaryMarshaler:
+		data, _ := v.MarshalBinary()
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// runStandbyLog appends the queued writes to the log the standby tails
+func (h *Hub) runStandbyLog() {
+	if h.standbyLog == nil {
+		return
+	}
+	ctx := context.Background()
+	for writes := range h.standbyLog {
+		data, _ := json.Marshal(writes)
+		err := h.redis.XAdd(ctx, &redis.XAddArgs{
+			Stream: standbyLogKey,
+			MaxLen: int64(standbyLogLength),
+			Approx: true,
+			Values: map[string]interface{}{"cmds": data},
+		}).Err()
+		if err != nil {
+			standbyEntries.Add("dropped", 1)
+			log.Printf("Yedek günlüğüne yazılamadı: %v", err)
+			continue
+		}
+		standbyEntries.Add("logged", 1)
+	}
+}
+
+// runStandby replays the primary's log into this node's Redis until the node
+// is promoted
+func (h *Hub) runStandby() {
+	if h.standbyPrimary == nil {
+		return
+	}
+	ctx := context.Background()
+	cursor, err := h.redis.Get(ctx, standbyCursorKey).Result()
+	if err != nil {
+		cursor = "0"
+	}
+	h.standby.mutex.Lock()
+	h.standby.cursor = cursor
+	h.standby.mutex.Unlock()
+	h.checkStandbyGap(ctx, cursor)
+
+	for h.standbyPassive.Load() {
+		streams, err := h.standbyPrimary.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{standbyLogKey, cursor},
+			Count:   100,
+			Block:   time.Second,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			log.Printf("Birincil sunucunun günlüğü okunamadı: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		for _, stream := range streams {
+			for _, entry := range stream.Messages {
+				if err := h.applyStandbyEntry(ctx, entry); err != nil {
+					var redisErr redis.Error
+					if !errors.As(err, &redisErr) {
+						log.Printf("Yedek Redis'e yazılamadı: %v", err)
+						time.Sleep(time.Second)
+						break // tried again from the same entry
+					}
+					standbyEntries.Add("failed", 1)
+					log.Printf("Günlük kaydı uygulanamadı (%s): %v", entry.ID, err)
+				} else {
+					standbyEntries.Add("applied", 1)
+				}
+				cursor = entry.ID
+				h.standby.mutex.Lock()
+				h.standby.cursor, h.standby.lastEntryAt = cursor, streamIDTime(cursor)
+				h.standby.mutex.Unlock()
+			}
+		}
+	}
+}
+
+// applyStandbyEntry runs the commands of a log entry in one transaction with
+// the cursor, so a restarted standby continues after the last applied entry
+func (h *Hub) applyStandbyEntry(ctx context.Context, entry redis.XMessage) error {
+	raw, _ := entry.Values["cmds"].(string)
+	var writes [][]string
+	if err := json.Unmarshal([]byte(raw), &writes); err != nil {
+		log.Printf("Geçersiz günlük kaydı atlandı (%s): %v", entry.ID, err)
+		return h.redis.Set(ctx, standbyCursorKey, entry.ID, 0).Err()
+	}
+	pipe := h.redis.TxPipeline()
+	for _, args := range writes {
+		cmd := make([]interface{}, len(args))
+		for i, arg := range args {
+			cmd[i] = arg
+		}
+		pipe.Do(ctx, cmd...)
+	}
+	pipe.Set(ctx, standbyCursorKey, entry.ID, 0)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return err
+	}
+	return nil
+}
+
+// checkStandbyGap warns when the log no longer holds the entries after the
+// cursor, as the state missed meanwhile can't be replayed
+func (h *Hub) checkStandbyGap(ctx context.Context, cursor string) {
+	if cursor == "0" {
+		return
+	}
+	oldest, err := h.standbyPrimary.XRangeN(ctx, standbyLogKey, "-", "+", 1).Result()
+	if err != nil || len(oldest) == 0 {
+		return
+	}
+	if streamIDTime(oldest[0].ID).After(streamIDTime(cursor)) {
+		log.Printf("Yedek günlüğü %s sonrasını artık tutmuyor, yedek durum eksik olabilir", cursor)
+	}
+}
+
+// streamIDTime returns when a stream entry was added
+func streamIDTime(id string) time.Time {
+	ms, _ := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	return time.UnixMilli(ms)
+}
+
+// promoteStandby makes a passive standby serve: it stops tailing and takes
+// over the presence the primary left behind
+func (h *Hub) promoteStandby(reason string) bool {
+	if !h.standbyPassive.CompareAndSwap(true, false) {
+		return false
+	}
+	log.Printf("Yedek sunucu devreye girdi: %s", reason)
+	h.loadMaintenance()
+	h.adoptPresence()
+	return true
+}
+
+// adoptPresence treats the users online on the primary as just disconnected
+// from here: those who reconnect within the grace window aren't announced,
+// the others leave once it passes
+func (h *Hub) adoptPresence() {
+	for _, state := range h.storedPresence(context.Background()) {
+		if state.Status == PresenceOffline {
+			continue
+		}
+		h.presenceMutex.Lock()
+		h.presence[state.Username] = state
+		h.presenceMutex.Unlock()
+		h.delayLeave(state.Username, "")
+	}
+}
+
+// handleAdminStandby serves /admin/standby: GET shows the replication state,
+// POST promotes a passive standby
+func handleAdminStandby(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, hub.standbyStatus())
+	case "POST":
+		if !hub.promoteStandby("yönetici") {
+			http.Error(w, "Not a passive standby", http.StatusConflict)
+			return
+		}
+		admin, _ := adminFromRequest(r)
+		hub.recordAudit("standby_promoted", admin, "", standbyOf)
+		writeJSON(w, http.StatusOK, hub.standbyStatus())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// standbyStatus reports the role of this node and how far the standby got
+func (h *Hub) standbyStatus() StandbyStatus {
+	var status StandbyStatus
+	switch {
+	case h.standbyPrimary != nil:
+		status.Role = "standby"
+	case h.standbyLog != nil:
+		status.Role = "primary"
+	default:
+		return status
+	}
+	status.Passive = h.standbyPassive.Load()
+	if h.standbyPrimary == nil {
+		return status
+	}
+	h.standby.mutex.Lock()
+	status.Cursor = h.standby.cursor
+	if !h.standby.lastEntryAt.IsZero() {
+		at := h.standby.lastEntryAt
+		status.LastEntryAt = &at
+	}
+	h.standby.mutex.Unlock()
+	newest, err := h.standbyPrimary.XRevRangeN(context.Background(), standbyLogKey, "+", "-", 1).Result()
+	status.CaughtUp = err == nil && (len(newest) == 0 || newest[0].ID == status.Cursor)
+	return status
+}
@@ -0,0 +1,557 @@
+// Small wrapper around the chat WebSocket protocol. Frame shapes are generated
+// from the server's Go types and served at /static/protocol.d.ts.
+//
+//   import { ChatClient } from "/static/chat-client.js";
+//   const chat = new ChatClient({ username: "melih" });
+//   chat.on("message", (msg) => console.log(msg.username, msg.message));
+//   chat.connect();
+
+/** @typedef {import("./protocol").ServerEnvelope} ServerEnvelope */
+/** @typedef {import("./protocol").ServerFrame} ServerFrame */
+/** @typedef {import("./protocol").ClientFrame} ClientFrame */
+
+// Protocol version this client speaks, see the server's hello frame
+const PROTOCOL_VERSION = 2;
+
+// Text messages kept to send again after a CAPTCHA
+const MAX_RECENT_SENT = 50;
+
+// Widget scripts of the CAPTCHA providers, rendered explicitly
+const CAPTCHA_SCRIPTS = {
+  hcaptcha: { src: "https://js.hcaptcha.com/1/api.js?render=explicit", global: "hcaptcha" },
+  turnstile: { src: "https://challenges.cloudflare.com/turnstile/v0/api.js?render=explicit", global: "turnstile" },
+};
+
+export class ChatClient {
+  /**
+   * Pass a MessagePack codec with encode/decodeMulti (e.g. @msgpack/msgpack) as
+   * `msgpack` to use the binary wire format instead of JSON. `lite` starts in
+   * low-bandwidth mode: no presence frames, no image previews, larger batches.
+   * Every `latencyInterval` ms the client pings the server and reports the
+   * measured round trips; 0 turns measuring off. `link` is a shared link
+   * (/#/channel/genel/message/<id>) to open once connected, answered with a
+   * "message_context" frame. `token` is a session token from /api/login or
+   * /api/register, see ChatClient.login; `username` must then be the account's.
+   * Without one, a server in guest mode names the connection itself: the
+   * "guest" frame sets `username`, and reconnects keep that name.
+   * `transport` "polling" starts with long polling instead of a WebSocket; the
+   * client also falls back to it by itself when WebSockets keep failing to open.
+   * @param {{ username: string, channel?: string, url?: string, maxReconnectDelay?: number, msgpack?: { encode: Function, decodeMulti: Function }, lite?: boolean, latencyInterval?: number, link?: string, token?: string, transport?: "websocket" | "polling" }} options
+   */
+  constructor({ username, channel = "genel", url, maxReconnectDelay = 30000, msgpack = null, lite = false, latencyInterval = 15000, link = "", token = "", transport = "websocket" }) {
+    const protocol = window.location.protocol === "https:" ? "wss:" : "ws:";
+    this.url = url || `${protocol}//${window.location.host}/ws`;
+    if (token) this.url += `${this.url.includes("?") ? "&" : "?"}token=${encodeURIComponent(token)}`;
+    this.username = username;
+    // Token of the name a guest was given, sent again on reconnect
+    this.guestToken = "";
+    this.channel = channel;
+    this.userId = null;
+    this.maxReconnectDelay = maxReconnectDelay;
+    this.handlers = new Map();
+    this.reconnectAttempts = 0;
+    this.closedByUser = false;
+    this.ws = null;
+    // "websocket" or "polling"; failedOpens counts WebSockets that never opened
+    this.transport = transport;
+    this.failedOpens = 0;
+    // Transports the server offers, preferred first, from the hello
+    this.transports = [];
+    // Negotiated protocol version and server features, set by the "hello" reply
+    this.version = null;
+    this.capabilities = new Set();
+    this.msgpack = msgpack;
+    this.encoding = "json";
+    this.lite = lite;
+    // Link to open on the first connect only
+    this.link = link;
+    // Highest message sequence seen per channel, replayed from on reconnect
+    this.lastSeq = new Map();
+    // Round trip times in ms measured since the last latency report
+    this.latencyInterval = latencyInterval;
+    this.latencySamples = [];
+    this.latencyTimer = null;
+    this.rtt = null;
+    this.pingNonce = null; // only the pong to the latest ping counts
+    // How the server rates the connection: "good", "degraded" or "poor"
+    this.quality = "good";
+    // Latest text messages by clientMsgId, and those the CAPTCHA gate rejected
+    this.recentSent = new Map();
+    this.captchaHeld = new Set();
+  }
+
+  /**
+   * Signs in, or registers a new account with `register`, and resolves with
+   * the session ({ username, token, expiresAt }) to pass as `token`.
+   * @param {string} username
+   * @param {string} password
+   * @param {boolean} [register]
+   */
+  static async login(username, password, register = false) {
+    const res = await fetch(register ? "/api/register" : "/api/login", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ username, password }),
+    });
+    if (!res.ok) throw new Error((await res.text()).trim() || `HTTP ${res.status}`);
+    return res.json();
+  }
+
+  connect() {
+    this.closedByUser = false;
+    this.encoding = "json";
+    const url = this.guestToken ? `${this.url}${this.url.includes("?") ? "&" : "?"}guest=${encodeURIComponent(this.guestToken)}` : this.url;
+    let opened = false;
+    if (this.transport === "polling") {
+      this.ws = new PollSocket(url.replace(/^ws/, "http").replace(/\/ws(\?|$)/, "/poll$1"));
+    } else {
+      this.ws = new WebSocket(url);
+      this.ws.binaryType = "arraybuffer";
+    }
+
+    this.ws.onopen = () => {
+      opened = true;
+      this.reconnectAttempts = 0;
+      this.failedOpens = 0;
+      this.send({ type: "hello", version: PROTOCOL_VERSION, encoding: this.msgpack ? "msgpack" : "json", lite: this.lite, link: this.link || undefined });
+      this.link = "";
+      this.send({
+        username: this.username,
+        message: "__USER_CONNECT__",
+        timestamp: new Date().toISOString(),
+        channel: this.channel,
+      });
+      for (const [channel, since] of this.lastSeq) this.resume(channel, since);
+      if (this.latencyInterval > 0) {
+        this.latencyTimer = setInterval(() => this.measureLatency(), this.latencyInterval);
+      }
+      this.emit("open", null);
+    };
+
+    this.ws.onmessage = (event) => {
+      for (const envelope of this.decode(event.data)) {
+        const frame = envelope.payload;
+        if (envelope.type === "message" && frame.seq > (this.lastSeq.get(frame.channel) || 0)) {
+          this.lastSeq.set(frame.channel, frame.seq);
+        }
+        if (envelope.type === "history") {
+          for (const msg of frame.messages) {
+            if (msg.seq > (this.lastSeq.get(frame.channel) || 0)) this.lastSeq.set(frame.channel, msg.seq);
+          }
+        }
+        if (envelope.type === "hello") {
+          this.version = frame.version;
+          this.encoding = frame.encoding;
+          this.capabilities = new Set(frame.capabilities);
+          this.transports = frame.transports || [];
+          this.lite = frame.lite;
+        }
+        if (envelope.type === "lite_mode") {
+          this.lite = frame.enabled;
+        }
+        if (envelope.type === "connection_quality") {
+          this.quality = frame.quality;
+        }
+        if (envelope.type === "pong" && frame.nonce === this.pingNonce) {
+          this.rtt = Date.now() - frame.clientTime;
+          this.latencySamples.push(this.rtt);
+        }
+        // Another login took over, or the server refuses a second one
+        if (
+          envelope.type === "session_replaced" ||
+          (envelope.type === "error" && frame.code === "session_exists")
+        ) {
+          this.closedByUser = true;
+        }
+        if (envelope.type === "error" && frame.code === "captcha_required" && frame.clientMsgId) {
+          this.captchaHeld.add(frame.clientMsgId);
+        }
+        if (envelope.type === "guest") {
+          this.username = frame.username;
+          this.guestToken = frame.token;
+        }
+        if (envelope.type === "user_connected" && frame.username === this.username) {
+          this.userId = frame.userId;
+        }
+        this.emit(envelope.type, frame, envelope);
+        this.emit("*", frame, envelope);
+      }
+    };
+
+    this.ws.onclose = () => {
+      clearInterval(this.latencyTimer);
+      this.latencyTimer = null;
+      this.quality = "good";
+      this.emit("close", null);
+      if (this.closedByUser) return;
+      // Networks that block WebSockets fail every handshake; poll instead
+      if (!opened && this.transport === "websocket" && ++this.failedOpens >= 2 &&
+          (!this.transports.length || this.transports.includes("polling"))) {
+        this.transport = "polling";
+      }
+      const delay = Math.min(1000 * 2 ** this.reconnectAttempts, this.maxReconnectDelay);
+      this.reconnectAttempts++;
+      setTimeout(() => this.connect(), delay);
+    };
+  }
+
+  close() {
+    this.closedByUser = true;
+    if (this.ws) this.ws.close();
+  }
+
+  /**
+   * Registers a handler for an envelope type ("message", "file_status", ...), "*"
+   * for every frame, or the "open"/"close" connection events. Handlers get the
+   * payload and the envelope it came in.
+   * @param {string} type
+   * @param {(frame: ServerFrame, envelope?: ServerEnvelope) => void} handler
+   * @returns {() => void} unsubscribe function
+   */
+  on(type, handler) {
+    if (!this.handlers.has(type)) this.handlers.set(type, new Set());
+    this.handlers.get(type).add(handler);
+    return () => this.handlers.get(type).delete(handler);
+  }
+
+  emit(type, frame, envelope) {
+    for (const handler of this.handlers.get(type) || []) handler(frame, envelope);
+  }
+
+  /**
+   * Splits a WebSocket message into envelopes. The server batches several per
+   * message: one per line in JSON, concatenated values in binary MessagePack.
+   * @returns {ServerEnvelope[]}
+   */
+  decode(data) {
+    if (data instanceof ArrayBuffer) {
+      return this.msgpack ? [...this.msgpack.decodeMulti(new Uint8Array(data))] : [];
+    }
+    const envelopes = [];
+    for (const line of data.split("\n")) {
+      if (!line.trim()) continue;
+      try {
+        envelopes.push(JSON.parse(line));
+      } catch (err) {
+        console.warn("Geçersiz frame:", line);
+      }
+    }
+    return envelopes;
+  }
+
+  /** @param {ClientFrame} frame */
+  send(frame) {
+    if (!this.ws || this.ws.readyState !== WebSocket.OPEN) return false;
+    if (this.encoding === "msgpack") {
+      this.ws.send(this.msgpack.encode(frame));
+    } else {
+      this.ws.send(JSON.stringify(frame));
+    }
+    return true;
+  }
+
+  /** Switches live traffic to another channel, dropping other subscriptions */
+  join(channel) {
+    this.channel = channel;
+    return this.send({ type: "join", channel });
+  }
+
+  /** Follows an additional channel on the same connection */
+  subscribe(channel) {
+    return this.send({ type: "subscribe", channel });
+  }
+
+  unsubscribe(channel) {
+    return this.send({ type: "unsubscribe", channel });
+  }
+
+  /**
+   * Sends a text message. Returns the clientMsgId echoed in the server's "ack"
+   * frame, or null when the connection isn't open.
+   */
+  sendMessage(channel, text, replyTo) {
+    const clientMsgId = `${Date.now().toString(36)}${Math.random().toString(36).slice(2)}`;
+    const frame = {
+      username: this.username,
+      message: text,
+      channel,
+      type: "text",
+      clientMsgId,
+      timestamp: new Date().toISOString(),
+      ...(replyTo ? { replyTo } : {}),
+    };
+    if (!this.send(frame)) return null;
+    this.recentSent.set(clientMsgId, frame);
+    if (this.recentSent.size > MAX_RECENT_SENT) this.recentSent.delete(this.recentSent.keys().next().value);
+    return clientMsgId;
+  }
+
+  /**
+   * Passes the CAPTCHA gate after a "captcha_required" error: renders the
+   * widget of the frame's provider into `container`, posts its token to
+   * /api/captcha/verify and sends the rejected messages again. Resolves once
+   * they are sent.
+   * @param {{ provider: string, siteKey: string }} frame
+   * @param {HTMLElement} container
+   */
+  async solveCaptcha(frame, container) {
+    const token = await captchaToken(frame.provider, frame.siteKey, container);
+    const res = await fetch("/api/captcha/verify", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ token }),
+    });
+    if (!res.ok) throw new Error((await res.text()).trim() || `HTTP ${res.status}`);
+    for (const clientMsgId of this.captchaHeld) {
+      const held = this.recentSent.get(clientMsgId);
+      if (held) this.send(held);
+    }
+    this.captchaHeld.clear();
+  }
+
+  /** Sets this user's custom status; both empty clears it */
+  setStatus(text, emoji) {
+    return this.send({ type: "status", text: text || "", emoji: emoji || "" });
+  }
+
+  /**
+   * Moves this user's last-read marker of a channel to a message; every
+   * connection of the user gets the channel's new "unread_counts"
+   */
+  markRead(channel, messageId) {
+    return this.send({ type: "mark_read", channel, messageId });
+  }
+
+  /** Tells the author of a message that it reached this client */
+  markDelivered(channel, messageId) {
+    return this.send({ type: "delivered", channel, messageId });
+  }
+
+  /** Marks a message seen by its server-assigned messageId */
+  markSeen(channel, messageId, timestamp) {
+    return this.send({ username: this.username, message: "", channel, type: "seen", messageId, timestamp });
+  }
+
+  /**
+   * Replays the messages of a channel after sequence `since`; a "resumed" frame
+   * with complete=false means the gap was too old and history should be reloaded
+   */
+  resume(channel, since) {
+    return this.send({ type: "resume", channel, since });
+  }
+
+  /** Whether the server announced an optional feature, e.g. "reactions" */
+  supports(capability) {
+    return this.capabilities.has(capability);
+  }
+
+  /** Switches low-bandwidth mode for this connection; kept across reconnects */
+  setLite(enabled) {
+    this.lite = enabled;
+    return this.send({ type: "lite_mode", enabled });
+  }
+
+  /**
+   * Asks for an upload token; the "upload_token" reply's token goes into the
+   * token form field of POST /upload
+   */
+  requestUploadToken(channel = this.channel) {
+    return this.send({ type: "upload_token", channel });
+  }
+
+  /** Reports the user as "online" or "away", e.g. when the page is hidden */
+  setPresence(status) {
+    return this.send({ type: "presence", status });
+  }
+
+  /**
+   * Pings the server; the "pong" reply to the latest ping updates `rtt`, which
+   * the next ping reports back. Every fourth sample the collected round trips
+   * are sent in a latency_report.
+   */
+  measureLatency() {
+    if (this.latencySamples.length >= 4) {
+      this.send({ type: "latency_report", samples: this.latencySamples });
+      this.latencySamples = [];
+    }
+    this.pingNonce = Math.random().toString(36).slice(2);
+    return this.send({
+      type: "ping",
+      nonce: this.pingNonce,
+      clientTime: Date.now(),
+      rtt: this.rtt ?? undefined,
+    });
+  }
+
+  /** Replaces the text of one of the user's own messages */
+  edit(messageId, message) {
+    return this.send({ type: "edit", messageId, message });
+  }
+
+  requestHistory(channel) {
+    return this.send({
+      username: this.username,
+      message: "__GET_RECENT_MESSAGES__",
+      channel,
+      timestamp: new Date().toISOString(),
+    });
+  }
+
+  /**
+   * Asks for the recent history of several channels at once; each arrives as
+   * its own "history" frame, in the order the server finishes reading them
+   */
+  requestHistories(channels) {
+    return this.send({ type: "history", channels });
+  }
+
+  /**
+   * Replaces this user's notification preferences on all their devices:
+   * channels whose mentions don't notify, and daily do-not-disturb windows
+   * like { start: "22:00", end: "07:00" } in the given IANA time zone
+   */
+  setNotificationPrefs(mutedChannels = [], dnd = [], timezone = Intl.DateTimeFormat().resolvedOptions().timeZone) {
+    return this.send({ type: "notification_prefs", mutedChannels, dnd, timezone });
+  }
+
+  /**
+   * Approves or drops a message held for review; only moderators of the
+   * channel may. Held messages arrive with `pending: true`.
+   */
+  reviewMessage(channel, messageId, approve) {
+    return this.send({ type: "review", channel, messageId, approve });
+  }
+
+  /** Asks for the held messages of a channel, answered with a "review_queue" frame */
+  requestReviewQueue(channel) {
+    return this.send({ type: "review_queue", channel });
+  }
+
+  /** Opens a shared link: subscribes to its channel and sends the page around its message */
+  openLink(link) {
+    return this.send({ type: "open_link", link });
+  }
+
+  requestRoster(cursor = "", limit = 50) {
+    return this.send({ type: "roster", cursor, limit });
+  }
+
+  requestLinkStats() {
+    return this.send({ type: "link_stats" });
+  }
+}
+
+/**
+ * Loads the widget script of a CAPTCHA provider ("hcaptcha" or "turnstile"),
+ * renders the widget into `container` and resolves with the token once the
+ * user solved it.
+ * @returns {Promise<string>}
+ */
+export async function captchaToken(provider, siteKey, container) {
+  const script = CAPTCHA_SCRIPTS[provider];
+  if (!script) throw new Error(`unknown CAPTCHA provider ${provider}`);
+  if (!window[script.global]) {
+    await new Promise((resolve, reject) => {
+      const tag = document.createElement("script");
+      tag.src = script.src;
+      tag.async = true;
+      tag.onload = resolve;
+      tag.onerror = () => reject(new Error(`${provider} could not be loaded`));
+      document.head.appendChild(tag);
+    });
+  }
+  return new Promise((resolve, reject) => {
+    window[script.global].render(container, {
+      sitekey: siteKey,
+      callback: resolve,
+      "error-callback": () => reject(new Error(`${provider} failed`)),
+    });
+  });
+}
+
+// WebSocket look-alike over the server's long-polling transport: GET /poll
+// returns batches of frames, POST /send takes ours one per line. Every session
+// starts without a cursor; ChatClient resumes its channels with resume frames
+// as it does over WebSockets.
+class PollSocket {
+  constructor(url) {
+    this.readyState = WebSocket.CONNECTING;
+    this.pollUrl = url.replace(/\?.*$/, "");
+    this.sendUrl = this.pollUrl.replace(/\/poll$/, "/send");
+    this.session = "";
+    this.cursor = "";
+    this.queue = [];
+    this.sending = false;
+    this.onopen = null;
+    this.onmessage = null;
+    this.onclose = null;
+    this.open(url);
+  }
+
+  async open(url) {
+    try {
+      const body = await this.fetchJSON(url);
+      this.session = body.session;
+      this.cursor = body.cursor;
+      this.readyState = WebSocket.OPEN;
+      if (this.onopen) this.onopen();
+      this.deliver(body.frames);
+    } catch (err) {
+      this.finish();
+      return;
+    }
+    // Each poll acknowledges the messages its cursor covers
+    while (this.readyState === WebSocket.OPEN) {
+      try {
+        const body = await this.fetchJSON(`${this.pollUrl}?session=${this.session}&cursor=${encodeURIComponent(this.cursor)}`);
+        this.cursor = body.cursor;
+        this.deliver(body.frames);
+        if (body.closed) break;
+      } catch (err) {
+        break;
+      }
+    }
+    this.finish();
+  }
+
+  async fetchJSON(url) {
+    const res = await fetch(url, { cache: "no-store" });
+    if (!res.ok) throw new Error(`HTTP ${res.status}`);
+    return res.json();
+  }
+
+  deliver(frames) {
+    if (frames.length && this.onmessage) {
+      this.onmessage({ data: frames.map((frame) => JSON.stringify(frame)).join("\n") });
+    }
+  }
+
+  send(data) {
+    this.queue.push(data);
+    this.flush();
+  }
+
+  // Sends queued frames in order, one request at a time
+  async flush() {
+    if (this.sending || !this.queue.length) return;
+    this.sending = true;
+    try {
+      const res = await fetch(`${this.sendUrl}?session=${this.session}`, { method: "POST", body: this.queue.splice(0).join("\n") });
+      if (!res.ok) throw new Error(`HTTP ${res.status}`);
+    } catch (err) {
+      this.finish();
+    }
+    this.sending = false;
+    if (this.readyState === WebSocket.OPEN) this.flush();
+  }
+
+  close() {
+    this.finish();
+  }
+
+  finish() {
+    if (this.readyState === WebSocket.CLOSED) return;
+    this.readyState = WebSocket.CLOSED;
+    if (this.onclose) this.onclose();
+  }
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Personal statistics are opt-in: a user enables them over the WebSocket and
+// gets a signed token for GET /api/me/stats. Counters are updated as messages
+// are stored, so reading them never scans the history.
+
+// StatsOptInRequest is the inbound {"type":"stats_opt_in","enabled":true} frame
+type StatsOptInRequest struct {
+	Type    string `json:"type"`
+	Enabled bool   `json:"enabled"`
+}
+
+// StatsTokenFrame confirms the choice; Token authenticates /api/me/stats
+type StatsTokenFrame struct {
+	Type    string `json:"type"`
+	Enabled bool   `json:"enabled"`
+	Token   string `json:"token,omitempty"`
+}
+
+// UserStats is the /api/me/stats response
+type UserStats struct {
+	Username               string    `json:"username"`
+	Since                  time.Time `json:"since"`
+	Messages               int64     `json:"messages"`
+	Words                  int64     `json:"words"`
+	Characters             int64     `json:"characters"`
+	TopEmoji               string    `json:"topEmoji,omitempty"`
+	TopEmojiCount          int64     `json:"topEmojiCount,omitempty"`
+	BusiestChannel         string    `json:"busiestChannel,omitempty"`
+	BusiestChannelMessages int64     `json:"busiestChannelMessages,omitempty"`
+}
+
+const statsOptInKey = "websocket:stats:optin"
+
+func userStatsKey(username, part string) string {
+	return "websocket:stats:" + username + ":" + part
+}
+
+// statsToken binds a token to the username; it stays valid until the user opts out
+func statsToken(username string) string {
+	return username + "." + signValue("stats", username)
+}
+
+// statsTokenUser returns the username of a valid token
+func statsTokenUser(token string) (string, bool) {
+	i := strings.LastIndex(token, ".")
+	if i <= 0 {
+		return "", false
+	}
+	username := token[:i]
+	return username, verifySignature(token[i+1:], "stats", username)
+}
+
+// messageEmoji returns the emoji used in a text, one entry per occurrence
+func messageEmoji(text string) []string {
+	var emoji []string
+	for _, r := range text {
+		if (r >= 0x1F300 && r <= 0x1FAFF) || (r >= 0x2600 && r <= 0x27BF) {
+			emoji = append(emoji, string(r))
+		}
+	}
+	return emoji
+}
+
+// recordUserStats counts a stored message for its author if they opted in
+func (h *Hub) recordUserStats(msg Message) {
+	if h.redis == nil || msg.Username == "" || msg.Type == "digest" || msg.Type == "system" {
+		return
+	}
+	ctx := context.Background()
+	optedIn, err := h.redis.SIsMember(ctx, statsOptInKey, msg.Username).Result()
+	if err != nil || !optedIn {
+		return
+	}
+	totals := userStatsKey(msg.Username, "totals")
+	pipe := h.redis.Pipeline()
+	pipe.HIncrBy(ctx, totals, "messages", 1)
+	if msg.Type == "" || msg.Type == "text" {
+		pipe.HIncrBy(ctx, totals, "words", int64(len(strings.FieldsFunc(msg.Message, unicode.IsSpace))))
+		pipe.HIncrBy(ctx, totals, "characters", int64(len([]rune(msg.Message))))
+		for _, e := range messageEmoji(msg.Message) {
+			pipe.ZIncrBy(ctx, userStatsKey(msg.Username, "emoji"), 1, e)
+		}
+	}
+	pipe.ZIncrBy(ctx, userStatsKey(msg.Username, "channels"), 1, msg.Channel)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Kullanıcı istatistiği kaydedilemedi: %v", err)
+	}
+}
+
+// handleStatsOptIn enables or disables the sender's statistics. Opting out
+// deletes everything collected so far.
+func (h *Hub) handleStatsOptIn(c *Client, raw []byte) {
+	var req StatsOptInRequest
+	if err := json.Unmarshal(raw, &req); err != nil || c.Username == "" {
+		return
+	}
+	if h.redis == nil {
+		c.sendError(ErrStatsUnavailable, "İstatistikler şu anda kullanılamıyor")
+		return
+	}
+	ctx := context.Background()
+	pipe := h.redis.Pipeline()
+	if req.Enabled {
+		pipe.SAdd(ctx, statsOptInKey, c.Username)
+		pipe.HSetNX(ctx, userStatsKey(c.Username, "totals"), "since", time.Now().UTC().Format(time.RFC3339))
+	} else {
+		pipe.SRem(ctx, statsOptInKey, c.Username)
+		pipe.Del(ctx, userStatsKey(c.Username, "totals"), userStatsKey(c.Username, "emoji"), userStatsKey(c.Username, "channels"))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("İstatistik tercihi kaydedilemedi: %v", err)
+		return
+	}
+	frame := StatsTokenFrame{Type: "stats_token", Enabled: req.Enabled}
+	if req.Enabled {
+		frame.Token = statsToken(c.Username)
+	}
+	c.sendFrame(frame, PriorityHigh)
+}
+
+// userStats reads the counters of an opted-in user
+func (h *Hub) userStats(username string) (UserStats, bool, error) {
+	ctx := context.Background()
+	pipe := h.redis.Pipeline()
+	optedIn := pipe.SIsMember(ctx, statsOptInKey, username)
+	totals := pipe.HGetAll(ctx, userStatsKey(username, "totals"))
+	emoji := pipe.ZRevRangeWithScores(ctx, userStatsKey(username, "emoji"), 0, 0)
+	channels := pipe.ZRevRangeWithScores(ctx, userStatsKey(username, "channels"), 0, 0)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return UserStats{}, false, err
+	}
+	if !optedIn.Val() {
+		return UserStats{}, false, nil
+	}
+
+	stats := UserStats{Username: username}
+	values := totals.Val()
+	stats.Since, _ = time.Parse(time.RFC3339, values["since"])
+	stats.Messages, _ = strconv.ParseInt(values["messages"], 10, 64)
+	stats.Words, _ = strconv.ParseInt(values["words"], 10, 64)
+	stats.Characters, _ = strconv.ParseInt(values["characters"], 10, 64)
+	if top := emoji.Val(); len(top) > 0 {
+		stats.TopEmoji, _ = top[0].Member.(string)
+		stats.TopEmojiCount = int64(top[0].Score)
+	}
+	if top := channels.Val(); len(top) > 0 {
+		stats.BusiestChannel, _ = top[0].Member.(string)
+		stats.BusiestChannelMessages = int64(top[0].Score)
+	}
+	return stats, true, nil
+}
+
+// handleMyStats serves GET /api/me/stats for the holder of a stats token
+func handleMyStats(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	username, ok := statsTokenUser(token)
+	if !ok {
+		http.Error(w, "Invalid stats token", http.StatusUnauthorized)
+		return
+	}
+	if hub.redis == nil {
+		http.Error(w, "Stats unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	stats, optedIn, err := hub.userStats(username)
+	if err != nil {
+		http.Error(w, "Stats unavailable", http.StatusInternalServerError)
+		return
+	}
+	if !optedIn {
+		http.Error(w, "Stats not enabled", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
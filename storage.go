@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// Storage counters are hashes of name -> bytes, one per kind and scope
+const (
+	storageUploads  = "uploads"
+	storageMessages = "messages"
+)
+
+func storageKey(kind, scope string) string {
+	return "websocket:storage:" + kind + ":" + scope
+}
+
+// recordStorage adds bytes written by a user in a channel to the usage counters
+func (h *Hub) recordStorage(kind, channel, username string, size int64) {
+	if h.redis == nil || size <= 0 {
+		return
+	}
+	ctx := context.Background()
+	pipe := h.redis.Pipeline()
+	pipe.HIncrBy(ctx, storageKey(kind, "channel"), channel, size)
+	if username != "" {
+		pipe.HIncrBy(ctx, storageKey(kind, "user"), username, size)
+	}
+	pipe.Exec(ctx)
+}
+
+// StorageUsage is the bytes used by one channel or user
+type StorageUsage struct {
+	Name     string `json:"name"`
+	Uploads  int64  `json:"uploads"`
+	Messages int64  `json:"messages"`
+	Total    int64  `json:"total"`
+}
+
+// storageUsage merges the upload and message counters of a scope, largest first
+func (h *Hub) storageUsage(scope string) ([]StorageUsage, error) {
+	ctx := context.Background()
+	pipe := h.redis.Pipeline()
+	uploads := pipe.HGetAll(ctx, storageKey(storageUploads, scope))
+	messages := pipe.HGetAll(ctx, storageKey(storageMessages, scope))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string]*StorageUsage)
+	entry := func(name string) *StorageUsage {
+		if usage[name] == nil {
+			usage[name] = &StorageUsage{Name: name}
+		}
+		return usage[name]
+	}
+	for name, v := range uploads.Val() {
+		n, _ := strconv.ParseInt(v, 10, 64)
+		entry(name).Uploads = n
+	}
+	for name, v := range messages.Val() {
+		n, _ := strconv.ParseInt(v, 10, 64)
+		entry(name).Messages = n
+	}
+
+	list := make([]StorageUsage, 0, len(usage))
+	for _, u := range usage {
+		u.Total = u.Uploads + u.Messages
+		list = append(list, *u)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Total != list[j].Total {
+			return list[i].Total > list[j].Total
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// handleAdminStorage serves GET /admin/storage?top=N with workspace totals and
+// the top channels and users by bytes written
+func handleAdminStorage(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if hub.redis == nil {
+		http.Error(w, "Storage statistics require Redis", http.StatusServiceUnavailable)
+		return
+	}
+	top := 10
+	if v, err := strconv.Atoi(r.URL.Query().Get("top")); err == nil && v > 0 {
+		top = min(v, 1000)
+	}
+
+	channels, err := hub.storageUsage("channel")
+	if err != nil {
+		http.Error(w, "Error reading storage statistics", http.StatusInternalServerError)
+		return
+	}
+	users, err := hub.storageUsage("user")
+	if err != nil {
+		http.Error(w, "Error reading storage statistics", http.StatusInternalServerError)
+		return
+	}
+
+	// Totals come from the channel counters, every write has a channel
+	var totals StorageUsage
+	totals.Name = "total"
+	for _, c := range channels {
+		totals.Uploads += c.Uploads
+		totals.Messages += c.Messages
+	}
+	totals.Total = totals.Uploads + totals.Messages
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"totals":       totals,
+		"channelCount": len(channels),
+		"userCount":    len(users),
+		"channels":     channels[:min(top, len(channels))],
+		"users":        users[:min(top, len(users))],
+	})
+}
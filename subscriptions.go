@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// Channel subscriptions of a client. A client that never joined or subscribed
+// to a channel is treated as a legacy client and still receives the traffic of
+// every channel.
+
+// A connection can follow at most this many channels
+const maxSubscriptions = 50
+
+// ChannelRequest is the inbound {"type":"join|subscribe|unsubscribe","channel":"..."}
+// frame. join replaces all subscriptions with the channel, subscribe and
+// unsubscribe add or remove one.
+type ChannelRequest struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+}
+
+// SubscriptionsFrame confirms the client's channel set after a change
+type SubscriptionsFrame struct {
+	Type      string    `json:"type"`
+	Channels  []string  `json:"channels"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// joinChannel makes channel the only channel the client receives traffic of
+func (c *Client) joinChannel(channel string) {
+	c.channelsMutex.Lock()
+	defer c.channelsMutex.Unlock()
+	c.channels = map[string]bool{channel: true}
+}
+
+// subscribe adds a channel; it returns false when the limit is reached
+func (c *Client) subscribe(channel string) bool {
+	c.channelsMutex.Lock()
+	defer c.channelsMutex.Unlock()
+	if c.channels == nil {
+		c.channels = make(map[string]bool)
+	}
+	if !c.channels[channel] && len(c.channels) >= maxSubscriptions {
+		return false
+	}
+	c.channels[channel] = true
+	return true
+}
+
+// unsubscribe removes a channel. Unsubscribing from everything leaves the
+// client with no traffic rather than turning it back into a legacy client.
+func (c *Client) unsubscribe(channel string) {
+	c.channelsMutex.Lock()
+	defer c.channelsMutex.Unlock()
+	if c.channels == nil {
+		c.channels = make(map[string]bool)
+	}
+	delete(c.channels, channel)
+}
+
+// subscriptions returns the subscribed channels, sorted
+func (c *Client) subscriptions() []string {
+	c.channelsMutex.RLock()
+	defer c.channelsMutex.RUnlock()
+	channels := make([]string, 0, len(c.channels))
+	for channel := range c.channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// followsAll reports whether the client is a legacy client receiving every channel
+func (c *Client) followsAll() bool {
+	c.channelsMutex.RLock()
+	defer c.channelsMutex.RUnlock()
+	return c.channels == nil
+}
+
+// inChannel reports whether traffic of channel should be delivered to the
+// client. Legacy clients don't get the traffic of conversations.
+func (c *Client) inChannel(channel string) bool {
+	c.channelsMutex.RLock()
+	defer c.channelsMutex.RUnlock()
+	if c.channels == nil {
+		return !isConversation(channel)
+	}
+	return c.channels[channel]
+}
+
+// handleChannelRequest applies a join/subscribe/unsubscribe frame and confirms
+// the resulting channel set
+func (c *Client) handleChannelRequest(req ChannelRequest) {
+	if req.Channel == "" {
+		c.sendError(ErrInvalidChannel, "Kanal belirtilmedi")
+		return
+	}
+	switch req.Type {
+	case "join":
+		c.joinChannel(req.Channel)
+	case "subscribe":
+		if !c.subscribe(req.Channel) {
+			c.sendError(ErrTooManySubscriptions, "Çok fazla kanala abone olundu")
+			return
+		}
+	case "unsubscribe":
+		c.unsubscribe(req.Channel)
+	}
+	c.sendFrame(SubscriptionsFrame{Type: "subscriptions", Channels: c.subscriptions(), Timestamp: time.Now()}, PriorityNormal)
+}
+
+// sendToChannel queues a frame for every client in channel except the given one
+func (h *Hub) sendToChannel(channel string, frame []byte, p Priority, except *Client) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for client := range h.clients {
+		if client != except && client.inChannel(channel) {
+			client.enqueue(frame, p)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// A reply joins the thread of the message it quotes: the thread is named after
+// its first message, the root, and every reply carries the root's ID as
+// threadId. Thread membership is kept next to the channel history, so roots
+// can show their reply count and clients can load a whole thread at once.
+
+// ThreadFetchRequest is the inbound {"type":"thread_fetch","threadId":"..."} frame
+type ThreadFetchRequest struct {
+	Type     string `json:"type"`
+	ThreadID string `json:"threadId"`
+}
+
+// ThreadFrame answers a thread_fetch with the root and its replies, oldest
+// first. Root is missing once it expired from the history.
+type ThreadFrame struct {
+	Type      string    `json:"type"`
+	ThreadID  string    `json:"threadId"`
+	Channel   string    `json:"channel"`
+	Root      *Message  `json:"root,omitempty"`
+	Messages  []Message `json:"messages"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Replies of a thread, message IDs scored by sequence number
+func threadKey(root string) string {
+	return "websocket:thread:" + root
+}
+
+// Reply counts of a channel's threads by root ID
+func threadCountsKey(channel string) string {
+	return "websocket:threads:" + channel
+}
+
+// appendThread queues a reply into its thread, kept as long as the history
+func appendThread(ctx context.Context, pipe redis.Pipeliner, msg Message) {
+	if msg.ThreadID == "" || msg.Seq == 0 {
+		return
+	}
+	pipe.ZAdd(ctx, threadKey(msg.ThreadID), &redis.Z{Score: float64(msg.Seq), Member: msg.MessageID})
+	pipe.Expire(ctx, threadKey(msg.ThreadID), 24*time.Hour)
+	pipe.HIncrBy(ctx, threadCountsKey(msg.Channel), msg.ThreadID, 1)
+	pipe.Expire(ctx, threadCountsKey(msg.Channel), 24*time.Hour)
+}
+
+// threadRoot returns the root of the thread a message of channel belongs to,
+// empty when the message isn't stored there
+func (h *Hub) threadRoot(channel, id string) string {
+	if h.redis == nil || !isULID(id) {
+		return ""
+	}
+	msg, ok := h.storedMessage(context.Background(), channel, id)
+	if !ok {
+		return ""
+	}
+	if msg.ThreadID != "" {
+		return msg.ThreadID
+	}
+	return id
+}
+
+// channelThreadCounts returns the reply counts of a channel's threads
+func (h *Hub) channelThreadCounts(channel string) map[string]int {
+	fields, err := h.redis.HGetAll(context.Background(), threadCountsKey(channel)).Result()
+	if err != nil || len(fields) == 0 {
+		return nil
+	}
+	counts := make(map[string]int, len(fields))
+	for root, value := range fields {
+		counts[root], _ = strconv.Atoi(value)
+	}
+	return counts
+}
+
+// applyThreadCounts fills the ThreadReplies of thread roots
+func applyThreadCounts(messages []Message, counts map[string]int) {
+	for i := range messages {
+		if n := counts[messages[i].MessageID]; n > 0 {
+			messages[i].ThreadReplies = n
+		}
+	}
+}
+
+// handleThreadFetch sends the messages of a thread in a channel the client joined
+func (h *Hub) handleThreadFetch(c *Client, raw []byte) {
+	var req ThreadFetchRequest
+	if err := json.Unmarshal(raw, &req); err != nil || !isULID(req.ThreadID) {
+		c.sendError(ErrInvalidThread, "Geçersiz konu")
+		return
+	}
+	if h.redis == nil {
+		c.sendError(ErrInvalidThread, "Mesaj geçmişi olmadan konular yüklenemez")
+		return
+	}
+	ctx := context.Background()
+	channel, seq, ok := h.locateMessage(ctx, req.ThreadID)
+	if !ok || !c.inChannel(channel) {
+		c.sendError(ErrInvalidThread, "Konu bulunamadı")
+		return
+	}
+
+	frame := ThreadFrame{Type: "thread", ThreadID: req.ThreadID, Channel: channel, Messages: []Message{}}
+	replies, err := h.redis.ZRangeWithScores(ctx, threadKey(req.ThreadID), 0, -1).Result()
+	if err != nil {
+		c.sendError(ErrInvalidThread, "Konu okunamadı")
+		return
+	}
+	members := make(map[string]bool, len(replies))
+	last := seq
+	for _, reply := range replies {
+		members[reply.Member.(string)] = true
+		last = max(last, int64(reply.Score))
+	}
+	stored, err := h.messagesBySeq(ctx, channel, seq, last)
+	if err != nil {
+		c.sendError(ErrInvalidThread, "Konu okunamadı")
+		return
+	}
+	receipts, reactions := h.channelReceipts(channel), h.channelReactions(channel)
+	applyReceipts(stored, receipts)
+	applyReactions(stored, reactions)
+	for i := range stored {
+		switch {
+		case stored[i].MessageID == req.ThreadID:
+			root := stored[i]
+			root.ThreadReplies = len(replies)
+			frame.Root = &root
+		case members[stored[i].MessageID]:
+			frame.Messages = append(frame.Messages, stored[i])
+		}
+	}
+	frame.Timestamp = time.Now()
+	c.sendFrame(frame, PriorityNormal)
+}
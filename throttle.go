@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	// Failed attempts are counted inside this sliding window
+	authFailureWindow = envDuration("AUTH_FAILURE_WINDOW", 15*time.Minute)
+	// Number of failures after which exponential delays kick in
+	authDelayAfter = envInt("AUTH_DELAY_AFTER", 3)
+	// Base delay, doubled for every further failure
+	authBaseDelay = envDuration("AUTH_BASE_DELAY", 2*time.Second)
+	// Number of failures that locks the account/IP completely
+	authLockoutThreshold = envInt("AUTH_LOCKOUT_THRESHOLD", 10)
+	authLockoutDuration  = envDuration("AUTH_LOCKOUT_DURATION", 15*time.Minute)
+)
+
+func authFailKey(scope, id string) string {
+	return fmt.Sprintf("websocket:auth:fail:%s:%s", scope, id)
+}
+
+func authBlockKey(scope, id string) string {
+	return fmt.Sprintf("websocket:auth:block:%s:%s", scope, id)
+}
+
+// authRetryAfter returns how long the IP/account must wait before the next attempt.
+// An empty account only checks the IP.
+func (h *Hub) authRetryAfter(ip, account string) time.Duration {
+	if h.redis == nil {
+		return 0
+	}
+	ctx := context.Background()
+	var wait time.Duration
+	keys := []string{authBlockKey("ip", ip)}
+	if account != "" {
+		keys = append(keys, authBlockKey("account", account))
+	}
+	for _, key := range keys {
+		if ttl, err := h.redis.PTTL(ctx, key).Result(); err == nil && ttl > wait {
+			wait = ttl
+		}
+	}
+	return wait
+}
+
+// recordAuthFailure bumps the failure counters and applies delays or a lockout
+func (h *Hub) recordAuthFailure(ip, account, reason string) {
+	authFailures.Add(reason, 1)
+	if h.redis == nil {
+		return
+	}
+	h.bumpAuthFailures("ip", ip, "")
+	if account != "" {
+		h.bumpAuthFailures("account", account, account)
+	}
+}
+
+func (h *Hub) bumpAuthFailures(scope, id, notify string) {
+	ctx := context.Background()
+	failKey := authFailKey(scope, id)
+	pipe := h.redis.TxPipeline()
+	incr := pipe.Incr(ctx, failKey)
+	pipe.Expire(ctx, failKey, authFailureWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Giriş denemesi sayacı hatası: %v", err)
+		return
+	}
+
+	failures := int(incr.Val())
+	switch {
+	case failures >= authLockoutThreshold:
+		h.redis.Set(ctx, authBlockKey(scope, id), 1, authLockoutDuration)
+		authLockouts.Add(1)
+		log.Printf("Çok fazla başarısız giriş, kilitlendi: %s=%s (%d deneme)", scope, id, failures)
+		if notify != "" {
+			message := fmt.Sprintf("Hesabınız çok fazla başarısız giriş denemesi nedeniyle %s süreyle kilitlendi.", authLockoutDuration)
+			h.notifyUser(notify, SecurityNoticeFrame{
+				Type:      "security_notice",
+				Reason:    "account_locked",
+				Message:   message,
+				Timestamp: time.Now(),
+				Notification: NotificationHint{
+					Title:       "Güvenlik uyarısı",
+					Body:        message,
+					Badge:       h.unreadBadge(notify),
+					Sound:       SoundAlert,
+					CollapseKey: "security:account_locked",
+					Link:        "/",
+				},
+			}, PriorityHigh)
+		}
+	case failures >= authDelayAfter:
+		delay := authBaseDelay << uint(failures-authDelayAfter)
+		if delay > authLockoutDuration || delay <= 0 {
+			delay = authLockoutDuration
+		}
+		h.redis.Set(ctx, authBlockKey(scope, id), 1, delay)
+	}
+}
+
+// recordAuthSuccess clears the account counters after a successful login
+func (h *Hub) recordAuthSuccess(account string) {
+	if h.redis == nil || account == "" {
+		return
+	}
+	h.redis.Del(context.Background(), authFailKey("account", account), authBlockKey("account", account))
+}
+
+// notifyUser sends a frame to every connection of the given username
+func (h *Hub) notifyUser(username string, frame interface{}, p Priority) {
+	payload, err := encodeFrame(frame)
+	if err != nil {
+		return
+	}
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for client := range h.clients {
+		if client.Username == username {
+			client.enqueue(payload, p)
+		}
+	}
+}
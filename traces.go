@@ -0,0 +1,175 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Every chat message gets a trace ID when it's read from the connection: the
+// traceId the client sent, or a new one. The ID stays with the message through
+// the pipeline, is stored with it and sent to every recipient, and comes back
+// to the sender in its ack or in the error that stopped the message. What
+// happened to the message on the way (the stage that stopped it, storing,
+// fan-out and each connection it was written to) is kept for the last
+// TRACE_BUFFER traces and served by GET /admin/traces?id=, so a "my message
+// never arrived" report can be followed to the connection that missed it.
+// Traces are kept in memory, per instance.
+
+var traceBufferSize = envInt("TRACE_BUFFER", 5000)
+
+const (
+	maxTraceEvents = 50 // later events of a trace are counted, not kept
+	traceIDMaxLen  = 64
+)
+
+// Trace events, as named in /admin/traces and the trace_events metric
+const (
+	TraceReceived  = "received"
+	TraceStopped   = "stopped"
+	TraceQueued    = "queued"
+	TraceDropped   = "dropped"
+	TraceDuplicate = "duplicate"
+	TraceHeld      = "held"
+	TracePersisted = "persisted"
+	TraceNotStored = "not_persisted"
+	TraceFanout    = "fanout"
+	TraceWritten   = "written"
+	TraceAcked     = "acked"
+)
+
+// Events recorded per event name
+var traceEvents = expvar.NewMap("trace_events")
+
+var traceIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// TraceEvent is one step of a traced message
+type TraceEvent struct {
+	Event  string    `json:"event"`
+	Detail string    `json:"detail,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// Trace is what happened to one inbound message, in the /admin/traces response
+type Trace struct {
+	TraceID   string       `json:"traceId"`
+	MessageID string       `json:"messageId,omitempty"`
+	Channel   string       `json:"channel,omitempty"`
+	Username  string       `json:"username,omitempty"`
+	Events    []TraceEvent `json:"events"`
+	Omitted   int          `json:"omitted,omitempty"` // events past maxTraceEvents
+}
+
+// traceLog keeps the most recent traces, dropping the oldest when full
+type traceLog struct {
+	mu     sync.Mutex
+	traces map[string]*Trace
+	order  []string // ring of trace IDs, oldest at next
+	next   int
+}
+
+var traces = &traceLog{traces: make(map[string]*Trace)}
+
+// traceID returns the trace ID the client sent when it's usable, or a new one
+func traceID(sent string) string {
+	if sent != "" && len(sent) <= traceIDMaxLen && traceIDPattern.MatchString(sent) {
+		return sent
+	}
+	return newULID()
+}
+
+// start begins the trace of a message read from a connection
+func (l *traceLog) start(msg Message) {
+	if traceBufferSize <= 0 || msg.TraceID == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.traces[msg.TraceID]; !ok {
+		if len(l.order) < traceBufferSize {
+			l.order = append(l.order, msg.TraceID)
+		} else {
+			delete(l.traces, l.order[l.next])
+			l.order[l.next] = msg.TraceID
+			l.next = (l.next + 1) % len(l.order)
+		}
+	}
+	l.traces[msg.TraceID] = &Trace{
+		TraceID:   msg.TraceID,
+		MessageID: msg.MessageID,
+		Channel:   msg.Channel,
+		Username:  msg.Username,
+	}
+}
+
+// record adds an event to a trace that is still kept
+func (l *traceLog) record(id, event, detail string) {
+	if id == "" {
+		return
+	}
+	traceEvents.Add(event, 1)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	trace, ok := l.traces[id]
+	if !ok {
+		return
+	}
+	if len(trace.Events) == maxTraceEvents {
+		trace.Omitted++
+		return
+	}
+	trace.Events = append(trace.Events, TraceEvent{Event: event, Detail: detail, At: time.Now()})
+}
+
+// find returns a copy of a trace by trace or message ID
+func (l *traceLog) find(id string) (Trace, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if trace, ok := l.traces[id]; ok {
+		return l.copy(trace), true
+	}
+	for _, trace := range l.traces {
+		if trace.MessageID == id {
+			return l.copy(trace), true
+		}
+	}
+	return Trace{}, false
+}
+
+func (l *traceLog) copy(trace *Trace) Trace {
+	c := *trace
+	c.Events = append([]TraceEvent(nil), trace.Events...)
+	return c
+}
+
+// reject stops a message with an error that carries its trace ID
+func (m *pipelineMessage) reject(code, reason string) {
+	m.Client.sendFrame(ErrorFrame{Type: "error", Code: code, Reason: reason, TraceID: m.Msg.TraceID}, PriorityHigh)
+}
+
+// rejectLimit stops a message over a size limit, naming the limit
+func (m *pipelineMessage) rejectLimit(code, reason string, limit int) {
+	m.Client.sendFrame(ErrorFrame{Type: "error", Code: code, Reason: reason, Limit: limit, TraceID: m.Msg.TraceID}, PriorityHigh)
+}
+
+// handleAdminTraces serves GET /admin/traces?id= with the trace of a message,
+// looked up by its trace ID or message ID
+func handleAdminTraces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+	trace, ok := traces.find(id)
+	if !ok {
+		http.Error(w, "Trace not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, trace)
+}
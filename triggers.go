@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Admins configure reaction triggers per channel: a given emoji on a message,
+// added by one of the listed users (e.g. ✅ by a moderator), pins the message,
+// forwards a copy to another channel or POSTs it to a webhook. A trigger fires
+// once per message; every firing is written to the audit log. Webhook events
+// go through the outbox, see outbox.go.
+
+// How long a trigger remembers the messages it already fired for
+var triggerFiredTTL = envDuration("TRIGGER_FIRED_TTL", 7*24*time.Hour)
+
+// ReactionTrigger is a channel's rule; Secret signs webhook calls like a slash
+// command's and is only shown when the trigger is created
+type ReactionTrigger struct {
+	ID        string    `json:"id"`
+	Channel   string    `json:"channel"`
+	Emoji     string    `json:"emoji"`
+	Users     []string  `json:"users,omitempty"` // who may fire it; anyone when empty
+	Action    string    `json:"action"`          // "pin", "forward" or "webhook"
+	Target    string    `json:"target,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ForwardInfo marks a message a trigger copied from another channel
+type ForwardInfo struct {
+	MessageID string `json:"messageId"`
+	Channel   string `json:"channel"`
+	Username  string `json:"username"`
+}
+
+// TriggerEvent is POSTed to a webhook trigger's URL
+type TriggerEvent struct {
+	Type      string    `json:"type"` // "reaction_trigger"
+	EventID   string    `json:"eventId"`
+	TriggerID string    `json:"triggerId"`
+	Channel   string    `json:"channel"`
+	Emoji     string    `json:"emoji"`
+	Username  string    `json:"username"`
+	Message   Message   `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Triggers of a channel by ID, as JSON ReactionTrigger values
+func triggersKey(channel string) string {
+	return "websocket:triggers:" + channel
+}
+
+func triggerFiredKey(id, message string) string {
+	return "websocket:triggers:fired:" + id + ":" + message
+}
+
+// channelTriggers returns the triggers of a channel
+func (h *Hub) channelTriggers(channel string) []ReactionTrigger {
+	stored, err := h.redis.HVals(context.Background(), triggersKey(channel)).Result()
+	if err != nil {
+		return nil
+	}
+	triggers := make([]ReactionTrigger, 0, len(stored))
+	for _, raw := range stored {
+		var t ReactionTrigger
+		if json.Unmarshal([]byte(raw), &t) == nil {
+			triggers = append(triggers, t)
+		}
+	}
+	sort.Slice(triggers, func(i, j int) bool { return triggers[i].ID < triggers[j].ID })
+	return triggers
+}
+
+func (t ReactionTrigger) firedBy(username string) bool {
+	if len(t.Users) == 0 {
+		return true
+	}
+	for _, user := range t.Users {
+		if user == username {
+			return true
+		}
+	}
+	return false
+}
+
+// fireReactionTriggers runs the pin and forward triggers matching a reaction
+// someone added; webhook triggers are queued with the reaction, see addReaction
+func (h *Hub) fireReactionTriggers(channel, messageID, emoji, username string) {
+	ctx := context.Background()
+	var msg *Message
+	for _, t := range h.channelTriggers(channel) {
+		if t.Action == "webhook" || t.Emoji != emoji || !t.firedBy(username) {
+			continue
+		}
+		if msg == nil {
+			located, ok := h.storedMessage(ctx, channel, messageID)
+			if !ok {
+				return
+			}
+			msg = &located
+		}
+		first, err := h.redis.SetNX(ctx, triggerFiredKey(t.ID, messageID), username, triggerFiredTTL).Result()
+		if err != nil || !first {
+			continue
+		}
+		if err := h.runTrigger(t, *msg, username); err != nil {
+			log.Printf("Tepki tetikleyicisi çalıştırılamadı: %s (%s): %v", t.ID, channel, err)
+			h.redis.Del(ctx, triggerFiredKey(t.ID, messageID))
+			continue
+		}
+		h.recordTriggerFired(t, username, messageID)
+	}
+}
+
+// recordTriggerFired writes a trigger's firing to the audit log
+func (h *Hub) recordTriggerFired(t ReactionTrigger, username, messageID string) {
+	h.recordAudit("reaction_trigger", username, t.Channel,
+		fmt.Sprintf("%s %s %s mesaj=%s", t.ID, t.Emoji, t.Action, messageID))
+}
+
+func (h *Hub) runTrigger(t ReactionTrigger, msg Message, username string) error {
+	switch t.Action {
+	case "pin":
+		return h.pinMessage(msg, username)
+	case "forward":
+		_, err := h.forwardMessage(msg, t.Target)
+		return err
+	}
+	return fmt.Errorf("unknown action %q", t.Action)
+}
+
+// webhookTriggers returns the webhook triggers a reaction fires, with the
+// message they send
+func (h *Hub) webhookTriggers(channel, messageID, emoji, username string) ([]ReactionTrigger, Message) {
+	var matching []ReactionTrigger
+	for _, t := range h.channelTriggers(channel) {
+		if t.Action == "webhook" && t.Emoji == emoji && t.firedBy(username) {
+			matching = append(matching, t)
+		}
+	}
+	if len(matching) == 0 {
+		return nil, Message{}
+	}
+	msg, ok := h.storedMessage(context.Background(), channel, messageID)
+	if !ok {
+		return nil, Message{}
+	}
+	return matching, msg
+}
+
+// triggerEvent is the outbox event of a webhook trigger fired for msg
+func triggerEvent(t ReactionTrigger, msg Message, username string) (OutboxEvent, error) {
+	id := newULID()
+	body, err := json.Marshal(TriggerEvent{
+		Type:      "reaction_trigger",
+		EventID:   id,
+		TriggerID: t.ID,
+		Channel:   t.Channel,
+		Emoji:     t.Emoji,
+		Username:  username,
+		Message:   msg,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	return OutboxEvent{ID: id, URL: t.URL, Secret: t.Secret, Body: body, CreatedAt: time.Now()}, nil
+}
+
+// forwardMessage posts a copy of a message to another channel
+func (h *Hub) forwardMessage(msg Message, target string) (Message, error) {
+	forwarded := msg
+	forwarded.MessageID = newULID()
+	forwarded.Seq = 0
+	forwarded.Timestamp = time.Now()
+	forwarded.Channel = target
+	forwarded.DeliveredTo, forwarded.SeenBy, forwarded.Reactions = nil, nil, nil
+	forwarded.Mentions, forwarded.ReplyTo = nil, nil
+	forwarded.Edited, forwarded.EditedAt = false, nil
+	forwarded.ForwardedFrom = &ForwardInfo{MessageID: msg.MessageID, Channel: msg.Channel, Username: msg.Username}
+	frame, err := json.Marshal(forwarded)
+	if err != nil {
+		return forwarded, err
+	}
+	h.broadcast <- inboundMessage{data: frame}
+	return forwarded, nil
+}
+
+// handleAdminTriggers serves /admin/triggers: GET ?channel= lists a channel's
+// triggers, POST creates one, DELETE ?channel=&id= removes one
+func handleAdminTriggers(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if hub.redis == nil {
+		http.Error(w, "Triggers require Redis", http.StatusServiceUnavailable)
+		return
+	}
+	ctx := context.Background()
+	admin, _ := adminFromRequest(r)
+	switch r.Method {
+	case "GET":
+		channel := r.URL.Query().Get("channel")
+		if channel == "" {
+			http.Error(w, "channel required", http.StatusBadRequest)
+			return
+		}
+		triggers := hub.channelTriggers(channel)
+		for i := range triggers {
+			triggers[i].Secret = ""
+		}
+		writeJSON(w, http.StatusOK, triggers)
+	case "POST":
+		var t ReactionTrigger
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil || t.Channel == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if !validEmoji(t.Emoji) {
+			http.Error(w, "Invalid emoji", http.StatusBadRequest)
+			return
+		}
+		t.Secret = ""
+		switch t.Action {
+		case "pin":
+			t.Target, t.URL = "", ""
+		case "forward":
+			if t.Target == "" || t.Target == t.Channel {
+				http.Error(w, "Invalid target channel", http.StatusBadRequest)
+				return
+			}
+			t.URL = ""
+		case "webhook":
+			if !validHookURL(t.URL) {
+				http.Error(w, "Invalid webhook URL", http.StatusBadRequest)
+				return
+			}
+			secret := make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				http.Error(w, "Error creating secret", http.StatusInternalServerError)
+				return
+			}
+			t.Target, t.Secret = "", hex.EncodeToString(secret)
+		default:
+			http.Error(w, "Invalid action", http.StatusBadRequest)
+			return
+		}
+		t.ID = newULID()
+		t.CreatedBy, t.CreatedAt = admin, time.Now()
+		data, err := json.Marshal(t)
+		if err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := hub.redis.HSet(ctx, triggersKey(t.Channel), t.ID, data).Err(); err != nil {
+			http.Error(w, "Error saving trigger", http.StatusInternalServerError)
+			return
+		}
+		detail := fmt.Sprintf("%s %s -> %s", t.ID, t.Emoji, t.Action)
+		if t.Target != "" || t.URL != "" {
+			detail += " " + t.Target + t.URL
+		}
+		hub.recordAudit("trigger_created", admin, t.Channel, detail)
+		writeJSON(w, http.StatusCreated, t)
+	case "DELETE":
+		channel, id := r.URL.Query().Get("channel"), r.URL.Query().Get("id")
+		removed, err := hub.redis.HDel(ctx, triggersKey(channel), id).Result()
+		if err != nil {
+			http.Error(w, "Error removing trigger", http.StatusInternalServerError)
+			return
+		}
+		if removed == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		hub.recordAudit("trigger_removed", admin, channel, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
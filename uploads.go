@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const uploadsDir = "./uploads"
+
+// Uploads are split into content addressed blobs and per-upload metadata. The
+// public URL only contains the opaque upload ID, the original filename is kept
+// in the metadata and never touches the filesystem.
+var (
+	blobsDir = filepath.Join(uploadsDir, "blobs")
+	metaDir  = filepath.Join(uploadsDir, "meta")
+)
+
+// storeUploadBlob writes the upload to a temporary file while hashing it and then
+// moves it to blobs/<sha256>. If the blob already exists the copy is discarded.
+func storeUploadBlob(src io.Reader) (string, int64, error) {
+	if err := os.MkdirAll(blobsDir, 0755); err != nil {
+		return "", 0, err
+	}
+	tmp, err := os.CreateTemp(blobsDir, ".upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hasher), src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	finalPath := filepath.Join(blobsDir, hash)
+	if _, err := os.Stat(finalPath); err == nil {
+		return hash, written, nil
+	}
+	if err := os.Rename(tmp.Name(), finalPath); err != nil {
+		return "", 0, err
+	}
+	return hash, written, nil
+}
+
+// isContentHash reports whether name is a hex encoded SHA-256 digest
+func isContentHash(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// FileMeta describes one upload. Several uploads may share the same blob.
+type FileMeta struct {
+	ID          string    `json:"id"`
+	Blob        string    `json:"blob"` // SHA-256 of the content
+	Name        string    `json:"name"` // original filename, only used for display and downloads
+	Channel     string    `json:"channel"`
+	Uploader    string    `json:"uploader"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	UploadedAt  time.Time `json:"uploadedAt"`
+	Status      string    `json:"status"` // processing state, see FileStatus*
+}
+
+func saveFileMeta(meta FileMeta) error {
+	if err := os.MkdirAll(metaDir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(metaDir, meta.ID+".json"), data, 0644)
+}
+
+func getFileMeta(id string) (*FileMeta, bool) {
+	if !isULID(id) {
+		return nil, false
+	}
+	data, err := os.ReadFile(filepath.Join(metaDir, id+".json"))
+	if err != nil {
+		return nil, false
+	}
+	var meta FileMeta
+	if err := json.Unmarshal(data, &meta); err != nil || !isContentHash(meta.Blob) {
+		return nil, false
+	}
+	return &meta, true
+}
+
+// countDownload increments the download counter of an upload
+func (h *Hub) countDownload(id string) {
+	if h.redis == nil {
+		return
+	}
+	h.redis.HIncrBy(context.Background(), "websocket:file:downloads", id, 1)
+}
+
+// signedFileURL returns the download URL handed out to channel members
+func signedFileURL(id, channel string) string {
+	return "/uploads/" + id + "?sig=" + signValue("file", id, channel)
+}
+
+// serveUploads is the download handler for /uploads: it checks permissions,
+// counts downloads and serves the file with ETag and Range support. An upload ID
+// always refers to the same content, so browsers and CDNs may cache it as immutable.
+func serveUploads(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Clean as an absolute path so ".." can't escape the uploads directory
+	name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/uploads/"))
+	if name == "/" || strings.HasPrefix(path.Base(name), ".") {
+		http.NotFound(w, r)
+		return
+	}
+
+	meta, ok := getFileMeta(strings.TrimPrefix(name, "/"))
+	if !ok {
+		serveLegacyUpload(w, r, name)
+		return
+	}
+	downloader, ok := hub.authorizeDownload(r, meta)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	stream := downloadMode == downloadModeStream
+
+	// ?w= serves a resized copy once it exists, the original until then
+	var f io.ReadSeekCloser
+	var err error
+	contentType, etag := meta.ContentType, meta.Blob
+	if p, ct, ok := renditionFile(meta, r.URL.Query().Get("w")); ok {
+		f, err = os.Open(p)
+		contentType, etag = ct, meta.Blob+"_"+r.URL.Query().Get("w")
+	} else {
+		f, err = blobStore.Open(meta.Blob)
+	}
+	if err != nil {
+		log.Printf("Dosya içeriği bulunamadı: %s (%s)", meta.ID, meta.Blob)
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	// Rename-on-download: the stored key never depends on the filename
+	downloadName := meta.Name
+	if rename := r.URL.Query().Get("name"); rename != "" {
+		downloadName = rename
+	}
+	disposition := "inline"
+	if r.URL.Query().Get("download") == "1" {
+		disposition = "attachment"
+	}
+
+	w.Header().Set("ETag", `"`+etag+`"`)
+	if stream {
+		// Every use of the file is authorized again, shared caches must not keep it
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else if etag == meta.Blob && r.URL.Query().Get("w") != "" {
+		// The rendition is still being generated, don't cache the fallback
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": downloadName}))
+
+	// Count a download once per transfer, not for every resumed range or rendition
+	if r.Method == "GET" && isFirstRange(r.Header.Get("Range")) && r.URL.Query().Get("w") == "" {
+		hub.countDownload(meta.ID)
+		hub.recordDigestDownload(meta)
+		if stream {
+			hub.recordAudit("file_downloaded", downloader, meta.ID, fmt.Sprintf("%s, #%s", meta.Name, meta.Channel))
+		}
+	}
+	w = throttle(w, r, downloadIPLimiters.get(clientIP(r)))
+	if stream && downloader != "" {
+		w = throttle(w, r, downloadUserLimiters.get(downloader))
+	}
+
+	// ServeContent answers Range, If-Range and If-None-Match requests
+	http.ServeContent(w, r, "", meta.UploadedAt, f)
+}
+
+// serveLegacyUpload serves files stored by path before upload IDs existed
+func serveLegacyUpload(w http.ResponseWriter, r *http.Request, name string) {
+	if strings.HasPrefix(name, "/blobs/") || strings.HasPrefix(name, "/meta/") {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(filepath.Join(uploadsDir, filepath.FromSlash(name)))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`W/"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, path.Base(name), info.ModTime(), f)
+}
+
+// isFirstRange reports whether a Range header is absent or starts at byte 0
+func isFirstRange(header string) bool {
+	return header == "" || strings.HasPrefix(header, "bytes=0-")
+}
+
+func handleFileUpload(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if state := currentMaintenance(); state.Enabled {
+		http.Error(w, state.Message, http.StatusServiceUnavailable)
+		return
+	}
+
+	// Parse multipart form (max 32MB)
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil {
+		log.Printf("Dosya parse hatası: %v", err)
+		http.Error(w, "File too large", http.StatusBadRequest)
+		return
+	}
+
+	// Get file from form
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		log.Printf("Dosya alma hatası: %v", err)
+		http.Error(w, "Error retrieving file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	// Uploader and channel come from the upload token, not the form
+	username, channel, ok := hub.uploadIdentity(r.FormValue("token"))
+	if !ok {
+		http.Error(w, "Invalid or expired upload token", http.StatusForbidden)
+		return
+	}
+	if (r.FormValue("username") != "" && r.FormValue("username") != username) ||
+		(r.FormValue("channel") != "" && r.FormValue("channel") != channel) {
+		http.Error(w, "Upload token doesn't match username or channel", http.StatusForbidden)
+		return
+	}
+	if hub.channelSettings(channel).ContentMode == contentModeEmojiOnly {
+		http.Error(w, "This channel only accepts emoji", http.StatusForbidden)
+		return
+	}
+
+	// Validate file size (max 10MB)
+	if header.Size > 10*1024*1024 {
+		log.Printf("Dosya çok büyük: %d bytes", header.Size)
+		http.Error(w, "File size too large (max 10MB)", http.StatusBadRequest)
+		return
+	}
+
+	if uploadExtensionDenied(header.Filename) {
+		log.Printf("İzin verilmeyen dosya uzantısı: %s", header.Filename)
+		http.Error(w, "File type not allowed", http.StatusBadRequest)
+		return
+	}
+
+	// Executables are refused by content, whatever name or type they claim
+	if !uploadAllowExecutables {
+		kind, err := detectExecutable(file)
+		if err != nil {
+			log.Printf("Dosya okuma hatası: %v", err)
+			http.Error(w, "Error reading file", http.StatusBadRequest)
+			return
+		}
+		if kind != "" {
+			log.Printf("Çalıştırılabilir dosya reddedildi (%s): %s", kind, header.Filename)
+			http.Error(w, "Executable files are not allowed", http.StatusBadRequest)
+			return
+		}
+	}
+
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" {
+		// Dosya uzantısından MIME type'ı tahmin et
+		ext := strings.ToLower(filepath.Ext(header.Filename))
+		switch ext {
+		case ".jpg", ".jpeg":
+			contentType = "image/jpeg"
+		case ".png":
+			contentType = "image/png"
+		case ".gif":
+			contentType = "image/gif"
+		case ".pdf":
+			contentType = "application/pdf"
+		case ".txt":
+			contentType = "text/plain"
+		case ".zip":
+			contentType = "application/zip"
+		case ".doc":
+			contentType = "application/msword"
+		case ".docx":
+			contentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+		case ".xls":
+			contentType = "application/vnd.ms-excel"
+		case ".xlsx":
+			contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		default:
+			log.Printf("Bilinmeyen dosya uzantısı: %s", ext)
+			http.Error(w, "Unsupported file type", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !uploadTypeAllowed(contentType) {
+		log.Printf("İzin verilmeyen dosya tipi: %s", contentType)
+		http.Error(w, "File type not allowed", http.StatusBadRequest)
+		return
+	}
+
+	// Store the content under its hash and the upload under an opaque ID;
+	// the original filename is only kept as metadata
+	blob, written, err := storeUploadBlob(file)
+	if err != nil {
+		log.Printf("Dosya kaydetme hatası: %v", err)
+		http.Error(w, "Error saving file", http.StatusInternalServerError)
+		return
+	}
+	meta := FileMeta{
+		ID:          newULID(),
+		Blob:        blob,
+		Name:        header.Filename,
+		Channel:     channel,
+		Uploader:    username,
+		ContentType: contentType,
+		Size:        written,
+		UploadedAt:  time.Now(),
+		Status:      FileStatusQueued,
+	}
+	if err := saveFileMeta(meta); err != nil {
+		log.Printf("Dosya bilgisi kaydetme hatası: %v", err)
+		http.Error(w, "Error saving file", http.StatusInternalServerError)
+		return
+	}
+	hub.recordStorage(storageUploads, channel, username, written)
+
+	log.Printf("Dosya başarıyla kaydedildi: %s -> %s (%d bytes)", meta.ID, blob, written)
+
+	// Determine message type
+	messageType := "file"
+	if strings.HasPrefix(contentType, "image/") {
+		messageType = "image"
+	}
+
+	// Create file message
+	fileURL := signedFileURL(meta.ID, channel)
+	fileMessage := Message{
+		MessageID: newULID(),
+		Username:  username,
+		Message:   fmt.Sprintf("Dosya paylaştı: %s", header.Filename),
+		Timestamp: time.Now(),
+		Channel:   channel,
+		Type:      messageType,
+		FileURL:   fileURL,
+		FileName:  header.Filename,
+		FileSize:  header.Size,
+		FileID:    meta.ID,
+		TraceID:   traceID(r.Header.Get("X-Trace-Id")),
+		Guest:     isGuestName(username),
+	}
+	if messageType == "image" {
+		describeImage(&fileMessage, meta)
+	}
+
+	// Broadcast file message
+	messageJSON, err := json.Marshal(fileMessage)
+	if err != nil {
+		log.Printf("Dosya mesajı marshalling hatası: %v", err)
+		http.Error(w, "Error processing file message", http.StatusInternalServerError)
+		return
+	}
+
+	// Thumbnails, scanning etc. run in the background once the message is out
+	// and report file_status frames
+	traces.start(fileMessage)
+	traces.record(fileMessage.TraceID, TraceReceived, messageType)
+	hub.broadcast <- inboundMessage{data: messageJSON, traceID: fileMessage.TraceID}
+
+	// Return success response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"message":  "File uploaded successfully",
+		"fileUrl":  fileURL,
+		"fileName": header.Filename,
+		"fileSize": header.Size,
+		"fileId":   meta.ID,
+		"traceId":  fileMessage.TraceID,
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Uploads go over plain HTTP, so /upload only accepts a short-lived token the
+// client asked for over its socket. The token binds the upload to the
+// connection, its username and a channel it has joined; the connection must
+// still be open when the upload arrives.
+
+// How long an upload token can be used
+var uploadTokenTTL = envDuration("UPLOAD_TOKEN_TTL", 5*time.Minute)
+
+// UploadTokenRequest is the inbound {"type":"upload_token","channel":"..."} frame
+type UploadTokenRequest struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+}
+
+// UploadTokenFrame carries a token for one channel, sent as the token form field of /upload
+type UploadTokenFrame struct {
+	Type      string    `json:"type"`
+	Channel   string    `json:"channel"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// uploadClaims is the signed content of an upload token
+type uploadClaims struct {
+	ClientID string `json:"cid"`
+	Username string `json:"u"`
+	Channel  string `json:"ch"`
+	Expires  int64  `json:"exp"`
+}
+
+// signClaims signs claims for a purpose as "<base64 claims>.<signature>"
+func signClaims(purpose string, claims interface{}) string {
+	data, _ := json.Marshal(claims)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signValue(purpose, payload)
+}
+
+// parseClaims decodes the claims of a token signed for purpose
+func parseClaims(purpose, token string, claims interface{}) bool {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !verifySignature(sig, purpose, payload) {
+		return false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	return err == nil && json.Unmarshal(data, claims) == nil
+}
+
+// uploadToken signs upload claims
+func uploadToken(claims uploadClaims) string {
+	return signClaims("upload", claims)
+}
+
+// parseUploadToken returns the claims of a valid, unexpired token
+func parseUploadToken(token string) (uploadClaims, bool) {
+	var claims uploadClaims
+	if !parseClaims("upload", token, &claims) {
+		return claims, false
+	}
+	return claims, time.Now().Unix() < claims.Expires
+}
+
+// handleUploadToken issues an upload token for a channel the client is in
+func (c *Client) handleUploadToken(raw []byte) {
+	var req UploadTokenRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return
+	}
+	if req.Channel == "" || !c.inChannel(req.Channel) {
+		c.sendError(ErrInvalidChannel, "Bu kanala dosya yükleyemezsiniz")
+		return
+	}
+	if c.guestRestricted(guestNoUploads) {
+		c.sendError(ErrGuestRestricted, "Misafirler dosya yükleyemez")
+		return
+	}
+	expires := time.Now().Add(uploadTokenTTL)
+	c.sendFrame(UploadTokenFrame{
+		Type:    "upload_token",
+		Channel: req.Channel,
+		Token: uploadToken(uploadClaims{
+			ClientID: c.ID,
+			Username: c.Username,
+			Channel:  req.Channel,
+			Expires:  expires.Unix(),
+		}),
+		ExpiresAt: expires,
+	}, PriorityHigh)
+}
+
+// uploadIdentity checks an upload token against the connections of the hub
+// and returns the username and channel the upload is for
+func (h *Hub) uploadIdentity(token string) (string, string, bool) {
+	claims, ok := parseUploadToken(token)
+	if !ok {
+		return "", "", false
+	}
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for client := range h.clients {
+		if client.ID == claims.ClientID && client.Username == claims.Username {
+			return claims.Username, claims.Channel, client.inChannel(claims.Channel)
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The proxied APIs each have their own policy, overridable per upstream with
+// UPSTREAM_<NAME>_CONNECT_TIMEOUT, _READ_TIMEOUT, _RETRIES, _BACKOFF and
+// _HEDGE_AFTER. Idempotent calls that fail with a network error or a 502, 503
+// or 504 are retried after an exponential backoff with full jitter; with
+// HedgeAfter set, a second copy of an attempt is sent when the first hasn't
+// answered by then and whichever answers first is used.
+
+const upstreamMaxResponse = 4 << 20
+
+// Attempts per upstream and kind ("maya/retry", "numerology/hedge", ...)
+var upstreamAttempts = expvar.NewMap("upstream_attempts")
+
+var errUpstreamStatus = errors.New("upstream unavailable")
+
+// upstreamPolicy bounds the calls to one upstream
+type upstreamPolicy struct {
+	ConnectTimeout time.Duration // dial and TLS handshake
+	ReadTimeout    time.Duration // from the request being sent to the body being read
+	Retries        int           // extra attempts, idempotent calls only
+	Backoff        time.Duration // base of the jittered exponential backoff
+	HedgeAfter     time.Duration // 0 turns hedging off
+	Idempotent     bool          // calls may be repeated, e.g. GETs
+	MaxResponse    int64         // bytes of the body read, upstreamMaxResponse when 0
+}
+
+// upstream is a proxied API with its policy
+type upstream struct {
+	name   string
+	policy upstreamPolicy
+	client *http.Client
+}
+
+// newUpstream applies the UPSTREAM_<NAME>_* overrides to a policy
+func newUpstream(name string, policy upstreamPolicy) *upstream {
+	prefix := "UPSTREAM_" + strings.ToUpper(name) + "_"
+	policy.ConnectTimeout = envDuration(prefix+"CONNECT_TIMEOUT", policy.ConnectTimeout)
+	policy.ReadTimeout = envDuration(prefix+"READ_TIMEOUT", policy.ReadTimeout)
+	policy.Retries = max(envInt(prefix+"RETRIES", policy.Retries), 0)
+	policy.Backoff = envDuration(prefix+"BACKOFF", policy.Backoff)
+	policy.HedgeAfter = envDuration(prefix+"HEDGE_AFTER", policy.HedgeAfter)
+
+	dialer := &net.Dialer{Timeout: policy.ConnectTimeout}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   policy.ConnectTimeout,
+		ResponseHeaderTimeout: policy.ReadTimeout,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	return &upstream{name: name, policy: policy, client: &http.Client{Transport: transport}}
+}
+
+var (
+	// Readings include an AI interpretation, hence the long read timeout; a
+	// reading only depends on the name and birth date, so it may be repeated
+	numerologyUpstream = newUpstream("numerology", upstreamPolicy{
+		ConnectTimeout: 5 * time.Second,
+		ReadTimeout:    120 * time.Second,
+		Retries:        1,
+		Backoff:        500 * time.Millisecond,
+		Idempotent:     true,
+	})
+	mayaUpstream = newUpstream("maya", upstreamPolicy{
+		ConnectTimeout: 2 * time.Second,
+		ReadTimeout:    30 * time.Second,
+		Retries:        2,
+		Backoff:        200 * time.Millisecond,
+		Idempotent:     true,
+	})
+)
+
+// upstreamResponse is a fully read answer
+type upstreamResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// retryable reports whether another attempt may get a better answer
+func retryable(resp *upstreamResponse, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	switch resp.Status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// do calls the upstream under its policy. The answer of the last attempt is
+// returned, with its status even if that is an error status.
+func (u *upstream) do(ctx context.Context, method, url string, body []byte, header http.Header) (*upstreamResponse, error) {
+	attempts := 1
+	if u.policy.Idempotent {
+		attempts += u.policy.Retries
+	}
+	var resp *upstreamResponse
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			upstreamAttempts.Add(u.name+"/retry", 1)
+			wait := time.Duration(rand.Int63n(int64(u.policy.Backoff<<(i-1)) + 1))
+			log.Printf("%s isteği yeniden deneniyor (%d/%d, %v sonra): %v", u.name, i, attempts-1, wait, u.failure(resp, err))
+			select {
+			case <-ctx.Done():
+				return resp, ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		resp, err = u.hedged(ctx, method, url, body, header)
+		if !retryable(resp, err) {
+			break
+		}
+	}
+	return resp, err
+}
+
+func (u *upstream) failure(resp *upstreamResponse, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: status %d", errUpstreamStatus, resp.Status)
+}
+
+// hedged runs one attempt, sending a second copy after HedgeAfter
+func (u *upstream) hedged(ctx context.Context, method, url string, body []byte, header http.Header) (*upstreamResponse, error) {
+	if !u.policy.Idempotent || u.policy.HedgeAfter <= 0 {
+		return u.attempt(ctx, method, url, body, header)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // the slower copy is abandoned
+
+	type result struct {
+		resp *upstreamResponse
+		err  error
+	}
+	results := make(chan result, 2)
+	send := func() {
+		resp, err := u.attempt(ctx, method, url, body, header)
+		results <- result{resp, err}
+	}
+	go send()
+	hedge := time.NewTimer(u.policy.HedgeAfter)
+	defer hedge.Stop()
+
+	pending := 1
+	var last result
+	for pending > 0 {
+		select {
+		case <-hedge.C:
+			upstreamAttempts.Add(u.name+"/hedge", 1)
+			pending++
+			go send()
+		case last = <-results:
+			pending--
+			if !retryable(last.resp, last.err) {
+				return last.resp, last.err
+			}
+		}
+	}
+	return last.resp, last.err
+}
+
+// attempt sends the request once and reads the answer within ReadTimeout
+func (u *upstream) attempt(ctx context.Context, method, url string, body []byte, header http.Header) (*upstreamResponse, error) {
+	upstreamAttempts.Add(u.name+"/attempt", 1)
+	ctx, cancel := context.WithTimeout(ctx, u.policy.ConnectTimeout+u.policy.ReadTimeout)
+	defer cancel()
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	limit := u.policy.MaxResponse
+	if limit <= 0 {
+		limit = upstreamMaxResponse
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	if err != nil {
+		return nil, err
+	}
+	return &upstreamResponse{Status: resp.StatusCode, Header: resp.Header, Body: data}, nil
+}
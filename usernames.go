@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Usernames are checked when a connection first names its user and whenever
+// it sends another name. A name must be USERNAME_MIN_LENGTH to
+// USERNAME_MAX_LENGTH characters without USERNAME_DISALLOWED_CHARS, invisible
+// characters or repeated spaces (surrounding ones are trimmed), and must not
+// mix Latin, Cyrillic and Greek letters. Names are compared by their skeleton, the name
+// with case, accents, separators and look-alike characters folded ("Melih",
+// "meIih", "mélih" and "меlih" with a Cyrillic е share one), so a name that
+// looks like one in USERNAME_RESERVED is refused, as is one that looks like
+// the name another user connected with in the last USERNAME_CLAIM_TTL or one
+// that looks like a registered account's, unless signed in to it.
+
+var (
+	usernameMinLength = envInt("USERNAME_MIN_LENGTH", 2)
+	usernameMaxLength = envInt("USERNAME_MAX_LENGTH", 32)
+	// Bot replies are posted as "/command", so "/" is never allowed either
+	usernameDisallowed = envString("USERNAME_DISALLOWED_CHARS", "@#:<>\"'`&\\")
+	usernameReserved   = envListOr("USERNAME_RESERVED", []string{
+		"admin", "administrator", "root", "system", "sistem", "server", "sunucu",
+		"moderator", "mod", "bot", "support", "destek", "chatliyo",
+	})
+	usernameClaimTTL = envDuration("USERNAME_CLAIM_TTL", 30*24*time.Hour)
+)
+
+// Refused names per error code
+var usernameRejections = expvar.NewMap("username_rejections")
+
+// homoglyphs maps characters to the Latin letter they are mistaken for. I, i,
+// l and 1 all fold to l, so "adm1n" and "admln" look like "admin".
+var homoglyphs = map[rune]rune{
+	'0': 'o', '1': 'l', '|': 'l', 'I': 'l', 'i': 'l', 'ı': 'l', 'ł': 'l', 'ø': 'o', 'đ': 'd', 'ħ': 'h',
+	// Cyrillic
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x', 'і': 'l', 'ј': 'j',
+	'ѕ': 's', 'һ': 'h', 'ԁ': 'd', 'ӏ': 'l', 'ԛ': 'q', 'ԝ': 'w', 'ɡ': 'g',
+	'А': 'a', 'В': 'b', 'Е': 'e', 'К': 'k', 'М': 'm', 'Н': 'h', 'О': 'o', 'Р': 'p', 'С': 'c',
+	'Т': 't', 'Х': 'x', 'У': 'y', 'І': 'l', 'Ј': 'j', 'Ѕ': 's',
+	// Greek
+	'α': 'a', 'ο': 'o', 'ν': 'v', 'ρ': 'p', 'ι': 'l', 'κ': 'k', 'υ': 'u',
+	'Α': 'a', 'Β': 'b', 'Ε': 'e', 'Ζ': 'z', 'Η': 'h', 'Ι': 'l', 'Κ': 'k', 'Μ': 'm', 'Ν': 'n',
+	'Ο': 'o', 'Ρ': 'p', 'Τ': 't', 'Υ': 'y', 'Χ': 'x',
+}
+
+// Letter pairs that read as one letter, applied to the folded name
+var homoglyphPairs = strings.NewReplacer("rn", "m", "vv", "w")
+
+func usernameSkeletonKey(skeleton string) string {
+	return "websocket:username:skeleton:" + skeleton
+}
+
+// normalizeUsername puts a name sent by a client in composed form without
+// surrounding spaces, so the same name always compares equal
+func normalizeUsername(name string) string {
+	return strings.TrimSpace(norm.NFC.String(name))
+}
+
+// usernameSkeleton folds a name to what it looks like
+func usernameSkeleton(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(name) {
+		if unicode.Is(unicode.Mn, r) || unicode.IsSpace(r) || r == '_' || r == '-' || r == '.' {
+			continue
+		}
+		if mapped, ok := homoglyphs[r]; ok {
+			r = mapped
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return homoglyphPairs.Replace(b.String())
+}
+
+// checkUsername applies the policy to a name; it returns an error code and
+// reason when the name isn't allowed
+func checkUsername(name string) (string, string) {
+	if n := utf8.RuneCountInString(name); n < usernameMinLength || n > usernameMaxLength {
+		return ErrInvalidUsername, fmt.Sprintf("Kullanıcı adı %d ile %d karakter arasında olmalı", usernameMinLength, usernameMaxLength)
+	}
+	if strings.ContainsAny(name, usernameDisallowed+"/") {
+		return ErrInvalidUsername, "Kullanıcı adında izin verilmeyen karakterler var"
+	}
+	scripts := make(map[string]bool)
+	var previous rune
+	for _, r := range name {
+		switch {
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r), !unicode.IsPrint(r) && r != ' ':
+			return ErrInvalidUsername, "Kullanıcı adında görünmeyen karakterler var"
+		case r == ' ' && previous == ' ':
+			return ErrInvalidUsername, "Kullanıcı adında art arda boşluk olamaz"
+		case unicode.Is(unicode.Latin, r):
+			scripts["latin"] = true
+		case unicode.Is(unicode.Cyrillic, r):
+			scripts["cyrillic"] = true
+		case unicode.Is(unicode.Greek, r):
+			scripts["greek"] = true
+		}
+		previous = r
+	}
+	if len(scripts) > 1 {
+		return ErrInvalidUsername, "Kullanıcı adı farklı alfabelerin harflerini karıştıramaz"
+	}
+	skeleton := usernameSkeleton(name)
+	if skeleton == "" {
+		return ErrInvalidUsername, "Kullanıcı adında harf ya da rakam olmalı"
+	}
+	for _, reserved := range usernameReserved {
+		if skeleton == usernameSkeleton(reserved) {
+			return ErrUsernameReserved, "Bu kullanıcı adı ayrılmış"
+		}
+	}
+	if isGuestName(name) {
+		return ErrUsernameReserved, "Bu biçimdeki adlar misafirlere ayrılmış"
+	}
+	return "", ""
+}
+
+// claimUsername checks a name a connection wants to use against the policy
+// and the names other users hold. It tells the client and returns false when
+// the name can't be used.
+func (h *Hub) claimUsername(c *Client, name string) bool {
+	code, reason := "", ""
+	if name != c.guestName {
+		code, reason = checkUsername(name)
+	}
+	if code == "" && c.account != "" && name != c.account {
+		code, reason = ErrLoginRequired, fmt.Sprintf("Bu bağlantı yalnızca %s adını kullanabilir", c.account)
+	}
+	if code == "" && c.account == "" && h.accountHolder(name) != "" {
+		code, reason = ErrLoginRequired, "Bu kullanıcı adı kayıtlı, kullanmak için giriş yapın"
+	}
+	if code == "" {
+		if holder := h.usernameHolder(usernameSkeleton(name), name); holder != "" {
+			code, reason = ErrUsernameTaken, "Bu kullanıcı adı başka bir kullanıcının adına çok benziyor"
+		}
+	}
+	if code != "" {
+		log.Printf("Kullanıcı adı reddedildi: %q, ID: %s, %s", name, c.ID, code)
+		usernameRejections.Add(code, 1)
+		c.sendError(code, reason)
+		return false
+	}
+	return true
+}
+
+// usernameHolder returns the user who holds a skeleton when it's someone
+// other than name, and otherwise claims it for name. Without Redis only
+// connected users hold names.
+func (h *Hub) usernameHolder(skeleton, name string) string {
+	if h.redis == nil {
+		h.mutex.RLock()
+		defer h.mutex.RUnlock()
+		for client := range h.clients {
+			if client.Username != "" && client.Username != name && usernameSkeleton(client.Username) == skeleton {
+				return client.Username
+			}
+		}
+		return ""
+	}
+	ctx := context.Background()
+	key := usernameSkeletonKey(skeleton)
+	claimed, err := h.redis.SetNX(ctx, key, name, usernameClaimTTL).Result()
+	if err != nil || claimed {
+		return ""
+	}
+	holder, err := h.redis.Get(ctx, key).Result()
+	if err != nil && err != redis.Nil {
+		log.Printf("Kullanıcı adı sahibi okunamadı: %v", err)
+		return ""
+	}
+	if holder != name {
+		return holder
+	}
+	h.redis.Expire(ctx, key, usernameClaimTTL)
+	return ""
+}
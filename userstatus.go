@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Users can set a custom status, a short text and an emoji, with
+// {"type":"status","text":"toplantıda","emoji":"📅"}; sending both empty clears
+// it. The status is kept per username in Redis, so it outlives connections,
+// and goes out with the user's presence: user_connected and presence frames
+// carry it as customStatus, the presence list has it, and member lists map
+// usernames to their statuses.
+
+var customStatusMaxLength = envInt("STATUS_MAX_LENGTH", 100)
+
+// Custom statuses, username -> JSON CustomStatus
+const customStatusKey = "websocket:status"
+
+// CustomStatus is what a user says they are up to
+type CustomStatus struct {
+	Text  string    `json:"text,omitempty"`
+	Emoji string    `json:"emoji,omitempty"`
+	Since time.Time `json:"since"`
+}
+
+// StatusRequest is the inbound {"type":"status","text":"...","emoji":"..."} frame
+type StatusRequest struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Emoji string `json:"emoji"`
+}
+
+// customStatus reads a user's status; nil when none is set
+func (h *Hub) customStatus(username string) *CustomStatus {
+	if h.redis == nil || username == "" {
+		return h.cachedStatus(username)
+	}
+	raw, err := h.redis.HGet(context.Background(), customStatusKey, username).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Özel durum okunamadı: %v", err)
+		}
+		return nil
+	}
+	var status CustomStatus
+	if json.Unmarshal(raw, &status) != nil {
+		return nil
+	}
+	return &status
+}
+
+// cachedStatus is the status in the hub's presence state, all there is without Redis
+func (h *Hub) cachedStatus(username string) *CustomStatus {
+	h.presenceMutex.Lock()
+	defer h.presenceMutex.Unlock()
+	return h.presence[username].CustomStatus
+}
+
+// customStatuses reads the statuses of several users, keyed by username
+func (h *Hub) customStatuses(usernames []string) map[string]CustomStatus {
+	statuses := make(map[string]CustomStatus)
+	if len(usernames) == 0 {
+		return statuses
+	}
+	if h.redis == nil {
+		for _, username := range usernames {
+			if status := h.cachedStatus(username); status != nil {
+				statuses[username] = *status
+			}
+		}
+		return statuses
+	}
+	values, err := h.redis.HMGet(context.Background(), customStatusKey, usernames...).Result()
+	if err != nil {
+		log.Printf("Özel durumlar okunamadı: %v", err)
+		return statuses
+	}
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var status CustomStatus
+		if json.Unmarshal([]byte(raw), &status) == nil {
+			statuses[usernames[i]] = status
+		}
+	}
+	return statuses
+}
+
+// handleStatus sets or clears the custom status of the client's user and
+// announces it
+func (h *Hub) handleStatus(c *Client, raw []byte) {
+	var req StatusRequest
+	if err := json.Unmarshal(raw, &req); err != nil || c.Username == "" {
+		return
+	}
+	text := strings.Join(strings.Fields(stripControls(req.Text)), " ")
+	emoji := strings.TrimSpace(req.Emoji)
+	if utf8.RuneCountInString(text) > customStatusMaxLength || (emoji != "" && !validEmoji(emoji)) {
+		c.sendError(ErrInvalidStatus, "Geçersiz durum")
+		return
+	}
+
+	var status *CustomStatus
+	if text != "" || emoji != "" {
+		status = &CustomStatus{Text: text, Emoji: emoji, Since: time.Now()}
+	}
+	if h.redis != nil {
+		ctx := context.Background()
+		var err error
+		if status == nil {
+			err = h.redis.HDel(ctx, customStatusKey, c.Username).Err()
+		} else {
+			data, _ := json.Marshal(status)
+			err = h.redis.HSet(ctx, customStatusKey, c.Username, data).Err()
+		}
+		if err != nil {
+			log.Printf("Özel durum kaydedilemedi: %v", err)
+			return
+		}
+	}
+
+	h.presenceMutex.Lock()
+	state, known := h.presence[c.Username]
+	if known {
+		state.CustomStatus = status
+		h.presence[c.Username] = state
+	}
+	h.presenceMutex.Unlock()
+	if known {
+		h.savePresence(state)
+	} else {
+		state = PresenceState{Username: c.Username, Status: PresenceOnline, Since: time.Now()}
+	}
+
+	frame, err := encodeFrame(PresenceStateFrame{
+		Type:         "presence",
+		Username:     c.Username,
+		Identity:     userIdentity(c.Username),
+		Status:       state.Status,
+		Since:        state.Since,
+		CustomStatus: status,
+	})
+	if err != nil {
+		return
+	}
+	// The connection that set it gets it even in lite mode
+	h.sendToAll(frame, PriorityLow, c)
+	c.enqueue(frame, PriorityNormal)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+)
+
+// Experimental WebTransport (HTTP/3) listener sharing the hub with /ws. QUIC needs
+// TLS, so it takes its own certificate even when plain HTTP sits behind a proxy.
+var (
+	webTransportEnabled = envBool("WEBTRANSPORT_ENABLED", false)
+	webTransportAddr    = envString("WEBTRANSPORT_ADDR", ":443")
+	webTransportCert    = envString("WEBTRANSPORT_CERT", "")
+	webTransportKey     = envString("WEBTRANSPORT_KEY", "")
+	// Public URL handed to clients; derived from the request host when empty
+	webTransportURL = envString("WEBTRANSPORT_URL", "")
+)
+
+// clientConn is the part of *websocket.Conn the client pumps use, so other
+// transports can be plugged into the hub
+type clientConn interface {
+	ReadMessage() (int, []byte, error)
+	WriteMessage(messageType int, data []byte) error
+	NextWriter(messageType int) (io.WriteCloser, error)
+	SetReadLimit(limit int64)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	Close() error
+}
+
+var errFrameTooLarge = errors.New("frame too large")
+
+// streamConn carries the chat protocol over one bidirectional WebTransport
+// stream as newline separated JSON frames. Liveness is left to QUIC keep-alives,
+// so pings and read deadlines are no-ops.
+type streamConn struct {
+	session *webtransport.Session
+	stream  webtransport.Stream
+	reader  *bufio.Reader
+	limit   int64
+}
+
+func newStreamConn(session *webtransport.Session, stream webtransport.Stream) *streamConn {
+	return &streamConn{session: session, stream: stream, reader: bufio.NewReader(stream)}
+}
+
+func (c *streamConn) ReadMessage() (int, []byte, error) {
+	var frame []byte
+	for {
+		chunk, err := c.reader.ReadSlice('\n')
+		frame = append(frame, chunk...)
+		if c.limit > 0 && int64(len(frame)) > c.limit+1 {
+			return 0, nil, errFrameTooLarge
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		frame = bytes.TrimSpace(frame)
+		if len(frame) == 0 {
+			continue // keep-alive blank line
+		}
+		return websocket.TextMessage, frame, nil
+	}
+}
+
+func (c *streamConn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case websocket.CloseMessage:
+		return c.Close()
+	case websocket.PingMessage, websocket.PongMessage:
+		return nil
+	}
+	// Frames are shared between clients, so don't append to data in place
+	line := make([]byte, 0, len(data)+1)
+	_, err := c.stream.Write(append(append(line, data...), '\n'))
+	return err
+}
+
+func (c *streamConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	return &streamWriter{conn: c}, nil
+}
+
+func (c *streamConn) SetReadLimit(limit int64)           { c.limit = limit }
+func (c *streamConn) SetReadDeadline(time.Time) error    { return nil }
+func (c *streamConn) SetWriteDeadline(t time.Time) error { return c.stream.SetWriteDeadline(t) }
+func (c *streamConn) SetPongHandler(func(string) error)  {}
+func (c *streamConn) Close() error                       { return c.session.CloseWithError(0, "") }
+
+// streamWriter buffers one batch of frames and writes it as a single line group
+type streamWriter struct {
+	conn *streamConn
+	buf  bytes.Buffer
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func (w *streamWriter) Close() error {
+	w.buf.WriteByte('\n')
+	_, err := w.conn.stream.Write(w.buf.Bytes())
+	return err
+}
+
+// runWebTransport serves WebTransport sessions at /wt until the process exits
+func (h *Hub) runWebTransport() {
+	if !webTransportEnabled {
+		return
+	}
+	if webTransportCert == "" || webTransportKey == "" {
+		log.Printf("WebTransport devre dışı: WEBTRANSPORT_CERT ve WEBTRANSPORT_KEY gerekli")
+		return
+	}
+
+	mux := http.NewServeMux()
+	server := &webtransport.Server{
+		H3: http3.Server{
+			Addr:       webTransportAddr,
+			Handler:    mux,
+			QuicConfig: &quic.Config{KeepAlivePeriod: 15 * time.Second, MaxIdleTimeout: 60 * time.Second},
+		},
+		CheckOrigin: func(r *http.Request) bool {
+			return true // Allow connections from any origin, like /ws
+		},
+	}
+	mux.HandleFunc("/wt", func(w http.ResponseWriter, r *http.Request) {
+		if h.checkIPReputation(clientIP(r)).Action == "block" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		account, ok := h.handshakeAccount(w, r)
+		if !ok {
+			return
+		}
+		session, err := server.Upgrade(w, r)
+		if err != nil {
+			log.Printf("WebTransport upgrade hatası: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		go h.serveWebTransportSession(session, clientIP(r), account, guestFromToken(r.URL.Query().Get("guest")), requestLatencyLabels(r))
+	})
+
+	log.Printf("Deneysel WebTransport sunucusu %s (UDP) adresinde başlatıldı...", webTransportAddr)
+	if err := server.ListenAndServeTLS(webTransportCert, webTransportKey); err != nil {
+		log.Printf("WebTransport sunucu hatası: %v", err)
+	}
+}
+
+// serveWebTransportSession waits for the client's stream and attaches it to the hub
+func (h *Hub) serveWebTransportSession(session *webtransport.Session, ip, account, guest string, labels latencyLabels) {
+	ctx, cancel := context.WithTimeout(session.Context(), 10*time.Second)
+	defer cancel()
+	stream, err := session.AcceptStream(ctx)
+	if err != nil {
+		log.Printf("WebTransport stream bekleme hatası: %v", err)
+		session.CloseWithError(0, "no stream")
+		return
+	}
+
+	transportConnections.Add("webtransport", 1)
+	client := newClient(tempClientID(), newStreamConn(session, stream), ip)
+	client.account = account
+	client.guestName = guest
+	client.latency = labels
+	h.register <- client
+	go client.writePump(h)
+	go client.readPump(h)
+}
+
+// handleTransportDiscovery serves GET /api/transport so clients that support
+// WebTransport can find the experimental endpoint and fall back to /ws, or to
+// long polling where WebSockets are blocked
+func handleTransportDiscovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	transports := map[string]string{"websocket": "/ws"}
+	if webTransportEnabled && webTransportCert != "" && webTransportKey != "" {
+		url := webTransportURL
+		if url == "" {
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host
+			}
+			_, port, _ := net.SplitHostPort(webTransportAddr)
+			url = "https://" + net.JoinHostPort(host, port) + "/wt"
+		}
+		transports["webtransport"] = url
+	}
+	if longPollingEnabled {
+		transports["polling"] = "/poll"
+	}
+	writeJSON(w, http.StatusOK, transports)
+}